@@ -0,0 +1,113 @@
+/*
+hc2Backup writes a snapshot of the configuration of a Fibaro HC2 system into a
+directory. Settings, sections, rooms, device metadata, users without passwords,
+profiles and global variables are written as pretty-printed JSON with sorted keys,
+scenes as lua-files with FIBARO_GIT_HOOK header and virtual devices as written by
+hc2DownloadVD. Running hc2Backup on an unchanged system does not change any file,
+so that the directory can be committed to git daily.
+
+	Usage: hc2Backup [options]
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Backup"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+	Test     bool      `help:"Just print information about the contacted HC2 system"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+
+	Dir string `opts:"group=Backup" help:"The directory the backup is written to"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		LogLevel: log.InfoLevel,
+		Dir:      "./backup",
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.Test {
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC2: %v", err)
+		}
+		fmt.Println(info)
+		os.Exit(0)
+	}
+
+	if err := f.Backup(conf.Dir); err != nil {
+		log.Fatalf("Could not write backup to %s: %v", conf.Dir, err)
+	}
+	log.Infof("wrote backup to %s\n", conf.Dir)
+}
@@ -0,0 +1,208 @@
+/*
+hc2DownloadQA downloads QuickApps from a Fibaro HC3 system. Every QuickApp is
+unpacked into its own directory, containing one lua-file for every file of the
+QuickApp and a quickapp.json with all other information. With --fqa the
+QuickApps are written as .fqa files instead.
+
+	Usage: hc2DownloadQA [options]
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mitchellh/go-homedir"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2DownloadQA"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+	Test     bool      `help:"Just print information about the contacted HC3 system"`
+
+	User     string `opts:"group=HC3" help:"Username for HC3 authentication"`
+	Password string `opts:"group=HC3" help:"Password for HC3 authentication"`
+	URL      string `opts:"group=HC3" help:"URL of the Fibaro HC3 system, in the form http://..."`
+
+	QaID int    `opts:"group=QuickApp" help:"The device id of the QuickApp that shall be used. If none given, all QuickApps will be downloaded."`
+	Dir  string `opts:"group=QuickApp" help:"The directory the QuickApps are written to"`
+	Fqa  bool   `opts:"group=QuickApp" help:"Write the QuickApps as .fqa files instead of unpacking them"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		QaID:     -1,
+		LogLevel: log.InfoLevel,
+		Dir:      "./download",
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.Test {
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC3: %v", err)
+		}
+		fmt.Println(info)
+		os.Exit(0)
+	}
+
+	fc, err := hc2.NewController(*f.Config())
+	if err != nil {
+		log.Fatalf("Could not contact Fibaro system: %v", err)
+	}
+	hc3, ok := fc.(*hc2.FibaroHc3)
+	if !ok {
+		log.Fatalf("QuickApps are only supported by HC3 systems, not by %s", fc.Config().Platform)
+	}
+
+	var ids []int
+	if conf.QaID == -1 {
+		qas, err := hc3.AllQuickApps()
+		if err != nil {
+			log.Fatalf("Could not retrieve QuickApps: %v", err)
+		}
+		for _, qa := range qas {
+			ids = append(ids, qa.ID)
+		}
+	} else {
+		ids = append(ids, conf.QaID)
+	}
+	log.Infof("Processing %d QuickApps\n", len(ids))
+
+	for _, id := range ids {
+		qa, err := hc3.ExportQuickApp(id)
+		if errors.Is(err, hc2.ErrNotFound) {
+			log.Fatalf("QuickApp with id %d does not exists\n", id)
+		} else if err != nil {
+			log.Fatalf("Could not retrieve QuickApp %d: %v", id, err)
+		}
+
+		if conf.Fqa {
+			if err := os.MkdirAll(conf.Dir, os.ModePerm); err != nil {
+				log.Fatalln(err)
+			}
+			file := filepath.Join(conf.Dir, fileName(qa)+hc2.QuickAppExtension)
+			if err := qa.WriteFqa(file); err != nil {
+				log.Fatalf("Could not write QuickApp %d to %s: %v", id, file, err)
+			}
+			log.Debugf("Wrote %d:%s to %s\n", id, qa.Name, file)
+			continue
+		}
+
+		dir, err := qaDir(conf.Dir, qa)
+		if err != nil {
+			log.Fatalf("Could not write QuickApp %d: %v", id, err)
+		}
+		if err := qa.WriteDir(dir); err != nil {
+			log.Fatalf("Could not write QuickApp %d to %s: %v", id, dir, err)
+		}
+		log.Debugf("Wrote %d:%s to %s\n", id, qa.Name, dir)
+	}
+	log.Infof("retrieved %d QuickApps\n", len(ids))
+}
+
+func fileName(qa hc2.Hc3QuickApp) string {
+	return hc2.SanitizeFileName(qa.Name)
+}
+
+// qaDir returns the directory for qa below baseDir. A directory is only reused if it
+// is empty or holds the manifest of qa. If the directory is used otherwise, e.g. by
+// another QuickApp or by scenes, the id is appended to the name.
+func qaDir(baseDir string, qa hc2.Hc3QuickApp) (string, error) {
+	name := fileName(qa)
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("QuickApp %d: name %q is not usable as directory", qa.ID, qa.Name)
+	}
+	dir := filepath.Join(baseDir, name)
+	for _, dir := range []string{dir, dir + "_" + strconv.Itoa(qa.ID)} {
+		if qaDirUsable(dir, qa.ID) {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("QuickApp %d: %s and %s_%d are used otherwise", qa.ID, dir, dir, qa.ID)
+}
+
+// qaDirUsable reports if dir does not exist, is empty or holds the manifest of the
+// QuickApp id.
+func qaDirUsable(dir string, id int) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	if len(entries) == 0 {
+		return true
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, hc2.QuickAppManifestFile))
+	if err != nil {
+		return false
+	}
+	var existing hc2.Hc3QuickApp
+	return json.Unmarshal(b, &existing) == nil && existing.ID == id
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/go-homedir"
 
@@ -35,10 +38,25 @@ type config struct {
 	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
 	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
 	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+	Platform string `opts:"group=HC2" help:"Platform of the Fibaro system, HC2 or HC3. Detected if not set"`
 
 	CreateHeader bool   `opts:"group=Scene" help:"If set create the FIBARO_GIT_HEADER if none present"`
 	SceneID      int    `opts:"group=Scene" help:"The sceneId that shall be used. If none given, all scenes will be downloaded."`
-	Dir          string `opts:"group=Scene" help:"Where to search for the included libraries"`
+	Dir          string `opts:"group=Scene" help:"The directory the scenes are written to"`
+
+	Layout       string `opts:"group=Files" help:"Template of the file a scene is written to, relative to dir. Available are {{.ID}}, {{.Name}}, {{.RoomID}}, {{.Room}}, {{.SectionID}} and {{.Section}}, or flat for {{.ID}}-{{.Name}}.lua"`
+	ASCII        bool   `opts:"group=Files" help:"Transliterate names to ASCII, e.g. for filesystems without unicode support"`
+	Overwrite    bool   `opts:"group=Files" help:"Overwrite existing files, even if they belong to another scene"`
+	SkipExisting bool   `opts:"group=Files" help:"Don't overwrite existing files of a scene"`
+	ByID         bool   `opts:"group=Files" help:"Write a scene to the file below dir with its @sceneID, wherever it is located"`
+
+	CacheFile string        `opts:"group=Cache" help:"The file rooms and sections are cached in between runs"`
+	CacheTTL  time.Duration `opts:"group=Cache" help:"How long rooms and sections are cached in cache-file, e.g. 10m. If 0 they are retrieved once per run"`
+
+	Parallel   int           `opts:"group=Download" help:"Number of scenes retrieved concurrently"`
+	Retries    int           `opts:"group=Download" help:"How often retrieving a scene is retried if the HC2 is busy or not reachable"`
+	Interval   time.Duration `opts:"group=Download" help:"Minimum time between two requests for scenes, e.g. 100ms, to take load off the HC2"`
+	NoProgress bool          `opts:"group=Download" help:"Don't show the progress, which is shown only if stderr is a terminal"`
 }
 
 func main() {
@@ -50,6 +68,10 @@ func main() {
 		SceneID:      -1,
 		LogLevel:     log.InfoLevel,
 		Dir:          "./download",
+		Layout:       hc2.DefaultSceneLayout,
+		CacheFile:    workingHomeDir + "/" + hc2.Hc2DefaultCacheFile,
+		Parallel:     1,
+		Retries:      3,
 	}
 
 	//parse config
@@ -60,6 +82,24 @@ func main() {
 
 	log.SetLevel(conf.LogLevel)
 
+	if conf.Overwrite && conf.SkipExisting {
+		log.Fatalln("--overwrite and --skip-existing are mutually exclusive")
+	}
+	if conf.Layout == "flat" {
+		conf.Layout = hc2.FlatSceneLayout
+	}
+	layout, err := hc2.NewSceneLayout(conf.Layout, conf.ASCII)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	files := &hc2.SceneFiles{
+		BaseDir:      conf.Dir,
+		Layout:       layout,
+		Overwrite:    conf.Overwrite,
+		SkipExisting: conf.SkipExisting,
+		ByID:         conf.ByID,
+	}
+
 	var f *hc2.FibaroHc2
 	if !conf.Init {
 		f = hc2.NewFibaroHc2Config(conf.CfgFile)
@@ -96,105 +136,196 @@ func main() {
 		cfg.BaseURL = conf.URL
 	}
 
+	if conf.Platform != "" {
+		cfg := f.Config()
+		cfg.Platform = conf.Platform
+	}
+
 	if conf.Init {
-		var i int
 		var filePath string
 		if conf.CfgFile != "" {
 			filePath = conf.CfgFile
 		} else {
 			filePath = workingHomeDir + "/" + hc2.Hc2DefaultConfigFile
 		}
-		i = f.WriteInitConfigFile(filePath)
+		i, err := f.WriteInitConfigFile(filePath)
+		if err != nil {
+			log.Fatalf("Could not write config file: %v", err)
+		}
 		log.Debugf("Wrote to file %s %d bytes", filePath, i)
 	}
 
 	if conf.Test {
-		fmt.Println(f.Info(2))
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC2: %v", err)
+		}
+		fmt.Println(info)
 		os.Exit(0)
 	}
 
+	fc, err := hc2.NewController(*f.Config())
+	if err != nil {
+		log.Fatalf("Could not contact Fibaro system: %v", err)
+	}
+	// rooms and sections are looked up for every scene
+	meta := hc2.NewMetadataCache(fc)
+	meta.Persist(conf.CacheFile, conf.CacheTTL)
+
 	if conf.SceneID == -1 {
-		allScenes := f.AllScenes()
+		allScenes, err := fc.AllScenes()
+		if err != nil {
+			log.Fatalf("Could not retrieve scenes: %v", err)
+		}
 		log.Infof("Processing %d scenes\n", len(allScenes))
 
-		var bytesWrote int
-		var filesCreated int
-
+		// scenes are written in the order of their ids, however they have been retrieved
+		sort.Slice(allScenes, func(i, j int) bool { return allScenes[i].SceneID < allScenes[j].SceneID })
+		ids := make([]int, len(allScenes))
 		for i, aScene := range allScenes {
-			amountOfBytes := writeFile(f, conf.Dir, f.OneScene(aScene.SceneID))
-			log.Debugf("%d: Wrote %d:%s \n", i, aScene.SceneID, aScene.Name)
+			ids[i] = aScene.SceneID
+		}
+		opts := downloadOptions()
+		downloads := hc2.DownloadScenes(fc, ids, opts)
+		if opts.Progress != nil {
+			fmt.Fprintln(os.Stderr)
+		}
+
+		var bytesWrote int
+		var scenesRetrieved, filesCreated, filesSkipped int
+
+		for i, d := range downloads {
+			aScene := allScenes[i]
+			if d.Err != nil {
+				log.Errorf("Could not retrieve scene %d: %v", d.SceneID, d.Err)
+				continue
+			}
+			scenesRetrieved++
+			file, amountOfBytes, err := writeFile(meta, files, d.Scene)
+			if errors.Is(err, errSkipped) {
+				log.Debugf("%d: Skipped %d:%s, %s exists\n", i, aScene.SceneID, aScene.Name, file)
+				filesSkipped++
+				continue
+			} else if err != nil {
+				log.Errorf("Could not write scene %d: %v", aScene.SceneID, err)
+				continue
+			}
+			log.Debugf("%d: Wrote %d:%s to %s\n", i, aScene.SceneID, aScene.Name, file)
 			bytesWrote += amountOfBytes
 			filesCreated++
 		}
 
-		log.Infof("retrieved %d scenes\n", len(allScenes))
+		log.Infof("retrieved %d scenes\n", scenesRetrieved)
 		log.Infof("created %d files\n", filesCreated)
+		if filesSkipped > 0 {
+			log.Infof("skipped %d existing files\n", filesSkipped)
+		}
 		log.Infof("wrote %d bytes\n", bytesWrote)
 
 	} else {
-		s := f.OneScene(conf.SceneID)
-		if s.SceneID == -1 {
+		opts := downloadOptions()
+		opts.Progress = nil
+		d := hc2.DownloadScenes(fc, []int{conf.SceneID}, opts)[0]
+		s, err := d.Scene, d.Err
+		if errors.Is(err, hc2.ErrNotFound) {
 			log.Fatalf("scene with id %d does not exists\n", conf.SceneID)
+		} else if err != nil {
+			log.Fatalf("Could not retrieve scene %d: %v", conf.SceneID, err)
+		}
+		file, bytesWrote, err := writeFile(meta, files, s)
+		if errors.Is(err, errSkipped) {
+			log.Infof("skipped scene %d, %s exists\n", conf.SceneID, file)
+			os.Exit(0)
+		} else if err != nil {
+			log.Fatalf("Could not write scene %d: %v", conf.SceneID, err)
 		}
-		bytesWrote := writeFile(f, conf.Dir, s)
 		log.Infof("retrieved scene %d", conf.SceneID)
 		log.Infof("wrote %d bytes\n", bytesWrote)
-		log.Infof("created file: %s\n", s.Name)
+		log.Infof("created file: %s\n", file)
 
 	}
 }
 
-func writeFile(fib *hc2.FibaroHc2, baseDir string, scene hc2.Hc2Scene) (bytesWrote int) {
+// downloadOptions returns the options for hc2.DownloadScenes as configured
+func downloadOptions() hc2.DownloadOptions {
+	opts := hc2.DownloadOptions{
+		Parallel: conf.Parallel,
+		Retries:  conf.Retries,
+		Backoff:  500 * time.Millisecond,
+		Interval: conf.Interval,
+	}
+	if !conf.NoProgress && isTerminal(os.Stderr) {
+		opts.Progress = progress
+	}
+	return opts
+}
 
-	room := fib.OneRoom(scene.RoomID)
-	section := fib.OneSection(room.SectionID)
-	path := filepath.Join(baseDir, section.Name, room.Name)
-	os.MkdirAll(path, os.ModePerm)
-	file := filepath.Join(path, scene.Name+".lua")
+// progress shows a progress bar on stderr
+func progress(done, total int) {
+	const width = 40
+	n := width * done / total
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3d%% %d/%d",
+		strings.Repeat("=", n), strings.Repeat(" ", width-n), 100*done/total, done, total)
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
 
-	i := 0
-	// check whether it exists and create a unique if yes
-	e, err := os.Open(file)
-	defer e.Close()
+var errSkipped = errors.New("file exists")
 
-	for err == nil {
-		log.Debugf("   File %s exists. Creating new fileName", file)
-		file = filepath.Join(path, scene.Name+"_"+strconv.Itoa(i)+".lua")
-		e, err = os.Open(file)
-		defer e.Close()
-		i++
+// writeFile writes the scene to the file determined by files. It returns errSkipped
+// if the file exists and shall not be overwritten.
+func writeFile(fib hc2.Controller, files *hc2.SceneFiles, scene hc2.Hc2Scene) (file string, bytesWrote int, err error) {
+
+	// Scenes not assigned to a room, or rooms not assigned to a section,
+	// have empty room or section names
+	room, err := fib.OneRoom(scene.RoomID)
+	if err != nil && !errors.Is(err, hc2.ErrNotFound) {
+		log.Errorf("Could not retrieve room %d: %v", scene.RoomID, err)
+	}
+	section, err := fib.OneSection(room.SectionID)
+	if err != nil && !errors.Is(err, hc2.ErrNotFound) {
+		log.Errorf("Could not retrieve section %d: %v", room.SectionID, err)
 	}
 
-	f, err := os.Create(file)
-	defer f.Close()
+	file, skip, err := files.Path(scene, room, section)
+	if err != nil {
+		return "", 0, err
+	}
+	if skip {
+		return file, 0, errSkipped
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return file, 0, err
+	}
 
+	f, err := os.Create(file)
 	if err != nil {
-		log.Printf("Problem creating file %s; %v", file, err)
-		return 0
+		return file, 0, err
 	}
+	defer f.Close()
 
-	check(err)
 	w := bufio.NewWriter(f)
 	n4, err := w.WriteString(scene.Lua + "\n")
-	check(err)
+	if err != nil {
+		return file, 0, err
+	}
 
 	var isPresent hc2.Hc2Scene
-	isPresent.Parse([]byte(scene.Lua))
+	if err := isPresent.Parse([]byte(scene.Lua)); err != nil {
+		log.Warnf("Scene %d: %v\n", scene.SceneID, err)
+	}
 	if isPresent.SceneID == -1 {
 		log.Infoln("No LuaSpec in file: " + file)
 		log.Infoln("Adding ... ")
 
 		n5, err := w.WriteString(scene.ToComment())
-		check(err)
+		if err != nil {
+			return file, 0, err
+		}
 		n4 += n5
 	}
-	w.Flush()
-	return n4
-
-}
-
-func check(e error) {
-	if e != nil {
-		log.Panic(e)
-	}
+	return file, n4, w.Flush()
 }
@@ -0,0 +1,179 @@
+/*
+hc2DownloadVD downloads virtual devices from a Fibaro HC2 system. Every virtual device
+is exploded into its own directory, containing one lua-file for the main loop, one
+lua-file for every button or slider executing lua code, and a layout.json with all
+other information.
+
+	Usage: hc2DownloadVD [options]
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mitchellh/go-homedir"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2DownloadVD"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+	Test     bool      `help:"Just print information about the contacted HC2 system"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+
+	VdID int    `opts:"group=Virtual Device" help:"The id of the virtual device that shall be used. If none given, all virtual devices will be downloaded."`
+	Dir  string `opts:"group=Virtual Device" help:"The directory the virtual devices are written to"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		VdID:     -1,
+		LogLevel: log.InfoLevel,
+		Dir:      "./download",
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.Test {
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC2: %v", err)
+		}
+		fmt.Println(info)
+		os.Exit(0)
+	}
+
+	var vds []hc2.Hc2VirtualDevice
+	if conf.VdID == -1 {
+		var err error
+		vds, err = f.AllVirtualDevices()
+		if err != nil {
+			log.Fatalf("Could not retrieve virtual devices: %v", err)
+		}
+	} else {
+		vd, err := f.OneVirtualDevice(conf.VdID)
+		if errors.Is(err, hc2.ErrNotFound) {
+			log.Fatalf("virtual device with id %d does not exists\n", conf.VdID)
+		} else if err != nil {
+			log.Fatalf("Could not retrieve virtual device %d: %v", conf.VdID, err)
+		}
+		vds = append(vds, vd)
+	}
+	log.Infof("Processing %d virtual devices\n", len(vds))
+
+	for _, vd := range vds {
+		dir, err := vdDir(conf.Dir, vd)
+		if err != nil {
+			log.Fatalf("Could not write virtual device %d: %v", vd.ID, err)
+		}
+		if err := vd.WriteDir(dir); err != nil {
+			log.Fatalf("Could not write virtual device %d to %s: %v", vd.ID, dir, err)
+		}
+		log.Debugf("Wrote %d:%s to %s\n", vd.ID, vd.Name, dir)
+	}
+	log.Infof("retrieved %d virtual devices\n", len(vds))
+}
+
+// vdDir returns the directory for vd below baseDir. A directory is only reused if it
+// is empty or holds the layout file of vd. If the directory is used otherwise, e.g. by
+// another virtual device or by scenes, the id is appended to the name.
+func vdDir(baseDir string, vd hc2.Hc2VirtualDevice) (string, error) {
+	name := hc2.SanitizeFileName(vd.Name)
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("virtual device %d: name %q is not usable as directory", vd.ID, vd.Name)
+	}
+	dir := filepath.Join(baseDir, name)
+	for _, dir := range []string{dir, dir + "_" + strconv.Itoa(vd.ID)} {
+		if vdDirUsable(dir, vd.ID) {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("virtual device %d: %s and %s_%d are used otherwise", vd.ID, dir, dir, vd.ID)
+}
+
+// vdDirUsable reports if dir does not exist, is empty or holds the layout file of the
+// virtual device id.
+func vdDirUsable(dir string, id int) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	if len(entries) == 0 {
+		return true
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, hc2.VirtualDeviceLayoutFile))
+	if err != nil {
+		return false
+	}
+	var existing hc2.Hc2VirtualDevice
+	return json.Unmarshal(b, &existing) == nil && existing.ID == id
+}
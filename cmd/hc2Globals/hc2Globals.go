@@ -0,0 +1,288 @@
+/*
+hc2Globals lists, reads, modifies, creates and deletes global variables of a Fibaro HC2 system.
+
+	Usage: hc2Globals [options] <command>
+
+	Commands:
+	· list      Lists all global variables
+	· get       Prints the value of a global variable
+	· set       Sets the value of a global variable
+	· create    Creates a new global variable
+	· delete    Deletes a global variable
+	· dump      Writes all global variables to a JSON file
+	· restore   Creates or updates the global variables from a JSON file
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Globals"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+}
+
+const shortUsage = "Manage the global variables of a Fibaro HC2"
+
+var f *hc2.FibaroHc2
+
+type list struct{}
+
+const listUsage = "Lists all global variables"
+
+func (cmd *list) Run() error {
+	globals, err := f.AllGlobals()
+	if err != nil {
+		return err
+	}
+	sortGlobals(globals)
+	for _, g := range globals {
+		if g.IsEnum {
+			fmt.Printf("%s = %s %v\n", g.Name, g.Value, g.EnumValues)
+		} else {
+			fmt.Printf("%s = %s\n", g.Name, g.Value)
+		}
+	}
+	return nil
+}
+
+type get struct {
+	Name string `type:"arg" help:"name of the global variable"`
+}
+
+const getUsage = "Prints the value of a global variable"
+
+func (cmd *get) Run() error {
+	g, err := f.OneGlobal(cmd.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Println(g.Value)
+	return nil
+}
+
+type set struct {
+	Name  string `type:"arg" help:"name of the global variable"`
+	Value string `type:"arg" help:"new value of the global variable"`
+}
+
+const setUsage = "Sets the value of a global variable"
+
+func (cmd *set) Run() error {
+	g, err := f.OneGlobal(cmd.Name)
+	if err != nil {
+		return err
+	}
+	g.Value = cmd.Value
+	return f.UpdateGlobal(g)
+}
+
+type create struct {
+	Name      string   `type:"arg" help:"name of the global variable"`
+	Value     string   `type:"arg" help:"initial value of the global variable"`
+	EnumValue []string `help:"allowed value of an enumerated variable. Can be given multiple times."`
+}
+
+const createUsage = "Creates a new global variable"
+
+func (cmd *create) Run() error {
+	g := hc2.Hc2GlobalVariable{
+		Name:       cmd.Name,
+		Value:      cmd.Value,
+		IsEnum:     len(cmd.EnumValue) > 0,
+		EnumValues: cmd.EnumValue,
+	}
+	_, err := f.CreateGlobal(g)
+	return err
+}
+
+type remove struct {
+	Name string `type:"arg" help:"name of the global variable"`
+}
+
+const removeUsage = "Deletes a global variable"
+
+func (cmd *remove) Run() error {
+	return f.DeleteGlobal(cmd.Name)
+}
+
+type dump struct {
+	File string `type:"arg" help:"file to write the global variables to"`
+}
+
+const dumpUsage = "Writes all global variables to a JSON file"
+
+func (cmd *dump) Run() error {
+	globals, err := f.AllGlobals()
+	if err != nil {
+		return err
+	}
+	sortGlobals(globals)
+	b, err := json.MarshalIndent(globals, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(cmd.File, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	log.Infof("wrote %d global variables to %s\n", len(globals), cmd.File)
+	return nil
+}
+
+type restore struct {
+	File          string `type:"arg" help:"file to read the global variables from"`
+	DeleteMissing bool   `help:"delete global variables not contained in the file"`
+}
+
+const restoreUsage = "Creates or updates the global variables from a JSON file"
+
+func (cmd *restore) Run() error {
+	b, err := ioutil.ReadFile(cmd.File)
+	if err != nil {
+		return err
+	}
+	var globals []hc2.Hc2GlobalVariable
+	if err := json.Unmarshal(b, &globals); err != nil {
+		return fmt.Errorf("reading %s: %w", cmd.File, err)
+	}
+
+	existing, err := f.AllGlobals()
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for _, g := range existing {
+		present[g.Name] = true
+	}
+
+	var created, updated, deleted int
+	wanted := map[string]bool{}
+	for _, g := range globals {
+		wanted[g.Name] = true
+		if present[g.Name] {
+			err = f.UpdateGlobal(g)
+			updated++
+		} else {
+			_, err = f.CreateGlobal(g)
+			created++
+		}
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", g.Name, err)
+		}
+	}
+
+	if cmd.DeleteMissing {
+		for _, g := range existing {
+			if wanted[g.Name] {
+				continue
+			}
+			if err := f.DeleteGlobal(g.Name); err != nil && !errors.Is(err, hc2.ErrNotFound) {
+				return fmt.Errorf("deleting %s: %w", g.Name, err)
+			}
+			deleted++
+		}
+	}
+
+	log.Infof("created %d, updated %d, deleted %d global variables\n", created, updated, deleted)
+	return nil
+}
+
+func sortGlobals(globals []hc2.Hc2GlobalVariable) {
+	sort.Slice(globals, func(i, j int) bool { return globals[i].Name < globals[j].Name })
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		LogLevel: log.InfoLevel,
+	}
+
+	//parse config
+	cmd := opts.New(&conf).
+		Summary(shortUsage).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		AddCommand(opts.New(&list{}).Summary(listUsage)).
+		AddCommand(opts.New(&get{}).Summary(getUsage)).
+		AddCommand(opts.New(&set{}).Summary(setUsage)).
+		AddCommand(opts.New(&create{}).Summary(createUsage)).
+		AddCommand(opts.New(&remove{}).Name("delete").Summary(removeUsage)).
+		AddCommand(opts.New(&dump{}).Summary(dumpUsage)).
+		AddCommand(opts.New(&restore{}).Summary(restoreUsage)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	if !cmd.IsRunnable() {
+		fmt.Print(cmd.Help())
+		os.Exit(1)
+	}
+
+	f = hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+hc2Lint checks lua scenes for mistakes in the use of the Fibaro API before they are uploaded to a Fibaro HC2 system.
+
+	Usage: hc2Lint [options] [lua-script] [lua-script] ...
+
+	<lua-script> the files, globs or directories to be checked
+
+	Options:
+	--log-level, -l    Log level, one of panic, fatal, error, warn or warning, info, debug, trace
+						(default info)
+	--cfg-file, -c     The config file to use (default /Users/the/.hc2-tools/config.json)
+	--live             Check device ids, actions and global variables against the HC2
+	--disable, -d      Rule not to be applied. Can be given multiple times. (allows multiple)
+	--list-rules       List the rules and exit
+	--json, -j         Print the diagnostics as JSON
+	--dont-expand      Report require statements, even if expanded by hc2UploadScene
+	--version, -v      display version
+	--help, -h         display help
+
+	HC2 options:
+	--user, -u         Username for HC2 authentication
+	--password, -p     Password for HC2 authentication
+	--url              URL of the Fibaro HC2 system, in the form http://...
+	--platform         Platform of the Fibaro system, HC2 or HC3. Detected if not set
+
+	Version:
+		0.0.1-src
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+	"github.com/theovassiliou/hc2-tools/pkg/lint"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Lint"
+)
+
+var conf = config{}
+
+type config struct {
+	LuaScripts []string  `type:"arg" name:"lua-script" help:"<lua-script> the files, globs or directories to be checked"`
+	LogLevel   log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile    string    `help:"The config file to use"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+	Platform string `opts:"group=HC2" help:"Platform of the Fibaro system, HC2 or HC3. Detected if not set"`
+
+	Live       bool     `help:"Check device ids, actions and global variables against the HC2"`
+	Disable    []string `help:"Rule not to be applied. Can be given multiple times."`
+	ListRules  bool     `help:"List the rules and exit"`
+	JSON       bool     `help:"Print the diagnostics as JSON"`
+	DontExpand bool     `help:"Report require statements, even if expanded by hc2UploadScene"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		LogLevel: log.InfoLevel,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	if conf.ListRules {
+		listRules()
+		os.Exit(0)
+	}
+
+	cfg := lint.Config{Disabled: conf.Disable, ExpandRequire: !conf.DontExpand}
+	if conf.Live {
+		fc := controller()
+		var err error
+		if cfg.Devices, err = fc.AllDevices(); err != nil {
+			log.Fatalf("Could not retrieve devices: %v", err)
+		}
+		if cfg.Globals, err = fc.AllGlobals(); err != nil {
+			log.Fatalf("Could not retrieve global variables: %v", err)
+		}
+	}
+
+	files, err := luaFiles(conf.LuaScripts)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(files) == 0 {
+		log.Fatalln("No lua-script given. Aborting.")
+	}
+
+	diagnostics := []lint.Diagnostic{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Could not read file %s: %v", file, err)
+		}
+		diagnostics = append(diagnostics, lint.Lint(file, string(b), cfg)...)
+	}
+
+	if conf.JSON {
+		b, _ := json.MarshalIndent(diagnostics, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+	}
+	for _, d := range diagnostics {
+		if d.Severity == lint.Error {
+			os.Exit(1)
+		}
+	}
+}
+
+// controller connects to the HC2 as configured by the config file and the command line
+func controller() hc2.Controller {
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2UploadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+		}
+	}
+
+	if conf.User != "" {
+		f.Config().Username = conf.User
+	}
+	if conf.Password != "" {
+		f.Config().Password = conf.Password
+	}
+	if conf.URL != "" {
+		f.Config().BaseURL = conf.URL
+	}
+	if conf.Platform != "" {
+		f.Config().Platform = conf.Platform
+	}
+
+	fc, err := hc2.NewController(*f.Config())
+	if err != nil {
+		log.Fatalf("Could not contact Fibaro system: %v", err)
+	}
+	return fc
+}
+
+func listRules() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range lint.Rules {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, r.Severity, r.Description)
+	}
+	w.Flush()
+}
+
+// luaFiles expands the lua-script arguments. Globs are expanded, directories are searched
+// recursively for .lua files, skipping hidden directories. Every file is returned once.
+func luaFiles(args []string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, arg := range args {
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if paths, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", arg, err)
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("no file matches %s", arg)
+			}
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() && p != path && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				if !info.IsDir() && filepath.Ext(p) == ".lua" {
+					add(p)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,173 @@
+/*
+hc2Mqtt bridges a Fibaro HC2 to an MQTT broker. It publishes the state of devices, global variables and scenes, and calls the actions received from MQTT.
+
+	Usage: hc2Mqtt [options]
+
+	Options:
+	--log-level, -l     Log level, one of panic, fatal, error, warn or warning, info, debug, trace
+	--cfg-file, -c      The config file to use
+	--version, -v       display version
+	--help, -h          display help
+
+	HC2 options:
+	--user, -u          Username for HC2 authentication
+	--password, -p      Password for HC2 authentication
+	--url               URL of the Fibaro HC2 system, in the form http://...
+	--platform          Platform of the Fibaro system, HC2 or HC3. Detected if not set
+
+	MQTT options:
+	--broker, -b        URL of the MQTT broker, in the form tcp://host:port (default tcp://localhost:1883)
+	--mqtt-user, -m     Username for MQTT authentication
+	--mqtt-password     Password for MQTT authentication
+	--client-id         Client id used to connect to the broker (default hc2Mqtt)
+	--prefix            First level of all topics (default hc2)
+	--property          Property of devices to publish. Can be given multiple times.
+	--discovery, -d     Announce the devices to Home Assistant by MQTT discovery
+	--discovery-prefix  Topic prefix of the Home Assistant MQTT discovery (default homeassistant)
+	--resync, -r        How often rooms, devices, scenes and global variables are reloaded (default 10m0s)
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+	"github.com/theovassiliou/hc2-tools/pkg/hc2mqtt"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Mqtt"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+	Platform string `opts:"group=HC2" help:"Platform of the Fibaro system, HC2 or HC3. Detected if not set"`
+
+	Broker          string        `opts:"group=MQTT" help:"URL of the MQTT broker, in the form tcp://host:port"`
+	MqttUser        string        `opts:"group=MQTT" help:"Username for MQTT authentication"`
+	MqttPassword    string        `opts:"group=MQTT" help:"Password for MQTT authentication"`
+	ClientID        string        `opts:"group=MQTT" help:"Client id used to connect to the broker"`
+	Prefix          string        `opts:"group=MQTT" help:"First level of all topics"`
+	Property        []string      `opts:"group=MQTT" help:"Property of devices to publish. Can be given multiple times. Default value, dead, batteryLevel, energy, power and armed"`
+	Discovery       bool          `opts:"group=MQTT" help:"Announce the devices to Home Assistant by MQTT discovery"`
+	DiscoveryPrefix string        `opts:"group=MQTT" help:"Topic prefix of the Home Assistant MQTT discovery"`
+	Resync          time.Duration `opts:"group=MQTT" help:"How often rooms, devices, scenes and global variables are reloaded"`
+}
+
+const shortUsage = "Bridges a Fibaro HC2 to an MQTT broker"
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:         workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		LogLevel:        log.InfoLevel,
+		Broker:          "tcp://localhost:1883",
+		ClientID:        cmdName,
+		Prefix:          "hc2",
+		DiscoveryPrefix: "homeassistant",
+		Resync:          10 * time.Minute,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Summary(shortUsage).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.Platform != "" {
+		cfg := f.Config()
+		cfg.Platform = conf.Platform
+	}
+
+	fc, err := hc2.NewController(*f.Config())
+	if err != nil {
+		log.Fatalf("Could not contact Fibaro system: %v", err)
+	}
+
+	mqttOpts := mqtt.NewClientOptions().
+		AddBroker(conf.Broker).
+		SetClientID(conf.ClientID).
+		SetUsername(conf.MqttUser).
+		SetPassword(conf.MqttPassword)
+
+	bridge := hc2mqtt.New(fc, mqttOpts, hc2mqtt.Options{
+		Prefix:          conf.Prefix,
+		Properties:      conf.Property,
+		Discovery:       conf.Discovery,
+		DiscoveryPrefix: conf.DiscoveryPrefix,
+		Resync:          conf.Resync,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+
+	log.Infof("Bridging %s to %s\n", f.Config().BaseURL, conf.Broker)
+	if err := bridge.Run(ctx); err != nil && err != context.Canceled {
+		log.Fatalln(err)
+	}
+}
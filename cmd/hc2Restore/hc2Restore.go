@@ -0,0 +1,125 @@
+/*
+hc2Restore re-creates the sections, rooms, global variables and scenes of a backup
+written by hc2Backup on a Fibaro HC2 system, e.g. a fresh or a simulated one.
+
+Sections and rooms with the same name are reused, global variables and scenes with
+the same name are updated, everything else is created. As the ids of created items
+change, rooms are assigned to the restored sections and scenes to the restored rooms,
+with @sceneID and @roomID of their FIBARO_GIT_HOOK headers rewritten accordingly.
+
+	Usage: hc2Restore [options] <backup-dir>
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Restore"
+)
+
+var conf = config{}
+
+type config struct {
+	BackupDir string    `type:"arg" help:"<backup-dir> the directory written by hc2Backup"`
+	LogLevel  log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile   string    `help:"The config file to use"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		LogLevel: log.InfoLevel,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	actions, err := f.Restore(conf.BackupDir)
+	printActions(actions)
+	if err != nil {
+		log.Fatalf("Could not restore %s: %v", conf.BackupDir, err)
+	}
+}
+
+func printActions(actions []hc2.RestoreAction) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tOLD ID\tNEW ID\tRESULT")
+	for _, a := range actions {
+		result := "created"
+		switch {
+		case a.Created:
+		case a.Kind == "section" || a.Kind == "room":
+			result = "reused"
+		default:
+			result = "updated"
+		}
+		if a.Kind == "global" {
+			fmt.Fprintf(w, "%s\t%s\t\t\t%s\n", a.Kind, a.Name, result)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", a.Kind, a.Name, a.OldID, a.NewID, result)
+	}
+	w.Flush()
+}
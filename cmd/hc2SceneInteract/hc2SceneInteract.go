@@ -34,12 +34,14 @@ type config struct {
 	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
 	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
 	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
-
-	SceneID  int       `opts:"group=Generic command" help:"The sceneId that shall be used."`
-	File     string    `opts:"group=Generic command" help:"sceneID is taken from <lua-script-file> with fibaro header. sceneID flag is ignored."`
-	Tail     bool      `opts:"group=Generic command" help:"The -t option causes get-debug to not stop when all debug messages are read, but rather to wait for additional data to be appended to the input."`
-	CfgFile  string    `help:"The config file to use"`
-	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	Platform string `opts:"group=HC2" help:"Platform of the Fibaro system, HC2 or HC3. Detected if not set"`
+
+	SceneID   int       `opts:"group=Generic command" help:"The sceneId that shall be used."`
+	File      string    `opts:"group=Generic command" help:"sceneID is taken from <lua-script-file> with fibaro header. sceneID flag is ignored."`
+	Tail      bool      `opts:"group=Generic command" help:"The -t option causes get-debug to not stop when all debug messages are read, but rather to wait for additional data to be appended to the input."`
+	SourceMap string    `opts:"group=Generic command" help:"Source map written by hc2UploadScene, used to rewrite line numbers in debug messages to the original files. Default <lua-script-file>.map"`
+	CfgFile   string    `help:"The config file to use"`
+	LogLevel  log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
 }
 
 var m = map[string]*regexp.Regexp{
@@ -101,23 +103,39 @@ func main() {
 		cfg.BaseURL = conf.URL
 	}
 
+	if conf.Platform != "" {
+		cfg := f.Config()
+		cfg.Platform = conf.Platform
+	}
+
 	if conf.Init {
-		var i int
 		var filePath string
 		if conf.CfgFile != "" {
 			filePath = conf.CfgFile
 		} else {
 			filePath = workingHomeDir + "/" + hc2.Hc2DefaultConfigFile
 		}
-		i = f.WriteInitConfigFile(filePath)
+		i, err := f.WriteInitConfigFile(filePath)
+		if err != nil {
+			log.Fatalf("Could not write config file: %v", err)
+		}
 		log.Debugf("Wrote to file %s %d bytes", filePath, i)
 	}
 
 	if conf.Test {
-		fmt.Println(f.Info(2))
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC2: %v", err)
+		}
+		fmt.Println(info)
 		os.Exit(0)
 	}
 
+	fc, err := hc2.NewController(*f.Config())
+	if err != nil {
+		log.Fatalf("Could not contact Fibaro system: %v", err)
+	}
+
 	if conf.Action == hc2.Undef && !conf.GetDebug {
 		log.Errorln("Nothing to be done. Aborting.")
 	}
@@ -129,16 +147,20 @@ func main() {
 		if conf.File == "" {
 			log.Fatalln("No SceneID and no file given. Aborting.")
 		}
-		hc2Scene.ParseFile(conf.File, false) // will exit if no such file
+		if err := hc2Scene.ParseFile(conf.File, false); err != nil {
+			log.Fatalf("Could not read file %s: %v", conf.File, err)
+		}
 		if hc2Scene.SceneID == -1 {
 			log.Fatalf("No SceneID included in file %s. Aborting", conf.File)
 		}
 	}
-	runAction(f, hc2Scene.SceneID)
-	runGetMessage(f, hc2Scene.SceneID)
+	runAction(fc, hc2Scene.SceneID)
+	if err := runGetMessage(fc, hc2Scene.SceneID, loadSourceMap(fc, hc2Scene.SceneID)); err != nil {
+		log.Fatalf("Could not retrieve debug messages: %v", err)
+	}
 }
 
-func runAction(f *hc2.FibaroHc2, sceneID int) error {
+func runAction(f hc2.Controller, sceneID int) error {
 	if conf.Action == hc2.Undef {
 		return nil
 	}
@@ -155,29 +177,64 @@ func runAction(f *hc2.FibaroHc2, sceneID int) error {
 	return nil
 }
 
-func runGetMessage(f *hc2.FibaroHc2, sceneID int) error {
+// loadSourceMap returns the source map of the scene, or nil if there is none or it is
+// outdated.
+func loadSourceMap(f hc2.Controller, sceneID int) hc2.SourceMap {
+	path := conf.SourceMap
+	if !conf.GetDebug || path == "" && conf.File == "" {
+		return nil
+	}
+	if path == "" {
+		path = conf.File + hc2.SourceMapSuffix
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+	sm, err := hc2.ReadSourceMap(path)
+	if err != nil {
+		log.Warnf("Could not read source map: %v", err)
+		return nil
+	}
+	if sm.SceneID != sceneID {
+		log.Warnf("Source map %s is for scene %d, not for scene %d. Line numbers are not rewritten.", path, sm.SceneID, sceneID)
+		return nil
+	}
+	scene, err := f.OneScene(sceneID)
+	if err != nil {
+		log.Warnf("Could not retrieve scene %d: %v", sceneID, err)
+		return nil
+	}
+	if hc2.LuaHash(scene.Lua) != sm.Hash {
+		log.Warnf("Scene %d has been modified since the source map %s was written. Line numbers are not rewritten.", sceneID, path)
+		return nil
+	}
+	log.Debugf("Rewriting line numbers with source map %s", path)
+	return sm.Lines
+}
+
+// runGetMessage prints the debug messages of the scene. Line numbers are rewritten
+// with sourceMap, if given.
+func runGetMessage(f hc2.Controller, sceneID int, sourceMap hc2.SourceMap) error {
 
 	if !conf.GetDebug {
 		return nil
 	}
 
-	var lenPrinted int
-	var firstTimestamp int64
+	var printed debugCursor
 
 	for {
-		dm := f.DebugMessages(sceneID)
-		if len(dm) > 0 {
-			if dm[0].Timestamp > firstTimestamp {
-				firstTimestamp = dm[0].Timestamp
-				lenPrinted = 0
-			}
+		dm, err := f.DebugMessages(sceneID)
+		if err != nil {
+			return err
 		}
-		for ; lenPrinted < len(dm); lenPrinted++ {
-			value := dm[lenPrinted]
+		for _, value := range printed.unseen(dm) {
 			t := time.Unix(value.Timestamp, 0)
 			var s string
 			s = m["span-color-open"].ReplaceAllString(value.Txt, "")
 			s = m["span-color-close"].ReplaceAllString(s, "")
+			if sourceMap != nil {
+				s = sourceMap.Rewrite(s)
+			}
 			fmt.Printf("[%s] %s: %s\n", value.Type, t.Format("15:04:05"), s)
 		}
 		if !conf.Tail {
@@ -187,3 +244,36 @@ func runGetMessage(f *hc2.FibaroHc2, sceneID int) error {
 	}
 	return nil
 }
+
+// debugCursor remembers the last debug message printed. The HC2 returns the messages
+// of the last run of a scene, the HC3 a rolling window of the latest messages, so the
+// position in the list can only be used for the HC2.
+type debugCursor struct {
+	id    int                 // id of the last message printed, HC3 only
+	n     int                 // number of messages printed of the current run, HC2 only
+	first hc2.Hc2DebugMessage // first message of the current run, HC2 only
+}
+
+// unseen returns the messages of dm, oldest first, that are newer than the ones
+// returned before. Messages with id are compared by their id. Messages without id,
+// from the HC2, by their position, unless the list shrank or starts with another
+// message, as the scene has been run again.
+func (c *debugCursor) unseen(dm []hc2.Hc2DebugMessage) []hc2.Hc2DebugMessage {
+	if len(dm) > 0 && dm[0].ID == 0 {
+		if len(dm) < c.n || dm[0] != c.first {
+			c.n = 0
+		}
+		messages := dm[c.n:]
+		c.n, c.first = len(dm), dm[0]
+		return messages
+	}
+
+	var messages []hc2.Hc2DebugMessage
+	for _, m := range dm {
+		if m.ID > c.id {
+			messages = append(messages, m)
+			c.id = m.ID
+		}
+	}
+	return messages
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+func TestDebugCursor_unseen(t *testing.T) {
+	msg := func(id int, ts int64, txt string) hc2.Hc2DebugMessage {
+		return hc2.Hc2DebugMessage{ID: id, Timestamp: ts, Txt: txt}
+	}
+	tests := []struct {
+		name  string
+		polls [][]hc2.Hc2DebugMessage
+		want  [][]string
+	}{
+		{"HC3 rolling window",
+			[][]hc2.Hc2DebugMessage{
+				{msg(1, 100, "a"), msg(2, 100, "b"), msg(3, 101, "c")},
+				{msg(2, 100, "b"), msg(3, 101, "c"), msg(4, 102, "d")},
+				{msg(4, 102, "d")},
+			},
+			[][]string{{"a", "b", "c"}, {"d"}, nil}},
+		{"HC2 messages of the same second",
+			[][]hc2.Hc2DebugMessage{
+				{msg(0, 100, "a"), msg(0, 101, "b")},
+				{msg(0, 100, "a"), msg(0, 101, "b"), msg(0, 101, "c")},
+			},
+			[][]string{{"a", "b"}, {"c"}}},
+		{"HC2 scene rerun in the same second",
+			[][]hc2.Hc2DebugMessage{
+				{msg(0, 100, "a"), msg(0, 101, "b")},
+				{msg(0, 101, "x"), msg(0, 101, "y"), msg(0, 101, "z")},
+				{msg(0, 101, "x"), msg(0, 101, "y"), msg(0, 101, "z")},
+			},
+			[][]string{{"a", "b"}, {"x", "y", "z"}, nil}},
+		{"HC2 shorter rerun",
+			[][]hc2.Hc2DebugMessage{
+				{msg(0, 100, "a"), msg(0, 101, "b")},
+				{msg(0, 100, "a")},
+			},
+			[][]string{{"a", "b"}, {"a"}}},
+		{"HC2 scene restarted",
+			[][]hc2.Hc2DebugMessage{
+				{msg(0, 100, "a")},
+				{msg(0, 200, "x"), msg(0, 201, "y")},
+			},
+			[][]string{{"a"}, {"x", "y"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c debugCursor
+			for i, dm := range tt.polls {
+				var got []string
+				for _, m := range c.unseen(dm) {
+					got = append(got, m.Txt)
+				}
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("poll %d: unseen() = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
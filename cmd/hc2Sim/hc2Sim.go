@@ -0,0 +1,116 @@
+/*
+hc2Sim simulates the REST API of a Fibaro HC2 system, for developing and testing scenes without a real HC2.
+
+	Usage: hc2Sim [options]
+
+	Options:
+	--log-level, -l   Log level, one of panic, fatal, error, warn or warning, info, debug, trace
+	--listen          Address to listen on (default localhost:8080)
+	--state-file, -s  JSON file with the initial state of the simulated HC2
+	--save            Write the state back into the state file on exit
+	--version, -v     display version
+	--help, -h        display help
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+	"github.com/theovassiliou/hc2-tools/pkg/hc2sim"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Sim"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel  log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	Listen    string    `help:"Address to listen on"`
+	StateFile string    `help:"JSON file with the initial state of the simulated HC2"`
+	Save      bool      `help:"Write the state back into the state file on exit"`
+}
+
+const shortUsage = "Simulates the REST API of a Fibaro HC2"
+
+func main() {
+	conf = config{
+		Listen:   "localhost:8080",
+		LogLevel: log.InfoLevel,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Summary(shortUsage).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	if conf.Save && conf.StateFile == "" {
+		log.Fatalln("--save requires a --state-file")
+	}
+
+	var state hc2sim.State
+	if conf.StateFile != "" {
+		f, err := os.Open(conf.StateFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		state, err = hc2sim.LoadState(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Could not read state file %s: %v\n", conf.StateFile, err)
+		}
+	}
+	sim := hc2sim.New(state)
+
+	server := &http.Server{Addr: conf.Listen, Handler: logRequests(sim)}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		server.Close()
+	}()
+
+	log.Infof("Simulating HC2 on http://%s\n", conf.Listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalln(err)
+	}
+
+	if conf.Save {
+		b, err := json.MarshalIndent(sim.Snapshot(), "", "  ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := ioutil.WriteFile(conf.StateFile, append(b, '\n'), 0644); err != nil {
+			log.Fatalln(err)
+		}
+		log.Infof("State written to %s\n", conf.StateFile)
+	}
+}
+
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Debugf("%s %s\n", r.Method, r.URL.Path)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,295 @@
+/*
+hc2Sync synchronises a directory of lua scenes with the scenes of a Fibaro HC2 system.
+
+Scenes are identified by the @sceneID of their FIBARO_GIT_HOOK header. The version of
+every scene at the last synchronisation is recorded in a state file. Scenes changed only
+in the HC2 are pulled, scenes changed only locally are pushed, and scenes changed on both
+sides are reported as conflicts together with a diff. Nothing is overwritten in case of a
+conflict.
+
+	Usage: hc2Sync [options]
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2Sync"
+)
+
+var conf = config{}
+
+type config struct {
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+
+	Dir       string `opts:"group=Sync" help:"The directory containing the lua scenes"`
+	StateFile string `opts:"group=Sync" help:"The file recording the last synchronised versions. Defaults to .hc2sync.json in dir"`
+	DryRun    bool   `opts:"group=Sync" help:"Only report what would be done"`
+	Force     bool   `opts:"group=Sync" help:"Push scenes even if they contain Lua syntax errors"`
+
+	DontExpand bool   `opts:"group=Require Expand" help:"Don't expand the require statements"`
+	ExpandPath string `opts:"group=Require Expand" help:"Where to search for the included libraries"`
+	LuaPath    string `opts:"group=Require Expand" help:"Search path for the included libraries, templates separated by ; where ? is replaced by the module name"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		LogLevel: log.InfoLevel,
+		Dir:      ".",
+		LuaPath:  hc2.DefaultLuaPath,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2DownloadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.StateFile == "" {
+		conf.StateFile = filepath.Join(conf.Dir, hc2.SyncStateFile)
+	}
+
+	state, err := hc2.ReadSyncState(conf.StateFile)
+	if err != nil {
+		log.Fatalf("Could not read state file %s: %v", conf.StateFile, err)
+	}
+
+	local, untracked, err := hc2.ReadLocalScenes(conf.Dir)
+	if err != nil {
+		log.Fatalf("Could not read scenes from %s: %v", conf.Dir, err)
+	}
+	for _, u := range untracked {
+		log.Infof("ignoring %s without FIBARO_GIT_HOOK header\n", u)
+	}
+	unpushable := prepareScenes(local)
+
+	remote, err := remoteScenes(f)
+	if err != nil {
+		log.Fatalf("Could not retrieve scenes: %v", err)
+	}
+
+	meta := hc2.NewMetadataCache(f)
+	layout, _ := hc2.NewSceneLayout(hc2.DefaultSceneLayout, false)
+	files := &hc2.SceneFiles{BaseDir: conf.Dir, Layout: layout}
+	var conflicts, failed int
+	counts := map[hc2.SyncActionKind]int{}
+	for _, a := range hc2.PlanSync(local, remote, state) {
+		counts[a.Kind]++
+		switch a.Kind {
+		case hc2.SyncUnchanged:
+			state[a.SceneID] = hc2.SyncStateEntry{Path: a.Local.Path, Hash: hc2.LuaHash(a.Local.Scene.Lua)}
+		case hc2.SyncPull:
+			path, err := pullPath(meta, files, a)
+			if errors.Is(err, hc2.ErrFileConflict) {
+				counts[a.Kind]--
+				conflicts++
+				fmt.Printf("conflict %4d %s, not pulled: %v\n", a.SceneID, a.Remote.Name, err)
+				continue
+			} else if err != nil {
+				log.Errorf("Could not determine the file of scene %d: %v", a.SceneID, err)
+				failed++
+				continue
+			}
+			fmt.Printf("pull     %4d %s\n", a.SceneID, path)
+			if conf.DryRun {
+				continue
+			}
+			if err := pull(*a.Remote, path); err != nil {
+				log.Errorf("Could not write scene %d to %s: %v", a.SceneID, path, err)
+				failed++
+				continue
+			}
+			state[a.SceneID] = hc2.SyncStateEntry{Path: path, Hash: hc2.LuaHash(a.Remote.Lua)}
+		case hc2.SyncPush:
+			if err := unpushable[a.Local.Path]; err != nil {
+				log.Errorf("Could not upload scene %d from %s: %v", a.SceneID, a.Local.Path, err)
+				failed++
+				counts[a.Kind]--
+				continue
+			}
+			fmt.Printf("push     %4d %s\n", a.SceneID, a.Local.Path)
+			if conf.DryRun {
+				continue
+			}
+			if _, err := f.PutOneScene(a.Local.Scene); err != nil {
+				log.Errorf("Could not upload scene %d from %s: %v", a.SceneID, a.Local.Path, err)
+				failed++
+				continue
+			}
+			state[a.SceneID] = hc2.SyncStateEntry{Path: a.Local.Path, Hash: hc2.LuaHash(a.Local.Scene.Lua)}
+		case hc2.SyncConflict:
+			conflicts++
+			fmt.Printf("conflict %4d %s\n", a.SceneID, a.Local.Path)
+			fmt.Print(a.Diff())
+		case hc2.SyncDeletedLocal:
+			fmt.Printf("deleted  %4d %s has been deleted locally, scene is kept in HC2\n", a.SceneID, state[a.SceneID].Path)
+		case hc2.SyncDeletedRemote:
+			fmt.Printf("deleted  %4d %s has been deleted in HC2, file is kept\n", a.SceneID, a.Local.Path)
+		case hc2.SyncUnknownRemote:
+			fmt.Printf("unknown  %4d %s refers to a scene not existing in HC2\n", a.SceneID, a.Local.Path)
+		case hc2.SyncDuplicate:
+			conflicts++
+			fmt.Printf("conflict %4d is used by %s, nothing is synchronised\n", a.SceneID, strings.Join(a.Duplicates, ", "))
+		}
+	}
+
+	if !conf.DryRun {
+		if err := state.Write(conf.StateFile); err != nil {
+			log.Fatalf("Could not write state file %s: %v", conf.StateFile, err)
+		}
+	}
+
+	log.Infof("unchanged %d, pulled %d, pushed %d, conflicts %d, failed %d\n",
+		counts[hc2.SyncUnchanged], counts[hc2.SyncPull], counts[hc2.SyncPush], conflicts, failed)
+	if conflicts > 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// prepareScenes replaces the Lua code of the local scenes by the code uploaded to the HC2,
+// with the require statements expanded as by hc2UploadScene, so that it can be compared
+// with the remote scenes. It returns the errors of the scenes that can not be pushed, by
+// path. Scenes with syntax errors can be pushed with --force.
+func prepareScenes(local []hc2.LocalScene) map[string]error {
+	var e *hc2.RequireExpander
+	if !conf.DontExpand {
+		e = hc2.NewRequireExpander(conf.LuaPath)
+		e.Dir = conf.ExpandPath
+		e.Ignore = hc2.DefaultRequireIgnore
+	}
+	unpushable := map[string]error{}
+	for i := range local {
+		l := &local[i]
+		lua, _, err := hc2.PrepareLua(e, l.Scene.Lua, filepath.Join(conf.Dir, l.Path))
+		if errors.Is(err, hc2.ErrLuaSyntax) {
+			if !conf.Force {
+				unpushable[l.Path] = fmt.Errorf("not pushed, use --force to push anyway: %w", err)
+			}
+		} else if err != nil {
+			unpushable[l.Path] = err
+			continue
+		}
+		l.Scene.Lua = lua
+	}
+	return unpushable
+}
+
+// remoteScenes downloads all scenes including their lua code
+func remoteScenes(f *hc2.FibaroHc2) ([]hc2.Hc2Scene, error) {
+	all, err := f.AllScenes()
+	if err != nil {
+		return nil, err
+	}
+	scenes := make([]hc2.Hc2Scene, 0, len(all))
+	for _, s := range all {
+		if !s.IsLua {
+			continue
+		}
+		scene, err := f.OneScene(s.SceneID)
+		if err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, nil
+}
+
+// pullPath returns the path, relative to the synchronised directory, a remote scene is written to.
+// Known scenes keep their path. New scenes are placed in section/room/name.lua, with sanitised
+// names, unless that file belongs to another scene. It fails with ErrFileConflict if no file
+// can be used without overwriting another scene.
+func pullPath(f hc2.Controller, files *hc2.SceneFiles, a hc2.SyncAction) (string, error) {
+	if a.Local != nil {
+		return a.Local.Path, nil
+	}
+	room, err := f.OneRoom(a.Remote.RoomID)
+	if err != nil && !errors.Is(err, hc2.ErrNotFound) {
+		log.Errorf("Could not retrieve room %d: %v", a.Remote.RoomID, err)
+	}
+	section, err := f.OneSection(room.SectionID)
+	if err != nil && !errors.Is(err, hc2.ErrNotFound) {
+		log.Errorf("Could not retrieve section %d: %v", room.SectionID, err)
+	}
+	file, _, err := files.Path(*a.Remote, room, section)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(conf.Dir, file)
+}
+
+// pull writes the scene with an up-to-date FIBARO_GIT_HOOK header to path
+func pull(scene hc2.Hc2Scene, path string) error {
+	path = filepath.Join(conf.Dir, path)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if scene.Lua == "" {
+		scene.Lua = "\n"
+	}
+	scene.UpdateLuaHeader()
+	return ioutil.WriteFile(path, []byte(scene.Lua), 0644)
+}
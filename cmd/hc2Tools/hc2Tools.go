@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"text/template"
 
 	"github.com/jpillora/opts"
@@ -49,7 +56,7 @@ func (cmd *devices) Run() {
 			i++
 			fmt.Printf("%d %s: %s with ID: %d\n", i, device.Name, device.Type, device.ID)
 		} else if selected(cmd.DeviceIds, device.ID) {
-			fmt.Printf("%#v\n\n", device)
+			printDevice(device)
 		}
 	}
 
@@ -77,7 +84,9 @@ func (cmd *showRemoteController) Run() {
 				fmt.Printf("%d %s: %s with ID: %d \n", i, device.Name, device.Type, device.ID)
 			} else if selected(cmd.DeviceIds, device.ID) {
 				var s []hc2.Key
-				json.Unmarshal([]byte(device.Properties.CentralSceneSupport.(string)), &s)
+				if support, err := device.Properties.CentralSceneSupport.String(); err == nil {
+					json.Unmarshal([]byte(support), &s)
+				}
 				fmt.Printf("\n%d %s: %s with ID: %d", i, device.Name, device.Type, device.ID)
 				err := tmpl.Execute(os.Stdout, s)
 				if err != nil {
@@ -128,7 +137,7 @@ func (cmd *showSceneActivation) Run() {
 			if cmd.DeviceIds == nil {
 				fmt.Printf("%d %s: %s with ID: %d\n", i, device.Name, device.Type, device.ID)
 			} else if selected(cmd.DeviceIds, device.ID) {
-				fmt.Printf("%#v\n\n", device)
+				printDevice(device)
 			}
 		}
 	}
@@ -169,6 +178,869 @@ func (cmd *showHues) Run() {
 
 }
 
+type triggers struct {
+	File         string   `type:"arg" name:"file" help:"Lua scene to show or edit the triggers of"`
+	AddProperty  []string `help:"add a property trigger, in the form <deviceID>:<property>"`
+	AddEvent     []string `help:"add an event trigger, in the form <deviceID>:<event>"`
+	AddGlobal    []string `help:"add a global variable trigger"`
+	RemoveDevice []int    `help:"remove all triggers of a device"`
+	RemoveGlobal []string `help:"remove a global variable trigger"`
+	Check        bool     `help:"check that the referenced devices and global variables exist in the HC2"`
+	Write        bool     `help:"write the triggers back into the file, instead of printing them"`
+}
+
+const triggersUsage = "Show, check and edit the trigger block (properties, events, globals) of a scene"
+
+func (cmd *triggers) Run() {
+	b, err := ioutil.ReadFile(cmd.File)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	lua := string(b)
+	t, found, err := hc2.ParseSceneTriggers(lua)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd.File, err)
+	}
+	if !found {
+		log.Infof("%s has no trigger block\n", cmd.File)
+	}
+
+	for _, p := range cmd.AddProperty {
+		id, name := splitTrigger(p)
+		t.Properties = append(t.Properties, hc2.PropertyTrigger{DeviceID: id, Property: name})
+	}
+	for _, e := range cmd.AddEvent {
+		id, name := splitTrigger(e)
+		t.Events = append(t.Events, hc2.EventTrigger{DeviceID: id, Event: name})
+	}
+	t.Globals = append(t.Globals, cmd.AddGlobal...)
+	for _, id := range cmd.RemoveDevice {
+		var properties []hc2.PropertyTrigger
+		for _, p := range t.Properties {
+			if p.DeviceID != id {
+				properties = append(properties, p)
+			}
+		}
+		var events []hc2.EventTrigger
+		for _, e := range t.Events {
+			if e.DeviceID != id {
+				events = append(events, e)
+			}
+		}
+		t.Properties, t.Events = properties, events
+	}
+	for _, name := range cmd.RemoveGlobal {
+		var globals []string
+		for _, g := range t.Globals {
+			if g != name {
+				globals = append(globals, g)
+			}
+		}
+		t.Globals = globals
+	}
+
+	if cmd.Check {
+		if err := f.ValidateTriggers(t); err != nil {
+			log.Fatalf("%s: %v", cmd.File, err)
+		}
+	}
+
+	if cmd.Write {
+		if err := ioutil.WriteFile(cmd.File, []byte(hc2.SetSceneTriggers(lua, t)), 0644); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	fmt.Println(t.ToComment())
+}
+
+// splitTrigger splits a trigger given as <deviceID>:<name>
+func splitTrigger(s string) (int, string) {
+	i := strings.Index(s, ":")
+	if i == -1 {
+		log.Fatalf("%q is not in the form <deviceID>:<name>", s)
+	}
+	id, err := strconv.Atoi(s[:i])
+	if err != nil {
+		log.Fatalf("%q has an invalid deviceID", s)
+	}
+	return id, s[i+1:]
+}
+
+type xref struct {
+	Device  []int    `help:"show only references to this device (allows multiple)"`
+	Global  []string `help:"show only references to this global variable (allows multiple)"`
+	NoVds   bool     `help:"do not scan virtual devices"`
+	Unknown bool     `help:"show only references to devices, scenes and global variables that do not exist"`
+}
+
+const xrefUsage = "Lists which scenes and virtual devices reference which devices, scenes and global variables"
+
+func (cmd *xref) Run() {
+	x := hc2.NewXRef()
+
+	allScenes, err := f.AllScenes()
+	if err != nil {
+		log.Fatalf("Could not retrieve scenes: %v", err)
+	}
+	for _, s := range allScenes {
+		if !s.IsLua {
+			continue
+		}
+		scene, err := f.OneScene(s.SceneID)
+		if err != nil {
+			log.Fatalf("Could not retrieve scene %d: %v", s.SceneID, err)
+		}
+		x.AddScene(scene)
+	}
+	var vds []hc2.Hc2VirtualDevice
+	if !cmd.NoVds {
+		if vds, err = f.AllVirtualDevices(); err != nil {
+			log.Fatalf("Could not retrieve virtual devices: %v", err)
+		}
+		for _, vd := range vds {
+			x.AddVirtualDevice(vd)
+		}
+	}
+	allDevices, err := f.AllDevices()
+	if err != nil {
+		log.Fatalf("Could not retrieve devices: %v", err)
+	}
+	globals, err := f.AllGlobals()
+	if err != nil {
+		log.Fatalf("Could not retrieve global variables: %v", err)
+	}
+
+	unknownDevices, unknownScenes, unknownGlobals := x.Unknown(allDevices, vds, allScenes, globals)
+	devices := map[int]hc2.Hc2Device{}
+	for _, d := range allDevices {
+		devices[d.ID] = d
+	}
+	for _, vd := range vds {
+		if _, ok := devices[vd.ID]; !ok {
+			devices[vd.ID] = hc2.Hc2Device{ID: vd.ID, Name: vd.Name, RoomID: vd.RoomID}
+		}
+	}
+	scenes := map[int]hc2.Hc2Scene{}
+	for _, s := range allScenes {
+		scenes[s.SceneID] = s
+	}
+	meta := hc2.NewMetadataCache(f)
+	roomName := func(id int) string {
+		if id == 0 {
+			return "unassigned"
+		}
+		room, err := meta.OneRoom(id)
+		if err != nil {
+			log.Debugf("Could not retrieve room %d: %v", id, err)
+		}
+		return room.Name
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if cmd.Global == nil {
+		fmt.Fprintln(w, "DEVICE\tNAME\tROOM\tREFERENCED BY")
+		for _, id := range x.DeviceIDs() {
+			if !(cmd.Device == nil || selected(cmd.Device, id)) || cmd.Unknown && !selected(unknownDevices, id) {
+				continue
+			}
+			name, room := "!! unknown device", ""
+			if d, ok := devices[id]; ok {
+				name, room = d.Name, roomName(d.RoomID)
+			}
+			printUses(w, strconv.Itoa(id)+"\t"+name+"\t"+room, x.Devices[id])
+		}
+		fmt.Fprintln(w)
+	}
+	if cmd.Device == nil {
+		fmt.Fprintln(w, "GLOBAL\t\t\tREFERENCED BY")
+		for _, name := range x.GlobalNames() {
+			if !(cmd.Global == nil || contains(cmd.Global, name)) || cmd.Unknown && !contains(unknownGlobals, name) {
+				continue
+			}
+			flag := ""
+			if contains(unknownGlobals, name) {
+				flag = "!! unknown global"
+			}
+			printUses(w, name+"\t"+flag+"\t", x.Globals[name])
+		}
+		fmt.Fprintln(w)
+	}
+	if cmd.Device == nil && cmd.Global == nil {
+		fmt.Fprintln(w, "SCENE\tNAME\t\tREFERENCED BY")
+		for _, id := range x.SceneIDs() {
+			if cmd.Unknown && !selected(unknownScenes, id) {
+				continue
+			}
+			name := "!! unknown scene"
+			if s, ok := scenes[id]; ok {
+				name = s.Name
+			}
+			printUses(w, strconv.Itoa(id)+"\t"+name+"\t", x.Scenes[id])
+		}
+	}
+	w.Flush()
+
+	if len(unknownDevices) > 0 {
+		log.Warnf("References to unknown devices %v", unknownDevices)
+	}
+	if len(unknownScenes) > 0 {
+		log.Warnf("References to unknown scenes %v", unknownScenes)
+	}
+	if len(unknownGlobals) > 0 {
+		log.Warnf("References to unknown global variables %v", unknownGlobals)
+	}
+}
+
+// printUses prints the uses of an item, one per line, the first line prefixed by item
+func printUses(w io.Writer, item string, uses []hc2.XRefUse) {
+	columns := strings.Count(item, "\t")
+	for i, u := range uses {
+		if i > 0 {
+			item = strings.Repeat("\t", columns)
+		}
+		fmt.Fprintf(w, "%s\t%s %d %q line %d (%s)\n", item, u.Source.Kind, u.Source.ID, u.Source.Name, u.Ref.Line, u.Ref.Via)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+type rooms struct {
+	Devices bool `help:"list the devices of the rooms"`
+	Scenes  bool `help:"list the scenes of the rooms"`
+}
+
+const roomsUsage = "Lists the rooms grouped by sections, and creates, modifies, deletes rooms and moves devices and scenes between them"
+
+func (cmd *rooms) Run() {
+	printTree(cmd.Devices, cmd.Scenes)
+}
+
+type createRoom struct {
+	Name    string `type:"arg" help:"name of the room"`
+	Section string `help:"id or name of the section of the room"`
+	Icon    string `help:"icon of the room"`
+}
+
+const createRoomUsage = "Creates a new room"
+
+func (cmd *createRoom) Run() {
+	room := hc2.Hc2Room{Name: cmd.Name, Icon: cmd.Icon}
+	if cmd.Section != "" {
+		room.SectionID = findSection(cmd.Section).SectionID
+	}
+	created, err := f.CreateRoom(room)
+	if err != nil {
+		log.Fatalf("Could not create room: %v", err)
+	}
+	fmt.Printf("Created room %d %s\n", created.RoomID, created.Name)
+}
+
+type updateRoom struct {
+	Room              string `type:"arg" help:"id or name of the room"`
+	Name              string `help:"new name of the room"`
+	Section           string `help:"id or name of the new section of the room"`
+	Icon              string `help:"new icon of the room"`
+	Temperature       int    `help:"id of the device shown as temperature of the room, -1 for none"`
+	Humidity          int    `help:"id of the device shown as humidity of the room, -1 for none"`
+	Light             int    `help:"id of the device shown as light of the room, -1 for none"`
+	DefaultThermostat int    `help:"id of the thermostat of the room, -1 for none"`
+}
+
+const updateRoomUsage = "Modifies a room"
+
+// newUpdateRoom returns an updateRoom keeping the default devices of the room,
+// unless they are given
+func newUpdateRoom() *updateRoom {
+	return &updateRoom{Temperature: -2, Humidity: -2, Light: -2, DefaultThermostat: -2}
+}
+
+func (cmd *updateRoom) Run() {
+	room := findRoom(cmd.Room)
+	cmd.apply(&room)
+	if err := f.UpdateRoom(room); err != nil {
+		log.Fatalf("Could not update room %d: %v", room.RoomID, err)
+	}
+}
+
+// apply sets the properties of room given to the command
+func (cmd *updateRoom) apply(room *hc2.Hc2Room) {
+	if cmd.Name != "" {
+		room.Name = cmd.Name
+	}
+	if cmd.Section != "" {
+		room.SectionID = findSection(cmd.Section).SectionID
+	}
+	if cmd.Icon != "" {
+		room.Icon = cmd.Icon
+	}
+	setDevice(&room.DefaultSensors.Temperature, cmd.Temperature)
+	setDevice(&room.DefaultSensors.Humidity, cmd.Humidity)
+	setDevice(&room.DefaultSensors.Light, cmd.Light)
+	setDevice(&room.DefaultThermostat, cmd.DefaultThermostat)
+}
+
+// setDevice sets a default device of a room. -2 keeps the device, -1 removes it.
+func setDevice(id *int, flag int) {
+	switch {
+	case flag == -1:
+		*id = 0
+	case flag >= 0:
+		*id = flag
+	}
+}
+
+type deleteRoom struct {
+	Room string `type:"arg" help:"id or name of the room"`
+}
+
+const deleteRoomUsage = "Deletes a room. Its devices and scenes are moved to the default room by the HC2"
+
+func (cmd *deleteRoom) Run() {
+	room := findRoom(cmd.Room)
+	if err := f.DeleteRoom(room.RoomID); err != nil {
+		log.Fatalf("Could not delete room %d: %v", room.RoomID, err)
+	}
+}
+
+type moveToRoom struct {
+	Room   string `type:"arg" help:"id or name of the room to move to"`
+	Device []int  `help:"id of a device to move (allows multiple)"`
+	Scene  []int  `help:"id of a scene to move (allows multiple)"`
+	From   string `help:"id or name of a room, all of its devices and scenes are moved"`
+	DryRun bool   `help:"only print what would be moved"`
+}
+
+const moveToRoomUsage = "Moves devices and scenes into a room"
+
+func (cmd *moveToRoom) Run() {
+	room := findRoom(cmd.Room)
+	devices, scenes := cmd.Device, cmd.Scene
+	if cmd.From != "" {
+		from := findRoom(cmd.From)
+		allDevices, err := f.AllDevices()
+		if err != nil {
+			log.Fatalf("Could not retrieve devices: %v", err)
+		}
+		for _, d := range allDevices {
+			if d.RoomID == from.RoomID {
+				devices = append(devices, d.ID)
+			}
+		}
+		allScenes, err := f.AllScenes()
+		if err != nil {
+			log.Fatalf("Could not retrieve scenes: %v", err)
+		}
+		for _, s := range allScenes {
+			if s.RoomID == from.RoomID {
+				scenes = append(scenes, s.SceneID)
+			}
+		}
+	}
+	devices, scenes = unique(devices), unique(scenes)
+	if len(devices) == 0 && len(scenes) == 0 {
+		log.Fatalln("Nothing to move. Use --device, --scene or --from.")
+	}
+
+	failed := false
+	for _, id := range devices {
+		fmt.Printf("device %d -> room %d %s\n", id, room.RoomID, room.Name)
+		if cmd.DryRun {
+			continue
+		}
+		if err := f.MoveDevice(id, room.RoomID); err != nil {
+			log.Errorf("Could not move device %d: %v", id, err)
+			failed = true
+		}
+	}
+	for _, id := range scenes {
+		fmt.Printf("scene %d -> room %d %s\n", id, room.RoomID, room.Name)
+		if cmd.DryRun {
+			continue
+		}
+		scene, err := f.OneScene(id)
+		if err == nil {
+			scene.RoomID = room.RoomID
+			// the @roomID of the header would move the scene back on the next upload
+			if scene.HasLuaHeader() {
+				scene.UpdateLuaHeader()
+			}
+			_, err = f.PutOneScene(scene)
+		}
+		if err != nil {
+			log.Errorf("Could not move scene %d: %v", id, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type call struct {
+	Args   []string `type:"arg" name:"args" help:"[deviceId] action [arguments]"`
+	Room   string   `help:"id or name of a room, the action is called on all of its devices supporting it"`
+	Type   string   `help:"device type, e.g. com.fibaro.binarySwitch or binarySwitch, the action is called on all devices of the type supporting it"`
+	DryRun bool     `help:"only print the devices the action would be called on"`
+}
+
+const callUsage = "Calls an action on a device, or on all devices of a room or type, e.g. call 449 setValue 50"
+
+func (cmd *call) Run() {
+	args := cmd.Args
+	deviceID := 0
+	if len(args) > 0 {
+		if id, err := strconv.Atoi(args[0]); err == nil {
+			deviceID, args = id, args[1:]
+		}
+	}
+	if len(args) == 0 {
+		log.Fatalln("No action given.")
+	}
+	action, actionArgs := args[0], make([]interface{}, len(args)-1)
+	for i, a := range args[1:] {
+		actionArgs[i] = actionArg(a)
+	}
+	if deviceID == 0 && cmd.Room == "" && cmd.Type == "" {
+		log.Fatalln("No device given. Use a deviceId, --room or --type.")
+	}
+
+	var targets []hc2.Hc2Device
+	if deviceID != 0 {
+		device, err := f.OneDevice(deviceID)
+		if err != nil {
+			log.Fatalf("Could not retrieve device %d: %v", deviceID, err)
+		}
+		if err := device.ValidateAction(action, len(actionArgs)); err != nil {
+			log.Fatalln(err)
+		}
+		targets = append(targets, device)
+	}
+	if cmd.Room != "" || cmd.Type != "" {
+		roomID := -1
+		if cmd.Room != "" {
+			roomID = findRoom(cmd.Room).RoomID
+		}
+		allDevices, err := f.AllDevices()
+		if err != nil {
+			log.Fatalf("Could not retrieve devices: %v", err)
+		}
+		for _, d := range allDevices {
+			if d.ID == deviceID || roomID != -1 && d.RoomID != roomID || cmd.Type != "" && !isType(d, cmd.Type) {
+				continue
+			}
+			if err := groupTarget(d, action, len(actionArgs)); err != nil {
+				log.Debugf("Skipping %v", err)
+				continue
+			}
+			targets = append(targets, d)
+		}
+		if len(targets) == 0 {
+			log.Fatalf("No device supports action %q", action)
+		}
+	}
+
+	failed := false
+	for _, d := range targets {
+		fmt.Printf("device %d %s: %s\n", d.ID, d.Name, strings.Join(args, " "))
+		if cmd.DryRun {
+			continue
+		}
+		if err := f.CallAction(d.ID, action, actionArgs...); err != nil {
+			log.Errorf("Could not call %s on device %d: %v", action, d.ID, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// groupTarget returns an error if an action selected by room or type is not to be
+// called on d. Unlike for a device given by its id, the action must be declared by
+// the device, and hidden or disabled devices, like controllers or child nodes, are
+// left out.
+func groupTarget(d hc2.Hc2Device, action string, nargs int) error {
+	if !d.Visible || !d.Enabled {
+		return fmt.Errorf("device %d (%s) is hidden or disabled", d.ID, d.Type)
+	}
+	if _, ok := d.Actions[action]; !ok {
+		return fmt.Errorf("device %d (%s) has no action %q: %w", d.ID, d.Type, action, hc2.ErrInvalidAction)
+	}
+	return d.ValidateAction(action, nargs)
+}
+
+// actionArg converts an argument of an action given on the command line into a
+// number or bool, if possible
+func actionArg(s string) interface{} {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(s); err == nil && (s == "true" || s == "false") {
+		return b
+	}
+	return s
+}
+
+// isType returns whether the device is of type t, given as full type or as its last
+// component, ignoring case
+func isType(d hc2.Hc2Device, t string) bool {
+	if strings.EqualFold(d.Type, t) {
+		return true
+	}
+	i := strings.LastIndex(d.Type, ".")
+	return strings.EqualFold(d.Type[i+1:], t)
+}
+
+type watch struct {
+	Device []int    `help:"id of a device whose events are shown (allows multiple)"`
+	Room   []string `help:"id or name of a room whose devices' and scenes' events are shown (allows multiple)"`
+	Type   []string `help:"type of the events shown, one of device, global, sceneStarted, sceneFinished, centralScene, other (allows multiple)"`
+	JSON   bool     `help:"print the events as JSON, one per line"`
+	Last   int64    `help:"last of an event printed with --json, to resume after it"`
+}
+
+const watchUsage = "Prints device, global variable, scene and button events as they happen, until interrupted"
+
+func (cmd *watch) Run() {
+	for _, t := range cmd.Type {
+		known := false
+		for _, et := range hc2.EventTypes {
+			known = known || string(et) == t
+		}
+		if !known {
+			log.Fatalf("Unknown event type %q, use one of %v", t, hc2.EventTypes)
+		}
+	}
+
+	allDevices, err := f.AllDevices()
+	if err != nil {
+		log.Fatalf("Could not retrieve devices: %v", err)
+	}
+	names := map[int]string{}
+	for _, d := range allDevices {
+		names[d.ID] = d.Name
+	}
+	devices, scenes := map[int]bool{}, map[int]bool{}
+	for _, id := range cmd.Device {
+		devices[id] = true
+	}
+	for _, ref := range cmd.Room {
+		room := findRoom(ref)
+		for _, d := range allDevices {
+			if d.RoomID == room.RoomID {
+				devices[d.ID] = true
+			}
+		}
+		allScenes, err := f.AllScenes()
+		if err != nil {
+			log.Fatalf("Could not retrieve scenes: %v", err)
+		}
+		for _, s := range allScenes {
+			if s.RoomID == room.RoomID {
+				scenes[s.SceneID] = true
+			}
+		}
+	}
+	selective := len(cmd.Device) > 0 || len(cmd.Room) > 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	events, err := f.RefreshStates(ctx, cmd.Last)
+	if err != nil {
+		log.Fatalf("Could not retrieve events: %v", err)
+	}
+	for e := range events {
+		if e.Type == hc2.EventError {
+			log.Fatalf("Could not retrieve events: %v", e.Err)
+		}
+		if len(cmd.Type) > 0 && !contains(cmd.Type, string(e.Type)) {
+			continue
+		}
+		if selective {
+			switch e.Type {
+			case hc2.EventDeviceProperty, hc2.EventCentralScene:
+				if !devices[e.DeviceID] {
+					continue
+				}
+			case hc2.EventSceneStarted, hc2.EventSceneFinished:
+				if !scenes[e.SceneID] {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if cmd.JSON {
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Fatalf("Could not encode event: %v", err)
+			}
+			fmt.Println(string(b))
+		} else {
+			fmt.Println(formatEvent(e, names))
+		}
+	}
+}
+
+// formatEvent returns the event as one line of text
+func formatEvent(e hc2.Event, names map[int]string) string {
+	text := func(v hc2.PropertyValue) string {
+		s, _ := v.String()
+		return s
+	}
+	var msg string
+	switch e.Type {
+	case hc2.EventDeviceProperty:
+		msg = fmt.Sprintf("device %d %s: %s = %s", e.DeviceID, names[e.DeviceID], e.Property, text(e.Value))
+	case hc2.EventGlobal:
+		msg = fmt.Sprintf("global %s = %s", e.Global, text(e.Value))
+	case hc2.EventSceneStarted:
+		msg = fmt.Sprintf("scene %d started", e.SceneID)
+	case hc2.EventSceneFinished:
+		msg = fmt.Sprintf("scene %d finished", e.SceneID)
+	case hc2.EventCentralScene:
+		msg = fmt.Sprintf("device %d %s: key %d %s", e.DeviceID, names[e.DeviceID], e.KeyID, e.KeyAttribute)
+	default:
+		b, _ := json.Marshal(e.Data)
+		msg = fmt.Sprintf("%s %s", e.Name, b)
+	}
+	if e.OldValue.IsSet() {
+		msg += " (was " + text(e.OldValue) + ")"
+	}
+	return e.Time.Format("15:04:05") + " " + msg
+}
+
+type sections struct {
+	Devices bool `help:"list the devices of the rooms"`
+	Scenes  bool `help:"list the scenes of the rooms"`
+}
+
+const sectionsUsage = "Lists the sections with their rooms, and creates, renames and deletes sections"
+
+func (cmd *sections) Run() {
+	printTree(cmd.Devices, cmd.Scenes)
+}
+
+type createSection struct {
+	Name string `type:"arg" help:"name of the section"`
+}
+
+const createSectionUsage = "Creates a new section"
+
+func (cmd *createSection) Run() {
+	created, err := f.CreateSection(hc2.Hc2Section{Name: cmd.Name})
+	if err != nil {
+		log.Fatalf("Could not create section: %v", err)
+	}
+	fmt.Printf("Created section %d %s\n", created.SectionID, created.Name)
+}
+
+type renameSection struct {
+	Section string `type:"arg" help:"id or name of the section"`
+	Name    string `type:"arg" help:"new name of the section"`
+}
+
+const renameSectionUsage = "Renames a section"
+
+func (cmd *renameSection) Run() {
+	section := findSection(cmd.Section)
+	section.Name = cmd.Name
+	if err := f.UpdateSection(section); err != nil {
+		log.Fatalf("Could not update section %d: %v", section.SectionID, err)
+	}
+}
+
+type deleteSection struct {
+	Section string `type:"arg" help:"id or name of the section"`
+}
+
+const deleteSectionUsage = "Deletes a section. It must not contain rooms"
+
+func (cmd *deleteSection) Run() {
+	section := findSection(cmd.Section)
+	allRooms, err := f.AllRooms()
+	if err != nil {
+		log.Fatalf("Could not retrieve rooms: %v", err)
+	}
+	for _, r := range allRooms {
+		if r.SectionID == section.SectionID {
+			log.Fatalf("Section %d still contains room %d %s. Move or delete the rooms first.", section.SectionID, r.RoomID, r.Name)
+		}
+	}
+	if err := f.DeleteSection(section.SectionID); err != nil {
+		log.Fatalf("Could not delete section %d: %v", section.SectionID, err)
+	}
+}
+
+// printDevice prints the device as indented JSON
+func printDevice(device hc2.Hc2Device) {
+	b, err := json.MarshalIndent(device, "", "  ")
+	if err != nil {
+		log.Fatalf("Could not encode device %d: %v", device.ID, err)
+	}
+	fmt.Printf("%s\n\n", b)
+}
+
+// unique returns ids without duplicates, in the order of their first occurrence
+func unique(ids []int) []int {
+	seen := map[int]bool{}
+	var result []int
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// findRoom returns the room identified by its id or its name, ignoring case
+func findRoom(ref string) hc2.Hc2Room {
+	allRooms, err := f.AllRooms()
+	if err != nil {
+		log.Fatalf("Could not retrieve rooms: %v", err)
+	}
+	var found []hc2.Hc2Room
+	for _, r := range allRooms {
+		if strconv.Itoa(r.RoomID) == ref || strings.EqualFold(r.Name, ref) {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		log.Fatalf("No room %q", ref)
+	case 1:
+	default:
+		log.Fatalf("Room %q is ambiguous, use its id", ref)
+	}
+	return found[0]
+}
+
+// findSection returns the section identified by its id or its name, ignoring case
+func findSection(ref string) hc2.Hc2Section {
+	allSections, err := f.AllSections()
+	if err != nil {
+		log.Fatalf("Could not retrieve sections: %v", err)
+	}
+	var found []hc2.Hc2Section
+	for _, s := range allSections {
+		if strconv.Itoa(s.SectionID) == ref || strings.EqualFold(s.Name, ref) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		log.Fatalf("No section %q", ref)
+	case 1:
+	default:
+		log.Fatalf("Section %q is ambiguous, use its id", ref)
+	}
+	return found[0]
+}
+
+// printTree prints the sections with their rooms, and optionally the devices and
+// scenes of the rooms. Rooms of unknown sections, and devices and scenes without
+// room are listed last.
+func printTree(withDevices, withScenes bool) {
+	allSections, err := f.AllSections()
+	if err != nil {
+		log.Fatalf("Could not retrieve sections: %v", err)
+	}
+	allRooms, err := f.AllRooms()
+	if err != nil {
+		log.Fatalf("Could not retrieve rooms: %v", err)
+	}
+	sort.SliceStable(allSections, func(i, j int) bool {
+		return allSections[i].SortOrder < allSections[j].SortOrder
+	})
+	sort.SliceStable(allRooms, func(i, j int) bool {
+		return allRooms[i].SortOrder < allRooms[j].SortOrder
+	})
+
+	items := map[int][]string{} // room to its devices and scenes
+	if withDevices {
+		allDevices, err := f.AllDevices()
+		if err != nil {
+			log.Fatalf("Could not retrieve devices: %v", err)
+		}
+		for _, d := range allDevices {
+			if d.Visible {
+				items[d.RoomID] = append(items[d.RoomID], fmt.Sprintf("device %d %s (%s)", d.ID, d.Name, d.Type))
+			}
+		}
+	}
+	if withScenes {
+		allScenes, err := f.AllScenes()
+		if err != nil {
+			log.Fatalf("Could not retrieve scenes: %v", err)
+		}
+		for _, s := range allScenes {
+			items[s.RoomID] = append(items[s.RoomID], fmt.Sprintf("scene %d %s", s.SceneID, s.Name))
+		}
+	}
+
+	printRooms := func(rooms []hc2.Hc2Room) {
+		for i, r := range rooms {
+			branch, indent := "├── ", "│   "
+			if i == len(rooms)-1 {
+				branch, indent = "└── ", "    "
+			}
+			fmt.Printf("%s%s (%d)\n", branch, r.Name, r.RoomID)
+			for j, item := range items[r.RoomID] {
+				if j == len(items[r.RoomID])-1 {
+					fmt.Printf("%s└── %s\n", indent, item)
+				} else {
+					fmt.Printf("%s├── %s\n", indent, item)
+				}
+			}
+		}
+	}
+
+	known := map[int]bool{}
+	for _, s := range allSections {
+		known[s.SectionID] = true
+		var rooms []hc2.Hc2Room
+		for _, r := range allRooms {
+			if r.SectionID == s.SectionID {
+				rooms = append(rooms, r)
+			}
+		}
+		fmt.Printf("%s (%d)\n", s.Name, s.SectionID)
+		printRooms(rooms)
+	}
+	var orphans []hc2.Hc2Room
+	for _, r := range allRooms {
+		if !known[r.SectionID] {
+			orphans = append(orphans, r)
+		}
+	}
+	if len(orphans) > 0 {
+		fmt.Println("without section")
+		printRooms(orphans)
+	}
+	if len(items[0]) > 0 {
+		fmt.Println("without room")
+		printRooms([]hc2.Hc2Room{{Name: "unassigned"}})
+	}
+}
+
 var f *hc2.FibaroHc2
 
 func main() {
@@ -199,6 +1071,31 @@ func main() {
 		AddCommand(
 			opts.New(&createSceneActivationScript{}).
 				Summary(createSceneActivationScriptUsage)).
+		AddCommand(
+			opts.New(&triggers{}).
+				Summary(triggersUsage)).
+		AddCommand(
+			opts.New(&xref{}).
+				Summary(xrefUsage)).
+		AddCommand(
+			opts.New(&rooms{}).
+				Summary(roomsUsage).
+				AddCommand(opts.New(&createRoom{}).Name("create").Summary(createRoomUsage)).
+				AddCommand(opts.New(newUpdateRoom()).Name("update").Summary(updateRoomUsage)).
+				AddCommand(opts.New(&deleteRoom{}).Name("delete").Summary(deleteRoomUsage)).
+				AddCommand(opts.New(&moveToRoom{}).Name("move").Summary(moveToRoomUsage))).
+		AddCommand(
+			opts.New(&call{}).
+				Summary(callUsage)).
+		AddCommand(
+			opts.New(&watch{}).
+				Summary(watchUsage)).
+		AddCommand(
+			opts.New(&sections{}).
+				Summary(sectionsUsage).
+				AddCommand(opts.New(&createSection{}).Name("create").Summary(createSectionUsage)).
+				AddCommand(opts.New(&renameSection{}).Name("rename").Summary(renameSectionUsage)).
+				AddCommand(opts.New(&deleteSection{}).Name("delete").Summary(deleteSectionUsage))).
 		Parse()
 	log.SetLevel(conf.LogLevel)
 
@@ -220,10 +1117,18 @@ func main() {
 func getDevices(deviceIDs []int) []hc2.Hc2Device {
 	var allDevices []hc2.Hc2Device
 	if deviceIDs == nil {
-		allDevices = f.AllDevices()
+		var err error
+		allDevices, err = f.AllDevices()
+		if err != nil {
+			log.Fatalf("Could not retrieve devices: %v", err)
+		}
 	} else {
 		for _, id := range deviceIDs {
-			allDevices = append(allDevices, *f.OneDevice(id))
+			device, err := f.OneDevice(id)
+			if err != nil {
+				log.Fatalf("Could not retrieve device %d: %v", id, err)
+			}
+			allDevices = append(allDevices, device)
 		}
 	}
 	return allDevices
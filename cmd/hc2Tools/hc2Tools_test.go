@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+func TestUpdateRoom_apply(t *testing.T) {
+	sensors := hc2.Hc2RoomSensors{Temperature: 543, Humidity: 545, Light: 544}
+	tests := []struct {
+		name           string
+		args           []string
+		wantName       string
+		wantSensors    hc2.Hc2RoomSensors
+		wantThermostat int
+	}{
+		{"rename keeps the default devices", []string{"--name", "Cuisine", "Kitchen"}, "Cuisine", sensors, 77},
+		{"set a sensor", []string{"--temperature", "600", "Kitchen"}, "Kitchen",
+			hc2.Hc2RoomSensors{Temperature: 600, Humidity: 545, Light: 544}, 77},
+		{"remove a sensor and the thermostat", []string{"--light", "-1", "--default-thermostat", "-1", "Kitchen"}, "Kitchen",
+			hc2.Hc2RoomSensors{Temperature: 543, Humidity: 545}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newUpdateRoom()
+			opts.New(cmd).Name("update").ParseArgs(append([]string{"update"}, tt.args...))
+			room := hc2.Hc2Room{RoomID: 5, Name: "Kitchen", DefaultSensors: sensors, DefaultThermostat: 77}
+			cmd.apply(&room)
+			if room.Name != tt.wantName || room.DefaultSensors != tt.wantSensors || room.DefaultThermostat != tt.wantThermostat {
+				t.Errorf("apply() = %+v", room)
+			}
+		})
+	}
+}
+
+func TestGroupTarget(t *testing.T) {
+	lamp := hc2.Hc2Device{ID: 449, Visible: true, Enabled: true, Actions: map[string]int{"turnOff": 0, "setValue": 1}}
+	hidden := lamp
+	hidden.Visible = false
+	disabled := lamp
+	disabled.Enabled = false
+	controller := hc2.Hc2Device{ID: 1, Visible: true, Enabled: true}
+	tests := []struct {
+		name   string
+		device hc2.Hc2Device
+		action string
+		nargs  int
+		want   bool
+	}{
+		{"declared action", lamp, "turnOff", 0, true},
+		{"undeclared action", lamp, "turnOn", 0, false},
+		{"missing argument", lamp, "setValue", 0, false},
+		{"hidden device", hidden, "turnOff", 0, false},
+		{"disabled device", disabled, "turnOff", 0, false},
+		{"device without actions", controller, "turnOff", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := groupTarget(tt.device, tt.action, tt.nargs) == nil; got != tt.want {
+				t.Errorf("groupTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+/*
+hc2UploadQA uploads a QuickApp to a Fibaro HC3 system. The QuickApp is rebuilt
+from a directory as written by hc2DownloadQA, or read from an .fqa file.
+
+	Usage: hc2UploadQA [options] <qa>
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2UploadQA"
+)
+
+var conf = config{}
+
+type config struct {
+	Qa       string    `type:"arg" help:"<qa> the directory or .fqa file of the QuickApp to be uploaded"`
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+	Test     bool      `help:"Just print information about the contacted HC3 system"`
+
+	User     string `opts:"group=HC3" help:"Username for HC3 authentication"`
+	Password string `opts:"group=HC3" help:"Password for HC3 authentication"`
+	URL      string `opts:"group=HC3" help:"URL of the Fibaro HC3 system, in the form http://..."`
+
+	DontUpload bool `help:"Don't upload the QuickApp but print only"`
+
+	QaID int `opts:"group=QuickApp" help:"The device id of the QuickApp that shall be used. If none given, the id of quickapp.json is used, or a new QuickApp is imported if it has none"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		QaID:     -1,
+		LogLevel: log.InfoLevel,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2UploadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.Test {
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC3: %v", err)
+		}
+		fmt.Println(info)
+		os.Exit(0)
+	}
+
+	qa, isDir, err := readQuickApp(conf.Qa)
+	if err != nil {
+		log.Fatalf("Could not read QuickApp from %s: %v", conf.Qa, err)
+	}
+
+	// CommandLine Parameters overrule quickapp.json
+	if conf.QaID != -1 {
+		qa.ID = conf.QaID
+	}
+
+	if conf.DontUpload {
+		b, _ := json.MarshalIndent(qa, "", "  ")
+		fmt.Println(string(b))
+		os.Exit(0)
+	}
+
+	fc, err := hc2.NewController(*f.Config())
+	if err != nil {
+		log.Fatalf("Could not contact Fibaro system: %v", err)
+	}
+	hc3, ok := fc.(*hc2.FibaroHc3)
+	if !ok {
+		log.Fatalf("QuickApps are only supported by HC3 systems, not by %s", fc.Config().Platform)
+	}
+
+	if qa.ID <= 0 {
+		// we have to import a new QuickApp
+		id, err := hc3.ImportQuickApp(qa)
+		if err != nil {
+			log.Fatalf("Could not import QuickApp \"%s\": %v", qa.Name, err)
+		}
+		qa.ID = id
+		// rewrite the directory so that quickapp.json carries the new id
+		if isDir {
+			if err := qa.WriteDir(conf.Qa); err != nil {
+				log.Fatalf("Could not update %s: %v", conf.Qa, err)
+			}
+		}
+		log.Infof("imported QuickApp %d\n", id)
+		os.Exit(0)
+	}
+
+	err = hc3.PutQuickAppFiles(qa)
+	if errors.Is(err, hc2.ErrNotFound) {
+		log.Fatalf("Could not upload QuickApp \"%s\" with id=%d as it does not exists in Fibaro HC3", qa.Name, qa.ID)
+	} else if err != nil {
+		log.Fatalf("Could not upload QuickApp \"%s\": %v", qa.Name, err)
+	}
+	log.Infof("updated QuickApp %d\n", qa.ID)
+}
+
+// readQuickApp reads the QuickApp from a directory written by hc2DownloadQA, or from an .fqa file
+func readQuickApp(path string) (qa hc2.Hc3QuickApp, isDir bool, err error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return qa, false, err
+	}
+	if stat.IsDir() {
+		qa, err = hc2.ReadQuickAppDir(path)
+		return qa, true, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return qa, false, err
+	}
+	defer file.Close()
+	qa, err = hc2.ReadQuickApp(file)
+	return qa, false, err
+}
@@ -1,9 +1,9 @@
 /*
-hc2UploadScene provides functionality to upload a lua scene to a Fibaro HC2 system.
+hc2UploadScene provides functionality to upload lua scenes to a Fibaro HC2 system.
 
-	Usage: hc2UploadScene [options] <lua-script>
+	Usage: hc2UploadScene [options] [lua-script] [lua-script] ...
 
-	<lua-script> the file to be uploaded
+	<lua-script> the files, globs or directories to be uploaded
 
 	Options:
 	--log-level, -l    Log level, one of panic, fatal, error, warn or warning, info, debug, trace
@@ -11,6 +11,9 @@ hc2UploadScene provides functionality to upload a lua scene to a Fibaro HC2 syst
 	--cfg-file, -c     The config file to use (default /Users/the/go/src/github.com/theovassiliou/hc2-tools/configs/config.json)
 	--create-header    Create the FIBARO_GIT_HEADER if set
 	--dont-upload, -d  Don't upload the file but print only
+	--diff             Don't upload the file but show the differences to the scene in the HC2
+	--force, -f        Upload the file even if it contains Lua syntax errors
+	--no-source-map, -n  Don't write the source map of scenes with expanded libraries
 	--version, -v      display version
 	--help, -h         display help
 
@@ -23,9 +26,14 @@ hc2UploadScene provides functionality to upload a lua scene to a Fibaro HC2 syst
 						take filename without file extenion and implies createHeader if header is
 						missing
 
+	Batch options:
+	--parallel         Number of lua-scripts uploaded in parallel (default 4)
+
 	Require Expand options:
 	--dont-expand      Don't expand the require statements
 	--expand-path, -e  Where to search for the included libraries
+	--lua-path         Search path for the included libraries, templates separated by ; where ? is
+						replaced by the module name (default ?.lua;?/init.lua)
 
 	Version:
 		0.0.1-src
@@ -36,13 +44,14 @@ hc2UploadScene provides functionality to upload a lua scene to a Fibaro HC2 syst
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
@@ -63,34 +72,34 @@ var (
 var conf = config{}
 
 type config struct {
-	LuaScript string    `type:"arg" help:"<lua-script> the file to be uploaded"`
-	LogLevel  log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
-	CfgFile   string    `help:"The config file to use"`
-	Init      bool      `help:"Create a default config file as defined by cfg-file, if set. If not set ~/.hc2-tools/config.json will be created."`
-	Test      bool      `help:"Just print information about the contacted HC2 system"`
+	LuaScripts []string  `type:"arg" name:"lua-script" help:"<lua-script> the files, globs or directories to be uploaded"`
+	LogLevel   log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile    string    `help:"The config file to use"`
+	Init       bool      `help:"Create a default config file as defined by cfg-file, if set. If not set ~/.hc2-tools/config.json will be created."`
+	Test       bool      `help:"Just print information about the contacted HC2 system"`
 
 	CreateHeader bool `help:"Create the FIBARO_GIT_HEADER if set"`
 
 	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
 	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
 	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+	Platform string `opts:"group=HC2" help:"Platform of the Fibaro system, HC2 or HC3. Detected if not set"`
 
 	DontUpload bool `help:"Don't upload the file but print only"`
+	Diff       bool `help:"Don't upload the file but show the differences to the scene in the HC2"`
+	Force      bool `help:"Upload the file even if it contains Lua syntax errors"`
+
+	NoSourceMap bool `help:"Don't write the source map of scenes with expanded libraries"`
 
 	SceneID   int    `opts:"group=Scene" help:"The sceneId that shall be used. If none given, create a new scene and implies createHeader if header is missing"`
 	RoomID    int    `opts:"group=Scene" help:"The roomId that shall be used. Implies createHeader if header is missing"`
 	SceneName string `opts:"group=Scene" help:"The scene name that shall be used. If none given and no header in file, than take filename without file extenion and implies createHeader if header is missing"`
 
+	Parallel int `opts:"group=Batch" help:"Number of lua-scripts uploaded in parallel"`
+
 	DontExpand bool   `opts:"group=Require Expand" help:"Don't expand the require statements"`
 	ExpandPath string `opts:"group=Require Expand" help:"Where to search for the included libraries"`
-}
-
-// TODO: Find a reasonable way to parametrize the libary2Ignore feature
-var m = map[string]*regexp.Regexp{
-	"uncommented":   regexp.MustCompile(`(?m)^\s*require\(('|")(.*)('|")\);?`),
-	"commented":     regexp.MustCompile(`(?m)^-+\s*require\(('|")(.*)('|")\)`),
-	"block-library": regexp.MustCompile(``),
-	"ignoreExpand":  regexp.MustCompile(`library2Ignore`),
+	LuaPath    string `opts:"group=Require Expand" help:"Search path for the included libraries, templates separated by ; where ? is replaced by the module name"`
 }
 
 func main() {
@@ -104,6 +113,8 @@ func main() {
 		SceneID:      -1,
 		RoomID:       -1,
 		SceneName:    "",
+		Parallel:     4,
+		LuaPath:      hc2.DefaultLuaPath,
 		LogLevel:     log.InfoLevel,
 	}
 
@@ -151,44 +162,186 @@ func main() {
 		cfg.BaseURL = conf.URL
 	}
 
+	if conf.Platform != "" {
+		cfg := f.Config()
+		cfg.Platform = conf.Platform
+	}
+
 	if conf.Init {
-		var i int
 		var filePath string
 		if conf.CfgFile != "" {
 			filePath = conf.CfgFile
 		} else {
 			filePath = workingHomeDir + "/" + hc2.Hc2DefaultConfigFile
 		}
-		i = f.WriteInitConfigFile(filePath)
+		i, err := f.WriteInitConfigFile(filePath)
+		if err != nil {
+			log.Fatalf("Could not write config file: %v", err)
+		}
 		log.Debugf("Wrote to file %s %d bytes", filePath, i)
 	}
 
 	if conf.Test {
-		fmt.Println(f.Info(2))
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC2: %v", err)
+		}
+		fmt.Println(info)
+		os.Exit(0)
+	}
+	files, err := luaFiles(conf.LuaScripts, conf.CreateHeader)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(files) == 0 {
+		log.Fatalln("No lua-script given. Aborting.")
+	}
+	if len(files) > 1 && (conf.SceneID != -1 || conf.SceneName != "") {
+		log.Fatalln("--scene-id and --scene-name can only be used with a single lua-script. Aborting.")
+	}
+
+	var fc hc2.Controller
+	if conf.Diff || !conf.DontUpload {
+		if fc, err = hc2.NewController(*f.Config()); err != nil {
+			log.Fatalf("Could not contact Fibaro system: %v", err)
+		}
+	}
+
+	if conf.Diff || conf.DontUpload {
+		for _, file := range files {
+			hc2Scene, _, err := prepareScene(file)
+			if errors.Is(err, hc2.ErrLuaSyntax) {
+				log.Warnln(err)
+			} else if err != nil {
+				log.Fatalln(err)
+			}
+			if conf.Diff {
+				showDiff(fc, file, hc2Scene)
+				continue
+			}
+			if hc2Scene.SceneID == -1 {
+				log.Debugf("%s: Creating a new scene\n", file)
+			} else {
+				log.Debugf("%s: Updating scene %d\n", file, hc2Scene.SceneID)
+			}
+			log.Println(hc2Scene)
+		}
 		os.Exit(0)
 	}
-	// Assumptions:
-	// CommandLine Parameters overrule file content, file content overrules config-defauls
-	/* Plan:
-	- Fill scene with config-details
-	- Read file
-	- Parse header if any
-	- Overwrite with parameters, if any
-	- Update header
-	- Upload file based on header information
-	*/
+
+	results := uploadAll(fc, files, conf.Parallel)
+	if len(results) > 1 {
+		printSummary(results)
+	}
+	for _, r := range results {
+		if r.Status == statusFailed {
+			os.Exit(1)
+		}
+	}
+}
+
+// luaFiles expands the lua-script arguments. Globs are expanded, directories are searched
+// recursively for .lua files, skipping hidden directories. Files found in directories
+// without FIBARO_GIT_HOOK header, e.g. libraries, are skipped unless createHeader is set.
+// Every file is returned once.
+func luaFiles(args []string, createHeader bool) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, arg := range args {
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if paths, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", arg, err)
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("no file matches %s", arg)
+			}
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() && p != path && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				if info.IsDir() || filepath.Ext(p) != ".lua" {
+					return nil
+				}
+				if !createHeader && !hasHeader(p) {
+					log.Debugf("%s: skipped, no FIBARO_GIT_HOOK header\n", p)
+					return nil
+				}
+				add(p)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// hasHeader returns whether file contains a FIBARO_GIT_HOOK header
+func hasHeader(file string) bool {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	scene := hc2.Hc2Scene{Lua: string(b)}
+	return scene.HasLuaHeader()
+}
+
+// prepareScene reads a lua-script and returns the scene to be uploaded.
+// Command line parameters overrule the header of the file, the file content overrules the defaults.
+// The returned SourceMap locates the lines of the scene in the file and the expanded libraries.
+// Syntax errors are returned together with the scene, as by hc2.PrepareLua.
+func prepareScene(file string) (hc2Scene hc2.Hc2Scene, sourceMap hc2.SourceMap, err error) {
+	if hc2Scene, err = readScene(file); err != nil {
+		return hc2Scene, nil, err
+	}
+	var e *hc2.RequireExpander
+	if !conf.DontExpand {
+		e = hc2.NewRequireExpander(conf.LuaPath)
+		e.Dir = conf.ExpandPath
+		e.Ignore = hc2.DefaultRequireIgnore
+	}
+	hc2Scene.Lua, sourceMap, err = hc2.PrepareLua(e, hc2Scene.Lua, file)
+	return hc2Scene, sourceMap, err
+}
+
+// readScene reads a lua-script and applies the command line parameters, without expanding
+// the require statements.
+func readScene(file string) (hc2.Hc2Scene, error) {
 	var shallUpdateHeader = false
 	hc2Scene := hc2.NewHc2Scene()
 
-	hc2Scene.ParseFile(conf.LuaScript, false) // will exit if no such file
+	if err := hc2Scene.ParseFile(file, false); err != nil {
+		return hc2Scene, fmt.Errorf("could not read file %s: %w", file, err)
+	}
 
+	// base filename without suffix
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(filepath.Base(file)))
 	if hc2Scene.SceneID == -1 {
 		// There was no header
-
-		// base filename without suffix
-		name := strings.TrimSuffix(filepath.Base(conf.LuaScript), filepath.Ext(filepath.Base(conf.LuaScript)))
 		hc2Scene.Name = name
-		if filepath.Ext(filepath.Base(conf.LuaScript)) == ".lua" {
+		if filepath.Ext(filepath.Base(file)) == ".lua" {
 			hc2Scene.IsLua = true
 		}
 		if conf.SceneName != "" {
@@ -206,7 +359,6 @@ func main() {
 			hc2Scene.Name = conf.SceneName
 			shallUpdateHeader = true
 		} else if hc2Scene.Name == "" {
-			name := strings.TrimSuffix(filepath.Base(conf.LuaScript), filepath.Ext(filepath.Base(conf.LuaScript)))
 			hc2Scene.Name = name
 			shallUpdateHeader = true
 		}
@@ -226,123 +378,179 @@ func main() {
 	if shallUpdateHeader {
 		hc2Scene.UpdateLuaHeader()
 	}
+	return hc2Scene, nil
+}
 
-	var sb strings.Builder
-
-	if !conf.DontExpand {
-		for m["uncommented"].MatchString(hc2Scene.Lua) {
-
-			// we have at least one uncommented require statement
-			scanner := bufio.NewScanner(strings.NewReader(hc2Scene.Lua))
-			for scanner.Scan() {
-				if len(scanner.Text()) == 0 {
-				} else {
-					if m["uncommented"].MatchString(scanner.Text()) {
-						sb.WriteString((m["uncommented"].ReplaceAllStringFunc(scanner.Text(), replaceFunc)))
-					} else {
-						sb.WriteString(scanner.Text())
-					}
-				}
-				sb.WriteString("\n")
-			}
-			check(scanner.Err())
-			hc2Scene.Lua = sb.String()
-			sb.Reset()
-		}
+// writeHeader writes the FIBARO_GIT_HOOK header of the scene created from file back to
+// it, so that uploading the file again updates the scene instead of creating another one.
+func writeHeader(file string, sceneID int) error {
+	hc2Scene, err := readScene(file)
+	if err != nil {
+		return err
 	}
+	hc2Scene.SceneID = sceneID
+	hc2Scene.UpdateLuaHeader()
+	return ioutil.WriteFile(file, []byte(hc2Scene.Lua), 0644)
+}
 
-	if !conf.DontUpload {
-		if hc2Scene.SceneID == -1 {
-			// we have to create a new scene in fibaro
-			f.CreateScene(hc2Scene)
-		} else {
-			// assume that there exists a scene with this sceneId
-			f.PutOneScene(hc2Scene)
+// showDiff prints the differences between the scene and the scene in the HC2.
+// A scene to be created is compared with an empty scene.
+func showDiff(f hc2.Controller, file string, hc2Scene hc2.Hc2Scene) {
+	var remote hc2.Hc2Scene
+	if hc2Scene.SceneID != -1 {
+		var err error
+		remote, err = f.OneScene(hc2Scene.SceneID)
+		if errors.Is(err, hc2.ErrNotFound) {
+			log.Fatalf("Scene with sceneID=%d does not exists in Fibaro HC2", hc2Scene.SceneID)
+		} else if err != nil {
+			log.Fatalf("Could not retrieve scene %d: %v", hc2Scene.SceneID, err)
 		}
-		os.Exit(0)
 	}
-
-	if hc2Scene.SceneID == -1 {
-		log.Debugln("Creating a new scene")
+	d := hc2.DiffScenes(remote, hc2Scene)
+	if d.Empty() {
+		log.Infof("%s: No differences to scene %d\n", file, hc2Scene.SceneID)
 	} else {
-		log.Debugf("Updating scene %d\n", hc2Scene.SceneID)
+		fmt.Print(d)
 	}
-	log.Println(hc2Scene)
-}
-
-func slComment(s string) string {
-	return "--^ " + s
 }
 
-func expandFile(reqStat, reqPar string) string {
-	// get the path prefix
-	pathPrefix := conf.ExpandPath
-
-	// read the file (from pathPrefix)
-	nL, f := readFile(filepath.Join(pathPrefix, reqPar+".lua"))
+// uploadStatus is the outcome of uploading one lua-script
+type uploadStatus int
 
-	// hopefully we found the file
-	if nL <= 0 {
-		return slComment(reqStat + " <-- FILE NOT FOUND")
-	}
-
-	var sb strings.Builder
-	// building the expanded require statement
-	sb.WriteString(slComment(reqStat))
-	sb.WriteString(`
--- LIBRARY BEGIN -------------------------
--- DO NOT MODIFY THE CODE
-`)
-	sb.Write(f)
-	sb.WriteString("\n-- LIBRARY END -------------------------\n")
+const (
+	statusCreated uploadStatus = iota
+	statusUpdated
+	statusUnchanged
+	statusFailed
+)
 
-	return sb.String()
+func (s uploadStatus) String() string {
+	return [...]string{"created", "updated", "unchanged", "failed"}[s]
 }
 
-func replaceFunc(s string) string {
+type uploadResult struct {
+	File    string
+	SceneID int
+	Name    string
+	Status  uploadStatus
+	Err     error
+}
 
-	// in case we find the ignoreExpand key we are just
-	// commenting the require statement
-	if m["ignoreExpand"].MatchString(s) {
-		return slComment(s)
+// uploadAll uploads the files using a pool of parallel workers, and returns the results
+// in the order of the files. Failures do not stop the upload of the other files.
+func uploadAll(f hc2.Controller, files []string, parallel int) []uploadResult {
+	if parallel < 1 {
+		parallel = 1
 	}
-
-	i := m["uncommented"].FindStringSubmatch(s)
-
-	return expandFile(s, i[2])
+	results := make([]uploadResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = upload(f, files[i])
+				if results[i].Status == statusFailed {
+					log.Errorf("%s: %v\n", files[i], results[i].Err)
+				} else {
+					log.Infof("%s: scene %d %s\n", files[i], results[i].SceneID, results[i].Status)
+				}
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
 }
 
-// ReadFile opens a file provided by it's path and returns the number of lines read and the file
-// contents as byte array.
-func readFile(path string) (int, []byte) {
-	dat, readErr := ioutil.ReadFile(path)
+// upload uploads one lua-script. Scenes without sceneID are created, existing scenes are
+// only updated if they differ from the scene in the HC2.
+func upload(f hc2.Controller, file string) uploadResult {
+	r := uploadResult{File: file, SceneID: -1, Status: statusFailed}
+	hc2Scene, sourceMap, err := prepareScene(file)
+	r.Name = hc2Scene.Name
+	if errors.Is(err, hc2.ErrLuaSyntax) {
+		if !conf.Force {
+			r.Err = fmt.Errorf("not uploaded, use --force to upload anyway: %w", err)
+			return r
+		}
+		log.Warnln(err)
+	} else if err != nil {
+		r.Err = err
+		return r
+	}
 
-	if readErr != nil {
-		return 0, []byte{}
-		// log.Fatal(readErr)
+	r.SceneID, r.Status, r.Err = put(f, hc2Scene)
+	if r.Status == statusCreated && conf.CreateHeader {
+		if err := writeHeader(file, r.SceneID); err != nil {
+			log.Warnf("%s: could not write header of scene %d: %v\n", file, r.SceneID, err)
+		}
+	}
+	if r.Err == nil && !conf.DontExpand && !conf.NoSourceMap {
+		if err := writeSourceMap(file, r.SceneID, hc2Scene.Lua, sourceMap); err != nil {
+			log.Warnf("%s: could not write source map: %v\n", file, err)
+		}
 	}
+	return r
+}
 
-	file, openErr := os.Open(path)
-	if openErr != nil {
-		return 0, []byte{}
-		// log.Fatal(openErr)
+// put creates or updates the scene in the HC2
+func put(f hc2.Controller, hc2Scene hc2.Hc2Scene) (int, uploadStatus, error) {
+	if hc2Scene.SceneID == -1 {
+		// we have to create a new scene in fibaro
+		sceneID, err := f.CreateScene(hc2Scene)
+		if err != nil {
+			return -1, statusFailed, fmt.Errorf("could not create scene \"%s\": %w", hc2Scene.Name, err)
+		}
+		return sceneID, statusCreated, nil
 	}
-	defer file.Close()
 
-	var noOfLines int
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		noOfLines++
+	remote, err := f.OneScene(hc2Scene.SceneID)
+	if errors.Is(err, hc2.ErrNotFound) {
+		return hc2Scene.SceneID, statusFailed, fmt.Errorf("could not upload scene \"%s\" with sceneID=%d as it does not exists in Fibaro HC2", hc2Scene.Name, hc2Scene.SceneID)
+	} else if err != nil {
+		return hc2Scene.SceneID, statusFailed, fmt.Errorf("could not retrieve scene %d: %w", hc2Scene.SceneID, err)
 	}
+	if hc2.DiffScenes(remote, hc2Scene).Empty() {
+		return hc2Scene.SceneID, statusUnchanged, nil
+	}
+	if _, err := f.PutOneScene(hc2Scene); err != nil {
+		return hc2Scene.SceneID, statusFailed, fmt.Errorf("could not upload scene \"%s\": %w", hc2Scene.Name, err)
+	}
+	return hc2Scene.SceneID, statusUpdated, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+// writeSourceMap writes the source map of the scene uploaded from file next to it, if
+// libraries have been inlined. Otherwise an outdated source map is removed.
+func writeSourceMap(file string, sceneID int, lua string, sourceMap hc2.SourceMap) error {
+	path := file + hc2.SourceMapSuffix
+	if !sourceMap.Inlined(file) {
+		if _, err := os.Stat(path); err == nil {
+			return os.Remove(path)
+		}
+		return nil
 	}
-	return noOfLines, dat
+	return hc2.WriteSourceMap(path, hc2.SceneSourceMap{SceneID: sceneID, Hash: hc2.LuaHash(lua), Lines: sourceMap})
 }
 
-func check(e error) {
-	if e != nil {
-		log.Fatal(e)
+// printSummary prints a table of all uploads, followed by the number of files per status
+func printSummary(results []uploadResult) {
+	counts := make([]int, statusFailed+1)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSCENE\tNAME\tRESULT\tERROR")
+	for _, r := range results {
+		counts[r.Status]++
+		errMsg := ""
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", r.File, r.SceneID, r.Name, r.Status, errMsg)
 	}
+	w.Flush()
+	fmt.Printf("\n%d created, %d updated, %d unchanged, %d failed\n",
+		counts[statusCreated], counts[statusUpdated], counts[statusUnchanged], counts[statusFailed])
 }
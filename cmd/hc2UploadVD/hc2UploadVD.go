@@ -0,0 +1,153 @@
+/*
+hc2UploadVD uploads a virtual device to a Fibaro HC2 system. The virtual device is
+reassembled from a directory as written by hc2DownloadVD.
+
+	Usage: hc2UploadVD [options] <vd-dir>
+
+	Read more:
+		github.com/theovassiliou/hc2-tools
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jpillora/opts"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+//set this via ldflags (see https://stackoverflow.com/q/11354518)
+var (
+	version = hc2.Version
+	commit  string
+	branch  string
+	cmdName = "hc2UploadVD"
+)
+
+var conf = config{}
+
+type config struct {
+	VdDir    string    `type:"arg" help:"<vd-dir> the directory of the virtual device to be uploaded"`
+	LogLevel log.Level `help:"Log level, one of panic, fatal, error, warn or warning, info, debug, trace"`
+	CfgFile  string    `help:"The config file to use"`
+	Test     bool      `help:"Just print information about the contacted HC2 system"`
+
+	User     string `opts:"group=HC2" help:"Username for HC2 authentication"`
+	Password string `opts:"group=HC2" help:"Password for HC2 authentication"`
+	URL      string `opts:"group=HC2" help:"URL of the Fibaro HC2 system, in the form http://..."`
+
+	DontUpload bool `help:"Don't upload the virtual device but print only"`
+
+	VdID   int `opts:"group=Virtual Device" help:"The id of the virtual device that shall be used. If none given, the id of the layout is used, or a new virtual device is created if the layout has none"`
+	RoomID int `opts:"group=Virtual Device" help:"The roomId that shall be used"`
+}
+
+func main() {
+	workingHomeDir, _ := homedir.Dir()
+
+	conf = config{
+		CfgFile:  workingHomeDir + "/" + hc2.Hc2DefaultConfigFile,
+		VdID:     -1,
+		RoomID:   -1,
+		LogLevel: log.InfoLevel,
+	}
+
+	//parse config
+	opts.New(&conf).
+		Repo(hc2.RepoName).
+		Version(hc2.FormatFullVersion(cmdName, version, branch, commit)).
+		Parse()
+
+	log.SetLevel(conf.LogLevel)
+
+	f := hc2.NewFibaroHc2Config(conf.CfgFile)
+
+	if f == nil {
+		if conf.User == "" && conf.Password == "" && conf.URL == "" {
+			log.Fatalf("Could not read config file (%s) and no parameters given.\n"+
+				" Consider using hc2UploadScene --init to create a config file\n", conf.CfgFile)
+		} else if conf.User != "" && conf.Password != "" && conf.URL != "" {
+			var confg hc2.FibaroConfig
+			hc2.Default(&confg)
+			f = &hc2.FibaroHc2{}
+			f.SetConfig(confg)
+		} else {
+			log.Fatalf("Not all login parameters provided. Aborting.")
+
+		}
+	}
+
+	if conf.User != "" {
+		cfg := f.Config()
+		log.Tracef("Configured user %s\n", conf.User)
+		cfg.Username = conf.User
+	}
+
+	if conf.Password != "" {
+		cfg := f.Config()
+		cfg.Password = conf.Password
+	}
+
+	if conf.URL != "" {
+		cfg := f.Config()
+		cfg.BaseURL = conf.URL
+	}
+
+	if conf.Test {
+		info, err := f.Info(2)
+		if err != nil {
+			log.Fatalf("Could not contact HC2: %v", err)
+		}
+		fmt.Println(info)
+		os.Exit(0)
+	}
+
+	vd, err := hc2.ReadVirtualDeviceDir(conf.VdDir)
+	if err != nil {
+		log.Fatalf("Could not read virtual device from %s: %v", conf.VdDir, err)
+	}
+
+	// CommandLine Parameters overrule the layout
+	if conf.VdID != -1 {
+		vd.ID = conf.VdID
+	}
+	if conf.RoomID != -1 {
+		vd.RoomID = conf.RoomID
+	}
+
+	if conf.DontUpload {
+		b, _ := json.MarshalIndent(vd, "", "  ")
+		fmt.Println(string(b))
+		os.Exit(0)
+	}
+
+	if vd.ID <= 0 {
+		// we have to create a new virtual device in fibaro
+		id, err := f.CreateVirtualDevice(vd)
+		if err != nil {
+			log.Fatalf("Could not create virtual device \"%s\": %v", vd.Name, err)
+		}
+		vd.ID = id
+		// rewrite the directory so that the headers carry the new id
+		if err := vd.WriteDir(conf.VdDir); err != nil {
+			log.Fatalf("Could not update %s: %v", conf.VdDir, err)
+		}
+		log.Infof("created virtual device %d\n", id)
+		os.Exit(0)
+	}
+
+	err = f.PutVirtualDevice(vd)
+	if errors.Is(err, hc2.ErrNotFound) {
+		log.Fatalf("Could not upload virtual device \"%s\" with id=%d as it does not exists in Fibaro HC2", vd.Name, vd.ID)
+	} else if err != nil {
+		log.Fatalf("Could not upload virtual device \"%s\": %v", vd.Name, err)
+	}
+	log.Infof("updated virtual device %d\n", vd.ID)
+}
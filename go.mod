@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/amimof/huego v1.2.0
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/go-resty/resty/v2 v2.3.0
 	github.com/jarcoal/httpmock v1.0.4
 	github.com/jpillora/opts v1.1.2
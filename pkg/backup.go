@@ -0,0 +1,454 @@
+package fibarohc2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Directories of a backup written by Backup holding one file per scene or virtual device
+const (
+	BackupScenesDir         string = "scenes"
+	BackupVirtualDevicesDir string = "vds"
+)
+
+// backupResource is a REST resource written to a JSON file of a backup
+type backupResource struct {
+	file     string
+	cmd      string
+	optional bool     // not available on all systems, skipped if not found
+	keep     []string // if set, only these keys of every item are kept
+	drop     []string // keys removed from every item, as they change without a change of the configuration
+}
+
+var backupResources = []backupResource{
+	{file: "settings/info.json", cmd: "/settings/info", drop: []string{"serverStatus", "updateStableAvailable", "updateBetaAvailable", "newestStableVersion", "newestBetaVersion"}},
+	{file: "settings/network.json", cmd: "/settings/network"},
+	{file: "settings/location.json", cmd: "/settings/location", optional: true},
+	{file: "sections.json", cmd: "/sections"},
+	{file: "rooms.json", cmd: "/rooms"},
+	{file: "devices.json", cmd: "/devices", keep: []string{"id", "name", "type", "baseType", "roomID", "parentId", "interfaces", "enabled", "visible", "isPlugin", "sortOrder"}},
+	{file: "users.json", cmd: "/users", optional: true},
+	{file: "profiles.json", cmd: "/profiles", optional: true},
+	{file: "globals.json", cmd: "/globalVariables", drop: []string{"created", "modified"}},
+}
+
+// Backup writes a snapshot of the configuration of the HC2 into dir. Settings, sections,
+// rooms, device metadata, users, profiles and global variables are written as JSON files,
+// every scene to scenes/<id>.lua with a FIBARO_GIT_HOOK header and every virtual device
+// to vds/<id> as written by WriteDir.
+//
+// All JSON is indented, with sorted keys and items sorted by id, and values that change
+// while the system is running, like device properties, are left out. Passwords are
+// removed. Running Backup twice on an unchanged system results in identical files.
+func (f *FibaroHc2) Backup(dir string) error {
+	for _, r := range backupResources {
+		v, err := f.getRaw(r.cmd)
+		if errors.Is(err, ErrNotFound) && r.optional {
+			log.Debugf("%s not available, skipping %s", r.cmd, r.file)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.file, err)
+		}
+		v = r.filter(v)
+		if err := writeSortedJSON(filepath.Join(dir, r.file), v); err != nil {
+			return err
+		}
+	}
+
+	// scenes and virtual devices are written from scratch, so that deleted ones disappear
+	scenesDir := filepath.Join(dir, BackupScenesDir)
+	if err := os.RemoveAll(scenesDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(scenesDir, os.ModePerm); err != nil {
+		return err
+	}
+	scenes, err := f.AllScenes()
+	if err != nil {
+		return fmt.Errorf("scenes: %w", err)
+	}
+	for _, s := range scenes {
+		scene, err := f.OneScene(s.SceneID)
+		if err != nil {
+			return fmt.Errorf("scene %d: %w", s.SceneID, err)
+		}
+		scene.UpdateLuaHeader()
+		if err := ioutil.WriteFile(filepath.Join(scenesDir, strconv.Itoa(scene.SceneID)+".lua"), []byte(scene.Lua), 0644); err != nil {
+			return err
+		}
+	}
+
+	vdsDir := filepath.Join(dir, BackupVirtualDevicesDir)
+	if err := os.RemoveAll(vdsDir); err != nil {
+		return err
+	}
+	vds, err := f.AllVirtualDevices()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("virtual devices: %w", err)
+	}
+	for _, vd := range vds {
+		vdDir := filepath.Join(vdsDir, strconv.Itoa(vd.ID))
+		if err := vd.WriteDir(vdDir); err != nil {
+			return fmt.Errorf("virtual device %d: %w", vd.ID, err)
+		}
+		if err := sortJSONFile(filepath.Join(vdDir, VirtualDeviceLayoutFile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRaw requests cmd and decodes the JSON response without losing any field
+func (f *FibaroHc2) getRaw(cmd string) (interface{}, error) {
+	var raw json.RawMessage
+	if err := f.getJSON(cmd, &raw); err != nil {
+		return nil, err
+	}
+	return decodeRaw(raw)
+}
+
+func decodeRaw(b []byte) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err := d.Decode(&v)
+	return v, err
+}
+
+// filter applies keep and drop to the items of v, removes all passwords and
+// sorts lists by id or name
+func (r backupResource) filter(v interface{}) interface{} {
+	apply := func(item interface{}) {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return
+		}
+		if len(r.keep) > 0 {
+			for k := range m {
+				if !containsString(r.keep, k) {
+					delete(m, k)
+				}
+			}
+		}
+		for _, k := range r.drop {
+			delete(m, k)
+		}
+	}
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			apply(item)
+		}
+		sortItems(list)
+	} else {
+		apply(v)
+	}
+	removePasswords(v)
+	return v
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removePasswords removes all keys containing "password" from v and the objects nested in v
+func removePasswords(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if strings.Contains(strings.ToLower(k), "password") {
+				delete(t, k)
+				continue
+			}
+			removePasswords(e)
+		}
+	case []interface{}:
+		for _, e := range t {
+			removePasswords(e)
+		}
+	}
+}
+
+// sortItems sorts a list of objects by their id, or by their name if they have no id
+func sortItems(list []interface{}) {
+	key := func(i int) (int, string) {
+		m, _ := list[i].(map[string]interface{})
+		name, _ := m["name"].(string)
+		return intField(m, "id"), name
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		idI, nameI := key(i)
+		idJ, nameJ := key(j)
+		if idI != idJ {
+			return idI < idJ
+		}
+		return nameI < nameJ
+	})
+}
+
+// intField returns the integer value of key in m, or 0
+func intField(m map[string]interface{}, key string) int {
+	switch v := m[key].(type) {
+	case json.Number:
+		i, _ := strconv.Atoi(v.String())
+		return i
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// writeSortedJSON writes v indented to path. Objects decoded into maps are written
+// with sorted keys.
+func writeSortedJSON(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// sortJSONFile rewrites the JSON file at path with sorted keys
+func sortJSONFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	v, err := decodeRaw(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return writeSortedJSON(path, v)
+}
+
+// RestoreAction records how an item of a backup was restored
+type RestoreAction struct {
+	Kind    string // section, room, global or scene
+	Name    string
+	OldID   int  // the id in the backup, 0 for global variables
+	NewID   int  // the id in the restored system, 0 for global variables
+	Created bool // false if an existing item with the same name was used or updated
+}
+
+// Restore re-creates the sections, rooms, global variables and scenes of a backup
+// written by Backup. Existing sections and rooms with the same name are reused,
+// existing global variables and scenes with the same name are updated, everything
+// else is created. IDs that change are remapped: rooms are assigned to the restored
+// sections, and scenes to the restored rooms, with @sceneID and @roomID of their
+// FIBARO_GIT_HOOK headers rewritten accordingly.
+func (f *FibaroHc2) Restore(dir string) ([]RestoreAction, error) {
+	var actions []RestoreAction
+
+	sections, err := readBackupList(filepath.Join(dir, "sections.json"))
+	if err != nil {
+		return actions, err
+	}
+	a, sectionIDs, err := f.restoreCollection("section", "/sections", sections,
+		func(m map[string]interface{}) string { return fmt.Sprint(m["name"]) },
+		func(m map[string]interface{}) {})
+	actions = append(actions, a...)
+	if err != nil {
+		return actions, err
+	}
+
+	rooms, err := readBackupList(filepath.Join(dir, "rooms.json"))
+	if err != nil {
+		return actions, err
+	}
+	a, roomIDs, err := f.restoreCollection("room", "/rooms", rooms,
+		func(m map[string]interface{}) string {
+			return fmt.Sprintf("%v/%d", m["name"], intField(m, "sectionID"))
+		},
+		func(m map[string]interface{}) {
+			if id, ok := sectionIDs[intField(m, "sectionID")]; ok {
+				m["sectionID"] = id
+			}
+		})
+	actions = append(actions, a...)
+	if err != nil {
+		return actions, err
+	}
+
+	a, err = f.restoreGlobals(filepath.Join(dir, "globals.json"))
+	actions = append(actions, a...)
+	if err != nil {
+		return actions, err
+	}
+
+	a, err = f.restoreScenes(filepath.Join(dir, BackupScenesDir), roomIDs)
+	actions = append(actions, a...)
+	return actions, err
+}
+
+func readBackupList(path string) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&items); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return items, nil
+}
+
+// restoreCollection creates the items not yet existing in the collection cmd, where
+// items are identified by key after prepare has been applied. Returns the mapping
+// from the ids of the backup to the ids of the restored system.
+func (f *FibaroHc2) restoreCollection(kind, cmd string, items []map[string]interface{},
+	key func(map[string]interface{}) string, prepare func(map[string]interface{})) ([]RestoreAction, map[int]int, error) {
+	var actions []RestoreAction
+	ids := map[int]int{}
+
+	var existing []map[string]interface{}
+	if err := f.getJSON(cmd, &existing); err != nil {
+		return actions, ids, err
+	}
+	byKey := map[string]int{}
+	for _, e := range existing {
+		if _, dup := byKey[key(e)]; !dup {
+			byKey[key(e)] = intField(e, "id")
+		}
+	}
+
+	for _, item := range items {
+		a := RestoreAction{Kind: kind, Name: fmt.Sprint(item["name"]), OldID: intField(item, "id")}
+		delete(item, "id")
+		prepare(item)
+
+		if id, ok := byKey[key(item)]; ok {
+			a.NewID = id
+		} else {
+			b, err := json.Marshal(item)
+			if err != nil {
+				return actions, ids, err
+			}
+			resp, err := requestPost(f.cfg, cmd, b)
+			if err := checkResponse(http.MethodPost, cmd, resp, err); err != nil {
+				return actions, ids, fmt.Errorf("creating %s %s: %w", kind, a.Name, err)
+			}
+			var created map[string]interface{}
+			if err := json.Unmarshal(resp.Body(), &created); err != nil {
+				return actions, ids, decodeError(http.MethodPost, cmd, resp, err)
+			}
+			a.NewID = intField(created, "id")
+			a.Created = true
+			byKey[key(item)] = a.NewID
+		}
+		ids[a.OldID] = a.NewID
+		actions = append(actions, a)
+	}
+	return actions, ids, nil
+}
+
+func (f *FibaroHc2) restoreGlobals(path string) ([]RestoreAction, error) {
+	var actions []RestoreAction
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return actions, err
+	}
+	var globals []Hc2GlobalVariable
+	if err := json.Unmarshal(b, &globals); err != nil {
+		return actions, fmt.Errorf("%s: %w", path, err)
+	}
+
+	existing, err := f.AllGlobals()
+	if err != nil {
+		return actions, err
+	}
+	exists := map[string]bool{}
+	for _, g := range existing {
+		exists[g.Name] = true
+	}
+
+	for _, g := range globals {
+		a := RestoreAction{Kind: "global", Name: g.Name}
+		if exists[g.Name] {
+			err = f.UpdateGlobal(g)
+		} else {
+			_, err = f.CreateGlobal(g)
+			a.Created = true
+		}
+		if err != nil {
+			return actions, fmt.Errorf("restoring global %s: %w", g.Name, err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+func (f *FibaroHc2) restoreScenes(dir string, roomIDs map[int]int) ([]RestoreAction, error) {
+	var actions []RestoreAction
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return actions, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(files[i]), ".lua"))
+		b, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(files[j]), ".lua"))
+		return a < b
+	})
+
+	existing, err := f.AllScenes()
+	if err != nil {
+		return actions, err
+	}
+	byName := map[string][]int{}
+	for _, s := range existing {
+		byName[s.Name] = append(byName[s.Name], s.SceneID)
+	}
+
+	for _, file := range files {
+		scene := NewHc2Scene()
+		if err := scene.ParseFile(file, false); err != nil {
+			return actions, err
+		}
+		if !scene.IsLua {
+			log.Warnf("%s: skipping scene %d, as it is not a Lua scene", file, scene.SceneID)
+			continue
+		}
+		a := RestoreAction{Kind: "scene", Name: scene.Name, OldID: scene.SceneID}
+		if id, ok := roomIDs[scene.RoomID]; ok {
+			scene.RoomID = id
+		}
+
+		if ids := byName[scene.Name]; len(ids) == 1 {
+			scene.SceneID = ids[0]
+			scene.UpdateLuaHeader()
+			if _, err := f.PutOneScene(scene); err != nil {
+				return actions, fmt.Errorf("restoring scene %s: %w", scene.Name, err)
+			}
+		} else {
+			if scene.SceneID, err = f.CreateScene(scene); err != nil {
+				return actions, fmt.Errorf("restoring scene %s: %w", scene.Name, err)
+			}
+			a.Created = true
+		}
+		a.NewID = scene.SceneID
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
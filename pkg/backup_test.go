@@ -0,0 +1,19 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBackupResource_filter(t *testing.T) {
+	v, err := decodeRaw([]byte(`[
+		{"id": 12, "name": "b", "password": "secret", "properties": {"value": "1"}, "sub": {"wifiPassword": "x", "ok": true}},
+		{"id": 3, "name": "a", "modified": 1600000000}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := backupResource{keep: []string{"id", "name", "password", "sub", "modified"}, drop: []string{"modified"}}
+	b, _ := json.Marshal(r.filter(v))
+	AssertEqual(t, string(b), `[{"id":3,"name":"a"},{"id":12,"name":"b","sub":{"ok":true}}]`)
+}
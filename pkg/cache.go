@@ -0,0 +1,356 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MetadataCache is a Controller that caches the rooms, sections and devices of the
+// wrapped Controller. The first lookup of a room, section or device retrieves all
+// items of its kind with one request, further lookups are answered from memory.
+// All other methods are passed on to the wrapped Controller. A MetadataCache is
+// safe for concurrent use.
+//
+// Device states are not refreshed while cached, so use the wrapped Controller
+// if the current values of a device are needed. A lookup of a room, section or
+// device missing in the items restored from a persisted file retrieves the items
+// again, as it may have been created since.
+type MetadataCache struct {
+	Controller
+
+	file string
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	rooms    []Hc2Room
+	sections []Hc2Section
+	devices  []Hc2Device
+	stored   *metadataFile
+	fetched  map[string]bool // kinds retrieved from the controller, not restored
+}
+
+// metadataFile is the content of the file a MetadataCache is persisted to.
+// It is only used for the controller at URL.
+type metadataFile struct {
+	URL     string                   `json:"url"`
+	Entries map[string]metadataEntry `json:"entries"`
+}
+
+type metadataEntry struct {
+	Fetched time.Time       `json:"fetched"`
+	Items   json.RawMessage `json:"items"`
+}
+
+// The kinds of items cached
+const (
+	cacheRooms    = "rooms"
+	cacheSections = "sections"
+	cacheDevices  = "devices"
+)
+
+// NewMetadataCache returns a cache for the rooms, sections and devices of c
+func NewMetadataCache(c Controller) *MetadataCache {
+	return &MetadataCache{Controller: c}
+}
+
+// Persist makes the cache write everything retrieved to file, and use the
+// items found in file as long as they have been retrieved less than ttl ago.
+// A ttl of 0 disables the persistence.
+func (c *MetadataCache) Persist(file string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file, c.ttl, c.stored = file, ttl, nil
+}
+
+// Invalidate drops all cached items, from memory and from the persisted file
+func (c *MetadataCache) Invalidate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rooms, c.sections, c.devices = nil, nil, nil
+	if !c.persistent() {
+		return nil
+	}
+	c.stored = &metadataFile{URL: c.Config().BaseURL, Entries: map[string]metadataEntry{}}
+	return c.writeFile()
+}
+
+// AllRooms returns all rooms of the controller
+func (c *MetadataCache) AllRooms() ([]Hc2Room, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rooms == nil {
+		var rooms []Hc2Room
+		if !c.restore(cacheRooms, &rooms) {
+			var err error
+			if rooms, err = c.Controller.AllRooms(); err != nil {
+				return nil, err
+			}
+			c.fetch(cacheRooms)
+			c.store(cacheRooms, rooms)
+		}
+		c.rooms = append([]Hc2Room{}, rooms...)
+	}
+	return append([]Hc2Room(nil), c.rooms...), nil
+}
+
+// OneRoom returns the room identified by roomID. If it does not exist the
+// returned error matches ErrNotFound.
+func (c *MetadataCache) OneRoom(roomID int) (Hc2Room, error) {
+	for {
+		rooms, err := c.AllRooms()
+		if err != nil {
+			return Hc2Room{}, err
+		}
+		for _, r := range rooms {
+			if r.RoomID == roomID {
+				return r, nil
+			}
+		}
+		if !c.reload(cacheRooms) {
+			return Hc2Room{}, notCached("/rooms/" + strconv.Itoa(roomID))
+		}
+	}
+}
+
+// AllSections returns all sections of the controller
+func (c *MetadataCache) AllSections() ([]Hc2Section, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sections == nil {
+		var sections []Hc2Section
+		if !c.restore(cacheSections, &sections) {
+			var err error
+			if sections, err = c.Controller.AllSections(); err != nil {
+				return nil, err
+			}
+			c.fetch(cacheSections)
+			c.store(cacheSections, sections)
+		}
+		c.sections = append([]Hc2Section{}, sections...)
+	}
+	return append([]Hc2Section(nil), c.sections...), nil
+}
+
+// OneSection returns the section identified by sectionID. If it does not exist the
+// returned error matches ErrNotFound.
+func (c *MetadataCache) OneSection(sectionID int) (Hc2Section, error) {
+	for {
+		sections, err := c.AllSections()
+		if err != nil {
+			return Hc2Section{}, err
+		}
+		for _, s := range sections {
+			if s.SectionID == sectionID {
+				return s, nil
+			}
+		}
+		if !c.reload(cacheSections) {
+			return Hc2Section{}, notCached("/sections/" + strconv.Itoa(sectionID))
+		}
+	}
+}
+
+// AllDevices returns all devices of the controller
+func (c *MetadataCache) AllDevices() ([]Hc2Device, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.devices == nil {
+		var devices []Hc2Device
+		if !c.restore(cacheDevices, &devices) {
+			var err error
+			if devices, err = c.Controller.AllDevices(); err != nil {
+				return nil, err
+			}
+			c.fetch(cacheDevices)
+			c.store(cacheDevices, devices)
+		}
+		c.devices = append([]Hc2Device{}, devices...)
+	}
+	return append([]Hc2Device(nil), c.devices...), nil
+}
+
+// OneDevice returns the device identified by deviceID. If it does not exist the
+// returned error matches ErrNotFound.
+func (c *MetadataCache) OneDevice(deviceID int) (Hc2Device, error) {
+	for {
+		devices, err := c.AllDevices()
+		if err != nil {
+			return Hc2Device{}, err
+		}
+		for _, d := range devices {
+			if d.ID == deviceID {
+				return d, nil
+			}
+		}
+		if !c.reload(cacheDevices) {
+			return Hc2Device{}, notCached("/devices/" + strconv.Itoa(deviceID))
+		}
+	}
+}
+
+// CreateRoom creates a room and drops the cached items
+func (c *MetadataCache) CreateRoom(room Hc2Room) (Hc2Room, error) {
+	defer c.modified()
+	return c.Controller.CreateRoom(room)
+}
+
+// UpdateRoom updates a room and drops the cached items
+func (c *MetadataCache) UpdateRoom(room Hc2Room) error {
+	defer c.modified()
+	return c.Controller.UpdateRoom(room)
+}
+
+// DeleteRoom deletes a room and drops the cached items
+func (c *MetadataCache) DeleteRoom(roomID int) error {
+	defer c.modified()
+	return c.Controller.DeleteRoom(roomID)
+}
+
+// CreateSection creates a section and drops the cached items
+func (c *MetadataCache) CreateSection(section Hc2Section) (Hc2Section, error) {
+	defer c.modified()
+	return c.Controller.CreateSection(section)
+}
+
+// UpdateSection updates a section and drops the cached items
+func (c *MetadataCache) UpdateSection(section Hc2Section) error {
+	defer c.modified()
+	return c.Controller.UpdateSection(section)
+}
+
+// DeleteSection deletes a section and drops the cached items
+func (c *MetadataCache) DeleteSection(sectionID int) error {
+	defer c.modified()
+	return c.Controller.DeleteSection(sectionID)
+}
+
+// MoveDevice moves a device into another room and drops the cached items
+func (c *MetadataCache) MoveDevice(deviceID, roomID int) error {
+	defer c.modified()
+	return c.Controller.MoveDevice(deviceID, roomID)
+}
+
+// modified invalidates the cache after rooms, sections or devices have been modified
+func (c *MetadataCache) modified() {
+	if err := c.Invalidate(); err != nil {
+		log.Warnf("Could not invalidate cache file %s: %v", c.file, err)
+	}
+}
+
+// fetch records that the items of kind have been retrieved from the controller
+func (c *MetadataCache) fetch(kind string) {
+	if c.fetched == nil {
+		c.fetched = map[string]bool{}
+	}
+	c.fetched[kind] = true
+}
+
+// reload drops the items of kind if they have been restored from the persisted file,
+// so that they are retrieved from the controller by the next lookup. It returns false
+// if they have been retrieved from the controller already.
+func (c *MetadataCache) reload(kind string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetched[kind] {
+		return false
+	}
+	switch kind {
+	case cacheRooms:
+		c.rooms = nil
+	case cacheSections:
+		c.sections = nil
+	case cacheDevices:
+		c.devices = nil
+	}
+	if c.stored != nil {
+		delete(c.stored.Entries, kind)
+	}
+	log.Debugf("%s not cached, retrieving them again", kind)
+	return true
+}
+
+func notCached(path string) error {
+	return &APIError{Method: http.MethodGet, Path: path, Kind: ErrNotFound}
+}
+
+func (c *MetadataCache) persistent() bool {
+	return c.file != "" && c.ttl > 0
+}
+
+// restore decodes the items of kind from the persisted file into v, if they are
+// not older than the ttl
+func (c *MetadataCache) restore(kind string, v interface{}) bool {
+	if !c.persistent() {
+		return false
+	}
+	if c.stored == nil {
+		c.stored = c.readFile()
+	}
+	e, ok := c.stored.Entries[kind]
+	if !ok || time.Since(e.Fetched) >= c.ttl {
+		return false
+	}
+	if err := json.Unmarshal(e.Items, v); err != nil {
+		log.Debugf("Ignoring cached %s in %s: %v", kind, c.file, err)
+		return false
+	}
+	log.Tracef("Using %s cached in %s at %v", kind, c.file, e.Fetched)
+	return true
+}
+
+// store writes the items of kind to the persisted file
+func (c *MetadataCache) store(kind string, items interface{}) {
+	if !c.persistent() {
+		return
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		log.Warnf("Could not cache %s: %v", kind, err)
+		return
+	}
+	if c.stored == nil {
+		c.stored = c.readFile()
+	}
+	c.stored.Entries[kind] = metadataEntry{Fetched: time.Now(), Items: b}
+	if err := c.writeFile(); err != nil {
+		log.Warnf("Could not write cache file %s: %v", c.file, err)
+	}
+}
+
+// readFile reads the persisted file. Files of another controller, or files that
+// can not be read, are ignored.
+func (c *MetadataCache) readFile() *metadataFile {
+	empty := &metadataFile{URL: c.Config().BaseURL, Entries: map[string]metadataEntry{}}
+	b, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return empty
+	}
+	var m metadataFile
+	if err := json.Unmarshal(b, &m); err != nil {
+		log.Debugf("Ignoring cache file %s: %v", c.file, err)
+		return empty
+	}
+	if m.URL != empty.URL || m.Entries == nil {
+		return empty
+	}
+	return &m
+}
+
+func (c *MetadataCache) writeFile() error {
+	b, err := json.Marshal(c.stored)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.file, b, 0600)
+}
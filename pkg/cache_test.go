@@ -0,0 +1,143 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+const (
+	roomsURL    = "http://192.10.66.55/api/rooms"
+	sectionsURL = "http://192.10.66.55/api/sections"
+)
+
+func mockMetadata() *FibaroHc2 {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	httpmock.RegisterResponder(http.MethodGet, roomsURL, httpmock.NewStringResponder(200,
+		`[{"id":5,"name":"Schlafzimmer","sectionID":4},{"id":6,"name":"Bad","sectionID":4}]`))
+	httpmock.RegisterResponder(http.MethodGet, sectionsURL, httpmock.NewStringResponder(200,
+		`[{"id":4,"name":"NickyTheo"}]`))
+	return &FibaroHc2{*cfg}
+}
+
+func TestMetadataCache(t *testing.T) {
+	f := mockMetadata()
+	defer httpmock.DeactivateAndReset()
+
+	c := NewMetadataCache(f)
+	for i := 0; i < 3; i++ {
+		room, err := c.OneRoom(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEqual(t, room.Name, "Schlafzimmer")
+		section, err := c.OneSection(room.SectionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEqual(t, section.Name, "NickyTheo")
+	}
+	if _, err := c.OneRoom(0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OneRoom(0) = %v, want ErrNotFound", err)
+	}
+
+	info := httpmock.GetCallCountInfo()
+	AssertEqual(t, info["GET "+roomsURL], 1)
+	AssertEqual(t, info["GET "+sectionsURL], 1)
+
+	if err := c.Invalidate(); err != nil {
+		t.Fatal(err)
+	}
+	c.OneRoom(5)
+	AssertEqual(t, httpmock.GetCallCountInfo()["GET "+roomsURL], 2)
+
+	// modifying a room drops the cached items
+	httpmock.RegisterResponder(http.MethodPut, roomsURL+"/5", httpmock.NewStringResponder(200, ""))
+	if err := c.UpdateRoom(Hc2Room{RoomID: 5, Name: "Gästezimmer", SectionID: 4}); err != nil {
+		t.Fatal(err)
+	}
+	c.OneRoom(5)
+	AssertEqual(t, httpmock.GetCallCountInfo()["GET "+roomsURL], 3)
+}
+
+func TestMetadataCache_Persist(t *testing.T) {
+	f := mockMetadata()
+	defer httpmock.DeactivateAndReset()
+
+	dir, err := ioutil.TempDir("", "hc2cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cache.json")
+
+	c := NewMetadataCache(f)
+	c.Persist(file, time.Hour)
+	if _, err := c.AllRooms(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a later run uses the persisted rooms
+	c = NewMetadataCache(f)
+	c.Persist(file, time.Hour)
+	rooms, err := c.AllRooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, len(rooms), 2)
+	AssertEqual(t, httpmock.GetCallCountInfo()["GET "+roomsURL], 1)
+
+	// a room created since is retrieved once, even before the ttl expired
+	httpmock.RegisterResponder(http.MethodGet, roomsURL, httpmock.NewStringResponder(200,
+		`[{"id":5,"name":"Schlafzimmer","sectionID":4},{"id":6,"name":"Bad","sectionID":4},{"id":7,"name":"Büro","sectionID":4}]`))
+	room, err := c.OneRoom(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, room.Name, "Büro")
+	if _, err := c.OneRoom(8); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OneRoom(8) = %v, want ErrNotFound", err)
+	}
+	// registering the responder again has reset the count
+	AssertEqual(t, httpmock.GetCallCountInfo()["GET "+roomsURL], 1)
+	c = NewMetadataCache(f)
+	c.Persist(file, time.Hour)
+	if _, err := c.OneRoom(7); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, httpmock.GetCallCountInfo()["GET "+roomsURL], 1)
+
+	// but not if they are expired ...
+	b, _ := ioutil.ReadFile(file)
+	var m metadataFile
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	e := m.Entries[cacheRooms]
+	e.Fetched = e.Fetched.Add(-2 * time.Hour)
+	m.Entries[cacheRooms] = e
+	b, _ = json.Marshal(m)
+	ioutil.WriteFile(file, b, 0600)
+
+	c = NewMetadataCache(f)
+	c.Persist(file, time.Hour)
+	c.AllRooms()
+	AssertEqual(t, httpmock.GetCallCountInfo()["GET "+roomsURL], 2)
+
+	// ... or belong to another controller
+	other := &FibaroHc2{f.cfg}
+	other.cfg.BaseURL = "http://10.0.0.1"
+	c = NewMetadataCache(other)
+	c.Persist(file, time.Hour)
+	if _, err := c.AllRooms(); err == nil {
+		t.Error("AllRooms() of another controller should not use the cache file")
+	}
+}
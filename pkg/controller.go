@@ -0,0 +1,99 @@
+package fibarohc2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+// The platforms of Fibaro controllers supported
+const (
+	PlatformHC2 = "HC2"
+	PlatformHC3 = "HC3" // Home Center 3, HC3 Lite and Yubii Home
+)
+
+// Controller is the API of a Fibaro controller used to download, upload and
+// interact with scenes. It is implemented by FibaroHc2 and FibaroHc3.
+type Controller interface {
+	Config() *FibaroConfig
+	SetConfig(fc FibaroConfig)
+	Info(indent int) (string, error)
+	WriteInitConfigFile(path string) (int, error)
+
+	AllScenes() ([]Hc2Scene, error)
+	OneScene(sceneID int) (Hc2Scene, error)
+	PutOneScene(scene Hc2Scene) (*resty.Response, error)
+	CreateScene(scene Hc2Scene) (int, error)
+	Action(sceneID int, c SceneActionCommand) error
+	DebugMessages(sceneID int) ([]Hc2DebugMessage, error)
+
+	AllDevices() ([]Hc2Device, error)
+	OneDevice(deviceID int) (Hc2Device, error)
+	MoveDevice(deviceID, roomID int) error
+	CallAction(deviceID int, action string, args ...interface{}) error
+	AllRooms() ([]Hc2Room, error)
+	OneRoom(roomID int) (Hc2Room, error)
+	CreateRoom(room Hc2Room) (Hc2Room, error)
+	UpdateRoom(room Hc2Room) error
+	DeleteRoom(roomID int) error
+	AllSections() ([]Hc2Section, error)
+	OneSection(sectionID int) (Hc2Section, error)
+	CreateSection(section Hc2Section) (Hc2Section, error)
+	UpdateSection(section Hc2Section) error
+	DeleteSection(sectionID int) error
+	AllGlobals() ([]Hc2GlobalVariable, error)
+
+	RefreshStates(ctx context.Context, last int64) (<-chan Event, error)
+}
+
+// NewController returns the Controller for the platform configured in cfg.
+// If no platform is configured it is detected by asking the controller
+// for its /api/settings/info.
+func NewController(cfg FibaroConfig) (Controller, error) {
+	if cfg.client == nil {
+		cfg.client = resty.New()
+	}
+	hc2 := &FibaroHc2{cfg}
+
+	platform := strings.ToUpper(cfg.Platform)
+	if platform == "" {
+		info, err := hc2.settingsInfo()
+		if err != nil {
+			return nil, fmt.Errorf("detecting platform: %w", err)
+		}
+		platform = DetectPlatform(info)
+	}
+
+	switch platform {
+	case PlatformHC2:
+		hc2.cfg.Platform = PlatformHC2
+		return hc2, nil
+	case PlatformHC3:
+		hc2.cfg.Platform = PlatformHC3
+		return &FibaroHc3{*hc2}, nil
+	default:
+		return nil, fmt.Errorf("unknown platform %q, use one of %s, %s", cfg.Platform, PlatformHC2, PlatformHC3)
+	}
+}
+
+// DetectPlatform returns the platform of a controller given its settings info.
+// HC3 and Yubii controllers report a platform, or can be recognized by their
+// serial number or a software version of 5 and above.
+func DetectPlatform(info Hc2Info) string {
+	p := strings.ToUpper(info.Platform)
+	serial := strings.ToUpper(info.SerialNumber)
+	switch {
+	case strings.HasPrefix(p, PlatformHC3), strings.HasPrefix(p, "YH"):
+		return PlatformHC3
+	case strings.HasPrefix(serial, PlatformHC3), strings.HasPrefix(serial, "YH"):
+		return PlatformHC3
+	}
+	major, err := strconv.Atoi(strings.SplitN(info.SoftVersion, ".", 2)[0])
+	if err == nil && major >= 5 {
+		return PlatformHC3
+	}
+	return PlatformHC2
+}
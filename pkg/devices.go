@@ -1,30 +1,262 @@
 package fibarohc2
 
-import "strconv"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
 
 // Hc2Device represents a device in the HC2 system. Can be encoded as JSON.
 type Hc2Device struct {
-	Name       string   `json:"name"`
-	Type       string   `json:"type"`
-	ID         int      `json:"id"`
-	RoomID     int      `json:"roomID"`
-	Interfaces []string `json:"interfaces"`
-	ParentID   int      `json:"parentId"`
-	Enabled    bool     `json:"enabled"`
-	Visible    bool     `json:"visible"`
-	Properties struct {
-		Dead                interface{} `json:"dead"`
-		Energy              interface{} `json:"energy"`
-		Power               interface{} `json:"power"`
-		Sat                 interface{} `json:"sat"`
-		Bri                 interface{} `json:"bri"`
-		Ct                  interface{} `json:"ct"`
-		Hue                 interface{} `json:"hue"`
-		On                  interface{} `json:"on"`
-		Value               interface{} `json:"value"`
-		CentralSceneSupport interface{} `json:"centralSceneSupport"`
-	} `json:"properties"`
+	Name       string              `json:"name"`
+	Type       string              `json:"type"`
+	ID         int                 `json:"id"`
+	RoomID     int                 `json:"roomID"`
+	Interfaces []string            `json:"interfaces"`
+	ParentID   int                 `json:"parentId"`
+	Enabled    bool                `json:"enabled"`
+	Visible    bool                `json:"visible"`
+	Actions    map[string]int      `json:"actions,omitempty"` // actions of the device and their number of arguments
+	Properties Hc2DeviceProperties `json:"properties"`
 }
+
+// Hc2DeviceProperties are the properties of a device. The HC2 encodes most values as
+// strings, e.g. "value": "0" or "dead": "false", the HC3 as numbers and booleans.
+// PropertyValue reads both. Properties not declared here are kept in Other, as are
+// declared ones of an unexpected type, so that a device is encoded as it was decoded.
+type Hc2DeviceProperties struct {
+	Value               PropertyValue
+	Dead                PropertyValue
+	BatteryLevel        PropertyValue
+	Energy              PropertyValue
+	Power               PropertyValue
+	Armed               PropertyValue
+	Configured          PropertyValue
+	On                  PropertyValue
+	Bri                 PropertyValue
+	Sat                 PropertyValue
+	Ct                  PropertyValue
+	Hue                 PropertyValue
+	CentralSceneSupport PropertyValue // a JSON encoded list of Key, as string on the HC2
+	Associations        PropertyValue // format differs between device types and firmwares
+	UserDescription     string
+	Parameters          []Hc2DeviceParameter
+
+	Other map[string]json.RawMessage
+}
+
+// Hc2DeviceParameter is a configuration parameter of a Z-Wave device
+type Hc2DeviceParameter struct {
+	ID                int `json:"id"`
+	Size              int `json:"size"`
+	Value             int `json:"value"`
+	LastReportedValue int `json:"lastReportedValue"`
+	LastSetValue      int `json:"lastSetValue"`
+}
+
+// values returns the properties of type PropertyValue by their JSON name
+func (p *Hc2DeviceProperties) values() map[string]*PropertyValue {
+	return map[string]*PropertyValue{
+		"value":               &p.Value,
+		"dead":                &p.Dead,
+		"batteryLevel":        &p.BatteryLevel,
+		"energy":              &p.Energy,
+		"power":               &p.Power,
+		"armed":               &p.Armed,
+		"configured":          &p.Configured,
+		"on":                  &p.On,
+		"bri":                 &p.Bri,
+		"sat":                 &p.Sat,
+		"ct":                  &p.Ct,
+		"hue":                 &p.Hue,
+		"centralSceneSupport": &p.CentralSceneSupport,
+		"associations":        &p.Associations,
+	}
+}
+
+// UnmarshalJSON decodes the properties of a device
+func (p *Hc2DeviceProperties) UnmarshalJSON(b []byte) error {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	*p = Hc2DeviceProperties{}
+	values := p.values()
+	for name, raw := range all {
+		var err error
+		switch name {
+		case "userDescription":
+			err = json.Unmarshal(raw, &p.UserDescription)
+		case "parameters":
+			err = json.Unmarshal(raw, &p.Parameters)
+		default:
+			if v, ok := values[name]; ok {
+				err = v.UnmarshalJSON(raw)
+			} else {
+				err = errUntyped
+			}
+		}
+		if err != nil {
+			// drop what has been decoded partially, so that raw is encoded unchanged
+			switch name {
+			case "userDescription":
+				p.UserDescription = ""
+			case "parameters":
+				p.Parameters = nil
+			}
+			if p.Other == nil {
+				p.Other = map[string]json.RawMessage{}
+			}
+			p.Other[name] = raw
+		}
+	}
+	return nil
+}
+
+var errUntyped = errors.New("untyped property")
+
+// MarshalJSON encodes the properties of a device. Properties not decoded are omitted.
+func (p Hc2DeviceProperties) MarshalJSON() ([]byte, error) {
+	all := map[string]json.RawMessage{}
+	for name, raw := range p.Other {
+		all[name] = raw
+	}
+	for name, v := range p.values() {
+		if len(*v) > 0 {
+			all[name] = json.RawMessage(*v)
+		}
+	}
+	if p.UserDescription != "" {
+		b, _ := json.Marshal(p.UserDescription)
+		all["userDescription"] = b
+	}
+	if p.Parameters != nil {
+		b, err := json.Marshal(p.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		all["parameters"] = b
+	}
+	return json.Marshal(all)
+}
+
+// Property returns the property name, whether declared in Hc2DeviceProperties or not.
+// The result is not set if the device has no such property.
+func (p Hc2DeviceProperties) Property(name string) PropertyValue {
+	if v, ok := p.values()[name]; ok && v.IsSet() {
+		return *v
+	}
+	if raw, ok := p.Other[name]; ok {
+		return PropertyValue(raw)
+	}
+	switch {
+	case name == "userDescription" && p.UserDescription != "":
+		b, _ := json.Marshal(p.UserDescription)
+		return b
+	case name == "parameters" && p.Parameters != nil:
+		b, _ := json.Marshal(p.Parameters)
+		return b
+	}
+	return nil
+}
+
+// PropertyValue is the JSON encoding of a property of a device. The accessors
+// convert it to the type needed, returning an error if it is not set or cannot be
+// converted.
+type PropertyValue json.RawMessage
+
+// ErrPropertyNotSet is returned by the accessors of a PropertyValue if the device
+// does not have the property
+var ErrPropertyNotSet = errors.New("property not set")
+
+// IsSet returns whether the device has the property
+func (v PropertyValue) IsSet() bool {
+	return len(v) > 0 && !bytes.Equal(v, []byte("null"))
+}
+
+// UnmarshalJSON keeps a copy of the encoded property
+func (v *PropertyValue) UnmarshalJSON(b []byte) error {
+	*v = append((*v)[:0], b...)
+	return nil
+}
+
+// MarshalJSON returns the encoded property, null if not set
+func (v PropertyValue) MarshalJSON() ([]byte, error) {
+	if len(v) == 0 {
+		return []byte("null"), nil
+	}
+	return v, nil
+}
+
+// String returns the property as string. Strings are returned unquoted, all other
+// values as their JSON encoding.
+func (v PropertyValue) String() (string, error) {
+	if !v.IsSet() {
+		return "", ErrPropertyNotSet
+	}
+	if v[0] == '"' {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	return string(v), nil
+}
+
+// Float returns the property as float64. Numbers and strings containing a number
+// are converted.
+func (v PropertyValue) Float() (float64, error) {
+	s, err := v.String()
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("property %s is not a number", v)
+	}
+	return f, nil
+}
+
+// Int returns the property as int. Numbers with a fraction, like "12.50", result in
+// an error.
+func (v PropertyValue) Int() (int, error) {
+	s, err := v.String()
+	if err != nil {
+		return 0, err
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return i, nil
+	}
+	f, err := v.Float()
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) {
+		return 0, fmt.Errorf("property %s is not an integer", v)
+	}
+	return int(f), nil
+}
+
+// Bool returns the property as bool. Besides booleans, "true" and "false", and
+// numbers, which are true if not 0, are converted.
+func (v PropertyValue) Bool() (bool, error) {
+	s, err := v.String()
+	if err != nil {
+		return false, err
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f != 0, nil
+	}
+	return false, fmt.Errorf("property %s is not a boolean", v)
+}
+
 type Key struct {
 	KeyAttribute []string `json:"keyAttributes"`
 	KeyId        int      `json:"keyId"`
@@ -39,31 +271,29 @@ func (d Hc2Device) Implements(name string) bool {
 	return false
 }
 
+// ValidateAction checks that the device supports action with nargs arguments. Devices
+// not declaring their actions support all actions.
+func (d Hc2Device) ValidateAction(action string, nargs int) error {
+	if d.Actions == nil {
+		return nil
+	}
+	n, ok := d.Actions[action]
+	switch {
+	case !ok:
+		return fmt.Errorf("device %d (%s) has no action %q: %w", d.ID, d.Type, action, ErrInvalidAction)
+	case nargs < n:
+		return fmt.Errorf("action %q of device %d expects %d arguments, %d given: %w", action, d.ID, n, nargs, ErrInvalidAction)
+	}
+	return nil
+}
+
+// GetValue returns the property value of the device as int. It returns -1 if the
+// device has no such property and 0 if it is not an integer.
 func (d Hc2Device) GetValue(value string) int {
-	switch value {
-	case "bri":
-		v, _ := strconv.Atoi(d.Properties.Bri.(string))
-		return v
-	case "sat":
-		v, _ := strconv.Atoi(d.Properties.Sat.(string))
-		return v
-	case "ct":
-		v, _ := strconv.Atoi(d.Properties.Ct.(string))
-		return v
-	case "hue":
-		v, _ := strconv.Atoi(d.Properties.Hue.(string))
-		return v
-	case "power":
-		v, _ := strconv.Atoi(d.Properties.Power.(string))
-		return v
-	case "energy":
-		v, _ := strconv.Atoi(d.Properties.Energy.(string))
-		return v
-	case "value":
-		v, _ := strconv.Atoi(d.Properties.Value.(string))
-		return v
-	}
-	return -1
-}
-
-// Don't forget to cast the interface type in case you are using it
+	p := d.Properties.Property(value)
+	if !p.IsSet() {
+		return -1
+	}
+	v, _ := p.Int()
+	return v
+}
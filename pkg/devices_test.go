@@ -0,0 +1,127 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const hc2Device = `{"id":449,"name":"Lamp","type":"com.fibaro.FGD212","roomID":5,"properties":{` +
+	`"value":"42","dead":"false","batteryLevel":"","energy":"1.25","power":"12.50","configured":"true",` +
+	`"userDescription":"desk","parameters":[{"id":1,"size":1,"value":255,"lastReportedValue":255,"lastSetValue":255}],` +
+	`"centralSceneSupport":"[{\"keyAttributes\":[\"Pressed\"],\"keyId\":1}]","deviceIcon":28,"zwaveInfo":"3,4,5"}}`
+
+const hc3Device = `{"id":30,"name":"Switch","type":"com.fibaro.binarySwitch","roomID":2,"properties":{` +
+	`"value":true,"dead":false,"batteryLevel":100,"energy":0.5,"armed":false,"userDescription":12}}`
+
+func TestHc2Device_Properties(t *testing.T) {
+	var hc2, hc3 Hc2Device
+	if err := json.Unmarshal([]byte(hc2Device), &hc2); err != nil {
+		t.Fatalf("Unmarshal(hc2) error = %v", err)
+	}
+	if err := json.Unmarshal([]byte(hc3Device), &hc3); err != nil {
+		t.Fatalf("Unmarshal(hc3) error = %v", err)
+	}
+
+	intOf := func(v PropertyValue) interface{} { i, err := v.Int(); return result(i, err) }
+	floatOf := func(v PropertyValue) interface{} { f, err := v.Float(); return result(f, err) }
+	boolOf := func(v PropertyValue) interface{} { b, err := v.Bool(); return result(b, err) }
+	stringOf := func(v PropertyValue) interface{} { s, err := v.String(); return result(s, err) }
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"hc2 value", intOf(hc2.Properties.Value), 42},
+		{"hc2 value as bool", boolOf(hc2.Properties.Value), true},
+		{"hc2 dead", boolOf(hc2.Properties.Dead), false},
+		{"hc2 energy", floatOf(hc2.Properties.Energy), 1.25},
+		{"hc2 power not integer", intOf(hc2.Properties.Power), "error"},
+		{"hc2 empty battery", intOf(hc2.Properties.BatteryLevel), "error"},
+		{"hc2 not set", boolOf(hc2.Properties.Armed), ErrPropertyNotSet},
+		{"hc2 dead not a number", floatOf(hc2.Properties.Dead), "error"},
+		{"hc2 user description", hc2.Properties.UserDescription, "desk"},
+		{"hc2 parameters", hc2.Properties.Parameters, []Hc2DeviceParameter{{1, 1, 255, 255, 255}}},
+		{"hc2 other", intOf(hc2.Properties.Property("deviceIcon")), 28},
+		{"hc2 other string", stringOf(hc2.Properties.Property("zwaveInfo")), "3,4,5"},
+		{"hc2 GetValue", hc2.GetValue("value"), 42},
+		{"hc2 GetValue not integer", hc2.GetValue("power"), 0},
+		{"hc2 GetValue unknown", hc2.GetValue("hue"), -1},
+		{"hc3 value", boolOf(hc3.Properties.Value), true},
+		{"hc3 value as string", stringOf(hc3.Properties.Value), "true"},
+		{"hc3 value not integer", intOf(hc3.Properties.Value), "error"},
+		{"hc3 battery", intOf(hc3.Properties.BatteryLevel), 100},
+		{"hc3 energy", floatOf(hc3.Properties.Energy), 0.5},
+		{"hc3 armed", boolOf(hc3.Properties.Armed), false},
+		{"hc3 mistyped user description", stringOf(hc3.Properties.Property("userDescription")), "12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+
+	var keys []Key
+	support, err := hc2.Properties.CentralSceneSupport.String()
+	if err == nil {
+		err = json.Unmarshal([]byte(support), &keys)
+	}
+	if err != nil || len(keys) != 1 || keys[0].KeyId != 1 {
+		t.Errorf("CentralSceneSupport = %v, %v", keys, err)
+	}
+}
+
+// result reduces the result of an accessor to a value comparable in tests
+func result(v interface{}, err error) interface{} {
+	switch {
+	case errors.Is(err, ErrPropertyNotSet):
+		return ErrPropertyNotSet
+	case err != nil:
+		return "error"
+	}
+	return v
+}
+
+func TestHc2Device_MarshalJSON(t *testing.T) {
+	for _, device := range []string{hc2Device, hc3Device} {
+		var d Hc2Device
+		if err := json.Unmarshal([]byte(device), &d); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		b, err := json.Marshal(d)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		var want, got map[string]interface{}
+		json.Unmarshal([]byte(device), &want)
+		json.Unmarshal(b, &got)
+		if !reflect.DeepEqual(got["properties"], want["properties"]) {
+			t.Errorf("Marshal() properties = %v, want %v", got["properties"], want["properties"])
+		}
+	}
+}
+
+func TestHc2DeviceProperties_MarshalJSON_mistyped(t *testing.T) {
+	for _, properties := range []string{
+		`{"parameters":[{"id":1,"value":"5"}],"userDescription":"lamp"}`,
+		`{"parameters":{"id":1},"userDescription":5}`,
+	} {
+		var p Hc2DeviceProperties
+		if err := json.Unmarshal([]byte(properties), &p); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		var want, got interface{}
+		json.Unmarshal([]byte(properties), &want)
+		json.Unmarshal(b, &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Marshal() = %s, want %s", b, properties)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package fibarohc2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns the differences between the texts a and b in the unified
+// diff format, labelled with aName and bName. An empty string is returned if
+// both texts are equal.
+func UnifiedDiff(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var str strings.Builder
+	_, _ = str.WriteString("--- " + aName + "\n")
+	_, _ = str.WriteString("+++ " + bName + "\n")
+
+	// aLine and bLine are the line numbers (0 based) before ops[i]
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		// start a hunk diffContext lines before the change
+		start := i
+		for start > 0 && i-start < diffContext && ops[start-1].kind == ' ' {
+			start--
+		}
+		hunkA, hunkB := aLine-(i-start), bLine-(i-start)
+
+		// extend the hunk until diffContext*2 unchanged lines follow
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end += minInt(run-end, diffContext)
+				break
+			}
+			end = run
+		}
+
+		var countA, countB int
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			_, _ = body.WriteString(string(op.kind) + op.line + "\n")
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		_, _ = str.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(hunkA, countA), hunkRange(hunkB, countB)))
+		_, _ = str.WriteString(body.String())
+
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return str.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes the edit script from a to b based on the longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// strip common prefix and suffix, to keep the table small
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	i, j := 0, 0
+	for i < len(ma) && j < len(mb) {
+		switch {
+		case ma[i] == mb[j]:
+			ops = append(ops, diffOp{' ', ma[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', ma[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', mb[j]})
+			j++
+		}
+	}
+	for ; i < len(ma); i++ {
+		ops = append(ops, diffOp{'-', ma[i]})
+	}
+	for ; j < len(mb); j++ {
+		ops = append(ops, diffOp{'+', mb[j]})
+	}
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// FieldDiff is a field whose value differs between two scenes
+type FieldDiff struct {
+	Field string
+	A, B  string
+}
+
+// SceneDiff describes the differences between two scenes
+type SceneDiff struct {
+	Fields []FieldDiff // differing fields, in the order of the FIBARO_GIT_HOOK header
+	Lua    string      // unified diff of the Lua code, without FIBARO_GIT_HOOK headers
+}
+
+// Empty returns true if there are no differences
+func (d SceneDiff) Empty() bool {
+	return len(d.Fields) == 0 && d.Lua == ""
+}
+
+func (d SceneDiff) String() string {
+	var str strings.Builder
+	for _, f := range d.Fields {
+		_, _ = str.WriteString(fmt.Sprintf("%s: %s -> %s\n", f.Field, f.A, f.B))
+	}
+	if len(d.Fields) > 0 && d.Lua != "" {
+		_, _ = str.WriteString("\n")
+	}
+	_, _ = str.WriteString(d.Lua)
+	return str.String()
+}
+
+// DiffScenes compares the scenes a and b. The fields name, roomID, runConfig,
+// maxRunningInstances, autostart and visible are compared by value, the Lua code
+// is compared line by line after removing the FIBARO_GIT_HOOK headers.
+func DiffScenes(a, b Hc2Scene) SceneDiff {
+	var d SceneDiff
+	field := func(name, va, vb string) {
+		if va != vb {
+			d.Fields = append(d.Fields, FieldDiff{Field: name, A: va, B: vb})
+		}
+	}
+	field("name", strconv.Quote(a.Name), strconv.Quote(b.Name))
+	field("roomID", strconv.Itoa(a.RoomID), strconv.Itoa(b.RoomID))
+	field("runConfig", a.RunConfig, b.RunConfig)
+	field("maxRunningInstances", strconv.Itoa(a.MaxRunningInstances), strconv.Itoa(b.MaxRunningInstances))
+	field("autostart", strconv.FormatBool(a.Autostart), strconv.FormatBool(b.Autostart))
+	field("visible", strconv.FormatBool(a.Visible), strconv.FormatBool(b.Visible))
+
+	a.TrimLuaHeaders()
+	b.TrimLuaHeaders()
+	d.Lua = UnifiedDiff("a/"+a.Name, "b/"+b.Name, normalizeLua(a.Lua), normalizeLua(b.Lua))
+	return d
+}
+
+// normalizeLua terminates non-empty Lua code with exactly one newline
+func normalizeLua(lua string) string {
+	lua = strings.TrimRight(lua, "\n")
+	if lua == "" {
+		return ""
+	}
+	return lua + "\n"
+}
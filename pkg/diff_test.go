@@ -0,0 +1,58 @@
+package fibarohc2
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"changed line",
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n2\n3\n4\nfive\n6\n7\n8\n",
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"added to empty",
+			"",
+			"x\n",
+			"--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			"two hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("a", "b", tt.a, tt.b); got != tt.want {
+				t.Errorf("UnifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffScenes(t *testing.T) {
+	a := Hc2Scene{SceneID: 203, Name: "A_Trial_2", RoomID: 305, RunConfig: TriggerAndManual, Visible: true, Lua: "print(1)\nprint(2)\n"}
+	a.UpdateLuaHeader()
+
+	b := a
+	b.TrimLuaHeaders()
+	if d := DiffScenes(a, b); !d.Empty() {
+		t.Errorf("DiffScenes() of scenes differing in header only = %v, want empty", d)
+	}
+
+	b.RoomID = 306
+	b.Visible = false
+	b.Lua = "print(1)\nprint(3)"
+	d := DiffScenes(a, b)
+	AssertEqual(t, len(d.Fields), 2)
+	AssertEqual(t, d.Fields[0], FieldDiff{Field: "roomID", A: "305", B: "306"})
+	AssertEqual(t, d.Fields[1], FieldDiff{Field: "visible", A: "true", B: "false"})
+	AssertEqual(t, d.Lua, "--- a/A_Trial_2\n+++ b/A_Trial_2\n@@ -1,2 +1,2 @@\n print(1)\n-print(2)\n+print(3)\n")
+}
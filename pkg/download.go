@@ -0,0 +1,95 @@
+package fibarohc2
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DownloadOptions control how DownloadScenes retrieves scenes
+type DownloadOptions struct {
+	Parallel int           // number of concurrent requests, at least 1
+	Retries  int           // how often a request failing with a temporary error is repeated
+	Backoff  time.Duration // delay before the first retry, doubled for every further retry
+	Interval time.Duration // minimum time between the start of two requests, 0 for no limit
+
+	// Progress, if set, is called after every scene retrieved with the number of scenes
+	// done so far. It is never called concurrently.
+	Progress func(done, total int)
+}
+
+// SceneDownload is the outcome of retrieving one scene by DownloadScenes
+type SceneDownload struct {
+	SceneID int
+	Scene   Hc2Scene
+	Err     error
+}
+
+// DownloadScenes retrieves the scenes identified by sceneIDs from c, using up to
+// opts.Parallel concurrent requests. Requests failing with a temporary error, see
+// IsTemporary, are retried. The results are returned in the order of sceneIDs,
+// regardless of the order in which the requests completed.
+func DownloadScenes(c Controller, sceneIDs []int, opts DownloadOptions) []SceneDownload {
+	results := make([]SceneDownload, len(sceneIDs))
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	if parallel > len(sceneIDs) {
+		parallel = len(sceneIDs)
+	}
+
+	// every request takes a token, which are handed out at most every Interval
+	var tokens <-chan time.Time
+	if opts.Interval > 0 {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+	request := func(sceneID int) (Hc2Scene, error) {
+		if tokens != nil {
+			<-tokens
+		}
+		return c.OneScene(sceneID)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = downloadScene(request, sceneIDs[i], opts)
+				if opts.Progress != nil {
+					mu.Lock()
+					done++
+					opts.Progress(done, len(sceneIDs))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range sceneIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func downloadScene(request func(int) (Hc2Scene, error), sceneID int, opts DownloadOptions) SceneDownload {
+	backoff := opts.Backoff
+	for attempt := 0; ; attempt++ {
+		scene, err := request(sceneID)
+		if err == nil || !IsTemporary(err) || attempt >= opts.Retries {
+			return SceneDownload{SceneID: sceneID, Scene: scene, Err: err}
+		}
+		log.Debugf("Retrieving scene %d failed, retrying in %v: %v", sceneID, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
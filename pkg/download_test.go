@@ -0,0 +1,85 @@
+package fibarohc2
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sceneController answers OneScene, failing temporarily for the ids in busy
+type sceneController struct {
+	Controller
+
+	mu      sync.Mutex
+	busy    map[int]int // remaining failures per scene
+	running int
+	maxRun  int
+}
+
+func (c *sceneController) OneScene(sceneID int) (Hc2Scene, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.maxRun {
+		c.maxRun = c.running
+	}
+	fail := c.busy[sceneID] > 0
+	if fail {
+		c.busy[sceneID]--
+	}
+	c.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	switch {
+	case sceneID < 0:
+		return Hc2Scene{}, &APIError{Kind: ErrNotFound}
+	case fail:
+		return Hc2Scene{}, &APIError{Kind: ErrBusy}
+	}
+	return Hc2Scene{SceneID: sceneID, Name: "scene"}, nil
+}
+
+func TestDownloadScenes(t *testing.T) {
+	c := &sceneController{busy: map[int]int{3: 1, 4: 5}}
+	ids := []int{1, 2, 3, 4, 5, 6, 7, 8, -1}
+
+	var progress []int
+	results := DownloadScenes(c, ids, DownloadOptions{
+		Parallel: 3,
+		Retries:  2,
+		Progress: func(done, total int) {
+			AssertEqual(t, total, len(ids))
+			progress = append(progress, done)
+		},
+	})
+
+	AssertEqual(t, len(results), len(ids))
+	for i, r := range results {
+		AssertEqual(t, r.SceneID, ids[i])
+		switch r.SceneID {
+		case 4:
+			if !errors.Is(r.Err, ErrBusy) {
+				t.Errorf("scene 4: got %v, want ErrBusy after all retries", r.Err)
+			}
+		case -1:
+			if !errors.Is(r.Err, ErrNotFound) {
+				t.Errorf("scene -1: got %v, want ErrNotFound", r.Err)
+			}
+		default:
+			if r.Err != nil || r.Scene.SceneID != r.SceneID {
+				t.Errorf("scene %d: got %v, %v", r.SceneID, r.Scene.SceneID, r.Err)
+			}
+		}
+	}
+	// scene 4 has been tried 1 + 2 times
+	AssertEqual(t, c.busy[4], 2)
+	if c.maxRun > 3 {
+		t.Errorf("%d concurrent requests, want at most 3", c.maxRun)
+	}
+	AssertEqual(t, len(progress), len(ids))
+	AssertEqual(t, progress[len(progress)-1], len(ids))
+}
@@ -0,0 +1,116 @@
+package fibarohc2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+// Sentinel errors returned by the FibaroHc2 API methods. Use errors.Is to check
+// for them, and errors.As with *APIError to get the details of the failed request.
+var (
+	// ErrNotFound is returned if the requested item does not exist in the HC2.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned if the HC2 rejected the credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrBusy is returned if the HC2 is temporarily not able to handle the request.
+	ErrBusy = errors.New("HC2 busy")
+	// ErrDecode is returned if the response of the HC2 could not be decoded.
+	ErrDecode = errors.New("decoding response failed")
+	// ErrTransport is returned if the HC2 could not be reached.
+	ErrTransport = errors.New("transport failure")
+	// ErrRejected is returned if the HC2 answered with an error not covered by the other errors.
+	ErrRejected = errors.New("request rejected")
+	// ErrInvalidScene is returned if a scene does not pass the SanityCheck.
+	ErrInvalidScene = errors.New("invalid scene")
+	// ErrInvalidGlobal is returned if a global variable does not pass the SanityCheck.
+	ErrInvalidGlobal = errors.New("invalid global variable")
+	// ErrInvalidHeader is returned if a FIBARO_GIT_HOOK header can not be parsed.
+	ErrInvalidHeader = errors.New("invalid FIBARO_GIT_HOOK header")
+	// ErrInvalidTriggers is returned if the trigger block of a scene is malformed or
+	// references unknown devices or global variables.
+	ErrInvalidTriggers = errors.New("invalid scene triggers")
+	// ErrLuaSyntax is returned if a lua-script is not valid Lua 5.2.
+	ErrLuaSyntax = errors.New("lua syntax error")
+	// ErrRequire is returned if a require statement can not be expanded, as the module
+	// is missing or required circularly.
+	ErrRequire = errors.New("require failed")
+	// ErrInvalidAction is returned if an action is called on a device that does not
+	// support it, or with too few arguments.
+	ErrInvalidAction = errors.New("invalid device action")
+	// ErrFileConflict is returned if the file a scene is to be written to belongs to
+	// another scene.
+	ErrFileConflict = errors.New("file belongs to another scene")
+)
+
+// APIError describes a failed request to the HC2. Kind is one of the sentinel
+// errors above and is matched by errors.Is.
+type APIError struct {
+	Method     string // HTTP method of the request
+	Path       string // path of the request, relative to /api
+	StatusCode int    // HTTP status code, 0 if no response has been received
+	Body       string // body of the response, if any
+	Kind       error  // one of the sentinel errors
+	Cause      error  // underlying error, if any
+}
+
+func (e *APIError) Error() string {
+	msg := e.Method + " " + e.Path + ": " + e.Kind.Error()
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (%d)", e.StatusCode)
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	} else if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error, so that errors.Is can be used on an APIError.
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// checkResponse translates the outcome of a request into an *APIError, or nil
+// if the request succeeded.
+func checkResponse(method, path string, resp *resty.Response, err error) error {
+	if err != nil {
+		return &APIError{Method: method, Path: path, Kind: ErrTransport, Cause: err}
+	}
+	if resp.IsSuccess() {
+		return nil
+	}
+
+	e := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode(), Body: resp.String()}
+	switch resp.StatusCode() {
+	case http.StatusNotFound:
+		e.Kind = ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		e.Kind = ErrUnauthorized
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusConflict:
+		e.Kind = ErrBusy
+	default:
+		e.Kind = ErrRejected
+	}
+	return e
+}
+
+// decodeError returns an *APIError for a response that could not be unmarshalled.
+func decodeError(method, path string, resp *resty.Response, err error) error {
+	return &APIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: resp.StatusCode(),
+		Kind:       ErrDecode,
+		Cause:      err,
+	}
+}
+
+// IsTemporary reports whether err is caused by a condition that might go away
+// when the request is repeated, i.e. it matches ErrBusy or ErrTransport.
+func IsTemporary(err error) bool {
+	return errors.Is(err, ErrBusy) || errors.Is(err, ErrTransport)
+}
@@ -0,0 +1,252 @@
+package fibarohc2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventType is the kind of an Event
+type EventType string
+
+// The types of events reported by RefreshStates
+const (
+	EventDeviceProperty EventType = "device"        // a property of a device changed
+	EventGlobal         EventType = "global"        // the value of a global variable changed
+	EventSceneStarted   EventType = "sceneStarted"  // a scene was started
+	EventSceneFinished  EventType = "sceneFinished" // a scene finished or was stopped
+	EventCentralScene   EventType = "centralScene"  // a button of a device was pressed, held or released
+	EventOther          EventType = "other"         // any other event, see Name and Data
+	EventError          EventType = "error"         // polling failed permanently, see Err
+)
+
+// EventTypes are the types of events, besides EventError
+var EventTypes = []EventType{EventDeviceProperty, EventGlobal, EventSceneStarted, EventSceneFinished, EventCentralScene, EventOther}
+
+// Event is a change in the Fibaro system reported by RefreshStates. Only the fields
+// of its type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	Last int64     `json:"last"` // to resume RefreshStates after this event
+
+	DeviceID     int           `json:"deviceID,omitempty"`     // device and central scene events
+	Property     string        `json:"property,omitempty"`     // device events
+	Value        PropertyValue `json:"value,omitempty"`        // device and global events
+	OldValue     PropertyValue `json:"oldValue,omitempty"`     // device and global events, if reported
+	Global       string        `json:"global,omitempty"`       // global events
+	SceneID      int           `json:"sceneID,omitempty"`      // scene events
+	KeyID        int           `json:"keyID,omitempty"`        // central scene events
+	KeyAttribute string        `json:"keyAttribute,omitempty"` // central scene events, e.g. Pressed
+	Name         string        `json:"name,omitempty"`         // the type reported by the Fibaro system for other events
+	Data         interface{}   `json:"data,omitempty"`         // the data of other events
+	Err          error         `json:"-"`                      // error events
+}
+
+// refreshStates is the response of /api/refreshStates
+type refreshStates struct {
+	Last      int64                        `json:"last"`
+	Timestamp int64                        `json:"timestamp"`
+	Changes   []map[string]json.RawMessage `json:"changes"`
+	Events    []struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	} `json:"events"`
+}
+
+// eventData is the union of the data of the events decoded
+type eventData struct {
+	ID               int           `json:"id"`
+	DeviceID         int           `json:"deviceId"`
+	Property         string        `json:"property"`
+	NewValue         PropertyValue `json:"newValue"`
+	OldValue         PropertyValue `json:"oldValue"`
+	VariableName     string        `json:"variableName"`
+	KeyID            int           `json:"keyId"`
+	KeyAttribute     string        `json:"keyAttribute"`
+	RunningInstances *int          `json:"runningInstances"`
+}
+
+// RefreshStates long-polls /api/refreshStates and sends the changes reported as
+// events until ctx is done, then the channel is closed. last is the Last of an event
+// received before, to resume after it, or 0 to receive the events from now on.
+// Temporary errors are retried, any other error is sent as EventError before the
+// channel is closed. An error is returned if the first request fails.
+func (f *FibaroHc2) RefreshStates(ctx context.Context, last int64) (<-chan Event, error) {
+	var skip bool
+	if last == 0 {
+		// the first response reports the current state, not changes
+		skip = true
+	}
+	states, err := f.refreshStates(ctx, last)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		backoff := time.Second
+		running := map[int]int{}
+		for {
+			batch := states.events(running)
+			if !skip {
+				for _, e := range batch {
+					select {
+					case events <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			skip = false
+			if states.Last != 0 {
+				last = states.Last
+			}
+			for {
+				states, err = f.refreshStates(ctx, last)
+				if err == nil || ctx.Err() != nil {
+					break
+				}
+				if !IsTemporary(err) {
+					select {
+					case events <- Event{Type: EventError, Time: time.Now(), Last: last, Err: err}:
+					case <-ctx.Done():
+					}
+					return
+				}
+				log.Warnf("Polling events failed, retrying in %v: %v", backoff, err)
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					return
+				}
+				if backoff < 30*time.Second {
+					backoff *= 2
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			backoff = time.Second
+		}
+	}()
+	return events, nil
+}
+
+func (f *FibaroHc2) refreshStates(ctx context.Context, last int64) (refreshStates, error) {
+	var s refreshStates
+	cmd := "/refreshStates?last=" + strconv.FormatInt(last, 10)
+	resp, err := requestGetContext(ctx, f.cfg, cmd)
+	if err := checkResponse(http.MethodGet, cmd, resp, err); err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(resp.Body(), &s); err != nil {
+		return s, decodeError(http.MethodGet, cmd, resp, err)
+	}
+	return s, nil
+}
+
+// decodedEvents are the types of events decoded as eventData, all others are EventOther
+var decodedEvents = map[string]bool{
+	"DevicePropertyUpdatedEvent": true, "GlobalVariableChangedEvent": true, "GlobalVariableChangeEvent": true,
+	"SceneStartedEvent": true, "SceneFinishedEvent": true, "SceneRunningInstancesEvent": true, "CentralSceneEvent": true,
+}
+
+// deviceProperty identifies a property of a device
+type deviceProperty struct {
+	id       int
+	property string
+}
+
+// events decodes the changes and events of the response. A property reported both as
+// change and as DevicePropertyUpdatedEvent results in one event. running holds the
+// number of running instances of scenes, updated by the response, so that only a scene
+// starting to run or stopping to run is reported. Undecodable events are skipped.
+func (s refreshStates) events(running map[int]int) []Event {
+	t := time.Now()
+	if s.Timestamp != 0 {
+		t = time.Unix(s.Timestamp, 0)
+	}
+	var events []Event
+	reported := map[deviceProperty]int{} // index of the event of a changed device property
+	for _, c := range s.Changes {
+		var id int
+		if err := json.Unmarshal(c["id"], &id); err != nil {
+			log.Debugf("Skipping change without device id: %v", err)
+			continue
+		}
+		properties := make([]string, 0, len(c))
+		for p := range c {
+			if p != "id" {
+				properties = append(properties, p)
+			}
+		}
+		sort.Strings(properties)
+		for _, p := range properties {
+			reported[deviceProperty{id, p}] = len(events)
+			events = append(events, Event{Type: EventDeviceProperty, Time: t, Last: s.Last, DeviceID: id, Property: p, Value: PropertyValue(c[p])})
+		}
+	}
+	for _, e := range s.Events {
+		event := Event{Time: t, Last: s.Last}
+		if !decodedEvents[e.Type] {
+			event.Type, event.Name = EventOther, e.Type
+			if err := json.Unmarshal(e.Data, &event.Data); err != nil {
+				log.Debugf("Skipping %s: %v", e.Type, err)
+				continue
+			}
+			events = append(events, event)
+			continue
+		}
+		var d eventData
+		if err := json.Unmarshal(e.Data, &d); err != nil {
+			log.Debugf("Skipping %s: %v", e.Type, err)
+			continue
+		}
+		if d.DeviceID == 0 {
+			d.DeviceID = d.ID
+		}
+		switch e.Type {
+		case "DevicePropertyUpdatedEvent":
+			if i, ok := reported[deviceProperty{d.DeviceID, d.Property}]; ok {
+				// already reported as change, which lacks the old value
+				if events[i].OldValue == nil {
+					events[i].OldValue = d.OldValue
+				}
+				continue
+			}
+			event.Type, event.DeviceID, event.Property, event.Value, event.OldValue = EventDeviceProperty, d.DeviceID, d.Property, d.NewValue, d.OldValue
+		case "GlobalVariableChangedEvent", "GlobalVariableChangeEvent":
+			event.Type, event.Global, event.Value, event.OldValue = EventGlobal, d.VariableName, d.NewValue, d.OldValue
+		case "SceneStartedEvent":
+			event.Type, event.SceneID = EventSceneStarted, d.ID
+		case "SceneFinishedEvent":
+			event.Type, event.SceneID = EventSceneFinished, d.ID
+		case "SceneRunningInstancesEvent":
+			if d.RunningInstances == nil {
+				log.Debugf("Skipping %s of scene %d without runningInstances", e.Type, d.ID)
+				continue
+			}
+			n := *d.RunningInstances
+			before, known := running[d.ID]
+			running[d.ID] = n
+			if known && (before > 0) == (n > 0) {
+				// another instance started or finished
+				continue
+			}
+			event.Type, event.SceneID = EventSceneFinished, d.ID
+			if n > 0 {
+				event.Type = EventSceneStarted
+			}
+		case "CentralSceneEvent":
+			event.Type, event.DeviceID, event.KeyID, event.KeyAttribute = EventCentralScene, d.DeviceID, d.KeyID, d.KeyAttribute
+		}
+		events = append(events, event)
+	}
+	return events
+}
@@ -0,0 +1,67 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRefreshStates_Events(t *testing.T) {
+	response := `{"status":"IDLE","last":4711,"timestamp":1600000000,
+		"changes":[{"id":449,"value":"1","power":"12.5"},{"id":"noDevice"}],
+		"events":[
+			{"type":"DevicePropertyUpdatedEvent","data":{"id":449,"property":"value","newValue":"1","oldValue":"0"}},
+			{"type":"DevicePropertyUpdatedEvent","data":{"id":30,"property":"value","newValue":true,"oldValue":false}},
+			{"type":"SceneStartedEvent","data":{"id":"noScene"}},
+			{"type":"GlobalVariableChangedEvent","data":{"variableName":"TimeOfDay","newValue":"Night","oldValue":"Day"}},
+			{"type":"SceneRunningInstancesEvent","data":{"id":55,"runningInstances":0}},
+			{"type":"SceneStartedEvent","data":{"id":12}},
+			{"type":"CentralSceneEvent","data":{"id":44,"keyId":1,"keyAttribute":"HeldDown"}},
+			{"type":"RoomModifiedEvent","data":{"id":5}}
+		]}`
+	var s refreshStates
+	if err := json.Unmarshal([]byte(response), &s); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1600000000, 0)
+	want := []Event{
+		{Type: EventDeviceProperty, Time: at, Last: 4711, DeviceID: 449, Property: "power", Value: PropertyValue(`"12.5"`)},
+		{Type: EventDeviceProperty, Time: at, Last: 4711, DeviceID: 449, Property: "value", Value: PropertyValue(`"1"`), OldValue: PropertyValue(`"0"`)},
+		{Type: EventDeviceProperty, Time: at, Last: 4711, DeviceID: 30, Property: "value", Value: PropertyValue(`true`), OldValue: PropertyValue(`false`)},
+		{Type: EventGlobal, Time: at, Last: 4711, Global: "TimeOfDay", Value: PropertyValue(`"Night"`), OldValue: PropertyValue(`"Day"`)},
+		{Type: EventSceneFinished, Time: at, Last: 4711, SceneID: 55},
+		{Type: EventSceneStarted, Time: at, Last: 4711, SceneID: 12},
+		{Type: EventCentralScene, Time: at, Last: 4711, DeviceID: 44, KeyID: 1, KeyAttribute: "HeldDown"},
+		{Type: EventOther, Time: at, Last: 4711, Name: "RoomModifiedEvent", Data: map[string]interface{}{"id": 5.0}},
+	}
+	got := s.events(map[int]int{})
+	if len(got) != len(want) {
+		t.Fatalf("events() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("events()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRefreshStates_SceneRunningInstances(t *testing.T) {
+	running := map[int]int{}
+	var got []EventType
+	for _, n := range []int{1, 2, 1, 0, 0, 3} {
+		s := refreshStates{Timestamp: 1600000000}
+		s.Events = append(s.Events, struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}{"SceneRunningInstancesEvent", json.RawMessage(fmt.Sprintf(`{"id":55,"runningInstances":%d}`, n))})
+		for _, e := range s.events(running) {
+			got = append(got, e.Type)
+		}
+	}
+	want := []EventType{EventSceneStarted, EventSceneFinished, EventSceneStarted}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events() = %v, want %v", got, want)
+	}
+}
@@ -1,10 +1,14 @@
 package fibarohc2
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -97,15 +101,22 @@ type FibaroConfig struct {
 	Username     string `json:"username"`
 	Password     string `json:"password"`
 	CreateHeader bool   `json:"createHeader"`
+	Platform     string `json:"platform,omitempty"` // HC2 or HC3, detected if empty
 	client       *resty.Client
 }
 
 func requestGet(cfg FibaroConfig, cmd string) (resp *resty.Response, err error) {
+	return requestGetContext(context.Background(), cfg, cmd)
+}
+
+// requestGetContext is requestGet, cancelled when ctx is done
+func requestGetContext(ctx context.Context, cfg FibaroConfig, cmd string) (resp *resty.Response, err error) {
 	msg := cfg.Username + ":" + cfg.Password
 	encoded := "Basic " + base64.StdEncoding.EncodeToString([]byte(msg))
 	client := cfg.client
 
 	resp, err = client.R().
+		SetContext(ctx).
 		SetHeader("Authorization", encoded).
 		Get(cfg.BaseURL + "/api" + cmd)
 	return
@@ -139,7 +150,50 @@ func requestPost(cfg FibaroConfig, cmd string, body []byte) (resp *resty.Respons
 	return
 }
 
+func requestPostFile(cfg FibaroConfig, cmd string, param string, fileName string, body []byte) (resp *resty.Response, err error) {
+	msg := cfg.Username + ":" + cfg.Password
+	encoded := "Basic " + base64.StdEncoding.EncodeToString([]byte(msg))
+	client := cfg.client
+
+	resp, err = client.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("Authorization", encoded).
+		SetFileReader(param, fileName, bytes.NewReader(body)).
+		Post(cfg.BaseURL + "/api" + cmd)
+	return
+}
+
+func requestDelete(cfg FibaroConfig, cmd string) (resp *resty.Response, err error) {
+	msg := cfg.Username + ":" + cfg.Password
+	encoded := "Basic " + base64.StdEncoding.EncodeToString([]byte(msg))
+	client := cfg.client
+
+	resp, err = client.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("Authorization", encoded).
+		Delete(cfg.BaseURL + "/api" + cmd)
+	return
+}
+
+// getJSON requests cmd from the HC2 and decodes the JSON response into v.
+// The HC2 answers some requests for unknown items with an empty body, which is
+// reported as ErrNotFound.
+func (f *FibaroHc2) getJSON(cmd string, v interface{}) error {
+	resp, err := requestGet(f.cfg, cmd)
+	if err := checkResponse(http.MethodGet, cmd, resp, err); err != nil {
+		return err
+	}
+	if len(resp.Body()) == 0 {
+		return &APIError{Method: http.MethodGet, Path: cmd, StatusCode: resp.StatusCode(), Kind: ErrNotFound}
+	}
+	if err := json.Unmarshal(resp.Body(), v); err != nil {
+		return decodeError(http.MethodGet, cmd, resp, err)
+	}
+	return nil
+}
+
 // PutOneScene upploads a scene to the FibaroHc2 system and returns the response, or any error encountered.
+// If the scene does not exist in the HC2 the returned error matches ErrNotFound.
 func (f *FibaroHc2) PutOneScene(scene Hc2Scene) (resp *resty.Response, err error) {
 	// TODO We need some sanity checks here for the H2Scene
 	// 1. runconfig only one of TriggerAndManual, ManualOnly,  Disabled
@@ -147,233 +201,406 @@ func (f *FibaroHc2) PutOneScene(scene Hc2Scene) (resp *resty.Response, err error
 	// What else ?
 
 	if !scene.SanityCheck() {
-		return nil, fmt.Errorf("Sanity check failed")
-
-	}
-
-	b, mer := json.Marshal(scene)
-	if mer != nil {
-		log.Errorln("Error while marshalling hc2Scene:", mer)
-		return nil, mer
+		return nil, fmt.Errorf("%w: sanity check failed for scene %d", ErrInvalidScene, scene.SceneID)
 	}
 
-	resp, err = requestPut(f.cfg, "/scenes/"+strconv.Itoa(scene.SceneID), b)
-	if resp.StatusCode() == 404 {
-		log.Fatalf("Could not upload scene \"%s\" with sceneID=%d as it does not exists in Fibaro HC2", scene.Name, scene.SceneID)
+	b, err := json.Marshal(scene)
+	if err != nil {
+		return nil, err
 	}
-	return
 
+	cmd := "/scenes/" + strconv.Itoa(scene.SceneID)
+	resp, err = requestPut(f.cfg, cmd, b)
+	return resp, checkResponse(http.MethodPut, cmd, resp, err)
 }
 
 // AllScenes downloads and returns all scenes of the FibaroHC2 system.
-// nil will be returned in case an error occured while downloading the
-// scenes.
-func (f *FibaroHc2) AllScenes() []Hc2Scene {
-	resp, err := requestGet(f.cfg, "/scenes")
-
+func (f *FibaroHc2) AllScenes() ([]Hc2Scene, error) {
 	var s []Hc2Scene
-	json.Unmarshal(resp.Body(), &s)
-	if err != nil {
-		log.Errorln("Error while decoding hc2scenes: ", err)
-		return nil
+	if err := f.getJSON("/scenes", &s); err != nil {
+		return nil, err
 	}
-	return s
+	return s, nil
 }
 
-// AllDevices downloads and returns all scenes of the FibaroHC2 system.
-// nil will be returned in case an error occured while downloading the
-// scenes.
-func (f *FibaroHc2) AllDevices() []Hc2Device {
+// AllDevices downloads and returns all devices of the FibaroHC2 system.
+func (f *FibaroHc2) AllDevices() ([]Hc2Device, error) {
 	log.Tracef("Calling at %v/devices\n", f.cfg.BaseURL)
-	resp, err := requestGet(f.cfg, "/devices")
 	var s []Hc2Device
-	json.Unmarshal(resp.Body(), &s)
-	if err != nil {
-		log.Errorln("Error while decoding hc2devices ", err)
-		return nil
+	if err := f.getJSON("/devices", &s); err != nil {
+		return nil, err
 	}
-	return s
+	return s, nil
 }
 
-func (f *FibaroHc2) OneDevice(deviceId int) *Hc2Device {
-	log.Tracef("Calling at %v/devices/%d\n", f.cfg.BaseURL, deviceId)
-	resp, err := requestGet(f.cfg, "/devices/"+strconv.Itoa(deviceId))
-	s := &Hc2Device{}
-	json.Unmarshal(resp.Body(), &s)
-	if err != nil {
-		log.Errorln("Error while decoding hc2device ", err)
-		return nil
-	}
-	return s
+// OneDevice downloads and returns one device as identified by the deviceID
+func (f *FibaroHc2) OneDevice(deviceID int) (Hc2Device, error) {
+	log.Tracef("Calling at %v/devices/%d\n", f.cfg.BaseURL, deviceID)
+	var s Hc2Device
+	err := f.getJSON("/devices/"+strconv.Itoa(deviceID), &s)
+	return s, err
 }
 
 // OneScene downloads and returns one scene as identified by the sceneID
-func (f *FibaroHc2) OneScene(sceneID int) Hc2Scene {
-	resp, err := requestGet(f.cfg, "/scenes/"+strconv.Itoa(sceneID))
-
-	if len(resp.Body()) == 0 || err != nil {
-		// scene not found
-		return Hc2Scene{SceneID: -1}
-	}
+func (f *FibaroHc2) OneScene(sceneID int) (Hc2Scene, error) {
 	var s Hc2Scene
-	json.Unmarshal(resp.Body(), &s)
-	return s
+	err := f.getJSON("/scenes/"+strconv.Itoa(sceneID), &s)
+	return s, err
 }
 
 // CreateScene creates a new scene in the fibaro system with the name parameters
 // set in the scene. SceneID will be updated with the new scene id being allocated.
-// the header in the lua field will be updated. On sucess CreateScene will return the allocated SceneID, or -1 on error.
-func (f *FibaroHc2) CreateScene(scene Hc2Scene) (newSceneID int) {
+// the header in the lua field will be updated. On sucess CreateScene will return the allocated SceneID.
+func (f *FibaroHc2) CreateScene(scene Hc2Scene) (newSceneID int, err error) {
 	dummyScene := Hc2Scene{
 		SceneID: -1,
 		Name:    scene.Name,
 		Type:    "com.fibaro.luaScene",
 	}
 
-	b, merr := json.Marshal(dummyScene)
-	if merr != nil {
-
-		log.Errorln("Error while marshalling hc2Scene:", merr)
-		return -1
+	b, err := json.Marshal(dummyScene)
+	if err != nil {
+		return -1, err
 	}
 	resp, err := requestPost(f.cfg, "/scenes", b)
-	if len(resp.Body()) == 0 || err != nil {
-		// some kind of error
-		log.Errorf("Error creating new scene with header=%v. Error:%v\n", string(b), err)
-		return -1
+	if err := checkResponse(http.MethodPost, "/scenes", resp, err); err != nil {
+		return -1, err
 	}
 	freshScene := Hc2Scene{}
-	uerr := json.Unmarshal(resp.Body(), &freshScene)
-	if uerr != nil {
-		log.Errorf("Error while unmarshalling received intermediate: %v", uerr)
-		return -1
+	if err := json.Unmarshal(resp.Body(), &freshScene); err != nil {
+		return -1, decodeError(http.MethodPost, "/scenes", resp, err)
 	}
 
 	scene.SceneID = freshScene.SceneID
 	scene.Type = freshScene.Type
 	scene.UpdateLuaHeader()
-	_, err = f.PutOneScene(scene)
-	if err != nil {
-		log.Errorf("Error while updating intermediate scene (%v): %v", scene.SceneID, err)
-		return -1
+	if _, err := f.PutOneScene(scene); err != nil {
+		return -1, fmt.Errorf("updating intermediate scene %d: %w", scene.SceneID, err)
 	}
-	return scene.SceneID
+	return scene.SceneID, nil
 }
 
-// OneRoom downloads and returns a room as identified by the roomID
-func (f *FibaroHc2) OneRoom(roomID int) Hc2Room {
-	resp, err := requestGet(f.cfg, "/rooms/"+strconv.Itoa(roomID))
+// AllRooms downloads and returns all rooms of the FibaroHC2 system.
+func (f *FibaroHc2) AllRooms() ([]Hc2Room, error) {
+	var s []Hc2Room
+	if err := f.getJSON("/rooms", &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	if len(resp.Body()) == 0 || err != nil {
-		// room not found
-		return Hc2Room{RoomID: -1}
+// AllSections downloads and returns all sections of the FibaroHC2 system.
+func (f *FibaroHc2) AllSections() ([]Hc2Section, error) {
+	var s []Hc2Section
+	if err := f.getJSON("/sections", &s); err != nil {
+		return nil, err
 	}
+	return s, nil
+}
 
+// OneRoom downloads and returns a room as identified by the roomID
+func (f *FibaroHc2) OneRoom(roomID int) (Hc2Room, error) {
 	var s Hc2Room
-	json.Unmarshal(resp.Body(), &s)
-
-	return s
+	err := f.getJSON("/rooms/"+strconv.Itoa(roomID), &s)
+	return s, err
 }
 
 // OneSection downloads and returns a section as identified by the sectionID
-func (f *FibaroHc2) OneSection(sectionID int) Hc2Section {
-	resp, err := requestGet(f.cfg, "/sections/"+strconv.Itoa(sectionID))
+func (f *FibaroHc2) OneSection(sectionID int) (Hc2Section, error) {
+	var s Hc2Section
+	err := f.getJSON("/sections/"+strconv.Itoa(sectionID), &s)
+	return s, err
+}
 
-	if len(resp.Body()) == 0 || err != nil {
-		// scene not found
-		return Hc2Section{SectionID: -1}
-	}
+// CreateRoom creates a new room in the FibaroHC2 system and returns the room as
+// created by the HC2.
+func (f *FibaroHc2) CreateRoom(room Hc2Room) (Hc2Room, error) {
+	var created Hc2Room
+	err := f.create("/rooms", room, &created)
+	return created, err
+}
 
-	var s Hc2Section
-	json.Unmarshal(resp.Body(), &s)
+// UpdateRoom updates the name, section, icon and default devices of an existing room
+func (f *FibaroHc2) UpdateRoom(room Hc2Room) error {
+	return f.update("/rooms/"+strconv.Itoa(room.RoomID), room)
+}
 
-	return s
+// DeleteRoom deletes the room identified by roomID. The HC2 moves its devices and
+// scenes to the default room.
+func (f *FibaroHc2) DeleteRoom(roomID int) error {
+	cmd := "/rooms/" + strconv.Itoa(roomID)
+	resp, err := requestDelete(f.cfg, cmd)
+	return checkResponse(http.MethodDelete, cmd, resp, err)
 }
 
-// DebugMessages downloads and returns all debug messages for a given sceneID
-func (f *FibaroHc2) DebugMessages(sceneID int) []Hc2DebugMessage {
-	resp, err := requestGet(f.cfg, "/scenes/"+strconv.Itoa(sceneID)+"/debugMessages")
+// CreateSection creates a new section in the FibaroHC2 system and returns the section
+// as created by the HC2.
+func (f *FibaroHc2) CreateSection(section Hc2Section) (Hc2Section, error) {
+	var created Hc2Section
+	err := f.create("/sections", section, &created)
+	return created, err
+}
 
-	if len(resp.Body()) == 0 || err != nil {
-		// scene not found
-		return nil
+// UpdateSection updates the name of an existing section
+func (f *FibaroHc2) UpdateSection(section Hc2Section) error {
+	return f.update("/sections/"+strconv.Itoa(section.SectionID), section)
+}
+
+// DeleteSection deletes the section identified by sectionID
+func (f *FibaroHc2) DeleteSection(sectionID int) error {
+	cmd := "/sections/" + strconv.Itoa(sectionID)
+	resp, err := requestDelete(f.cfg, cmd)
+	return checkResponse(http.MethodDelete, cmd, resp, err)
+}
+
+// MoveDevice moves the device identified by deviceID into the room identified by roomID
+func (f *FibaroHc2) MoveDevice(deviceID, roomID int) error {
+	return f.update("/devices/"+strconv.Itoa(deviceID), map[string]int{"id": deviceID, "roomID": roomID})
+}
+
+// create posts v to cmd and decodes the item created by the HC2 into created
+func (f *FibaroHc2) create(cmd string, v interface{}, created interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := requestPost(f.cfg, cmd, b)
+	if err := checkResponse(http.MethodPost, cmd, resp, err); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resp.Body(), created); err != nil {
+		return decodeError(http.MethodPost, cmd, resp, err)
 	}
+	return nil
+}
+
+// update puts v to cmd
+func (f *FibaroHc2) update(cmd string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := requestPut(f.cfg, cmd, b)
+	return checkResponse(http.MethodPut, cmd, resp, err)
+}
+
+// DebugMessages downloads and returns all debug messages for a given sceneID
+func (f *FibaroHc2) DebugMessages(sceneID int) ([]Hc2DebugMessage, error) {
 	var s []Hc2DebugMessage
-	json.Unmarshal(resp.Body(), &s)
-	return s
+	if err := f.getJSON("/scenes/"+strconv.Itoa(sceneID)+"/debugMessages", &s); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // Action starts an actions on a given sceneID
 func (f *FibaroHc2) Action(sceneID int, c SceneActionCommand) error {
-	resp, err := requestPost(f.cfg, "/scenes/"+strconv.Itoa(sceneID)+"/action/"+c.String(), []byte(""))
-	if err != nil {
+	cmd := "/scenes/" + strconv.Itoa(sceneID) + "/action/" + c.String()
+	resp, err := requestPost(f.cfg, cmd, []byte(""))
+	if err := checkResponse(http.MethodPost, cmd, resp, err); err != nil {
 		return err
 	}
 	log.Debug(resp)
 	if len(resp.Body()) > 0 {
-		return errors.New(resp.String())
+		return &APIError{Method: http.MethodPost, Path: cmd, StatusCode: resp.StatusCode(), Body: resp.String(), Kind: ErrRejected}
 	}
 	return nil
 }
 
-func (f *FibaroHc2) settingsInfo() Hc2Info {
-	resp, err := requestGet(f.cfg, "/settings/info")
-	log.Debug(resp)
+// CallAction calls action on the device deviceID with args, e.g. turnOn, setValue 50
+// or, for virtual devices, pressButton 3. The action is checked against the actions
+// of the device first, an unknown action or too few args result in ErrInvalidAction.
+func (f *FibaroHc2) CallAction(deviceID int, action string, args ...interface{}) error {
+	device, err := f.OneDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	if err := device.ValidateAction(action, len(args)); err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("deviceID", strconv.Itoa(deviceID))
+	params.Set("name", action)
+	for i, a := range args {
+		params.Set("arg"+strconv.Itoa(i+1), fmt.Sprint(a))
+	}
+	cmd := "/callAction?" + params.Encode()
+	resp, err := requestGet(f.cfg, cmd)
+	return checkResponse(http.MethodGet, cmd, resp, err)
+}
 
-	if len(resp.Body()) == 0 || err != nil {
-		// scene not found
-		return Hc2Info{}
+// AllVirtualDevices downloads and returns all virtual devices of the FibaroHC2 system.
+func (f *FibaroHc2) AllVirtualDevices() ([]Hc2VirtualDevice, error) {
+	var s []Hc2VirtualDevice
+	if err := f.getJSON("/virtualDevices", &s); err != nil {
+		return nil, err
 	}
+	return s, nil
+}
 
-	var s Hc2Info
-	json.Unmarshal(resp.Body(), &s)
+// OneVirtualDevice downloads and returns one virtual device as identified by the vdID
+func (f *FibaroHc2) OneVirtualDevice(vdID int) (Hc2VirtualDevice, error) {
+	var s Hc2VirtualDevice
+	err := f.getJSON("/virtualDevices/"+strconv.Itoa(vdID), &s)
+	return s, err
+}
 
-	return s
+// PutVirtualDevice uploads a virtual device to the FibaroHc2 system. If the virtual
+// device does not exist in the HC2 the returned error matches ErrNotFound.
+func (f *FibaroHc2) PutVirtualDevice(vd Hc2VirtualDevice) error {
+	b, err := json.Marshal(vd)
+	if err != nil {
+		return err
+	}
+	cmd := "/virtualDevices/" + strconv.Itoa(vd.ID)
+	resp, err := requestPut(f.cfg, cmd, b)
+	return checkResponse(http.MethodPut, cmd, resp, err)
 }
 
-func (f *FibaroHc2) settingsNetwork() Hc2Network {
-	resp, err := requestGet(f.cfg, "/settings/network")
-	log.Debug(resp)
+// CreateVirtualDevice creates a new virtual device in the fibaro system with the
+// name and room set in vd, and uploads the remaining fields of vd to it. On sucess
+// CreateVirtualDevice will return the allocated ID.
+func (f *FibaroHc2) CreateVirtualDevice(vd Hc2VirtualDevice) (newVdID int, err error) {
+	dummy := map[string]interface{}{
+		"name":   vd.Name,
+		"roomID": vd.RoomID,
+		"type":   "virtual_device",
+	}
+	b, err := json.Marshal(dummy)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := requestPost(f.cfg, "/virtualDevices", b)
+	if err := checkResponse(http.MethodPost, "/virtualDevices", resp, err); err != nil {
+		return -1, err
+	}
+	fresh := Hc2VirtualDevice{}
+	if err := json.Unmarshal(resp.Body(), &fresh); err != nil {
+		return -1, decodeError(http.MethodPost, "/virtualDevices", resp, err)
+	}
 
-	if len(resp.Body()) == 0 || err != nil {
-		// scene not found
-		return Hc2Network{}
+	vd.ID = fresh.ID
+	vd.Type = fresh.Type
+	if err := f.PutVirtualDevice(vd); err != nil {
+		return -1, fmt.Errorf("updating intermediate virtual device %d: %w", vd.ID, err)
 	}
+	return vd.ID, nil
+}
 
-	var s Hc2Network
-	json.Unmarshal(resp.Body(), &s)
+// AllGlobals downloads and returns all global variables of the FibaroHC2 system.
+func (f *FibaroHc2) AllGlobals() ([]Hc2GlobalVariable, error) {
+	var s []Hc2GlobalVariable
+	if err := f.getJSON("/globalVariables", &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	return s
+// OneGlobal downloads and returns the global variable identified by name
+func (f *FibaroHc2) OneGlobal(name string) (Hc2GlobalVariable, error) {
+	var s Hc2GlobalVariable
+	err := f.getJSON("/globalVariables/"+url.PathEscape(name), &s)
+	return s, err
 }
 
-func (f *FibaroHc2) loginStatus() Hc2LoginStatus {
-	resp, err := requestGet(f.cfg, "/loginStatus")
-	log.Debug(resp)
+// CreateGlobal creates a new global variable in the FibaroHC2 system and returns
+// the variable as created by the HC2.
+func (f *FibaroHc2) CreateGlobal(g Hc2GlobalVariable) (Hc2GlobalVariable, error) {
+	if err := g.SanityCheck(); err != nil {
+		return Hc2GlobalVariable{}, err
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		return Hc2GlobalVariable{}, err
+	}
+	resp, err := requestPost(f.cfg, "/globalVariables", b)
+	if err := checkResponse(http.MethodPost, "/globalVariables", resp, err); err != nil {
+		return Hc2GlobalVariable{}, err
+	}
+	if len(resp.Body()) == 0 {
+		return g, nil
+	}
+	var created Hc2GlobalVariable
+	if err := json.Unmarshal(resp.Body(), &created); err != nil {
+		return Hc2GlobalVariable{}, decodeError(http.MethodPost, "/globalVariables", resp, err)
+	}
+	return created, nil
+}
 
-	if len(resp.Body()) == 0 || err != nil {
-		// scene not found
-		return Hc2LoginStatus{}
+// UpdateGlobal updates the value, and for enumerated variables the allowed
+// values, of an existing global variable.
+func (f *FibaroHc2) UpdateGlobal(g Hc2GlobalVariable) error {
+	if err := g.SanityCheck(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
 	}
+	cmd := "/globalVariables/" + url.PathEscape(g.Name)
+	resp, err := requestPut(f.cfg, cmd, b)
+	return checkResponse(http.MethodPut, cmd, resp, err)
+}
 
-	var s Hc2LoginStatus
-	json.Unmarshal(resp.Body(), &s)
+// DeleteGlobal deletes the global variable identified by name
+func (f *FibaroHc2) DeleteGlobal(name string) error {
+	cmd := "/globalVariables/" + url.PathEscape(name)
+	resp, err := requestDelete(f.cfg, cmd)
+	return checkResponse(http.MethodDelete, cmd, resp, err)
+}
 
-	if s.Username == "" {
-		h := Hc2LoginStatus{ErrorMsg: string(resp.Body())}
-		return h
+// ValidateTriggers checks that all devices and global variables referenced by the
+// triggers exist in the FibaroHC2 system. Unknown references are reported as
+// *UnknownReferencesError.
+func (f *FibaroHc2) ValidateTriggers(t SceneTriggers) error {
+	devices, err := f.AllDevices()
+	if err != nil {
+		return err
+	}
+	globals, err := f.AllGlobals()
+	if err != nil {
+		return err
 	}
+	return t.Validate(devices, globals)
+}
 
-	return s
+func (f *FibaroHc2) settingsInfo() (Hc2Info, error) {
+	var s Hc2Info
+	err := f.getJSON("/settings/info", &s)
+	return s, err
+}
+
+func (f *FibaroHc2) settingsNetwork() (Hc2Network, error) {
+	var s Hc2Network
+	err := f.getJSON("/settings/network", &s)
+	return s, err
 }
 
-// Info returns a human-readable string on information of the HC2
+func (f *FibaroHc2) loginStatus() (Hc2LoginStatus, error) {
+	var s Hc2LoginStatus
+	err := f.getJSON("/loginStatus", &s)
+	return s, err
+}
+
+// Info returns a human-readable string on information of the HC2.
+// Rejected credentials are reported as part of the string, all other
+// errors are returned.
 // TODO: Write Tests
-func (f *FibaroHc2) Info(indent int) string {
+func (f *FibaroHc2) Info(indent int) (string, error) {
 	ind := strings.Repeat(" ", indent)
 
-	i := f.settingsInfo()
-	n := f.settingsNetwork()
-	l := f.loginStatus()
+	i, err := f.settingsInfo()
+	if err != nil {
+		return "", err
+	}
+	n, err := f.settingsNetwork()
+	if err != nil {
+		return "", err
+	}
+	l, lerr := f.loginStatus()
+	if lerr != nil && !errors.Is(lerr, ErrUnauthorized) {
+		return "", lerr
+	}
 
 	var str strings.Builder
 	_, _ = str.WriteString("Successful connected to ...\n")
@@ -384,35 +611,38 @@ func (f *FibaroHc2) Info(indent int) string {
 	_, _ = str.WriteString(ind + "ZWaveVersion : " + i.ZwaveVersion + "\n")
 	_, _ = str.WriteString("\n")
 
-	if l.ErrorMsg == "" {
+	if lerr == nil {
 		_, _ = str.WriteString("and logged in as:\n")
 		_, _ = str.WriteString(ind + "User:         " + l.Username + "\n")
 		_, _ = str.WriteString(ind + "Type:         " + l.Type + "\n")
 	} else {
 		_, _ = str.WriteString("couldn't log in with resp:\n")
-		_, _ = str.WriteString(l.ErrorMsg)
+		_, _ = str.WriteString(lerr.Error())
 	}
 
-	return str.String()
+	return str.String(), nil
 }
 
 // WriteInitConfigFile writes the configuration to a file. Paths required will be created if not presend.
-func (f *FibaroHc2) WriteInitConfigFile(path string) (bytesWrote int) {
-	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+func (f *FibaroHc2) WriteInitConfigFile(path string) (bytesWrote int, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return 0, err
+	}
 
 	file, err := os.Create(path)
 	if err != nil {
-		log.Panicf("Problem creating file %s; %v\n", path, err)
+		return 0, fmt.Errorf("creating file %s: %w", path, err)
 	}
-
 	defer file.Close()
 
-	b, _ := json.MarshalIndent(f.Config(), "", " ")
+	b, err := json.MarshalIndent(f.Config(), "", " ")
+	if err != nil {
+		return 0, err
+	}
 	n4, err := file.Write(b)
 	if err != nil {
-		log.Panicf("Problem writing file %s; %v\n", path, err)
+		return n4, fmt.Errorf("writing file %s: %w", path, err)
 	}
 
-	return n4
-
+	return n4, nil
 }
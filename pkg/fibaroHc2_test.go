@@ -2,6 +2,7 @@ package fibarohc2
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -26,9 +27,10 @@ func TestFibaroHc2_OneScene(t *testing.T) {
 		route        string
 	}
 	tests := []struct {
-		name string
-		args args
-		want Hc2Scene
+		name    string
+		args    args
+		want    Hc2Scene
+		wantErr error
 	}{
 		{
 			"get one short scene",
@@ -49,6 +51,7 @@ func TestFibaroHc2_OneScene(t *testing.T) {
 				Autostart:           false,
 				IsLua:               true,
 			},
+			nil,
 		},
 		{
 			"get one long scene",
@@ -69,6 +72,7 @@ func TestFibaroHc2_OneScene(t *testing.T) {
 				Autostart:           false,
 				IsLua:               true,
 			},
+			nil,
 		},
 		{
 			"get one non existing scene",
@@ -79,9 +83,44 @@ func TestFibaroHc2_OneScene(t *testing.T) {
 				"GET",
 				"http://192.10.66.55/api/scenes/147",
 			},
-			Hc2Scene{
-				SceneID: -1,
+			Hc2Scene{},
+			ErrNotFound,
+		},
+		{
+			"get one scene unauthorized",
+			args{
+				148,
+				"",
+				401,
+				"GET",
+				"http://192.10.66.55/api/scenes/148",
+			},
+			Hc2Scene{},
+			ErrUnauthorized,
+		},
+		{
+			"get one scene while busy",
+			args{
+				149,
+				"",
+				503,
+				"GET",
+				"http://192.10.66.55/api/scenes/149",
 			},
+			Hc2Scene{},
+			ErrBusy,
+		},
+		{
+			"get one scene with invalid json",
+			args{
+				150,
+				"../test/simpleLua.lua",
+				200,
+				"GET",
+				"http://192.10.66.55/api/scenes/150",
+			},
+			Hc2Scene{},
+			ErrDecode,
 		},
 	}
 	for _, tt := range tests {
@@ -93,8 +132,13 @@ func TestFibaroHc2_OneScene(t *testing.T) {
 			f := &FibaroHc2{
 				cfg: *cfg,
 			}
-			got := f.OneScene(tt.args.sceneID)
-			AssertHc2SceneWOLuaEqual(t, got, tt.want)
+			got, err := f.OneScene(tt.args.sceneID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FibaroHc2.OneScene() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				AssertHc2SceneWOLuaEqual(t, got, tt.want)
+			}
 		})
 	}
 }
@@ -112,7 +156,7 @@ func testFibaroHc2OneSceneParametrized(t *testing.T) {
 		cfg: *configuration,
 	}
 
-	got := f.OneScene(55)
+	got, _ := f.OneScene(55)
 	log.Println(got)
 	t.Fail()
 }
@@ -130,7 +174,7 @@ func testFibaroHc2OneSceneParametrized2(t *testing.T) {
 		cfg: *configuration,
 	}
 
-	got := f.OneScene(105)
+	got, _ := f.OneScene(105)
 	log.Println(got)
 	t.Fail()
 }
@@ -147,7 +191,10 @@ func TestFibaroHc2_AllScenes(t *testing.T) {
 	f := &FibaroHc2{
 		cfg: *cfg,
 	}
-	got := f.AllScenes()
+	got, err := f.AllScenes()
+	if err != nil {
+		t.Fatalf("FibaroHc2.AllScenes() produced error = %v", err)
+	}
 	AssertEqual(t, len(got), 93)
 	AssertHc2SceneWOLuaEqual(t, got[1], Hc2Scene{
 		SceneID:             19,
@@ -174,9 +221,10 @@ func TestFibaroHc2_OneRoom(t *testing.T) {
 		route        string
 	}
 	tests := []struct {
-		name string
-		args args
-		want Hc2Room
+		name    string
+		args    args
+		want    Hc2Room
+		wantErr error
 	}{
 		{
 			"get one room",
@@ -188,10 +236,14 @@ func TestFibaroHc2_OneRoom(t *testing.T) {
 				"http://192.10.66.55/api/rooms/5",
 			},
 			Hc2Room{
-				Name:      "Schlafzimmer",
-				RoomID:    5,
-				SectionID: 4,
+				Name:           "Schlafzimmer",
+				RoomID:         5,
+				SectionID:      4,
+				Icon:           "room_sypialnia2",
+				DefaultSensors: Hc2RoomSensors{Temperature: 543, Light: 544},
+				SortOrder:      1,
 			},
+			nil,
 		},
 		{
 			"get nonexisting room",
@@ -202,9 +254,8 @@ func TestFibaroHc2_OneRoom(t *testing.T) {
 				"GET",
 				"http://192.10.66.55/api/rooms/6",
 			},
-			Hc2Room{
-				RoomID: -1,
-			},
+			Hc2Room{},
+			ErrNotFound,
 		},
 	}
 	for _, tt := range tests {
@@ -217,7 +268,11 @@ func TestFibaroHc2_OneRoom(t *testing.T) {
 			f := &FibaroHc2{
 				cfg: *cfg,
 			}
-			if got := f.OneRoom(tt.args.roomID); !reflect.DeepEqual(got, tt.want) {
+			got, err := f.OneRoom(tt.args.roomID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FibaroHc2.OneRoom() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("FibaroHc2.OneRoom() = %v, want %v", got, tt.want)
 			}
 		})
@@ -237,9 +292,10 @@ func TestFibaroHc2_OneSection(t *testing.T) {
 		route        string
 	}
 	tests := []struct {
-		name string
-		args args
-		want Hc2Section
+		name    string
+		args    args
+		want    Hc2Section
+		wantErr error
 	}{
 		{
 			"get one section",
@@ -253,7 +309,9 @@ func TestFibaroHc2_OneSection(t *testing.T) {
 			Hc2Section{
 				Name:      "NickyTheo",
 				SectionID: 4,
+				SortOrder: 1,
 			},
+			nil,
 		},
 		{
 			"get nonexisting section",
@@ -264,9 +322,8 @@ func TestFibaroHc2_OneSection(t *testing.T) {
 				"GET",
 				"http://192.10.66.55/api/sections/5",
 			},
-			Hc2Section{
-				SectionID: -1,
-			},
+			Hc2Section{},
+			ErrNotFound,
 		},
 	}
 	for _, tt := range tests {
@@ -279,7 +336,11 @@ func TestFibaroHc2_OneSection(t *testing.T) {
 			f := &FibaroHc2{
 				cfg: *cfg,
 			}
-			if got := f.OneSection(tt.args.sectionID); !reflect.DeepEqual(got, tt.want) {
+			got, err := f.OneSection(tt.args.sectionID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FibaroHc2.OneSection() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("FibaroHc2.OneSection() = %v, want %v", got, tt.want)
 			}
 		})
@@ -303,7 +364,7 @@ func TestFibaroHc2_PutOneScene(t *testing.T) {
 		name     string
 		args     args
 		wantResp string
-		wantErr  bool
+		wantErr  error
 	}{
 		{
 			"put one scene (assuming existing)",
@@ -315,7 +376,7 @@ func TestFibaroHc2_PutOneScene(t *testing.T) {
 				"http://192.10.66.55/api/scenes/203",
 			},
 			"",
-			false,
+			nil,
 		},
 		{
 			"put one scene (wrong runConfig)",
@@ -327,7 +388,19 @@ func TestFibaroHc2_PutOneScene(t *testing.T) {
 				"http://192.10.66.55/api/scenes/205",
 			},
 			`{"type":"ERROR","reason":"Invalid runConfig parameter","message":"Invalid runConfig parameter"}`,
-			true,
+			ErrInvalidScene,
+		},
+		{
+			"put one scene (not existing)",
+			args{
+				"../test/shortHeader3.lua",
+				"",
+				404,
+				http.MethodPut,
+				"http://192.10.66.55/api/scenes/205",
+			},
+			"",
+			ErrNotFound,
 		},
 		// TODO: Add test cases.
 	}
@@ -341,12 +414,14 @@ func TestFibaroHc2_PutOneScene(t *testing.T) {
 			responder := httpmock.NewBytesResponder(tt.args.responseCode, fixture)
 			httpmock.RegisterResponder(tt.args.method, tt.args.route, responder)
 			var scene Hc2Scene
-			scene.ParseFile(tt.args.sceneFile, false)
+			if err := scene.ParseFile(tt.args.sceneFile, false); err != nil {
+				t.Fatal(err)
+			}
 			gotResp, err := f.PutOneScene(scene)
-			if (err != nil) && tt.wantErr {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FibaroHHc2.PutOneScene() error = %v, want %v", err, tt.wantErr)
 				return
-			} else if (err != nil) && !tt.wantErr {
-				t.Errorf("FibaroHHc2.PutOneScene() produced error = %v", err)
+			} else if err != nil {
 				return
 			}
 			var i, j interface{}
@@ -378,7 +453,10 @@ func TestOneSceneAndUpdate(t *testing.T) {
 	f := &FibaroHc2{
 		cfg: *cfg,
 	}
-	gotScene := f.OneScene(55)
+	gotScene, err := f.OneScene(55)
+	if err != nil {
+		t.Fatalf("FibaroHc2.OneScene() produced error = %v", err)
+	}
 	secondScene := Hc2Scene{}
 	secondScene.Parse([]byte(gotScene.Lua))
 	if gotScene.SceneID == secondScene.SceneID {
@@ -434,15 +512,191 @@ func TestCreateNewFile(t *testing.T) {
 	// including the luaField
 
 	// now we can CreateScene()
-	sceneIDCreated := f.CreateScene(loadedScene)
-	if sceneIDCreated == -1 {
-		t.Errorf("Scene creation failed, loaded scene was  %v", loadedScene)
+	sceneIDCreated, err := f.CreateScene(loadedScene)
+	if err != nil {
+		t.Errorf("Scene creation failed with %v, loaded scene was  %v", err, loadedScene)
 	}
 
-	sceneCreated := f.OneScene(sceneIDCreated)
+	sceneCreated, err := f.OneScene(sceneIDCreated)
+	if err != nil {
+		t.Fatalf("FibaroHc2.OneScene() produced error = %v", err)
+	}
 	want := Hc2Scene{IsLua: true, Name: loadedScene.Name, SceneID: sceneIDCreated, Type: "com.fibaro.luaScene", Visible: true, RoomID: 305, RunConfig: TriggerAndManual, MaxRunningInstances: 4}
 	AssertHc2SceneWOLuaEqual(t, sceneCreated, want)
 
 }
 
-// TODO: Add test cases for action and test.
+func TestFibaroHc2_Action(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodPost, "/api/scenes/55/action/start", httpmock.NewBytesResponder(202, []byte("")))
+	httpmock.RegisterResponder(http.MethodPost, "/api/scenes/56/action/start", httpmock.NewBytesResponder(404, []byte("")))
+	httpmock.RegisterResponder(http.MethodPost, "/api/scenes/57/action/start", httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	f := &FibaroHc2{
+		cfg: *cfg,
+	}
+
+	tests := []struct {
+		name    string
+		sceneID int
+		wantErr error
+	}{
+		{"start existing scene", 55, nil},
+		{"start non existing scene", 56, ErrNotFound},
+		{"start without connection", 57, ErrTransport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := f.Action(tt.sceneID, Start)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FibaroHc2.Action() error = %v, want %v", err, tt.wantErr)
+			}
+			var apiErr *APIError
+			if tt.wantErr != nil && !errors.As(err, &apiErr) {
+				t.Errorf("FibaroHc2.Action() error = %v is not an *APIError", err)
+			}
+		})
+	}
+}
+
+// TODO: Add test cases for test.
+
+func TestFibaroHc2_Globals(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	fixture, _ := ioutil.ReadFile("../test/globalVariables.json")
+	httpmock.RegisterResponder(http.MethodGet, "/api/globalVariables", httpmock.NewBytesResponder(200, fixture))
+	fixture, _ = ioutil.ReadFile("../test/globalVariableTimeOfDay.json")
+	httpmock.RegisterResponder(http.MethodGet, "/api/globalVariables/TimeOfDay", httpmock.NewBytesResponder(200, fixture))
+	httpmock.RegisterResponder(http.MethodGet, "/api/globalVariables/Unknown", httpmock.NewBytesResponder(404, []byte("")))
+	httpmock.RegisterResponder(http.MethodPut, "/api/globalVariables/TimeOfDay", httpmock.NewBytesResponder(200, []byte("")))
+	httpmock.RegisterResponder(http.MethodPost, "/api/globalVariables", httpmock.NewBytesResponder(201, []byte(`{"name":"NewVar","value":"1","isEnum":false}`)))
+	httpmock.RegisterResponder(http.MethodDelete, "/api/globalVariables/NewVar", httpmock.NewBytesResponder(200, []byte("")))
+
+	f := &FibaroHc2{
+		cfg: *cfg,
+	}
+
+	all, err := f.AllGlobals()
+	if err != nil {
+		t.Fatalf("FibaroHc2.AllGlobals() produced error = %v", err)
+	}
+	AssertEqual(t, len(all), 3)
+	AssertEqual(t, all[1].Name, "HomeTable")
+
+	g, err := f.OneGlobal("TimeOfDay")
+	if err != nil {
+		t.Fatalf("FibaroHc2.OneGlobal() produced error = %v", err)
+	}
+	want := Hc2GlobalVariable{
+		Name:       "TimeOfDay",
+		Value:      "Morning",
+		IsEnum:     true,
+		EnumValues: []string{"Morning", "Day", "Evening", "Night"},
+		Created:    1556403295,
+		Modified:   1603456789,
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("FibaroHc2.OneGlobal() = %v, want %v", g, want)
+	}
+
+	if _, err := f.OneGlobal("Unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FibaroHc2.OneGlobal() error = %v, want %v", err, ErrNotFound)
+	}
+
+	g.Value = "Night"
+	if err := f.UpdateGlobal(g); err != nil {
+		t.Errorf("FibaroHc2.UpdateGlobal() produced error = %v", err)
+	}
+	g.Value = "Midnight"
+	if err := f.UpdateGlobal(g); !errors.Is(err, ErrInvalidGlobal) {
+		t.Errorf("FibaroHc2.UpdateGlobal() error = %v, want %v", err, ErrInvalidGlobal)
+	}
+
+	created, err := f.CreateGlobal(Hc2GlobalVariable{Name: "NewVar", Value: "1"})
+	if err != nil {
+		t.Fatalf("FibaroHc2.CreateGlobal() produced error = %v", err)
+	}
+	AssertEqual(t, created.Name, "NewVar")
+
+	if err := f.DeleteGlobal("NewVar"); err != nil {
+		t.Errorf("FibaroHc2.DeleteGlobal() produced error = %v", err)
+	}
+}
+
+func TestFibaroHc2_RoomsAndSections(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	var body map[string]interface{}
+	record := func(code int, response string) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			body = nil
+			json.NewDecoder(req.Body).Decode(&body)
+			return httpmock.NewStringResponse(code, response), nil
+		}
+	}
+	httpmock.RegisterResponder(http.MethodPost, "/api/rooms", record(201, `{"id":7,"name":"Küche","sectionID":4,"icon":"room_kitchen"}`))
+	httpmock.RegisterResponder(http.MethodPut, "/api/rooms/7", record(200, ""))
+	httpmock.RegisterResponder(http.MethodDelete, "/api/rooms/7", httpmock.NewStringResponder(200, ""))
+	httpmock.RegisterResponder(http.MethodDelete, "/api/rooms/8", httpmock.NewStringResponder(404, ""))
+	httpmock.RegisterResponder(http.MethodPost, "/api/sections", record(201, `{"id":9,"name":"Erdgeschoss"}`))
+	httpmock.RegisterResponder(http.MethodPut, "/api/sections/9", record(200, ""))
+	httpmock.RegisterResponder(http.MethodDelete, "/api/sections/9", httpmock.NewStringResponder(200, ""))
+	httpmock.RegisterResponder(http.MethodPut, "/api/devices/12", record(200, ""))
+
+	f := &FibaroHc2{cfg: *cfg}
+
+	room, err := f.CreateRoom(Hc2Room{Name: "Küche", SectionID: 4, Icon: "room_kitchen"})
+	if err != nil {
+		t.Fatalf("FibaroHc2.CreateRoom() produced error = %v", err)
+	}
+	AssertEqual(t, room.RoomID, 7)
+	AssertEqual(t, body["name"], "Küche")
+
+	room.DefaultSensors.Temperature = 543
+	if err := f.UpdateRoom(room); err != nil {
+		t.Errorf("FibaroHc2.UpdateRoom() produced error = %v", err)
+	}
+	AssertEqual(t, body["defaultSensors"].(map[string]interface{})["temperature"], 543.0)
+
+	if err := f.DeleteRoom(7); err != nil {
+		t.Errorf("FibaroHc2.DeleteRoom() produced error = %v", err)
+	}
+	if err := f.DeleteRoom(8); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FibaroHc2.DeleteRoom() error = %v, want %v", err, ErrNotFound)
+	}
+
+	section, err := f.CreateSection(Hc2Section{Name: "Erdgeschoss"})
+	if err != nil {
+		t.Fatalf("FibaroHc2.CreateSection() produced error = %v", err)
+	}
+	AssertEqual(t, section.SectionID, 9)
+	section.Name = "EG"
+	if err := f.UpdateSection(section); err != nil {
+		t.Errorf("FibaroHc2.UpdateSection() produced error = %v", err)
+	}
+	AssertEqual(t, body["name"], "EG")
+	if err := f.DeleteSection(9); err != nil {
+		t.Errorf("FibaroHc2.DeleteSection() produced error = %v", err)
+	}
+
+	if err := f.MoveDevice(12, 7); err != nil {
+		t.Errorf("FibaroHc2.MoveDevice() produced error = %v", err)
+	}
+	AssertEqual(t, body["roomID"], 7.0)
+}
+
+func TestHc2RoomSensors_UnmarshalJSON(t *testing.T) {
+	var r Hc2Room
+	if err := json.Unmarshal([]byte(`{"id":1,"defaultSensors":[12,13]}`), &r); err != nil {
+		t.Errorf("decoding HC3 room: %v", err)
+	}
+	AssertEqual(t, r.DefaultSensors, Hc2RoomSensors{})
+}
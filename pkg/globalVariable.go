@@ -0,0 +1,45 @@
+package fibarohc2
+
+import "fmt"
+
+// Hc2GlobalVariable represents a global variable in the HC2 system. Enumerated
+// (predefined) variables have IsEnum set and carry their allowed values in EnumValues.
+// Can be encoded as JSON.
+type Hc2GlobalVariable struct {
+	Name       string   `json:"name"`
+	Value      string   `json:"value"`
+	ReadOnly   bool     `json:"readOnly,omitempty"`
+	IsEnum     bool     `json:"isEnum"`
+	EnumValues []string `json:"enumValues,omitempty"`
+	Created    int64    `json:"created,omitempty"`
+	Modified   int64    `json:"modified,omitempty"`
+}
+
+// Allows returns true if value can be assigned to the global variable, i.e. the
+// variable is not enumerated or value is one of its enumerated values.
+func (g Hc2GlobalVariable) Allows(value string) bool {
+	if !g.IsEnum {
+		return true
+	}
+	for _, v := range g.EnumValues {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SanityCheck returns an error matching ErrInvalidGlobal if the variable has no
+// name, or if an enumerated variable has a value not included in its enumerated values.
+func (g Hc2GlobalVariable) SanityCheck() error {
+	if g.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidGlobal)
+	}
+	if g.IsEnum && len(g.EnumValues) == 0 {
+		return fmt.Errorf("%w: enumerated variable %s without values", ErrInvalidGlobal, g.Name)
+	}
+	if !g.Allows(g.Value) {
+		return fmt.Errorf("%w: value \"%s\" not allowed for %s, one of %v", ErrInvalidGlobal, g.Value, g.Name, g.EnumValues)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package fibarohc2
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHc2GlobalVariable_SanityCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  Hc2GlobalVariable
+		wantErr error
+	}{
+		{
+			"plain variable",
+			Hc2GlobalVariable{Name: "Darkness", Value: "1"},
+			nil,
+		},
+		{
+			"missing name",
+			Hc2GlobalVariable{Value: "1"},
+			ErrInvalidGlobal,
+		},
+		{
+			"enumerated with allowed value",
+			Hc2GlobalVariable{Name: "TimeOfDay", Value: "Day", IsEnum: true, EnumValues: []string{"Day", "Night"}},
+			nil,
+		},
+		{
+			"enumerated with invalid value",
+			Hc2GlobalVariable{Name: "TimeOfDay", Value: "Noon", IsEnum: true, EnumValues: []string{"Day", "Night"}},
+			ErrInvalidGlobal,
+		},
+		{
+			"enumerated without values",
+			Hc2GlobalVariable{Name: "TimeOfDay", IsEnum: true},
+			ErrInvalidGlobal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.global.SanityCheck(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Hc2GlobalVariable.SanityCheck() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
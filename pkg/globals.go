@@ -9,6 +9,9 @@ const (
 	// Hc2DefaultConfigFile is the default name of the configuration file
 	Hc2DefaultConfigFile string = ".hc2-tools/config.json"
 
+	// Hc2DefaultCacheFile is the default name of the file the metadata cache is persisted to
+	Hc2DefaultCacheFile string = ".hc2-tools/cache.json"
+
 	// RepoName is the name of this repository
 	RepoName string = "github.com/theovassiliou/hc2-tools"
 
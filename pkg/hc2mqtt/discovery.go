@@ -0,0 +1,130 @@
+package hc2mqtt
+
+import (
+	"strconv"
+	"strings"
+
+	fibarohc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+// discoveryConfig is a Home Assistant MQTT discovery message
+type discoveryConfig struct {
+	component string
+	objectID  string
+	config    map[string]interface{}
+}
+
+// the states of the HC2, "1" or "0", and the HC3, true or false, as ON or OFF
+const onOffTemplate = "{{ 'OFF' if value in ['0', 'false', ''] else 'ON' }}"
+
+// discovery returns the discovery messages of device d, published below topic,
+// in room. Switches, dimmers, roller shutters, sensors and binary sensors are
+// announced, and the battery level, power and energy of all devices having them.
+func (b *Bridge) discovery(d fibarohc2.Hc2Device, topic, room string) []discoveryConfig {
+	id := "hc2_" + strconv.Itoa(d.ID)
+	newConfig := func(component, suffix, name string) discoveryConfig {
+		c := discoveryConfig{component: component, objectID: id + suffix, config: map[string]interface{}{
+			"name":               name,
+			"unique_id":          id + suffix,
+			"availability_topic": b.statusTopic(),
+			"device": map[string]interface{}{
+				"identifiers":    []string{id},
+				"name":           d.Name,
+				"model":          d.Type,
+				"manufacturer":   "Fibaro",
+				"suggested_area": room,
+			},
+		}}
+		return c
+	}
+	has := func(actions ...string) bool {
+		for _, a := range actions {
+			if _, ok := d.Actions[a]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	kind := strings.ToLower(d.Type[strings.LastIndex(d.Type, ".")+1:])
+
+	var configs []discoveryConfig
+	value := topic + "/value"
+	switch {
+	case strings.Contains(kind, "rollershutter") && has("open", "close"):
+		c := newConfig("cover", "", d.Name)
+		c.config["command_topic"] = topic + "/set"
+		c.config["payload_open"] = "open"
+		c.config["payload_close"] = "close"
+		c.config["payload_stop"] = "stop"
+		c.config["position_topic"] = value
+		c.config["set_position_topic"] = value + "/set"
+		c.config["position_open"] = 99
+		c.config["position_closed"] = 0
+		configs = append(configs, c)
+	case has("turnOn", "turnOff", "setValue"):
+		c := newConfig("light", "", d.Name)
+		c.config["state_topic"] = value
+		c.config["state_value_template"] = onOffTemplate
+		c.config["command_topic"] = value + "/set"
+		c.config["brightness_state_topic"] = value
+		c.config["brightness_command_topic"] = value + "/set"
+		c.config["brightness_scale"] = 99
+		configs = append(configs, c)
+	case has("turnOn", "turnOff"):
+		c := newConfig("switch", "", d.Name)
+		c.config["state_topic"] = value
+		c.config["value_template"] = onOffTemplate
+		c.config["command_topic"] = value + "/set"
+		configs = append(configs, c)
+	case strings.HasSuffix(kind, "sensor") && sensorUnits[kind] != "":
+		c := newConfig("sensor", "", d.Name)
+		c.config["state_topic"] = value
+		c.config["unit_of_measurement"] = sensorUnits[kind]
+		if class := strings.TrimSuffix(kind, "sensor"); class != "light" {
+			c.config["device_class"] = class
+		} else {
+			c.config["device_class"] = "illuminance"
+		}
+		configs = append(configs, c)
+	case strings.HasSuffix(kind, "sensor"):
+		c := newConfig("binary_sensor", "", d.Name)
+		c.config["state_topic"] = value
+		c.config["value_template"] = onOffTemplate
+		if class, ok := binarySensorClasses[kind]; ok {
+			c.config["device_class"] = class
+		}
+		configs = append(configs, c)
+	}
+
+	for _, p := range []struct{ property, unit, class string }{
+		{"batteryLevel", "%", "battery"},
+		{"power", "W", "power"},
+		{"energy", "kWh", "energy"},
+	} {
+		if !d.Properties.Property(p.property).IsSet() || !contains(b.opts.Properties, p.property) {
+			continue
+		}
+		c := newConfig("sensor", "_"+p.property, d.Name+" "+p.property)
+		c.config["state_topic"] = topic + "/" + p.property
+		c.config["unit_of_measurement"] = p.unit
+		c.config["device_class"] = p.class
+		configs = append(configs, c)
+	}
+	return configs
+}
+
+// units of the sensors measuring a value, by their device type
+var sensorUnits = map[string]string{
+	"temperaturesensor": "°C",
+	"humiditysensor":    "%",
+	"lightsensor":       "lx",
+}
+
+// device classes of binary sensors, by their device type
+var binarySensorClasses = map[string]string{
+	"doorsensor":   "door",
+	"windowsensor": "window",
+	"motionsensor": "motion",
+	"floodsensor":  "moisture",
+	"smokesensor":  "smoke",
+}
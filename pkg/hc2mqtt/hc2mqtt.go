@@ -0,0 +1,485 @@
+/*
+Package hc2mqtt bridges a Fibaro HC2 to an MQTT broker. The properties of devices
+and the values of global variables are published as retained messages, and updated
+as the HC2 reports changes:
+
+	hc2/<room>/<device>/<property>   e.g. hc2/kitchen/lamp/value
+	hc2/<room>/<device>/key/<keyId>  attribute of a pressed button, e.g. Pressed
+	hc2/global/<variable>            e.g. hc2/global/timeofday
+	hc2/scene/<scene>/running        true or false, as the scene starts and finishes
+	hc2/status                       online, or offline if the HC2 or the bridge is down
+
+Room, device, scene and variable names are turned into topic levels by lowercasing
+them and replacing blanks by _. Names occurring twice get the id appended.
+
+Messages published to these topics with /set appended control the HC2:
+
+	hc2/<room>/<device>/set          an action with its arguments, as "setValue 50"
+	                                 or {"action": "setValue", "args": [50]}
+	hc2/<room>/<device>/value/set    ON, OFF or a number, calls turnOn, turnOff or setValue
+	hc2/<room>/<device>/<prop>/set   calls set<Prop>, e.g. setArmed for armed
+	hc2/scene/<scene>/set            start, stop, enable or disable, start if empty
+
+With Discovery the devices are announced to Home Assistant by MQTT discovery.
+*/
+package hc2mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+
+	fibarohc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+// DefaultProperties are the properties of devices published if none are configured
+var DefaultProperties = []string{"value", "dead", "batteryLevel", "energy", "power", "armed"}
+
+// Options configure a Bridge
+type Options struct {
+	Prefix          string        // first level of all topics, hc2 if empty
+	Properties      []string      // properties of devices published, DefaultProperties if empty
+	Discovery       bool          // announce the devices by Home Assistant MQTT discovery
+	DiscoveryPrefix string        // discovery topic prefix, homeassistant if empty
+	Resync          time.Duration // how often rooms, devices, scenes and globals are reloaded, 10m if 0
+	Backoff         time.Duration // delay before reconnecting after a failure, doubled up to 1m, 1s if 0
+}
+
+// Bridge publishes the state of a Fibaro controller to MQTT and calls the actions
+// received from MQTT
+type Bridge struct {
+	hc2      fibarohc2.Controller
+	opts     Options
+	client   mqtt.Client
+	resync   chan struct{}
+	connects int32 // number of connections to the broker so far
+
+	mu       sync.Mutex
+	devices  map[int]string    // device to its topic
+	scenes   map[int]string    // scene to its topic
+	commands map[string]target // topic, without /set, to what it controls
+}
+
+// target is a device or scene controlled by a topic
+type target struct {
+	scene bool
+	id    int
+}
+
+// New returns a bridge between c and the MQTT broker configured by mqttOpts. The
+// handlers and the will of mqttOpts are set by the bridge.
+func New(c fibarohc2.Controller, mqttOpts *mqtt.ClientOptions, opts Options) *Bridge {
+	if opts.Prefix == "" {
+		opts.Prefix = "hc2"
+	}
+	if len(opts.Properties) == 0 {
+		opts.Properties = DefaultProperties
+	}
+	if opts.DiscoveryPrefix == "" {
+		opts.DiscoveryPrefix = "homeassistant"
+	}
+	if opts.Resync == 0 {
+		opts.Resync = 10 * time.Minute
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = time.Second
+	}
+	b := &Bridge{hc2: c, opts: opts, resync: make(chan struct{}, 1)}
+
+	mqttOpts.SetWill(b.statusTopic(), "offline", 1, true)
+	mqttOpts.SetAutoReconnect(true)
+	mqttOpts.SetOnConnectHandler(b.onConnect)
+	mqttOpts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Warnf("Lost connection to MQTT broker, reconnecting: %v", err)
+	})
+	b.client = mqtt.NewClient(mqttOpts)
+	return b
+}
+
+// Run connects to the broker and bridges until ctx is done. Failures of the broker
+// or the HC2 are logged and retried.
+func (b *Bridge) Run(ctx context.Context) error {
+	backoff := b.opts.Backoff
+	for {
+		t := b.client.Connect()
+		t.Wait()
+		if t.Error() == nil {
+			break
+		}
+		log.Warnf("Could not connect to MQTT broker, retrying in %v: %v", backoff, t.Error())
+		if !sleep(ctx, &backoff) {
+			return ctx.Err()
+		}
+	}
+	defer b.client.Disconnect(250)
+	defer b.publish(b.statusTopic(), "offline", true)
+
+	backoff = b.opts.Backoff
+	for ctx.Err() == nil {
+		if err := b.bridge(ctx); err != nil && ctx.Err() == nil {
+			log.Warnf("Bridging failed, retrying in %v: %v", backoff, err)
+			b.publish(b.statusTopic(), "offline", true)
+			sleep(ctx, &backoff)
+			continue
+		}
+		backoff = b.opts.Backoff
+	}
+	return ctx.Err()
+}
+
+// bridge loads the state of the HC2, publishes it and then its changes, until a
+// resync is due
+func (b *Bridge) bridge(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := b.hc2.RefreshStates(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if err := b.sync(); err != nil {
+		return err
+	}
+	b.publish(b.statusTopic(), "online", true)
+
+	resync := time.NewTimer(b.opts.Resync)
+	defer resync.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return ctx.Err()
+			}
+			if e.Type == fibarohc2.EventError {
+				return e.Err
+			}
+			b.handleEvent(e)
+		case <-resync.C:
+			return nil
+		case <-b.resync:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// onConnect subscribes to the command topics. After a reconnect the state is
+// published again, as the broker may have lost it.
+func (b *Bridge) onConnect(c mqtt.Client) {
+	log.Infof("Connected to MQTT broker")
+	filters := map[string]byte{
+		b.opts.Prefix + "/+/+/set":   1,
+		b.opts.Prefix + "/+/+/+/set": 1,
+	}
+	if t := c.SubscribeMultiple(filters, b.onCommand); t.Wait() && t.Error() != nil {
+		log.Errorf("Could not subscribe to commands: %v", t.Error())
+	}
+	if atomic.AddInt32(&b.connects, 1) == 1 {
+		return
+	}
+	select {
+	case b.resync <- struct{}{}:
+	default:
+	}
+}
+
+// sync loads rooms, devices, scenes and global variables and publishes their state
+func (b *Bridge) sync() error {
+	rooms, err := b.hc2.AllRooms()
+	if err != nil {
+		return err
+	}
+	devices, err := b.hc2.AllDevices()
+	if err != nil {
+		return err
+	}
+	scenes, err := b.hc2.AllScenes()
+	if err != nil {
+		return err
+	}
+	globals, err := b.hc2.AllGlobals()
+	if err != nil {
+		return err
+	}
+
+	roomNames, roomLevelOf := map[int]string{}, map[int]string{}
+	roomLevels := newLevels()
+	roomLevels.reserve("global", "scene", "status")
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomID < rooms[j].RoomID })
+	for _, r := range rooms {
+		roomNames[r.RoomID] = r.Name
+		roomLevelOf[r.RoomID] = roomLevels.level(r.Name, r.RoomID)
+	}
+	unassigned := roomLevels.level("unassigned", 0)
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	deviceTopics, commands := map[int]string{}, map[string]target{}
+	deviceLevels := map[string]*levels{} // room to the levels of its devices
+	var visible []fibarohc2.Hc2Device
+	for _, d := range devices {
+		if !d.Visible {
+			continue
+		}
+		room, ok := roomLevelOf[d.RoomID]
+		if !ok {
+			room = unassigned
+		}
+		if deviceLevels[room] == nil {
+			deviceLevels[room] = newLevels()
+		}
+		topic := b.opts.Prefix + "/" + room + "/" + deviceLevels[room].level(d.Name, d.ID)
+		deviceTopics[d.ID] = topic
+		commands[topic] = target{id: d.ID}
+		visible = append(visible, d)
+	}
+
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i].SceneID < scenes[j].SceneID })
+	sceneTopics := map[int]string{}
+	sceneLevels := newLevels()
+	for _, s := range scenes {
+		topic := b.opts.Prefix + "/scene/" + sceneLevels.level(s.Name, s.SceneID)
+		sceneTopics[s.SceneID] = topic
+		commands[topic] = target{scene: true, id: s.SceneID}
+	}
+
+	b.mu.Lock()
+	b.devices, b.scenes, b.commands = deviceTopics, sceneTopics, commands
+	b.mu.Unlock()
+
+	for _, d := range visible {
+		for _, p := range b.opts.Properties {
+			if v, err := d.Properties.Property(p).String(); err == nil {
+				b.publish(deviceTopics[d.ID]+"/"+p, v, true)
+			}
+		}
+		if b.opts.Discovery {
+			for _, c := range b.discovery(d, deviceTopics[d.ID], roomNames[d.RoomID]) {
+				payload, _ := json.Marshal(c.config)
+				b.publish(b.opts.DiscoveryPrefix+"/"+c.component+"/"+c.objectID+"/config", string(payload), true)
+			}
+		}
+	}
+	for _, g := range globals {
+		b.publish(b.globalTopic(g.Name), g.Value, true)
+	}
+	log.Infof("Published %d devices and %d global variables", len(visible), len(globals))
+	return nil
+}
+
+func (b *Bridge) handleEvent(e fibarohc2.Event) {
+	b.mu.Lock()
+	device, knownDevice := b.devices[e.DeviceID]
+	scene, knownScene := b.scenes[e.SceneID]
+	b.mu.Unlock()
+
+	switch e.Type {
+	case fibarohc2.EventDeviceProperty:
+		if !knownDevice || !contains(b.opts.Properties, e.Property) {
+			return
+		}
+		if v, err := e.Value.String(); err == nil {
+			b.publish(device+"/"+e.Property, v, true)
+		}
+	case fibarohc2.EventGlobal:
+		if v, err := e.Value.String(); err == nil {
+			b.publish(b.globalTopic(e.Global), v, true)
+		}
+	case fibarohc2.EventSceneStarted, fibarohc2.EventSceneFinished:
+		if knownScene {
+			b.publish(scene+"/running", strconv.FormatBool(e.Type == fibarohc2.EventSceneStarted), true)
+		}
+	case fibarohc2.EventCentralScene:
+		if knownDevice {
+			b.publish(device+"/key/"+strconv.Itoa(e.KeyID), e.KeyAttribute, false)
+		}
+	}
+}
+
+// onCommand handles a message to a /set topic
+func (b *Bridge) onCommand(_ mqtt.Client, m mqtt.Message) {
+	topic := strings.TrimSuffix(m.Topic(), "/set")
+	payload := strings.TrimSpace(string(m.Payload()))
+
+	b.mu.Lock()
+	t, ok := b.commands[topic]
+	property := ""
+	if !ok {
+		if i := strings.LastIndex(topic, "/"); i >= 0 {
+			t, ok = b.commands[topic[:i]]
+			property = topic[i+1:]
+		}
+	}
+	b.mu.Unlock()
+	if !ok || t.scene && property != "" {
+		log.Warnf("Ignoring message to unknown topic %s", m.Topic())
+		return
+	}
+
+	var err error
+	switch {
+	case t.scene:
+		err = b.sceneAction(t.id, payload)
+	case property != "":
+		err = b.setProperty(t.id, property, payload)
+	default:
+		err = b.deviceAction(t.id, payload)
+	}
+	if err != nil {
+		log.Errorf("Could not handle %s %q: %v", m.Topic(), payload, err)
+	}
+}
+
+func (b *Bridge) sceneAction(sceneID int, payload string) error {
+	if payload == "" {
+		payload = fibarohc2.Start.String()
+	}
+	var action fibarohc2.SceneActionCommand
+	if err := action.Set(strings.ToLower(payload)); err != nil {
+		return err
+	}
+	return b.hc2.Action(sceneID, action)
+}
+
+func (b *Bridge) setProperty(deviceID int, property, payload string) error {
+	if property != "value" {
+		return b.hc2.CallAction(deviceID, "set"+strings.ToUpper(property[:1])+property[1:], actionArg(payload))
+	}
+	switch strings.ToLower(payload) {
+	case "on", "true":
+		return b.hc2.CallAction(deviceID, "turnOn")
+	case "off", "false":
+		return b.hc2.CallAction(deviceID, "turnOff")
+	}
+	if _, err := strconv.ParseFloat(payload, 64); err != nil {
+		return fmt.Errorf("value %q is neither ON, OFF nor a number", payload)
+	}
+	return b.hc2.CallAction(deviceID, "setValue", actionArg(payload))
+}
+
+func (b *Bridge) deviceAction(deviceID int, payload string) error {
+	var call struct {
+		Action string        `json:"action"`
+		Args   []interface{} `json:"args"`
+	}
+	if strings.HasPrefix(payload, "{") {
+		if err := json.Unmarshal([]byte(payload), &call); err != nil {
+			return err
+		}
+	} else {
+		fields := strings.Fields(payload)
+		if len(fields) > 0 {
+			call.Action = fields[0]
+			for _, f := range fields[1:] {
+				call.Args = append(call.Args, actionArg(f))
+			}
+		}
+	}
+	if call.Action == "" {
+		return fmt.Errorf("no action given")
+	}
+	return b.hc2.CallAction(deviceID, call.Action, call.Args...)
+}
+
+func (b *Bridge) publish(topic, payload string, retained bool) {
+	t := b.client.Publish(topic, 1, retained, payload)
+	if t.WaitTimeout(5*time.Second) && t.Error() != nil {
+		log.Debugf("Could not publish %s: %v", topic, t.Error())
+	}
+}
+
+func (b *Bridge) statusTopic() string {
+	return b.opts.Prefix + "/status"
+}
+
+func (b *Bridge) globalTopic(name string) string {
+	return b.opts.Prefix + "/global/" + slug(name, 0)
+}
+
+// levels turns names into unique topic levels
+type levels map[string]bool
+
+func newLevels() *levels {
+	return &levels{}
+}
+
+func (l *levels) reserve(names ...string) {
+	for _, n := range names {
+		(*l)[n] = true
+	}
+}
+
+// level returns the topic level of name, with id appended if it is taken
+func (l *levels) level(name string, id int) string {
+	s := slug(name, id)
+	if (*l)[s] {
+		s += "_" + strconv.Itoa(id)
+	}
+	(*l)[s] = true
+	return s
+}
+
+// slug returns name lowercased, with blanks and / replaced by _ and the wildcards
+// and control characters removed. It returns id for names without other characters.
+func slug(name string, id int) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case unicode.IsSpace(r) || r == '/':
+			sb.WriteRune('_')
+		case r == '+' || r == '#' || r == '$' || unicode.IsControl(r):
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() == 0 {
+		return strconv.Itoa(id)
+	}
+	return sb.String()
+}
+
+// actionArg converts an argument of an action into a number or bool, if possible
+func actionArg(s string) interface{} {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	return s
+}
+
+// sleep waits for backoff, which is then doubled up to a minute. It returns false
+// if ctx is done before.
+func sleep(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-time.After(*backoff):
+	case <-ctx.Done():
+		return false
+	}
+	if *backoff *= 2; *backoff > time.Minute {
+		*backoff = time.Minute
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
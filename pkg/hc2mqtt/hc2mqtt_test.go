@@ -0,0 +1,146 @@
+package hc2mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	fibarohc2 "github.com/theovassiliou/hc2-tools/pkg"
+	"github.com/theovassiliou/hc2-tools/pkg/hc2mqtt/mqtttest"
+	"github.com/theovassiliou/hc2-tools/pkg/hc2sim"
+)
+
+const stateFileName string = "../../test/hc2simState.json"
+
+func TestBridge(t *testing.T) {
+	f, err := os.Open(stateFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := hc2sim.LoadState(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := hc2sim.New(state)
+	var down int32 // the HC2 answers all requests with 500 if set
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) != 0 {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	broker, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	var cfg fibarohc2.FibaroConfig
+	fibarohc2.Default(&cfg)
+	cfg.BaseURL, cfg.Username, cfg.Password = ts.URL, "admin", "secret"
+	hc2 := &fibarohc2.FibaroHc2{}
+	hc2.SetConfig(cfg)
+
+	mqttOpts := mqtt.NewClientOptions().AddBroker(broker.URL()).SetClientID("hc2Mqtt").
+		SetMaxReconnectInterval(100 * time.Millisecond)
+	bridge := New(hc2, mqttOpts, Options{Discovery: true, Backoff: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- bridge.Run(ctx) }()
+
+	waitRetained := func(topic, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, _ := broker.Retained(topic)
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("retained %s = %q, want %q", topic, got, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitRetained("hc2/status", "online")
+	waitRetained("hc2/schlafzimmer/lamp/value", "0")
+	waitRetained("hc2/schlafzimmer/lamp/dead", "false")
+	waitRetained("hc2/global/timeofday", "Morning")
+
+	config, ok := broker.Retained("homeassistant/light/hc2_449/config")
+	if !ok {
+		t.Fatalf("no discovery message for device 449")
+	}
+	var discovery map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery["command_topic"] != "hc2/schlafzimmer/lamp/value/set" || discovery["unique_id"] != "hc2_449" {
+		t.Errorf("discovery message = %v", discovery)
+	}
+
+	broker.Publish("hc2/schlafzimmer/lamp/value/set", "ON", false)
+	waitRetained("hc2/schlafzimmer/lamp/value", "1")
+	broker.Publish("hc2/schlafzimmer/lamp/set", "setValue 42", false)
+	waitRetained("hc2/schlafzimmer/lamp/value", "42")
+	broker.Publish("hc2/schlafzimmer/lamp/set", `{"action": "turnOff"}`, false)
+	waitRetained("hc2/schlafzimmer/lamp/value", "0")
+	broker.Publish("hc2/scene/short/set", "start", false)
+	waitRetained("hc2/scene/short/running", "true")
+
+	// the broker restarts
+	broker.Disconnect()
+	broker.Publish("hc2/status", "", true)
+	waitRetained("hc2/status", "online")
+	broker.Publish("hc2/schlafzimmer/lamp/value/set", "25", false)
+	waitRetained("hc2/schlafzimmer/lamp/value", "25")
+
+	// the HC2 fails
+	atomic.StoreInt32(&down, 1)
+	waitRetained("hc2/status", "offline")
+	atomic.StoreInt32(&down, 0)
+	waitRetained("hc2/status", "online")
+	broker.Publish("hc2/schlafzimmer/lamp/value/set", "OFF", false)
+	waitRetained("hc2/schlafzimmer/lamp/value", "0")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after cancel")
+	}
+	waitRetained("hc2/status", "offline")
+}
+
+func TestSlug(t *testing.T) {
+	l := newLevels()
+	l.reserve("scene")
+	tests := []struct {
+		name string
+		id   int
+		want string
+	}{
+		{"Living Room", 1, "living_room"},
+		{"Living room", 2, "living_room_2"},
+		{"Küche/Ost #1", 3, "küche_ost_1"},
+		{"Scene", 4, "scene_4"},
+		{"+#", 5, "5"},
+	}
+	for _, tt := range tests {
+		if got := l.level(tt.name, tt.id); got != tt.want {
+			t.Errorf("level(%q, %d) = %q, want %q", tt.name, tt.id, got, tt.want)
+		}
+	}
+}
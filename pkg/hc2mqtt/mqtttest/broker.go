@@ -0,0 +1,340 @@
+/*
+Package mqtttest provides a minimal MQTT 3.1.1 broker to test MQTT clients, like
+the bridge of hc2Mqtt, without an external broker.
+
+The broker keeps retained messages, matches subscriptions with the wildcards + and #,
+and publishes the will of clients losing their connection. Messages are delivered
+to subscribers with QoS 0, regardless of the QoS they are published or subscribed
+with. Sessions are not kept, and clients are not authenticated.
+*/
+package mqtttest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Message is a message published to the broker
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// Broker is an MQTT broker listening on a local port
+type Broker struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	clients   map[*client]bool
+	retained  map[string][]byte
+	published []Message
+	changed   chan struct{} // closed and replaced whenever a message is published
+}
+
+type client struct {
+	conn net.Conn
+	id   string
+	will *Message
+
+	mu            sync.Mutex // guards writes to conn and subscriptions
+	subscriptions []string
+}
+
+// NewBroker starts a broker listening on a random port of localhost
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		ln:       ln,
+		clients:  map[*client]bool{},
+		retained: map[string][]byte{},
+		changed:  make(chan struct{}),
+	}
+	go b.serve()
+	return b, nil
+}
+
+// URL returns the URL clients connect to, as tcp://127.0.0.1:port
+func (b *Broker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// Close stops the broker and disconnects all clients
+func (b *Broker) Close() {
+	b.ln.Close()
+	b.Disconnect()
+}
+
+// Disconnect closes the connections of all clients, as a restarting broker would.
+// Retained messages are kept.
+func (b *Broker) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		c.conn.Close()
+	}
+}
+
+// Clients returns the ids of the clients connected
+func (b *Broker) Clients() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []string
+	for c := range b.clients {
+		ids = append(ids, c.id)
+	}
+	return ids
+}
+
+// Retained returns the retained message of topic
+func (b *Broker) Retained(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.retained[topic]
+	return string(payload), ok
+}
+
+// Published returns all messages published so far, in the order they were received
+func (b *Broker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message{}, b.published...)
+}
+
+// WaitFor waits until a message matching the topic filter is published, and returns
+// the last one. It returns false if there is none within timeout.
+func (b *Broker) WaitFor(filter string, timeout time.Duration) (Message, bool) {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		for i := len(b.published) - 1; i >= 0; i-- {
+			if Match(filter, b.published[i].Topic) {
+				m := b.published[i]
+				b.mu.Unlock()
+				return m, true
+			}
+		}
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return Message{}, false
+		}
+	}
+}
+
+// Publish publishes a message as if it was sent by a client
+func (b *Broker) Publish(topic, payload string, retain bool) {
+	b.publish(Message{Topic: topic, Payload: []byte(payload), Retained: retain})
+}
+
+func (b *Broker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(&client{conn: conn})
+	}
+}
+
+func (b *Broker) handle(c *client) {
+	defer func() {
+		c.conn.Close()
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+		if c.will != nil {
+			b.publish(*c.will)
+		}
+	}()
+
+	for {
+		p, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.ConnectPacket:
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ack.ReturnCode = p.Validate()
+			if err := c.write(ack); err != nil || ack.ReturnCode != packets.Accepted {
+				return
+			}
+			c.id = p.ClientIdentifier
+			if p.WillFlag {
+				c.will = &Message{Topic: p.WillTopic, Payload: p.WillMessage, Retained: p.WillRetain}
+			}
+			b.mu.Lock()
+			b.clients[c] = true
+			b.mu.Unlock()
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			c.mu.Lock()
+			for i, topic := range p.Topics {
+				c.subscriptions = append(c.subscriptions, topic)
+				ack.ReturnCodes = append(ack.ReturnCodes, minByte(p.Qoss[i], 1))
+			}
+			c.mu.Unlock()
+			if err := c.write(ack); err != nil {
+				return
+			}
+			b.sendRetained(c, p.Topics)
+		case *packets.UnsubscribePacket:
+			c.mu.Lock()
+			var kept []string
+			for _, s := range c.subscriptions {
+				if !contains(p.Topics, s) {
+					kept = append(kept, s)
+				}
+			}
+			c.subscriptions = kept
+			c.mu.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			if err := c.write(ack); err != nil {
+				return
+			}
+		case *packets.PublishPacket:
+			switch p.Qos {
+			case 1:
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				err = c.write(ack)
+			case 2:
+				ack := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				ack.MessageID = p.MessageID
+				err = c.write(ack)
+			}
+			if err != nil {
+				return
+			}
+			b.publish(Message{Topic: p.TopicName, Payload: p.Payload, Retained: p.Retain})
+		case *packets.PubrelPacket:
+			ack := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			ack.MessageID = p.MessageID
+			if err := c.write(ack); err != nil {
+				return
+			}
+		case *packets.PingreqPacket:
+			if err := c.write(packets.NewControlPacket(packets.Pingresp)); err != nil {
+				return
+			}
+		case *packets.DisconnectPacket:
+			c.will = nil
+			return
+		}
+	}
+}
+
+func (c *client) write(p packets.ControlPacket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return p.Write(c.conn)
+}
+
+// subscribed returns whether the client subscribed to topic
+func (c *client) subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.subscriptions {
+		if Match(s, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Broker) publish(m Message) {
+	b.mu.Lock()
+	if m.Retained {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m.Payload
+		}
+	}
+	b.published = append(b.published, m)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	var receivers []*client
+	for c := range b.clients {
+		receivers = append(receivers, c)
+	}
+	b.mu.Unlock()
+
+	for _, c := range receivers {
+		if c.subscribed(m.Topic) {
+			c.send(m.Topic, m.Payload, false)
+		}
+	}
+}
+
+func (b *Broker) sendRetained(c *client, filters []string) {
+	b.mu.Lock()
+	var matching []Message
+	for topic, payload := range b.retained {
+		for _, f := range filters {
+			if Match(f, topic) {
+				matching = append(matching, Message{Topic: topic, Payload: payload})
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, m := range matching {
+		c.send(m.Topic, m.Payload, true)
+	}
+}
+
+func (c *client) send(topic string, payload []byte, retained bool) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = payload
+	p.Retain = retained
+	if err := c.write(p); err != nil {
+		c.conn.Close()
+	}
+}
+
+// Match returns whether topic matches the subscription filter, which may contain
+// the wildcards + for one level and # for all remaining levels
+func Match(filter, topic string) bool {
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		switch {
+		case level == "#":
+			return true
+		case i >= len(t):
+			return false
+		case level != "+" && level != t[i]:
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func minByte(a, b byte) byte {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,23 @@
+package mqtttest
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"hc2/kitchen/lamp/value", "hc2/kitchen/lamp/value", true},
+		{"hc2/+/+/set", "hc2/kitchen/lamp/set", true},
+		{"hc2/+/+/set", "hc2/kitchen/lamp/value/set", false},
+		{"hc2/#", "hc2/kitchen/lamp/value", true},
+		{"hc2/#", "hc2", true},
+		{"hc2/+", "hc2/kitchen/lamp", false},
+		{"hc2/kitchen", "hc2/kitchen/lamp", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
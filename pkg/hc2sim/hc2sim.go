@@ -0,0 +1,521 @@
+// Package hc2sim simulates the REST API of a Fibaro HC2 system for offline development
+// and tests. The simulator keeps its state in memory, can be seeded from JSON, and
+// implements http.Handler so that it can be served by net/http or net/http/httptest.
+//
+//	sim := hc2sim.New(hc2sim.State{Scenes: []fibarohc2.Hc2Scene{{SceneID: 1, Name: "A"}}})
+//	ts := httptest.NewServer(sim)
+//	defer ts.Close()
+//
+// All requests have to be authenticated using basic auth with one of the users of
+// the state. If the state has no users, admin/admin is accepted.
+package hc2sim
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fibarohc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+// User is a user accepted by the simulator
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Type     string `json:"type"`
+}
+
+// State is the content of the simulated HC2. Can be encoded as JSON.
+type State struct {
+	Info           fibarohc2.Hc2Info                   `json:"info"`
+	Network        fibarohc2.Hc2Network                `json:"network"`
+	Users          []User                              `json:"users,omitempty"`
+	Scenes         []fibarohc2.Hc2Scene                `json:"scenes,omitempty"`
+	Devices        []fibarohc2.Hc2Device               `json:"devices,omitempty"`
+	Rooms          []fibarohc2.Hc2Room                 `json:"rooms,omitempty"`
+	Sections       []fibarohc2.Hc2Section              `json:"sections,omitempty"`
+	Globals        []fibarohc2.Hc2GlobalVariable       `json:"globalVariables,omitempty"`
+	VirtualDevices []fibarohc2.Hc2VirtualDevice        `json:"virtualDevices,omitempty"`
+	DebugMessages  map[int][]fibarohc2.Hc2DebugMessage `json:"debugMessages,omitempty"`
+}
+
+// LoadState reads a JSON encoded State
+func LoadState(r io.Reader) (State, error) {
+	var s State
+	err := json.NewDecoder(r).Decode(&s)
+	return s, err
+}
+
+// Server simulates a HC2. It is safe for concurrent use.
+type Server struct {
+	mu sync.Mutex
+
+	info          fibarohc2.Hc2Info
+	network       fibarohc2.Hc2Network
+	users         []User
+	collections   map[string]*collection
+	debugMessages map[int][]fibarohc2.Hc2DebugMessage
+	refreshStates []refreshEntry
+	last          int64
+}
+
+// refreshEntry is a change reported by /api/refreshStates, either a change of the
+// properties of a device or an event
+type refreshEntry struct {
+	last   int64
+	change map[string]interface{}
+	event  map[string]interface{}
+}
+
+// pollTimeout is how long /api/refreshStates waits for changes
+var pollTimeout = 2 * time.Second
+
+// New creates a simulator with the given initial state.
+func New(state State) *Server {
+	s := &Server{
+		info:          state.Info,
+		network:       state.Network,
+		users:         state.Users,
+		debugMessages: map[int][]fibarohc2.Hc2DebugMessage{},
+		last:          1,
+		collections: map[string]*collection{
+			"scenes":          newCollection("id"),
+			"devices":         newCollection("id"),
+			"rooms":           newCollection("id"),
+			"sections":        newCollection("id"),
+			"globalVariables": newCollection("name"),
+			"virtualDevices":  newCollection("id"),
+		},
+	}
+	if s.info.HcName == "" {
+		s.info = fibarohc2.Hc2Info{SerialNumber: "HC2-000000", HcName: "HC2-Simulator", SoftVersion: "4.600"}
+	}
+	if len(s.users) == 0 {
+		s.users = []User{{ID: 2, Username: "admin", Password: "admin", Type: "superuser"}}
+	}
+	for k, v := range state.DebugMessages {
+		s.debugMessages[k] = v
+	}
+	s.collections["scenes"].load(state.Scenes)
+	s.collections["devices"].load(state.Devices)
+	s.collections["rooms"].load(state.Rooms)
+	s.collections["sections"].load(state.Sections)
+	s.collections["globalVariables"].load(state.Globals)
+	s.collections["virtualDevices"].load(state.VirtualDevices)
+	return s
+}
+
+// Snapshot returns the current state of the simulator
+func (s *Server) Snapshot() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := State{
+		Info:          s.info,
+		Network:       s.network,
+		Users:         s.users,
+		DebugMessages: map[int][]fibarohc2.Hc2DebugMessage{},
+	}
+	for k, v := range s.debugMessages {
+		state.DebugMessages[k] = v
+	}
+	s.collections["scenes"].store(&state.Scenes)
+	s.collections["devices"].store(&state.Devices)
+	s.collections["rooms"].store(&state.Rooms)
+	s.collections["sections"].store(&state.Sections)
+	s.collections["globalVariables"].store(&state.Globals)
+	s.collections["virtualDevices"].store(&state.VirtualDevices)
+	return state
+}
+
+// AddDebugMessage appends a debug message to the messages of a scene
+func (s *Server) AddDebugMessage(sceneID int, msgType, txt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addDebugMessage(sceneID, msgType, txt)
+}
+
+func (s *Server) addDebugMessage(sceneID int, msgType, txt string) {
+	s.debugMessages[sceneID] = append(s.debugMessages[sceneID], fibarohc2.Hc2DebugMessage{
+		Timestamp: time.Now().Unix(),
+		Type:      msgType,
+		Txt:       txt,
+	})
+}
+
+// AddEvent adds an event of the type with data to the changes reported by
+// /api/refreshStates, e.g. a CentralSceneEvent with the data
+// {"deviceId": 44, "keyId": 1, "keyAttribute": "Pressed"}
+func (s *Server) AddEvent(eventType string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addEvent(eventType, data)
+}
+
+func (s *Server) addEvent(eventType string, data map[string]interface{}) {
+	s.last++
+	s.refreshStates = append(s.refreshStates, refreshEntry{last: s.last, event: map[string]interface{}{"type": eventType, "data": data}})
+}
+
+func (s *Server) addChange(change map[string]interface{}) {
+	s.last++
+	s.refreshStates = append(s.refreshStates, refreshEntry{last: s.last, change: change})
+}
+
+// ServeHTTP serves the simulated REST API below /api
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fibaro"`)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api"), "/")
+	parts := strings.Split(path, "/")
+	if path == "refreshStates" {
+		s.serveRefreshStates(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case path == "loginStatus":
+		writeJSON(w, http.StatusOK, fibarohc2.Hc2LoginStatus{Status: true, UserID: user.ID, Username: user.Username, Type: user.Type})
+	case path == "settings/info":
+		writeJSON(w, http.StatusOK, s.info)
+	case path == "settings/network":
+		writeJSON(w, http.StatusOK, s.network)
+	case len(parts) == 3 && parts[0] == "scenes" && parts[2] == "debugMessages":
+		s.serveDebugMessages(w, r, parts[1])
+	case path == "callAction":
+		s.serveCallAction(w, r)
+	case len(parts) == 4 && parts[0] == "scenes" && parts[2] == "action":
+		s.serveSceneAction(w, r, parts[1], parts[3])
+	case s.collections[parts[0]] != nil && len(parts) <= 2:
+		s.serveCollection(w, r, s.collections[parts[0]], parts[1:])
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) authenticate(r *http.Request) (User, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return User{}, false
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return User{}, false
+	}
+	for _, u := range s.users {
+		if string(b) == u.Username+":"+u.Password {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (s *Server) serveCollection(w http.ResponseWriter, r *http.Request, c *collection, key []string) {
+	if len(key) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, c.list())
+		case http.MethodPost:
+			item, err := readObject(r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			created, ok := c.create(item)
+			if !ok {
+				writeError(w, http.StatusConflict, "Already exists")
+				return
+			}
+			writeJSON(w, http.StatusCreated, created)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	item, ok := c.get(key[0])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, item)
+	case http.MethodPut:
+		update, err := readObject(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if rc, ok := update["runConfig"].(string); ok && rc != "" &&
+			rc != fibarohc2.TriggerAndManual && rc != fibarohc2.ManualOnly && rc != fibarohc2.Disabled {
+			writeError(w, http.StatusBadRequest, "Invalid runConfig parameter")
+			return
+		}
+		if c == s.collections["globalVariables"] {
+			if v, ok := update["value"]; ok && v != item["value"] {
+				s.addEvent("GlobalVariableChangedEvent", map[string]interface{}{"variableName": key[0], "newValue": v, "oldValue": item["value"]})
+			}
+		}
+		for k, v := range update {
+			if k != c.key {
+				item[k] = v
+			}
+		}
+		writeJSON(w, http.StatusOK, item)
+	case http.MethodDelete:
+		c.remove(key[0])
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) serveDebugMessages(w http.ResponseWriter, r *http.Request, id string) {
+	sceneID, err := strconv.Atoi(id)
+	if _, ok := s.collections["scenes"].get(id); err != nil || !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	msgs := s.debugMessages[sceneID]
+	if msgs == nil {
+		msgs = []fibarohc2.Hc2DebugMessage{}
+	}
+	writeJSON(w, http.StatusOK, msgs)
+}
+
+func (s *Server) serveSceneAction(w http.ResponseWriter, r *http.Request, id, action string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	scene, ok := s.collections["scenes"].get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	sceneID, _ := strconv.Atoi(id)
+	switch action {
+	case "start":
+		if scene["runConfig"] == fibarohc2.Disabled {
+			writeError(w, http.StatusBadRequest, "Scene disabled")
+			return
+		}
+		s.addDebugMessage(sceneID, "debug", "scene started")
+		s.addEvent("SceneRunningInstancesEvent", map[string]interface{}{"id": sceneID, "runningInstances": 1})
+	case "stop":
+		s.addDebugMessage(sceneID, "debug", "scene stopped")
+		s.addEvent("SceneRunningInstancesEvent", map[string]interface{}{"id": sceneID, "runningInstances": 0})
+	case "enable":
+		scene["runConfig"] = fibarohc2.TriggerAndManual
+	case "disable":
+		scene["runConfig"] = fibarohc2.Disabled
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveCallAction calls an action of a device. turnOn, turnOff and setValue change
+// the value of the device, all other actions are accepted without effect.
+func (s *Server) serveCallAction(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	device, ok := s.collections["devices"].get(q.Get("deviceID"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	action := q.Get("name")
+	if actions, ok := device["actions"].(map[string]interface{}); ok {
+		if _, ok := actions[action]; !ok {
+			writeError(w, http.StatusBadRequest, "Unknown action")
+			return
+		}
+	}
+	properties, ok := device["properties"].(map[string]interface{})
+	if !ok {
+		properties = map[string]interface{}{}
+		device["properties"] = properties
+	}
+	value := properties["value"]
+	switch action {
+	case "turnOn":
+		value = "1"
+	case "turnOff":
+		value = "0"
+	case "setValue":
+		value = q.Get("arg1")
+	}
+	if old := properties["value"]; value != old {
+		properties["value"] = value
+		// as the HC2, report the change both as change and as event
+		s.addChange(map[string]interface{}{"id": device["id"], "value": value})
+		s.addEvent("DevicePropertyUpdatedEvent", map[string]interface{}{"id": device["id"], "property": "value", "newValue": value, "oldValue": old})
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveRefreshStates reports the changes after the query parameter last. Without
+// changes it waits up to pollTimeout for some, as the HC2 does. With last 0 only
+// the current last is reported.
+func (s *Server) serveRefreshStates(w http.ResponseWriter, r *http.Request) {
+	last, _ := strconv.ParseInt(r.URL.Query().Get("last"), 10, 64)
+	deadline := time.Now().Add(pollTimeout)
+	for {
+		s.mu.Lock()
+		changes, events := []interface{}{}, []interface{}{}
+		if last != 0 {
+			for _, e := range s.refreshStates {
+				switch {
+				case e.last <= last:
+				case e.change != nil:
+					changes = append(changes, e.change)
+				default:
+					events = append(events, e.event)
+				}
+			}
+		}
+		current := s.last
+		s.mu.Unlock()
+
+		if last == 0 || len(changes)+len(events) > 0 || time.Now().After(deadline) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status":    "IDLE",
+				"last":      current,
+				"timestamp": time.Now().Unix(),
+				"changes":   changes,
+				"events":    events,
+			})
+			return
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func readObject(r *http.Request) (map[string]interface{}, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var o map[string]interface{}
+	err = json.Unmarshal(b, &o)
+	return o, err
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format used by the HC2
+func writeError(w http.ResponseWriter, code int, reason string) {
+	writeJSON(w, code, map[string]string{"type": "ERROR", "reason": reason, "message": reason})
+}
+
+// collection holds the items of one REST resource as decoded JSON objects,
+// identified by the field key.
+type collection struct {
+	key   string
+	items map[string]map[string]interface{}
+}
+
+func newCollection(key string) *collection {
+	return &collection{key: key, items: map[string]map[string]interface{}{}}
+}
+
+// load adds all items of a slice, by encoding them to JSON
+func (c *collection) load(slice interface{}) {
+	b, _ := json.Marshal(slice)
+	var items []map[string]interface{}
+	_ = json.Unmarshal(b, &items)
+	for _, item := range items {
+		c.items[c.keyOf(item)] = item
+	}
+}
+
+// store decodes all items into the slice pointed to by ptr
+func (c *collection) store(ptr interface{}) {
+	b, _ := json.Marshal(c.list())
+	_ = json.Unmarshal(b, ptr)
+}
+
+func (c *collection) keyOf(item map[string]interface{}) string {
+	switch v := item[c.key].(type) {
+	case float64:
+		return strconv.Itoa(int(v))
+	case string:
+		return v
+	}
+	return ""
+}
+
+// list returns all items, ordered by their key
+func (c *collection) list() []map[string]interface{} {
+	keys := make([]string, 0, len(c.items))
+	for k := range c.items {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	list := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, c.items[k])
+	}
+	return list
+}
+
+func (c *collection) get(key string) (map[string]interface{}, bool) {
+	item, ok := c.items[key]
+	return item, ok
+}
+
+// create adds an item. Items identified by an id get the next free id assigned.
+func (c *collection) create(item map[string]interface{}) (map[string]interface{}, bool) {
+	if c.key == "id" {
+		next := 1
+		for k := range c.items {
+			if id, err := strconv.Atoi(k); err == nil && id >= next {
+				next = id + 1
+			}
+		}
+		item["id"] = float64(next)
+	}
+	key := c.keyOf(item)
+	if _, exists := c.items[key]; exists || key == "" {
+		return nil, false
+	}
+	c.items[key] = item
+	return item, true
+}
+
+func (c *collection) remove(key string) {
+	delete(c.items, key)
+}
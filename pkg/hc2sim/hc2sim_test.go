@@ -0,0 +1,303 @@
+package hc2sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fibarohc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+const stateFileName string = "../../test/hc2simState.json"
+
+func newTestClient(t *testing.T, password string) (*Server, *fibarohc2.FibaroHc2, func()) {
+	f, err := os.Open(stateFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	state, err := LoadState(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := New(state)
+	ts := httptest.NewServer(sim)
+
+	var cfg fibarohc2.FibaroConfig
+	fibarohc2.Default(&cfg)
+	cfg.BaseURL = ts.URL
+	cfg.Username = "admin"
+	cfg.Password = password
+	hc2 := &fibarohc2.FibaroHc2{}
+	hc2.SetConfig(cfg)
+	return sim, hc2, ts.Close
+}
+
+func TestServer_Scenes(t *testing.T) {
+	sim, hc2, done := newTestClient(t, "secret")
+	defer done()
+
+	scene, err := hc2.OneScene(55)
+	if err != nil {
+		t.Fatalf("OneScene() error = %v", err)
+	}
+	if scene.Name != "Short" || scene.RoomID != 5 {
+		t.Errorf("OneScene() = %v", scene)
+	}
+	if _, err := hc2.OneScene(56); !errors.Is(err, fibarohc2.ErrNotFound) {
+		t.Errorf("OneScene(56) error = %v, want %v", err, fibarohc2.ErrNotFound)
+	}
+
+	scene.Lua = "fibaro:debug(\"changed\")\n"
+	if _, err := hc2.PutOneScene(scene); err != nil {
+		t.Fatalf("PutOneScene() error = %v", err)
+	}
+
+	newScene := fibarohc2.NewHc2Scene()
+	newScene.Name = "New"
+	newScene.Lua = "fibaro:debug(\"new\")\n"
+	id, err := hc2.CreateScene(newScene)
+	if err != nil {
+		t.Fatalf("CreateScene() error = %v", err)
+	}
+	if id != 56 {
+		t.Errorf("CreateScene() = %v, want 56", id)
+	}
+
+	if err := hc2.Action(55, fibarohc2.Start); err != nil {
+		t.Fatalf("Action() error = %v", err)
+	}
+	msgs, err := hc2.DebugMessages(55)
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("DebugMessages() = %v, %v", msgs, err)
+	}
+
+	state := sim.Snapshot()
+	if len(state.Scenes) != 2 || state.Scenes[0].Lua != scene.Lua || state.Scenes[1].Name != "New" {
+		t.Errorf("Snapshot() scenes = %v", state.Scenes)
+	}
+}
+
+func TestServer_Globals(t *testing.T) {
+	_, hc2, done := newTestClient(t, "secret")
+	defer done()
+
+	g, err := hc2.OneGlobal("TimeOfDay")
+	if err != nil {
+		t.Fatalf("OneGlobal() error = %v", err)
+	}
+	g.Value = "Night"
+	if err := hc2.UpdateGlobal(g); err != nil {
+		t.Fatalf("UpdateGlobal() error = %v", err)
+	}
+	if _, err := hc2.CreateGlobal(fibarohc2.Hc2GlobalVariable{Name: "Away", Value: "0"}); err != nil {
+		t.Fatalf("CreateGlobal() error = %v", err)
+	}
+	all, err := hc2.AllGlobals()
+	if err != nil || len(all) != 2 || all[1].Value != "Night" {
+		t.Errorf("AllGlobals() = %v, %v", all, err)
+	}
+	if err := hc2.DeleteGlobal("Away"); err != nil {
+		t.Fatalf("DeleteGlobal() error = %v", err)
+	}
+	if _, err := hc2.OneGlobal("Away"); !errors.Is(err, fibarohc2.ErrNotFound) {
+		t.Errorf("OneGlobal() error = %v, want %v", err, fibarohc2.ErrNotFound)
+	}
+}
+
+func TestServer_RoomsAndSettings(t *testing.T) {
+	_, hc2, done := newTestClient(t, "secret")
+	defer done()
+
+	room, err := hc2.OneRoom(5)
+	if err != nil || room.SectionID != 4 {
+		t.Errorf("OneRoom() = %v, %v", room, err)
+	}
+	section, err := hc2.OneSection(4)
+	if err != nil || section.Name != "NickyTheo" {
+		t.Errorf("OneSection() = %v, %v", section, err)
+	}
+	info, err := hc2.Info(0)
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	if want := "HC2-Test"; !strings.Contains(info, want) {
+		t.Errorf("Info() = %v, want to contain %v", info, want)
+	}
+}
+
+func TestServer_CallAction(t *testing.T) {
+	_, hc2, done := newTestClient(t, "secret")
+	defer done()
+
+	value := func() int {
+		d, err := hc2.OneDevice(449)
+		if err != nil {
+			t.Fatalf("OneDevice() error = %v", err)
+		}
+		v, _ := d.Properties.Value.Int()
+		return v
+	}
+	if err := hc2.CallAction(449, "turnOn"); err != nil || value() != 1 {
+		t.Errorf("CallAction(turnOn) error = %v, value %d", err, value())
+	}
+	if err := hc2.CallAction(449, "setValue", 42); err != nil || value() != 42 {
+		t.Errorf("CallAction(setValue) error = %v, value %d", err, value())
+	}
+	if err := hc2.CallAction(449, "setValue"); !errors.Is(err, fibarohc2.ErrInvalidAction) {
+		t.Errorf("CallAction(setValue) error = %v, want %v", err, fibarohc2.ErrInvalidAction)
+	}
+	if err := hc2.CallAction(449, "pressButton", 1); !errors.Is(err, fibarohc2.ErrInvalidAction) {
+		t.Errorf("CallAction(pressButton) error = %v, want %v", err, fibarohc2.ErrInvalidAction)
+	}
+	if err := hc2.CallAction(450, "turnOn"); !errors.Is(err, fibarohc2.ErrNotFound) {
+		t.Errorf("CallAction(450) error = %v, want %v", err, fibarohc2.ErrNotFound)
+	}
+}
+
+func TestServer_RefreshStates(t *testing.T) {
+	sim, hc2, done := newTestClient(t, "secret")
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := hc2.RefreshStates(ctx, 0)
+	if err != nil {
+		t.Fatalf("RefreshStates() error = %v", err)
+	}
+	if err := hc2.CallAction(449, "turnOn"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hc2.UpdateGlobal(fibarohc2.Hc2GlobalVariable{Name: "TimeOfDay", Value: "Night", IsEnum: true, EnumValues: []string{"Morning", "Night"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hc2.Action(55, fibarohc2.Start); err != nil {
+		t.Fatal(err)
+	}
+	sim.AddEvent("CentralSceneEvent", map[string]interface{}{"deviceId": 449, "keyId": 2, "keyAttribute": "Pressed"})
+
+	want := []string{"device 449 value 1", "global TimeOfDay Night", "sceneStarted 55", "centralScene 449 2 Pressed"}
+	var got []string
+	for len(got) < len(want) {
+		select {
+		case e := <-events:
+			v, _ := e.Value.String()
+			switch e.Type {
+			case fibarohc2.EventDeviceProperty:
+				got = append(got, fmt.Sprintf("%s %d %s %s", e.Type, e.DeviceID, e.Property, v))
+			case fibarohc2.EventGlobal:
+				got = append(got, fmt.Sprintf("%s %s %s", e.Type, e.Global, v))
+			case fibarohc2.EventSceneStarted:
+				got = append(got, fmt.Sprintf("%s %d", e.Type, e.SceneID))
+			case fibarohc2.EventCentralScene:
+				got = append(got, fmt.Sprintf("%s %d %d %s", e.Type, e.DeviceID, e.KeyID, e.KeyAttribute))
+			default:
+				got = append(got, string(e.Type))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("RefreshStates() events = %v, want %v", got, want)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RefreshStates() events = %v, want %v", got, want)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestServer_Unauthorized(t *testing.T) {
+	_, hc2, done := newTestClient(t, "wrong")
+	defer done()
+
+	if _, err := hc2.AllScenes(); !errors.Is(err, fibarohc2.ErrUnauthorized) {
+		t.Errorf("AllScenes() error = %v, want %v", err, fibarohc2.ErrUnauthorized)
+	}
+}
+
+func TestServer_BackupRestore(t *testing.T) {
+	_, hc2, done := newTestClient(t, "secret")
+	defer done()
+
+	dir, err := ioutil.TempDir("", "hc2backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := hc2.Backup(dir); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	first, err := ioutil.ReadFile(filepath.Join(dir, "rooms.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hc2.Backup(dir); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	second, _ := ioutil.ReadFile(filepath.Join(dir, "rooms.json"))
+	if string(first) != string(second) {
+		t.Errorf("Backup() is not deterministic:\n%s\n%s", first, second)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scenes", "55.lua")); err != nil {
+		t.Errorf("Backup() did not write scene 55: %v", err)
+	}
+
+	// restore into a fresh system, where all ids change
+	fresh := New(State{Users: []User{{ID: 2, Username: "admin", Password: "secret", Type: "superuser"}}})
+	ts := httptest.NewServer(fresh)
+	defer ts.Close()
+	cfg := *hc2.Config()
+	cfg.BaseURL = ts.URL
+	target := &fibarohc2.FibaroHc2{}
+	target.SetConfig(cfg)
+
+	actions, err := target.Restore(dir)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if len(actions) != 4 {
+		t.Fatalf("Restore() = %v, want 4 actions", actions)
+	}
+	for _, a := range actions {
+		if !a.Created {
+			t.Errorf("Restore() did not create %v", a)
+		}
+	}
+
+	room, err := target.OneRoom(actions[1].NewID)
+	if err != nil || room.Name != "Schlafzimmer" || room.SectionID != actions[0].NewID {
+		t.Errorf("restored room = %v, %v", room, err)
+	}
+	scene, err := target.OneScene(actions[3].NewID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scene.RoomID != room.RoomID {
+		t.Errorf("restored scene in room %d, want %d", scene.RoomID, room.RoomID)
+	}
+	header := fibarohc2.Hc2Scene{}
+	if err := header.Parse([]byte(scene.Lua)); err != nil || header.SceneID != scene.SceneID || header.RoomID != room.RoomID {
+		t.Errorf("restored header = %v, %v", header, err)
+	}
+
+	// a second restore reuses everything
+	actions, err = target.Restore(dir)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	for _, a := range actions {
+		if a.Created {
+			t.Errorf("second Restore() created %v", a)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+// FibaroHc3 represents a Fibaro Home Center 3 or Yubii Home system. Scenes are
+// converted from and to Hc2Scene, all other calls are shared with FibaroHc2.
+type FibaroHc3 struct {
+	FibaroHc2
+}
+
+// Hc3Scene represents a scene of the HC3 system. Can be encoded as JSON.
+type Hc3Scene struct {
+	ID                  int    `json:"id,omitempty"`
+	Name                string `json:"name,omitempty"`
+	Type                string `json:"type,omitempty"` // lua or json (block scene)
+	Mode                string `json:"mode,omitempty"` // automatic or manual
+	MaxRunningInstances int    `json:"maxRunningInstances,omitempty"`
+	Hidden              bool   `json:"hidden"`
+	Enabled             bool   `json:"enabled"`
+	RoomID              int    `json:"roomId"`
+	Content             string `json:"content,omitempty"` // JSON encoded Hc3SceneContent
+}
+
+// Hc3SceneContent is the Lua code of an HC3 scene, split into conditions and actions
+type Hc3SceneContent struct {
+	Conditions string `json:"conditions"`
+	Actions    string `json:"actions"`
+}
+
+// The modes of an HC3 scene
+const (
+	Hc3Automatic = "automatic"
+	Hc3Manual    = "manual"
+)
+
+// hc3ConditionsTag marks the long comment holding the conditions in the Lua of a
+// converted HC3 scene.
+const hc3ConditionsTag = "HC3_CONDITIONS"
+
+// Hc2Scene converts the HC3 scene. The conditions and actions are joined into one
+// Lua script, see JoinHc3Lua.
+func (s Hc3Scene) Hc2Scene() (Hc2Scene, error) {
+	scene := Hc2Scene{
+		SceneID:             s.ID,
+		Name:                s.Name,
+		RoomID:              s.RoomID,
+		MaxRunningInstances: s.MaxRunningInstances,
+		Type:                s.Type,
+		IsLua:               s.Type == "lua",
+		Visible:             !s.Hidden,
+	}
+	switch {
+	case !s.Enabled:
+		scene.RunConfig = Disabled
+	case s.Mode == Hc3Manual:
+		scene.RunConfig = ManualOnly
+	default:
+		scene.RunConfig = TriggerAndManual
+	}
+	if !scene.IsLua {
+		return scene, nil
+	}
+	scene.Type = "com.fibaro.luaScene"
+
+	var c Hc3SceneContent
+	if s.Content != "" {
+		if err := json.Unmarshal([]byte(s.Content), &c); err != nil {
+			return scene, fmt.Errorf("%w: content of scene %d: %v", ErrInvalidScene, s.ID, err)
+		}
+	}
+	scene.Lua = JoinHc3Lua(c.Conditions, c.Actions)
+	return scene, nil
+}
+
+// NewHc3Scene converts an Hc2Scene into an HC3 Lua scene. The Lua code is split
+// into conditions and actions, see SplitHc3Lua.
+func NewHc3Scene(scene Hc2Scene) (Hc3Scene, error) {
+	s := Hc3Scene{
+		ID:                  scene.SceneID,
+		Name:                scene.Name,
+		Type:                "lua",
+		Mode:                Hc3Automatic,
+		MaxRunningInstances: scene.MaxRunningInstances,
+		Hidden:              !scene.Visible,
+		Enabled:             scene.RunConfig != Disabled,
+		RoomID:              scene.RoomID,
+	}
+	if scene.RunConfig == ManualOnly {
+		s.Mode = Hc3Manual
+	}
+	if s.ID < 0 {
+		s.ID = 0
+	}
+
+	var c Hc3SceneContent
+	c.Conditions, c.Actions = SplitHc3Lua(scene.Lua)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return s, err
+	}
+	s.Content = string(b)
+	return s, nil
+}
+
+// JoinHc3Lua joins the conditions and the actions of an HC3 scene into one Lua
+// script. The conditions are put into a long comment tagged HC3_CONDITIONS in
+// front of the actions.
+func JoinHc3Lua(conditions, actions string) string {
+	level := 0
+	for strings.Contains(conditions, "]"+strings.Repeat("=", level)+"]") {
+		level++
+	}
+	eq := strings.Repeat("=", level)
+	return "--[" + eq + "[ " + hc3ConditionsTag + "\n" + conditions + "\n]" + eq + "]\n" + actions
+}
+
+// SplitHc3Lua splits a Lua script as created by JoinHc3Lua into conditions and
+// actions. A script without conditions block is returned as actions.
+func SplitHc3Lua(lua string) (conditions, actions string) {
+	b := []byte(lua)
+	if !strings.HasPrefix(lua, "--[") {
+		return "", lua
+	}
+	level := longBracketLevel(b[2:])
+	if level < 0 {
+		return "", lua
+	}
+	opening := "--[" + strings.Repeat("=", level) + "[ " + hc3ConditionsTag + "\n"
+	closing := "\n]" + strings.Repeat("=", level) + "]"
+	end := longBracketEnd(b, 2)
+	if !strings.HasPrefix(lua, opening) || !strings.HasSuffix(lua[:end], closing) || end-len(closing) < len(opening) {
+		return "", lua
+	}
+	return lua[len(opening) : end-len(closing)], strings.TrimPrefix(lua[end:], "\n")
+}
+
+// AllScenes downloads and returns all scenes of the HC3 system.
+func (f *FibaroHc3) AllScenes() ([]Hc2Scene, error) {
+	var s []Hc3Scene
+	if err := f.getJSON("/scenes", &s); err != nil {
+		return nil, err
+	}
+	scenes := make([]Hc2Scene, 0, len(s))
+	for _, h := range s {
+		scene, err := h.Hc2Scene()
+		if err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, nil
+}
+
+// OneScene downloads and returns one scene as identified by the sceneID
+func (f *FibaroHc3) OneScene(sceneID int) (Hc2Scene, error) {
+	var s Hc3Scene
+	if err := f.getJSON("/scenes/"+strconv.Itoa(sceneID), &s); err != nil {
+		return Hc2Scene{}, err
+	}
+	return s.Hc2Scene()
+}
+
+// PutOneScene uploads a scene to the HC3 system and returns the response, or any error encountered.
+// If the scene does not exist in the HC3 the returned error matches ErrNotFound.
+func (f *FibaroHc3) PutOneScene(scene Hc2Scene) (resp *resty.Response, err error) {
+	if !scene.SanityCheck() {
+		return nil, fmt.Errorf("%w: sanity check failed for scene %d", ErrInvalidScene, scene.SceneID)
+	}
+	s, err := NewHc3Scene(scene)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := "/scenes/" + strconv.Itoa(scene.SceneID)
+	resp, err = requestPut(f.cfg, cmd, b)
+	return resp, checkResponse(http.MethodPut, cmd, resp, err)
+}
+
+// CreateScene creates a new scene in the HC3 system. As with FibaroHc2.CreateScene
+// the header in the lua field is updated with the allocated SceneID, which is returned.
+func (f *FibaroHc3) CreateScene(scene Hc2Scene) (newSceneID int, err error) {
+	s, err := NewHc3Scene(scene)
+	if err != nil {
+		return -1, err
+	}
+	s.ID = 0
+	b, err := json.Marshal(s)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := requestPost(f.cfg, "/scenes", b)
+	if err := checkResponse(http.MethodPost, "/scenes", resp, err); err != nil {
+		return -1, err
+	}
+	var fresh Hc3Scene
+	if err := json.Unmarshal(resp.Body(), &fresh); err != nil {
+		return -1, decodeError(http.MethodPost, "/scenes", resp, err)
+	}
+
+	scene.SceneID = fresh.ID
+	scene.Type = "com.fibaro.luaScene"
+	scene.UpdateLuaHeader()
+	if _, err := f.PutOneScene(scene); err != nil {
+		return -1, fmt.Errorf("updating intermediate scene %d: %w", scene.SceneID, err)
+	}
+	return scene.SceneID, nil
+}
+
+// Action starts an action on a given sceneID. Start and stop execute or kill the
+// scene, enable and disable change the enabled flag of the scene.
+func (f *FibaroHc3) Action(sceneID int, c SceneActionCommand) error {
+	cmd := "/scenes/" + strconv.Itoa(sceneID)
+	var resp *resty.Response
+	var err error
+	method := http.MethodPost
+	switch c {
+	case Start:
+		cmd += "/execute"
+		resp, err = requestPost(f.cfg, cmd, []byte("{}"))
+	case Stop:
+		cmd += "/kill"
+		resp, err = requestPost(f.cfg, cmd, []byte("{}"))
+	case Enable, Disable:
+		method = http.MethodPut
+		resp, err = requestPut(f.cfg, cmd, []byte(`{"enabled":`+strconv.FormatBool(c == Enable)+`}`))
+	default:
+		return fmt.Errorf("unsupported action %v", c)
+	}
+	return checkResponse(method, cmd, resp, err)
+}
+
+// CallAction calls action on the device deviceID with args. The action is checked
+// against the actions of the device first, as by FibaroHc2.
+func (f *FibaroHc3) CallAction(deviceID int, action string, args ...interface{}) error {
+	device, err := f.OneDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	if err := device.ValidateAction(action, len(args)); err != nil {
+		return err
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	b, err := json.Marshal(struct {
+		Args []interface{} `json:"args"`
+	}{args})
+	if err != nil {
+		return err
+	}
+	cmd := "/devices/" + strconv.Itoa(deviceID) + "/action/" + url.PathEscape(action)
+	resp, err := requestPost(f.cfg, cmd, b)
+	return checkResponse(http.MethodPost, cmd, resp, err)
+}
+
+type hc3DebugMessage struct {
+	ID        int    `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Tag       string `json:"tag"`
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+}
+
+// DebugMessages downloads and returns the debug messages for a given sceneID,
+// i.e. the messages tagged with SCENE<sceneID>, oldest first.
+func (f *FibaroHc3) DebugMessages(sceneID int) ([]Hc2DebugMessage, error) {
+	var s struct {
+		Messages []hc3DebugMessage `json:"messages"`
+	}
+	if err := f.getJSON("/debugMessages", &s); err != nil {
+		return nil, err
+	}
+	tag := "SCENE" + strconv.Itoa(sceneID)
+	dm := []Hc2DebugMessage{}
+	for _, m := range s.Messages {
+		if strings.EqualFold(m.Tag, tag) {
+			dm = append(dm, Hc2DebugMessage{ID: m.ID, Timestamp: m.Timestamp, Type: m.Type, Txt: m.Message})
+		}
+	}
+	sort.SliceStable(dm, func(i, j int) bool { return dm[i].Timestamp < dm[j].Timestamp })
+	return dm, nil
+}
@@ -0,0 +1,218 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"testing/quick"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestNewController(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	fixture, _ := ioutil.ReadFile("../test/hc3Info.json")
+	httpmock.RegisterResponder(http.MethodGet, "http://192.10.66.55/api/settings/info", httpmock.NewBytesResponder(200, fixture))
+
+	c, err := NewController(*cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*FibaroHc3); !ok {
+		t.Errorf("NewController() = %T, want *FibaroHc3", c)
+	}
+	AssertEqual(t, c.Config().Platform, PlatformHC3)
+
+	cfg.Platform = "hc2"
+	c, err = NewController(*cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*FibaroHc2); !ok {
+		t.Errorf("NewController() = %T, want *FibaroHc2", c)
+	}
+	AssertEqual(t, httpmock.GetTotalCallCount(), 1)
+
+	cfg.Platform = "HC4"
+	if _, err := NewController(*cfg); err == nil {
+		t.Error("NewController() of unknown platform should fail")
+	}
+}
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		info Hc2Info
+		want string
+	}{
+		{Hc2Info{SerialNumber: "HC2-012345", SoftVersion: "4.600"}, PlatformHC2},
+		{Hc2Info{}, PlatformHC2},
+		{Hc2Info{SerialNumber: "HC3-00012345", SoftVersion: "5.050.13"}, PlatformHC3},
+		{Hc2Info{Platform: "HC3L"}, PlatformHC3},
+		{Hc2Info{SerialNumber: "YH-00001234"}, PlatformHC3},
+		{Hc2Info{SoftVersion: "5.030.45"}, PlatformHC3},
+	}
+	for _, tt := range tests {
+		AssertEqual(t, DetectPlatform(tt.info), tt.want)
+	}
+}
+
+func TestFibaroHc3_OneScene(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	fixture, _ := ioutil.ReadFile("../test/hc3Scene12.json")
+	httpmock.RegisterResponder(http.MethodGet, "http://192.10.66.55/api/scenes/12", httpmock.NewBytesResponder(200, fixture))
+	httpmock.RegisterResponder(http.MethodGet, "http://192.10.66.55/api/scenes/13", httpmock.NewStringResponder(404, ""))
+
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	got, err := f.OneScene(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Hc2Scene{
+		SceneID:             12,
+		Name:                "Evening lights",
+		RoomID:              219,
+		RunConfig:           TriggerAndManual,
+		MaxRunningInstances: 2,
+		Lua:                 "--[[ HC3_CONDITIONS\n{\n  operator = \"all\",\n  conditions = {}\n}\n]]\nfibaro.call(31, \"turnOn\")\n",
+		Type:                "com.fibaro.luaScene",
+		IsLua:               true,
+		Visible:             true,
+	}
+	AssertEqual(t, got, want)
+
+	if _, err := f.OneScene(13); !errors.Is(err, ErrNotFound) {
+		t.Errorf("OneScene() of missing scene error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFibaroHc3_PutOneScene(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	var put Hc3Scene
+	httpmock.RegisterResponder(http.MethodPut, "http://192.10.66.55/api/scenes/12",
+		func(req *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(req.Body).Decode(&put); err != nil {
+				return nil, err
+			}
+			return httpmock.NewStringResponse(204, ""), nil
+		})
+
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	scene := Hc2Scene{
+		SceneID:   12,
+		Name:      "Evening lights",
+		RoomID:    219,
+		RunConfig: ManualOnly,
+		Lua:       JoinHc3Lua("{}", "print(1)\n"),
+		Visible:   false,
+	}
+	if _, err := f.PutOneScene(scene); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, put.Mode, Hc3Manual)
+	AssertEqual(t, put.Hidden, true)
+	AssertEqual(t, put.Enabled, true)
+	AssertEqual(t, put.Type, "lua")
+
+	var c Hc3SceneContent
+	if err := json.Unmarshal([]byte(put.Content), &c); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, c, Hc3SceneContent{Conditions: "{}", Actions: "print(1)\n"})
+}
+
+func TestFibaroHc3_Action(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodPost, "http://192.10.66.55/api/scenes/12/execute", httpmock.NewStringResponder(202, ""))
+	httpmock.RegisterResponder(http.MethodPost, "http://192.10.66.55/api/scenes/12/kill", httpmock.NewStringResponder(202, ""))
+	httpmock.RegisterResponder(http.MethodPut, "http://192.10.66.55/api/scenes/12", httpmock.NewStringResponder(204, ""))
+
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	for _, c := range []SceneActionCommand{Start, Stop, Enable, Disable} {
+		if err := f.Action(12, c); err != nil {
+			t.Errorf("Action(%v) error = %v", c, err)
+		}
+	}
+	info := httpmock.GetCallCountInfo()
+	AssertEqual(t, info["POST http://192.10.66.55/api/scenes/12/execute"], 1)
+	AssertEqual(t, info["POST http://192.10.66.55/api/scenes/12/kill"], 1)
+	AssertEqual(t, info["PUT http://192.10.66.55/api/scenes/12"], 2)
+}
+
+func TestFibaroHc3_CallAction(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder(http.MethodGet, "http://192.10.66.55/api/devices/30",
+		httpmock.NewStringResponder(200, `{"id":30,"type":"com.fibaro.multilevelSwitch","actions":{"turnOn":0,"setValue":1}}`))
+	var body string
+	httpmock.RegisterResponder(http.MethodPost, "http://192.10.66.55/api/devices/30/action/setValue",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			body = string(b)
+			return httpmock.NewStringResponse(202, ""), nil
+		})
+
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	if err := f.CallAction(30, "setValue", 50); err != nil {
+		t.Fatalf("CallAction() error = %v", err)
+	}
+	AssertEqual(t, body, `{"args":[50]}`)
+	if err := f.CallAction(30, "turnOff"); !errors.Is(err, ErrInvalidAction) {
+		t.Errorf("CallAction(turnOff) error = %v, want %v", err, ErrInvalidAction)
+	}
+	AssertEqual(t, httpmock.GetCallCountInfo()["POST http://192.10.66.55/api/devices/30/action/setValue"], 1)
+}
+
+func TestFibaroHc3_DebugMessages(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	fixture, _ := ioutil.ReadFile("../test/hc3DebugMessages.json")
+	httpmock.RegisterResponder(http.MethodGet, "http://192.10.66.55/api/debugMessages", httpmock.NewBytesResponder(200, fixture))
+
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	dm, err := f.DebugMessages(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dm) != 2 {
+		t.Fatalf("DebugMessages() = %v, want 2 messages", dm)
+	}
+	AssertEqual(t, dm[0], Hc2DebugMessage{ID: 1, Timestamp: 1600000100, Type: "error", Txt: "first"})
+	AssertEqual(t, dm[1].Txt, "second")
+}
+
+func TestSplitHc3Lua(t *testing.T) {
+	roundTrip := func(conditions, actions string) bool {
+		c, a := SplitHc3Lua(JoinHc3Lua(conditions, actions))
+		return c == conditions && a == actions
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+	for _, c := range []string{"", "]]", "a]=]b]]", "\n"} {
+		if !roundTrip(c, "print(1)") {
+			t.Errorf("SplitHc3Lua(JoinHc3Lua(%q)) failed", c)
+		}
+	}
+
+	c, a := SplitHc3Lua("--[[ other comment ]]\nprint(1)")
+	AssertEqual(t, c, "")
+	AssertEqual(t, a, "--[[ other comment ]]\nprint(1)")
+}
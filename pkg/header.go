@@ -0,0 +1,156 @@
+package fibarohc2
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HeaderError reports a malformed FIBARO_GIT_HOOK header. Line is the line
+// number in the parsed input, starting at 1.
+type HeaderError struct {
+	Line int
+	Msg  string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("FIBARO_GIT_HOOK header, line %d: %s", e.Line, e.Msg)
+}
+
+// Unwrap returns ErrInvalidHeader, so that errors.Is can be used on a HeaderError.
+func (e *HeaderError) Unwrap() error {
+	return ErrInvalidHeader
+}
+
+// headerLine is a @key=value line of a FIBARO_GIT_HOOK header
+type headerLine struct {
+	line  int
+	key   string
+	value string
+}
+
+var headerKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// parseHeader returns the @key=value lines of the first FIBARO_GIT_HOOK header in
+// input. Text following FIBARO_GIT_HOOK on the opening line is ignored, as are empty
+// lines. found is false if input has no header.
+func parseHeader(input []byte) (lines []headerLine, found bool, err error) {
+	i := m["gitHookComment"].FindSubmatchIndex(input)
+	if i == nil {
+		return nil, false, nil
+	}
+	first := 1 + bytes.Count(input[:i[2]], []byte("\n"))
+	for n, l := range strings.Split(string(input[i[2]:i[3]]), "\n") {
+		if n == 0 {
+			// remainder of the opening line
+			continue
+		}
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		eq := strings.Index(l, "=")
+		if !strings.HasPrefix(l, "@") || eq == -1 {
+			return nil, true, &HeaderError{Line: first + n, Msg: fmt.Sprintf("expected @key=value, got %q", l)}
+		}
+		key := l[1:eq]
+		if !headerKey.MatchString(key) {
+			return nil, true, &HeaderError{Line: first + n, Msg: fmt.Sprintf("invalid key %q", key)}
+		}
+		lines = append(lines, headerLine{line: first + n, key: key, value: l[eq+1:]})
+	}
+	return lines, true, nil
+}
+
+// headerQuote quotes s as a Go string literal. ']' is escaped, so that the value
+// can not terminate the Lua comment.
+func headerQuote(s string) string {
+	return strings.Replace(strconv.Quote(s), "]", `\x5d`, -1)
+}
+
+var bareHeaderValue = regexp.MustCompile(`^[A-Za-z0-9_.]*$`)
+
+// headerString quotes s unless it can be written as bare value
+func headerString(s string) string {
+	if bareHeaderValue.MatchString(s) {
+		return s
+	}
+	return headerQuote(s)
+}
+
+// parseHeaderString accepts quoted and bare values. Values quoted but not escaped, as
+// written by earlier versions for names containing " or \, are taken as they are.
+func parseHeaderString(v string) (string, error) {
+	if strings.HasPrefix(v, `"`) {
+		s, err := strconv.Unquote(v)
+		if err == nil {
+			return s, nil
+		}
+		if len(v) >= 2 && strings.HasSuffix(v, `"`) {
+			return v[1 : len(v)-1], nil
+		}
+		return "", fmt.Errorf("invalid quoted string %s", v)
+	}
+	return v, nil
+}
+
+func parseHeaderInt(v string) (int, error) {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", v)
+	}
+	return i, nil
+}
+
+func parseHeaderBool(v string) (bool, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", v)
+	}
+	return b, nil
+}
+
+// sceneHeaderField is a field of Hc2Scene represented in the FIBARO_GIT_HOOK header
+type sceneHeaderField struct {
+	key    string
+	format func(s *Hc2Scene) string
+	parse  func(s *Hc2Scene, v string) error
+}
+
+// sceneHeaderFields lists the header fields in the order written by ToComment
+var sceneHeaderFields = []sceneHeaderField{
+	{"sceneID",
+		func(s *Hc2Scene) string { return strconv.Itoa(s.SceneID) },
+		func(s *Hc2Scene, v string) (err error) { s.SceneID, err = parseHeaderInt(v); return }},
+	{"name",
+		func(s *Hc2Scene) string { return headerQuote(s.Name) },
+		func(s *Hc2Scene, v string) (err error) { s.Name, err = parseHeaderString(v); return }},
+	{"roomID",
+		func(s *Hc2Scene) string { return strconv.Itoa(s.RoomID) },
+		func(s *Hc2Scene, v string) (err error) { s.RoomID, err = parseHeaderInt(v); return }},
+	{"autostart",
+		func(s *Hc2Scene) string { return strconv.FormatBool(s.Autostart) },
+		func(s *Hc2Scene, v string) (err error) { s.Autostart, err = parseHeaderBool(v); return }},
+	{"runConfig",
+		func(s *Hc2Scene) string { return headerString(s.RunConfig) },
+		func(s *Hc2Scene, v string) (err error) { s.RunConfig, err = parseHeaderString(v); return }},
+	{"maxRunningInstances",
+		func(s *Hc2Scene) string { return strconv.Itoa(s.MaxRunningInstances) },
+		func(s *Hc2Scene, v string) (err error) { s.MaxRunningInstances, err = parseHeaderInt(v); return }},
+	{"type",
+		func(s *Hc2Scene) string { return headerQuote(s.Type) },
+		func(s *Hc2Scene, v string) (err error) { s.Type, err = parseHeaderString(v); return }},
+	{"isLua",
+		func(s *Hc2Scene) string { return strconv.FormatBool(s.IsLua) },
+		func(s *Hc2Scene, v string) (err error) { s.IsLua, err = parseHeaderBool(v); return }},
+	{"visible",
+		func(s *Hc2Scene) string { return strconv.FormatBool(s.Visible) },
+		func(s *Hc2Scene, v string) (err error) { s.Visible, err = parseHeaderBool(v); return }},
+}
+
+// legacyHeaderKeys maps keys written by earlier versions to the current keys
+var legacyHeaderKeys = map[string]string{
+	"maxRunningInstance": "maxRunningInstances",
+}
@@ -23,7 +23,10 @@ func AssertHc2SceneEqual(t *testing.T, got Hc2Scene, want Hc2Scene) {
 	AssertEqual(t, got.RoomID, want.RoomID)
 	AssertEqual(t, got.RunConfig, want.RunConfig)
 	AssertEqual(t, got.MaxRunningInstances, want.MaxRunningInstances)
-	_, luaFileContents := readFile(want.Lua)
+	_, luaFileContents, err := readFile(want.Lua)
+	if err != nil {
+		t.Fatal(err)
+	}
 	AssertEqual(t, got.Lua, string(luaFileContents))
 	AssertEqual(t, got.Type, want.Type)
 	AssertEqual(t, got.Autostart, want.Autostart)
@@ -0,0 +1,256 @@
+package fibarohc2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Layouts of the files written by hc2DownloadScene
+const (
+	DefaultSceneLayout string = "{{.Section}}/{{.Room}}/{{.Name}}.lua"
+	FlatSceneLayout    string = "{{.ID}}-{{.Name}}.lua"
+)
+
+// SceneFileData is the data available in a scene layout template. All names are
+// sanitised by SanitizeFileName. Section and Room are empty for scenes not assigned
+// to a room, or rooms not assigned to a section.
+type SceneFileData struct {
+	ID        int
+	Name      string
+	RoomID    int
+	Room      string
+	SectionID int
+	Section   string
+}
+
+// SceneLayout renders the path of the file a scene is written to from a template
+type SceneLayout struct {
+	t     *template.Template
+	ascii bool
+}
+
+// NewSceneLayout parses the layout template, e.g. {{.Section}}/{{.Room}}/{{.ID}}-{{.Name}}.lua.
+// Paths are separated by /, and .lua is appended if the layout does not end with it.
+// If ascii is set, names are transliterated to ASCII.
+func NewSceneLayout(layout string, ascii bool) (*SceneLayout, error) {
+	if !strings.HasSuffix(layout, ".lua") {
+		layout += ".lua"
+	}
+	t, err := template.New("layout").Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout: %w", err)
+	}
+	l := &SceneLayout{t: t, ascii: ascii}
+	if _, err := l.Path(SceneFileData{Name: "test"}); err != nil {
+		return nil, fmt.Errorf("invalid layout: %w", err)
+	}
+	return l, nil
+}
+
+// Data returns the template data for a scene in room and section
+func (l *SceneLayout) Data(scene Hc2Scene, room Hc2Room, section Hc2Section) SceneFileData {
+	name := func(s string) string {
+		if l.ascii {
+			s = transliterate(s)
+		}
+		return SanitizeFileName(s)
+	}
+	return SceneFileData{
+		ID:        scene.SceneID,
+		Name:      name(scene.Name),
+		RoomID:    room.RoomID,
+		Room:      name(room.Name),
+		SectionID: section.SectionID,
+		Section:   name(section.Name),
+	}
+}
+
+// Path renders the relative path for data. Empty path elements, e.g. of a scene
+// without room, are left out.
+func (l *SceneLayout) Path(data SceneFileData) (string, error) {
+	var b strings.Builder
+	if err := l.t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	var elements []string
+	for _, e := range strings.Split(b.String(), "/") {
+		if e == "" || e == "." {
+			continue
+		}
+		if e == ".." {
+			return "", fmt.Errorf("layout must not leave the download directory: %s", b.String())
+		}
+		elements = append(elements, e)
+	}
+	p := path.Join(elements...)
+	if p == "" || p == ".lua" {
+		return "", fmt.Errorf("layout results in an empty file name for scene %d", data.ID)
+	}
+	return filepath.FromSlash(p), nil
+}
+
+// SanitizeFileName makes a scene, room or section name usable as a file or directory
+// name on all common filesystems. Path separators, characters reserved on Windows,
+// control characters and invalid UTF-8 are replaced by _, as are leading dots, which
+// would hide the file. Trailing dots and spaces are removed.
+func SanitizeFileName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == utf8.RuneError:
+			r = '_'
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			r = '_'
+		case !unicode.IsPrint(r):
+			r = '_'
+		case r == '.' && i == 0:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimRight(strings.TrimSpace(b.String()), ". ")
+}
+
+var transliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'ú': "u", 'û': "u", 'ý': "y", 'ÿ': "y",
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Å': "A", 'Æ': "Ae", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ø': "O", 'Œ': "Oe",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ý': "Y",
+}
+
+// transliterate replaces common accented latin characters by their ASCII
+// equivalent, and all other non ASCII characters by _
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch t, ok := transliterations[r]; {
+		case ok:
+			b.WriteString(t)
+		case r > unicode.MaxASCII:
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// SceneFiles decides which file a downloaded scene is written to. Existing files are
+// recognised as belonging to a scene by the @sceneID of their FIBARO_GIT_HOOK header.
+//
+// By default the file given by the layout is overwritten if it belongs to the same
+// scene. If it belongs to another scene, or has no header, the id of the scene is
+// appended to the file name instead. The same happens if the path differs only in
+// case from the path of another scene written before, so that the files can be
+// used on case-insensitive filesystems. If the file with the id appended belongs to
+// another scene too, Path fails with ErrFileConflict.
+type SceneFiles struct {
+	BaseDir      string
+	Layout       *SceneLayout
+	Overwrite    bool // overwrite existing files even if they belong to another scene
+	SkipExisting bool // do not overwrite existing files of the same scene
+	ByID         bool // write a scene to the file below BaseDir that has its @sceneID, wherever it is
+
+	claimed map[string]int // lower cased paths written to, and their sceneID
+	index   map[int]string // sceneID of all files below BaseDir, for ByID
+}
+
+// Path returns the file the scene is to be written to, or skip if the file exists
+// and is not to be overwritten.
+func (s *SceneFiles) Path(scene Hc2Scene, room Hc2Room, section Hc2Section) (file string, skip bool, err error) {
+	if s.claimed == nil {
+		s.claimed = map[string]int{}
+	}
+	if s.ByID && s.index == nil {
+		s.index = indexSceneFiles(s.BaseDir)
+	}
+
+	if p, ok := s.index[scene.SceneID]; ok {
+		return s.claim(p, scene.SceneID), s.SkipExisting, nil
+	}
+
+	data := s.Layout.Data(scene, room, section)
+	rel, err := s.Layout.Path(data)
+	if err != nil {
+		return "", false, err
+	}
+	file = filepath.Join(s.BaseDir, rel)
+
+	owner, exists := s.owner(file)
+	switch {
+	case !exists:
+	case owner == scene.SceneID:
+		return s.claim(file, scene.SceneID), s.SkipExisting, nil
+	case s.Overwrite:
+	default:
+		file = strings.TrimSuffix(file, ".lua") + "_" + strconv.Itoa(scene.SceneID) + ".lua"
+		owner, exists = s.owner(file)
+		switch {
+		case !exists:
+		case owner == scene.SceneID:
+			return s.claim(file, scene.SceneID), s.SkipExisting, nil
+		default:
+			return "", false, fmt.Errorf("%w: %s", ErrFileConflict, file)
+		}
+	}
+	return s.claim(file, scene.SceneID), false, nil
+}
+
+// owner returns the @sceneID of file, or of the scene it has been claimed by, and
+// whether the file exists or has been claimed
+func (s *SceneFiles) owner(file string) (int, bool) {
+	if id, ok := s.claimed[strings.ToLower(file)]; ok {
+		return id, true
+	}
+	return sceneIDOfFile(file)
+}
+
+func (s *SceneFiles) claim(file string, sceneID int) string {
+	s.claimed[strings.ToLower(file)] = sceneID
+	return file
+}
+
+// sceneIDOfFile returns the @sceneID of the file, -1 if it has no header, and
+// whether the file exists
+func sceneIDOfFile(file string) (int, bool) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return -1, false
+	}
+	scene := NewHc2Scene()
+	if err := scene.Parse(b); err != nil {
+		return -1, true
+	}
+	return scene.SceneID, true
+}
+
+// indexSceneFiles maps the @sceneID of all lua-files below dir to their path.
+// Files without or with an invalid header are ignored.
+func indexSceneFiles(dir string) map[int]string {
+	index := map[int]string{}
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(p) != ".lua" {
+			return nil
+		}
+		if id, _ := sceneIDOfFile(p); id != -1 {
+			if _, dup := index[id]; !dup {
+				index[id] = p
+			}
+		}
+		return nil
+	})
+	return index
+}
@@ -0,0 +1,144 @@
+package fibarohc2
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Licht an/aus", "Licht an_aus"},
+		{`C:\Temp`, "C__Temp"},
+		{"Was? <Alles>|*", "Was_ _Alles___"},
+		{"Schlafzimmer", "Schlafzimmer"},
+		{"Küche", "Küche"},
+		{".hidden", "_hidden"},
+		{" trailing. ", "trailing"},
+		{"tab\there", "tab_here"},
+		{"bad\xffutf8", "bad_utf8"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSceneLayout_Path(t *testing.T) {
+	scene := Hc2Scene{SceneID: 12, Name: "Licht an/aus"}
+	room := Hc2Room{RoomID: 3, Name: "Küche", SectionID: 1}
+	section := Hc2Section{SectionID: 1, Name: "EG"}
+
+	tests := []struct {
+		layout string
+		ascii  bool
+		room   Hc2Room
+		want   string
+	}{
+		{DefaultSceneLayout, false, room, "EG/Küche/Licht an_aus.lua"},
+		{DefaultSceneLayout, false, Hc2Room{}, "Licht an_aus.lua"},
+		{DefaultSceneLayout, true, room, "EG/Kueche/Licht an_aus.lua"},
+		{FlatSceneLayout, false, room, "12-Licht an_aus.lua"},
+		{"{{.SectionID}}/{{.RoomID}}/{{.ID}}", false, room, "1/3/12.lua"},
+	}
+	for _, tt := range tests {
+		l, err := NewSceneLayout(tt.layout, tt.ascii)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s Hc2Section
+		if tt.room.SectionID != 0 {
+			s = section
+		}
+		got, err := l.Path(l.Data(scene, tt.room, s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s: Path() = %q, want %q", tt.layout, got, tt.want)
+		}
+	}
+
+	for _, layout := range []string{"{{.Unknown}}", "{{.Name", "../{{.Name}}"} {
+		if _, err := NewSceneLayout(layout, false); err == nil {
+			t.Errorf("NewSceneLayout(%q) should fail", layout)
+		}
+	}
+}
+
+func TestSceneFiles_Path(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hc2layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout, _ := NewSceneLayout(DefaultSceneLayout, false)
+	write := func(file string, sceneID int) {
+		scene := NewHc2Scene()
+		scene.SceneID = sceneID
+		scene.Name = "x"
+		content := "-- no header\n"
+		if sceneID != -1 {
+			content = scene.ToComment()
+		}
+		os.MkdirAll(filepath.Dir(file), os.ModePerm)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(s *SceneFiles, id int, name string) (string, bool) {
+		file, skip, err := s.Path(Hc2Scene{SceneID: id, Name: name}, Hc2Room{}, Hc2Section{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file, skip
+	}
+
+	write(filepath.Join(dir, "Mine.lua"), 1)
+	write(filepath.Join(dir, "Other.lua"), 2)
+	write(filepath.Join(dir, "Plain.lua"), -1)
+	write(filepath.Join(dir, "moved", "Renamed.lua"), 4)
+
+	s := &SceneFiles{BaseDir: dir, Layout: layout}
+	AssertEqual(t, fileOf(path(s, 1, "Mine")), filepath.Join(dir, "Mine.lua"))
+	AssertEqual(t, fileOf(path(s, 3, "Other")), filepath.Join(dir, "Other_3.lua"))
+	AssertEqual(t, fileOf(path(s, 5, "Plain")), filepath.Join(dir, "Plain_5.lua"))
+	AssertEqual(t, fileOf(path(s, 4, "Renamed")), filepath.Join(dir, "Renamed.lua"))
+	// case-insensitive filesystems
+	AssertEqual(t, fileOf(path(s, 6, "mine")), filepath.Join(dir, "mine_6.lua"))
+
+	// the fallback file is only used if it belongs to the scene
+	write(filepath.Join(dir, "Taken.lua"), 2)
+	write(filepath.Join(dir, "Taken_8.lua"), 9)
+	write(filepath.Join(dir, "Taken_10.lua"), 10)
+	AssertEqual(t, fileOf(path(s, 10, "Taken")), filepath.Join(dir, "Taken_10.lua"))
+	if _, _, err := s.Path(Hc2Scene{SceneID: 8, Name: "Taken"}, Hc2Room{}, Hc2Section{}); !errors.Is(err, ErrFileConflict) {
+		t.Errorf("Path() of a scene whose fallback file belongs to another scene = %v, want ErrFileConflict", err)
+	}
+
+	s = &SceneFiles{BaseDir: dir, Layout: layout, Overwrite: true}
+	AssertEqual(t, fileOf(path(s, 3, "Other")), filepath.Join(dir, "Other.lua"))
+
+	s = &SceneFiles{BaseDir: dir, Layout: layout, SkipExisting: true}
+	if _, skip := path(s, 1, "Mine"); !skip {
+		t.Error("SkipExisting should skip the existing file of the scene")
+	}
+	if _, skip := path(s, 7, "New"); skip {
+		t.Error("SkipExisting should not skip new files")
+	}
+
+	s = &SceneFiles{BaseDir: dir, Layout: layout, ByID: true}
+	AssertEqual(t, fileOf(path(s, 4, "Renamed")), filepath.Join(dir, "moved", "Renamed.lua"))
+	AssertEqual(t, fileOf(path(s, 2, "Something else")), filepath.Join(dir, "Other.lua"))
+}
+
+func fileOf(file string, _ bool) string {
+	return file
+}
@@ -0,0 +1,198 @@
+/*
+Package lint checks Fibaro HC2 lua-scripts for mistakes that only show up when the
+scene is run: syntax errors, calls of the fibaro API with unknown devices, actions
+or global variables, scenes not guarding against concurrent instances, and the use
+of functions not available in the HC2 sandbox.
+
+Diagnostics on a line are suppressed by a comment on the same line, or on a line of
+its own before it:
+
+	fibaro:call(999, "turnOn") -- hc2lint:ignore unknown-device
+	-- hc2lint:ignore-next-line
+	os.execute("reboot")
+
+Without rule names all rules are suppressed. A comment hc2lint:ignore-file suppresses
+the rules in the whole file, e.g. count-scenes, which is reported on the first line.
+*/
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+	"github.com/theovassiliou/hc2-tools/pkg/lua"
+)
+
+// Severity of a diagnostic
+type Severity int
+
+// The severities of diagnostics
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	return [...]string{"warning", "error"}[s]
+}
+
+// MarshalText encodes the severity as its name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic is a problem found in a lua-script
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Msg      string   `json:"message"`
+}
+
+// String formats the diagnostic as file:line: severity: message (rule), as understood
+// by the problem matchers of most editors
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s (%s)", d.File, d.Line, d.Severity, d.Msg, d.Rule)
+}
+
+// Config configures the checks. Devices and globals are only checked if they are given.
+type Config struct {
+	Devices  []hc2.Hc2Device         // devices of the HC2, nil to skip checking devices and actions
+	Globals  []hc2.Hc2GlobalVariable // global variables of the HC2, nil to skip checking globals
+	Disabled []string                // names of rules not to be applied
+
+	// ExpandRequire allows require statements that are expanded by hc2UploadScene
+	ExpandRequire bool
+}
+
+// Rule is a check applied to lua-scripts
+type Rule struct {
+	Name        string
+	Severity    Severity
+	Description string
+	check       func(*context)
+}
+
+// context is the state of linting one file
+type context struct {
+	file        string
+	src         string
+	lines       []string
+	chunk       *lua.Chunk
+	cfg         Config
+	rule        *Rule
+	devices     map[int]hc2.Hc2Device
+	globals     map[string]bool
+	diagnostics []Diagnostic
+}
+
+func (c *context) report(line int, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		File:     c.file,
+		Line:     line,
+		Rule:     c.rule.Name,
+		Severity: c.rule.Severity,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// Lint checks the lua-script src, read from file. The diagnostics are sorted by line.
+func Lint(file, src string, cfg Config) []Diagnostic {
+	c := &context{file: file, src: src, lines: strings.Split(src, "\n"), cfg: cfg}
+
+	chunk, err := lua.Parse(src)
+	if err != nil {
+		c.rule = &syntaxRule
+		se := err.(*lua.SyntaxError)
+		c.report(se.Line, "%s", se.Msg)
+		return c.suppress(c.diagnostics, nil)
+	}
+	c.chunk = chunk
+
+	if cfg.Devices != nil {
+		c.devices = map[int]hc2.Hc2Device{}
+		for _, d := range cfg.Devices {
+			c.devices[d.ID] = d
+		}
+	}
+	if cfg.Globals != nil {
+		c.globals = map[string]bool{}
+		for _, g := range cfg.Globals {
+			c.globals[g.Name] = true
+		}
+	}
+
+	for i := range Rules {
+		if containsString(cfg.Disabled, Rules[i].Name) {
+			continue
+		}
+		c.rule = &Rules[i]
+		c.rule.check(c)
+	}
+	sort.SliceStable(c.diagnostics, func(i, j int) bool { return c.diagnostics[i].Line < c.diagnostics[j].Line })
+	return c.suppress(c.diagnostics, chunk.Comments)
+}
+
+var suppression = regexp.MustCompile(`^\s*hc2lint:ignore(-next-line|-file)?(?:\s+([\w,\s-]+))?`)
+
+// suppress removes the diagnostics suppressed by hc2lint:ignore comments. For
+// scripts with syntax errors the comments are searched in the source.
+func (c *context) suppress(diagnostics []Diagnostic, comments []lua.Comment) []Diagnostic {
+	if comments == nil {
+		for i, l := range c.lines {
+			if j := strings.Index(l, "--"); j >= 0 {
+				comments = append(comments, lua.Comment{Line: i + 1, Text: l[j+2:]})
+			}
+		}
+	}
+	ignored := map[int][]string{} // line to rules, empty for all, 0 for the whole file
+	for _, cm := range comments {
+		m := suppression.FindStringSubmatch(cm.Text)
+		if m == nil {
+			continue
+		}
+		line := cm.Line
+		switch m[1] {
+		case "-next-line":
+			line++
+		case "-file":
+			line = 0
+		}
+		rules := strings.FieldsFunc(m[2], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if prev, ok := ignored[line]; ok && len(prev) == 0 {
+			continue
+		}
+		if len(rules) == 0 {
+			ignored[line] = []string{}
+		} else {
+			ignored[line] = append(ignored[line], rules...)
+		}
+	}
+
+	var result []Diagnostic
+	for _, d := range diagnostics {
+		if ignores(ignored, d.Line, d.Rule) || ignores(ignored, 0, d.Rule) {
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+func ignores(ignored map[int][]string, line int, rule string) bool {
+	rules, ok := ignored[line]
+	return ok && (len(rules) == 0 || containsString(rules, rule))
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
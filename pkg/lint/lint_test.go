@@ -0,0 +1,120 @@
+package lint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+)
+
+var testConfig = Config{
+	Devices: []hc2.Hc2Device{
+		{ID: 12, Name: "Lamp", Type: "com.fibaro.binarySwitch", Actions: map[string]int{"turnOn": 0, "turnOff": 0, "setValue": 1}},
+		{ID: 13, Name: "Sensor", Type: "com.fibaro.motionSensor"},
+	},
+	Globals:       []hc2.Hc2GlobalVariable{{Name: "TimeOfDay"}},
+	ExpandRequire: true,
+}
+
+// lintLines returns the rule and line of each diagnostic
+func lintLines(src string, cfg Config) []string {
+	var result []string
+	for _, d := range Lint("test.lua", src, cfg) {
+		result = append(result, fmt.Sprintf("%s:%d", d.Rule, d.Line))
+	}
+	return result
+}
+
+func TestLint_Rules(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"valid", "fibaro:call(12, 'turnOn')\nfibaro:call(12, 'setValue', 50)\nlocal v = fibaro:getValue('13', 'value')", nil},
+		{"unknown device", "fibaro:call(12, 'turnOn')\nfibaro:call(99, 'turnOn')\nfibaro:getValue(\"98\", 'value')", []string{"unknown-device:2", "unknown-device:3"}},
+		{"modification time", "fibaro:getModificationTime(99, 'value')\nfibaro:getGlobalModificationTime('Nope')", []string{"unknown-device:1", "unknown-global:2"}},
+		{"decimal and hex ids", "fibaro:call(012, 'turnOn')\nfibaro:call(0xC, 'turnOn')\nfibaro:call(010, 'turnOn')", []string{"unknown-device:3"}},
+		{"computed device", "local id = 99\nfibaro:call(id, 'turnOn')", nil},
+		{"unknown action", "fibaro:call(12, 'toggle')", []string{"unknown-action:1"}},
+		{"missing argument", "fibaro:call(12, 'setValue')", []string{"unknown-action:1"}},
+		{"device without actions", "fibaro:call(13, 'anything')", nil},
+		{"unknown global", "fibaro:setGlobal('TimeOfDay', 'Night')\nif fibaro:getGlobalValue('Nope') then end", []string{"unknown-global:2"}},
+		{"forbidden", "local f = io.open('x')\nos.execute('ls')\nlocal t = os.time()\ndofile('x.lua')\nlocal s = require(name)", []string{"forbidden-function:1", "forbidden-function:2", "forbidden-function:4", "forbidden-function:5"}},
+		{"expanded require", "require('lib')\nlocal x = require 'lib.json'\nx = require('lib')", []string{"forbidden-function:3"}},
+		{"syntax", "x = = 1\nos.exit()", []string{"syntax:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lintLines(tt.src, testConfig); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLint_CountScenes(t *testing.T) {
+	header := "--[[\n%% properties\n12 value\n--]]\n"
+	if got := lintLines(header+"fibaro:call(12, 'turnOn')", testConfig); !reflect.DeepEqual(got, []string{"count-scenes:1"}) {
+		t.Errorf("unguarded scene: Lint() = %v", got)
+	}
+	guarded := header + "if (fibaro:countScenes() > 1) then fibaro:abort() end"
+	if got := lintLines(guarded, testConfig); got != nil {
+		t.Errorf("guarded scene: Lint() = %v", got)
+	}
+	killing := "--[[\n%% killOtherInstances\n%% properties\n12 value\n--]]\n"
+	if got := lintLines(killing, testConfig); got != nil {
+		t.Errorf("killOtherInstances: Lint() = %v", got)
+	}
+	if got := lintLines("--[[\n%% autostart\n--]]\n", testConfig); got != nil {
+		t.Errorf("autostart only: Lint() = %v", got)
+	}
+}
+
+func TestLint_Unchecked(t *testing.T) {
+	src := "fibaro:call(99, 'turnOn')\nfibaro:getGlobal('Nope')"
+	if got := lintLines(src, Config{}); got != nil {
+		t.Errorf("without devices and globals: Lint() = %v", got)
+	}
+	cfg := testConfig
+	cfg.Disabled = []string{"unknown-device"}
+	if got := lintLines(src, cfg); !reflect.DeepEqual(got, []string{"unknown-global:2"}) {
+		t.Errorf("disabled unknown-device: Lint() = %v", got)
+	}
+	cfg = testConfig
+	cfg.ExpandRequire = false
+	if got := lintLines("require('lib')", cfg); !reflect.DeepEqual(got, []string{"forbidden-function:1"}) {
+		t.Errorf("without ExpandRequire: Lint() = %v", got)
+	}
+}
+
+func TestLint_Suppression(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"same line", "fibaro:call(99, 'turnOn') -- hc2lint:ignore", nil},
+		{"same line rule", "fibaro:call(99, 'turnOn') -- hc2lint:ignore unknown-device", nil},
+		{"other rule", "fibaro:call(99, 'turnOn') -- hc2lint:ignore unknown-global", []string{"unknown-device:1"}},
+		{"next line", "-- hc2lint:ignore-next-line forbidden-function, unknown-device\nfibaro:call(99, 'turnOn') os.exit()\nos.exit()", []string{"forbidden-function:3"}},
+		{"file", "os.exit()\n-- hc2lint:ignore-file forbidden-function\nos.exit()\nfibaro:call(99, 'turnOn')", []string{"unknown-device:4"}},
+		{"syntax error", "x = = 1 -- hc2lint:ignore syntax", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lintLines(tt.src, testConfig); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	d := Diagnostic{File: "scene.lua", Line: 3, Rule: "unknown-device", Severity: Error, Msg: "device 99 does not exist"}
+	want := "scene.lua:3: error: device 99 does not exist (unknown-device)"
+	if d.String() != want {
+		t.Errorf("String() = %q, want %q", d.String(), want)
+	}
+}
@@ -0,0 +1,220 @@
+package lint
+
+import (
+	"strconv"
+	"strings"
+
+	hc2 "github.com/theovassiliou/hc2-tools/pkg"
+	"github.com/theovassiliou/hc2-tools/pkg/lua"
+)
+
+var syntaxRule = Rule{
+	Name:        "syntax",
+	Severity:    Error,
+	Description: "The script is not valid Lua 5.2",
+}
+
+// Rules are all rules applied by Lint, besides the syntax check
+var Rules = []Rule{
+	{
+		Name:        "unknown-device",
+		Severity:    Error,
+		Description: "fibaro:call, fibaro:getValue, ... with a device id not existing in the HC2",
+		check:       checkUnknownDevice,
+	},
+	{
+		Name:        "unknown-action",
+		Severity:    Error,
+		Description: "fibaro:call with an action the device does not support, or too few arguments",
+		check:       checkUnknownAction,
+	},
+	{
+		Name:        "unknown-global",
+		Severity:    Error,
+		Description: "fibaro:getGlobal, fibaro:setGlobal, ... with a global variable not defined in the HC2",
+		check:       checkUnknownGlobal,
+	},
+	{
+		Name:        "count-scenes",
+		Severity:    Warning,
+		Description: "A triggered scene does not check fibaro:countScenes() to avoid running concurrently",
+		check:       checkCountScenes,
+	},
+	{
+		Name:        "forbidden-function",
+		Severity:    Error,
+		Description: "Use of functions not available in the HC2 sandbox, like os.execute, io.* and require",
+		check:       checkForbiddenFunction,
+	},
+}
+
+// fibaro methods taking a device id, or the name of a global variable, as first argument
+var (
+	deviceMethods = methodSet(hc2.FibaroDeviceMethods)
+	globalMethods = methodSet(hc2.FibaroGlobalMethods)
+)
+
+func methodSet(methods []string) map[string]bool {
+	set := map[string]bool{}
+	for _, m := range methods {
+		set[m] = true
+	}
+	return set
+}
+
+// fibaroCalls calls f for every call of a method of fibaro
+func (c *context) fibaroCalls(f func(call *lua.CallExpr)) {
+	lua.Inspect(c.chunk.Block, func(n lua.Node) bool {
+		if call, ok := n.(*lua.CallExpr); ok && call.Method != "" {
+			if name, ok := call.Func.(*lua.NameExpr); ok && name.Name == "fibaro" {
+				f(call)
+			}
+		}
+		return true
+	})
+}
+
+// intArg returns the argument i of call, if it is a number or a string containing one. As
+// in Lua the number is decimal, unless prefixed by 0x.
+func intArg(call *lua.CallExpr, i int) (int, bool) {
+	if i >= len(call.Args) {
+		return 0, false
+	}
+	var s string
+	switch a := call.Args[i].(type) {
+	case *lua.NumberExpr:
+		s = a.Value
+	case *lua.StringExpr:
+		s = a.Value
+	default:
+		return 0, false
+	}
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
+	}
+	v, err := strconv.ParseInt(s, base, 64)
+	return int(v), err == nil
+}
+
+// stringArg returns the argument i of call, if it is a string
+func stringArg(call *lua.CallExpr, i int) (string, bool) {
+	if i >= len(call.Args) {
+		return "", false
+	}
+	s, ok := call.Args[i].(*lua.StringExpr)
+	if !ok {
+		return "", false
+	}
+	return s.Value, true
+}
+
+func checkUnknownDevice(c *context) {
+	if c.devices == nil {
+		return
+	}
+	c.fibaroCalls(func(call *lua.CallExpr) {
+		if !deviceMethods[call.Method] {
+			return
+		}
+		if id, ok := intArg(call, 0); ok {
+			if _, exists := c.devices[id]; !exists {
+				c.report(call.Line(), "device %d does not exist", id)
+			}
+		}
+	})
+}
+
+func checkUnknownAction(c *context) {
+	if c.devices == nil {
+		return
+	}
+	c.fibaroCalls(func(call *lua.CallExpr) {
+		if call.Method != "call" {
+			return
+		}
+		id, ok := intArg(call, 0)
+		if !ok {
+			return
+		}
+		action, ok := stringArg(call, 1)
+		device, exists := c.devices[id]
+		if !ok || !exists || device.Actions == nil {
+			return
+		}
+		n, known := device.Actions[action]
+		switch {
+		case !known:
+			c.report(call.Line(), "device %d (%s, %s) has no action %q", id, device.Name, device.Type, action)
+		case len(call.Args)-2 < n:
+			c.report(call.Line(), "action %q of device %d expects %d arguments, %d given", action, id, n, len(call.Args)-2)
+		}
+	})
+}
+
+func checkUnknownGlobal(c *context) {
+	if c.globals == nil {
+		return
+	}
+	c.fibaroCalls(func(call *lua.CallExpr) {
+		if !globalMethods[call.Method] {
+			return
+		}
+		if name, ok := stringArg(call, 0); ok && !c.globals[name] {
+			c.report(call.Line(), "global variable %q does not exist", name)
+		}
+	})
+}
+
+func checkCountScenes(c *context) {
+	t, found, err := hc2.ParseSceneTriggers(c.src)
+	if !found || err != nil || t.KillOtherInstances {
+		return
+	}
+	if len(t.Properties) == 0 && len(t.Events) == 0 && len(t.Globals) == 0 && len(t.Weather) == 0 {
+		return
+	}
+	guarded := false
+	c.fibaroCalls(func(call *lua.CallExpr) {
+		guarded = guarded || call.Method == "countScenes"
+	})
+	if !guarded {
+		c.report(1, "scene is started by triggers, but does not check fibaro:countScenes() to avoid running concurrently")
+	}
+}
+
+// the functions of os not available in the HC2 sandbox
+var forbiddenOs = map[string]bool{
+	"execute": true, "exit": true, "getenv": true, "remove": true, "rename": true, "tmpname": true,
+}
+
+func checkForbiddenFunction(c *context) {
+	lua.Inspect(c.chunk.Block, func(n lua.Node) bool {
+		switch e := n.(type) {
+		case *lua.IndexExpr:
+			obj, ok := e.Obj.(*lua.NameExpr)
+			key, isString := e.Key.(*lua.StringExpr)
+			if !ok || !isString {
+				break
+			}
+			if obj.Name == "io" || obj.Name == "debug" || obj.Name == "os" && forbiddenOs[key.Value] {
+				c.report(e.Line(), "%s.%s is not available on the HC2", obj.Name, key.Value)
+			}
+		case *lua.CallExpr:
+			name, ok := e.Func.(*lua.NameExpr)
+			if !ok || e.Method != "" {
+				break
+			}
+			switch name.Name {
+			case "require":
+				if c.cfg.ExpandRequire && e.Line() <= len(c.lines) && hc2.RequireStatement.MatchString(c.lines[e.Line()-1]) {
+					break
+				}
+				c.report(e.Line(), "require is not available on the HC2")
+			case "dofile", "loadfile":
+				c.report(e.Line(), "%s is not available on the HC2", name.Name)
+			}
+		}
+		return true
+	})
+}
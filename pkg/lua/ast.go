@@ -0,0 +1,320 @@
+package lua
+
+// Node is a node of the syntax tree. Line is the line the node starts on.
+type Node interface {
+	Line() int
+}
+
+// Pos is embedded in all nodes to record their line
+type Pos struct {
+	L int
+}
+
+// Line returns the line the node starts on
+func (p Pos) Line() int { return p.L }
+
+// Stmt is a statement
+type Stmt interface {
+	Node
+	stmt()
+}
+
+// Expr is an expression
+type Expr interface {
+	Node
+	expr()
+}
+
+// Block is a sequence of statements
+type Block struct {
+	Pos
+	Stmts []Stmt
+}
+
+// Chunk is a parsed Lua chunk
+type Chunk struct {
+	Block    *Block
+	Comments []Comment
+}
+
+// Statements
+type (
+	// LocalStmt is local Names = Exprs
+	LocalStmt struct {
+		Pos
+		Names []string
+		Exprs []Expr
+	}
+	// AssignStmt is Targets = Exprs, each target a *NameExpr or *IndexExpr
+	AssignStmt struct {
+		Pos
+		Targets []Expr
+		Exprs   []Expr
+	}
+	// CallStmt is a function call used as statement
+	CallStmt struct {
+		Pos
+		Call *CallExpr
+	}
+	// DoStmt is do Block end
+	DoStmt struct {
+		Pos
+		Block *Block
+	}
+	// WhileStmt is while Cond do Block end
+	WhileStmt struct {
+		Pos
+		Cond  Expr
+		Block *Block
+	}
+	// RepeatStmt is repeat Block until Cond
+	RepeatStmt struct {
+		Pos
+		Block *Block
+		Cond  Expr
+	}
+	// IfStmt is if Conds[0] then Blocks[0] elseif Conds[1] then Blocks[1] ... else Else end
+	IfStmt struct {
+		Pos
+		Conds  []Expr
+		Blocks []*Block
+		Else   *Block // nil if there is no else branch
+	}
+	// NumericForStmt is for Name = Start, Limit, Step do Block end
+	NumericForStmt struct {
+		Pos
+		Name               string
+		Start, Limit, Step Expr // Step is nil if not given
+		Block              *Block
+	}
+	// GenericForStmt is for Names in Exprs do Block end
+	GenericForStmt struct {
+		Pos
+		Names []string
+		Exprs []Expr
+		Block *Block
+	}
+	// FunctionStmt is function Name body, where Name is a *NameExpr or an *IndexExpr.
+	// For function a.b:c() Name is a.b.c and Method is set.
+	FunctionStmt struct {
+		Pos
+		Name   Expr
+		Method bool
+		Func   *FunctionExpr
+	}
+	// LocalFunctionStmt is local function Name body
+	LocalFunctionStmt struct {
+		Pos
+		Name string
+		Func *FunctionExpr
+	}
+	// ReturnStmt is return Exprs
+	ReturnStmt struct {
+		Pos
+		Exprs []Expr
+	}
+	// BreakStmt is break
+	BreakStmt struct {
+		Pos
+	}
+	// GotoStmt is goto Label
+	GotoStmt struct {
+		Pos
+		Label string
+	}
+	// LabelStmt is ::Name::
+	LabelStmt struct {
+		Pos
+		Name string
+	}
+)
+
+// Expressions
+type (
+	// NilExpr is nil
+	NilExpr struct{ Pos }
+	// TrueExpr is true
+	TrueExpr struct{ Pos }
+	// FalseExpr is false
+	FalseExpr struct{ Pos }
+	// VarargExpr is ...
+	VarargExpr struct{ Pos }
+	// NumberExpr is a number as written in the source
+	NumberExpr struct {
+		Pos
+		Value string
+	}
+	// StringExpr is a string literal, with escape sequences decoded
+	StringExpr struct {
+		Pos
+		Value string
+	}
+	// NameExpr is a variable
+	NameExpr struct {
+		Pos
+		Name string
+	}
+	// IndexExpr is Obj[Key]. For Obj.name Key is a *StringExpr.
+	IndexExpr struct {
+		Pos
+		Obj Expr
+		Key Expr
+	}
+	// CallExpr is Func(Args), or Func:Method(Args) if Method is not empty
+	CallExpr struct {
+		Pos
+		Func   Expr
+		Method string
+		Args   []Expr
+	}
+	// FunctionExpr is function(Params) Block end
+	FunctionExpr struct {
+		Pos
+		Params []string
+		Vararg bool
+		Block  *Block
+	}
+	// TableExpr is a table constructor
+	TableExpr struct {
+		Pos
+		Fields []Field
+	}
+	// BinaryExpr is L Op R
+	BinaryExpr struct {
+		Pos
+		Op   string
+		L, R Expr
+	}
+	// UnaryExpr is Op X, with Op one of -, not and #
+	UnaryExpr struct {
+		Pos
+		Op string
+		X  Expr
+	}
+	// ParenExpr is (X)
+	ParenExpr struct {
+		Pos
+		X Expr
+	}
+)
+
+// Field is a field of a table constructor. Key is nil for positional fields.
+type Field struct {
+	Key   Expr
+	Value Expr
+}
+
+func (*LocalStmt) stmt()         {}
+func (*AssignStmt) stmt()        {}
+func (*CallStmt) stmt()          {}
+func (*DoStmt) stmt()            {}
+func (*WhileStmt) stmt()         {}
+func (*RepeatStmt) stmt()        {}
+func (*IfStmt) stmt()            {}
+func (*NumericForStmt) stmt()    {}
+func (*GenericForStmt) stmt()    {}
+func (*FunctionStmt) stmt()      {}
+func (*LocalFunctionStmt) stmt() {}
+func (*ReturnStmt) stmt()        {}
+func (*BreakStmt) stmt()         {}
+func (*GotoStmt) stmt()          {}
+func (*LabelStmt) stmt()         {}
+
+func (*NilExpr) expr()      {}
+func (*TrueExpr) expr()     {}
+func (*FalseExpr) expr()    {}
+func (*VarargExpr) expr()   {}
+func (*NumberExpr) expr()   {}
+func (*StringExpr) expr()   {}
+func (*NameExpr) expr()     {}
+func (*IndexExpr) expr()    {}
+func (*CallExpr) expr()     {}
+func (*FunctionExpr) expr() {}
+func (*TableExpr) expr()    {}
+func (*BinaryExpr) expr()   {}
+func (*UnaryExpr) expr()    {}
+func (*ParenExpr) expr()    {}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f for
+// every node. If f returns false the children of the node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	exprs := func(list []Expr) {
+		for _, e := range list {
+			Inspect(e, f)
+		}
+	}
+	block := func(b *Block) {
+		if b != nil {
+			Inspect(b, f)
+		}
+	}
+	switch n := node.(type) {
+	case *Block:
+		for _, s := range n.Stmts {
+			Inspect(s, f)
+		}
+	case *LocalStmt:
+		exprs(n.Exprs)
+	case *AssignStmt:
+		exprs(n.Targets)
+		exprs(n.Exprs)
+	case *CallStmt:
+		Inspect(n.Call, f)
+	case *DoStmt:
+		block(n.Block)
+	case *WhileStmt:
+		Inspect(n.Cond, f)
+		block(n.Block)
+	case *RepeatStmt:
+		block(n.Block)
+		Inspect(n.Cond, f)
+	case *IfStmt:
+		for i := range n.Conds {
+			Inspect(n.Conds[i], f)
+			block(n.Blocks[i])
+		}
+		block(n.Else)
+	case *NumericForStmt:
+		Inspect(n.Start, f)
+		Inspect(n.Limit, f)
+		if n.Step != nil {
+			Inspect(n.Step, f)
+		}
+		block(n.Block)
+	case *GenericForStmt:
+		exprs(n.Exprs)
+		block(n.Block)
+	case *FunctionStmt:
+		Inspect(n.Name, f)
+		Inspect(n.Func, f)
+	case *LocalFunctionStmt:
+		Inspect(n.Func, f)
+	case *ReturnStmt:
+		exprs(n.Exprs)
+	case *IndexExpr:
+		Inspect(n.Obj, f)
+		Inspect(n.Key, f)
+	case *CallExpr:
+		Inspect(n.Func, f)
+		exprs(n.Args)
+	case *FunctionExpr:
+		block(n.Block)
+	case *TableExpr:
+		for _, fld := range n.Fields {
+			if fld.Key != nil {
+				Inspect(fld.Key, f)
+			}
+			Inspect(fld.Value, f)
+		}
+	case *BinaryExpr:
+		Inspect(n.L, f)
+		Inspect(n.R, f)
+	case *UnaryExpr:
+		Inspect(n.X, f)
+	case *ParenExpr:
+		Inspect(n.X, f)
+	}
+}
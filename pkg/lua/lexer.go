@@ -0,0 +1,350 @@
+package lua
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TokenType is the type of a lexical token
+type TokenType int
+
+// The token types of Lua 5.2
+const (
+	EOF TokenType = iota
+	Name
+	Number
+	String
+	Keyword
+	Op
+)
+
+// Token is a lexical token of a Lua chunk
+type Token struct {
+	Type  TokenType
+	Value string // the keyword, operator or name; the decoded string; the number as written
+	Raw   string // the token as written in the source
+	Line  int
+}
+
+// near returns the token as shown in error messages
+func (t Token) near() string {
+	if t.Type == EOF {
+		return "<eof>"
+	}
+	return t.Raw
+}
+
+// Comment is a comment of a Lua chunk, without the leading -- and brackets
+type Comment struct {
+	Line int
+	Text string
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+// operators, longest first
+var operators = []string{
+	"...", "..", "==", "~=", "<=", ">=", "::",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=", "(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+var (
+	decimalNumber = regexp.MustCompile(`^([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+	hexNumber     = regexp.MustCompile(`^0[xX]([0-9a-fA-F]+\.?[0-9a-fA-F]*|\.[0-9a-fA-F]+)([pP][+-]?[0-9]+)?$`)
+)
+
+// lexer splits a Lua chunk into tokens
+type lexer struct {
+	src      string
+	pos      int
+	line     int
+	comments []Comment
+}
+
+func newLexer(src string) *lexer {
+	l := &lexer{src: src, line: 1}
+	// skip a first line starting with #, as the standalone interpreter does
+	if strings.HasPrefix(src, "#") {
+		for l.pos < len(src) && src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	return l
+}
+
+func (l *lexer) errorf(near string, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if near != "" {
+		msg += " near '" + near + "'"
+	}
+	return &SyntaxError{Line: l.line, Msg: msg}
+}
+
+func (l *lexer) peekByte(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+// newline consumes a \n, \r, \r\n or \n\r sequence
+func (l *lexer) newline() {
+	c := l.src[l.pos]
+	l.pos++
+	if next := l.peekByte(0); (next == '\n' || next == '\r') && next != c {
+		l.pos++
+	}
+	l.line++
+}
+
+// next returns the next token
+func (l *lexer) next() (Token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n' || c == '\r':
+			l.newline()
+		case c == ' ' || c == '\t' || c == '\f' || c == '\v':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-':
+			if err := l.comment(); err != nil {
+				return Token{}, err
+			}
+		default:
+			return l.token()
+		}
+	}
+	return Token{Type: EOF, Line: l.line}, nil
+}
+
+func (l *lexer) comment() error {
+	line := l.line
+	l.pos += 2
+	if l.peekByte(0) == '[' {
+		if level := l.longBracketLevel(); level >= 0 {
+			text, err := l.longString(level, "comment")
+			if err != nil {
+				return err
+			}
+			l.comments = append(l.comments, Comment{Line: line, Text: text})
+			return nil
+		}
+	}
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+		l.pos++
+	}
+	l.comments = append(l.comments, Comment{Line: line, Text: l.src[start:l.pos]})
+	return nil
+}
+
+// longBracketLevel returns the level of the opening long bracket at pos, or -1
+func (l *lexer) longBracketLevel() int {
+	i := 1
+	for l.peekByte(i) == '=' {
+		i++
+	}
+	if l.peekByte(i) == '[' {
+		return i - 1
+	}
+	return -1
+}
+
+// longString reads a long string or comment starting at pos
+func (l *lexer) longString(level int, what string) (string, error) {
+	l.pos += level + 2
+	if c := l.peekByte(0); c == '\n' || c == '\r' {
+		l.newline()
+	}
+	var b strings.Builder
+	closing := "]" + strings.Repeat("=", level) + "]"
+	for {
+		if l.pos >= len(l.src) {
+			return "", l.errorf("<eof>", "unfinished long %s", what)
+		}
+		c := l.src[l.pos]
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], closing):
+			l.pos += len(closing)
+			return b.String(), nil
+		case c == '\n' || c == '\r':
+			l.newline()
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) token() (Token, error) {
+	start := l.pos
+	line := l.line
+	c := l.src[l.pos]
+	tok := func(t TokenType, value string) (Token, error) {
+		return Token{Type: t, Value: value, Raw: l.src[start:l.pos], Line: line}, nil
+	}
+
+	switch {
+	case isLetter(c):
+		for l.pos < len(l.src) && (isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		if keywords[word] {
+			return tok(Keyword, word)
+		}
+		return tok(Name, word)
+
+	case isDigit(c) || c == '.' && isDigit(l.peekByte(1)):
+		return l.number()
+
+	case c == '"' || c == '\'':
+		s, err := l.shortString(c)
+		if err != nil {
+			return Token{}, err
+		}
+		return tok(String, s)
+
+	case c == '[' && l.longBracketLevel() >= 0:
+		s, err := l.longString(l.longBracketLevel(), "string")
+		if err != nil {
+			return Token{}, err
+		}
+		return tok(String, s)
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return tok(Op, op)
+		}
+	}
+	l.pos++
+	return Token{}, l.errorf(l.src[start:l.pos], "unexpected symbol")
+}
+
+func (l *lexer) number() (Token, error) {
+	start := l.pos
+	exponent := "Ee"
+	if l.src[l.pos] == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		exponent = "Pp"
+		l.pos += 2
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if strings.IndexByte(exponent, c) >= 0 && (l.peekByte(1) == '+' || l.peekByte(1) == '-') {
+			l.pos += 2
+			continue
+		}
+		if !isLetter(c) && !isDigit(c) && c != '.' {
+			break
+		}
+		l.pos++
+	}
+	raw := l.src[start:l.pos]
+	if !decimalNumber.MatchString(raw) && !hexNumber.MatchString(raw) {
+		return Token{}, l.errorf(raw, "malformed number")
+	}
+	return Token{Type: Number, Value: raw, Raw: raw, Line: l.line}, nil
+}
+
+func (l *lexer) shortString(quote byte) (string, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return "", l.errorf("<eof>", "unfinished string")
+		}
+		c := l.src[l.pos]
+		switch c {
+		case quote:
+			l.pos++
+			return b.String(), nil
+		case '\n', '\r':
+			return "", l.errorf(l.src[start:l.pos], "unfinished string")
+		case '\\':
+			if err := l.escape(&b, start); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) escape(b *strings.Builder, start int) error {
+	l.pos++ // the backslash
+	if l.pos >= len(l.src) {
+		return l.errorf("<eof>", "unfinished string")
+	}
+	c := l.src[l.pos]
+	if r, ok := map[byte]byte{'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t',
+		'v': '\v', '\\': '\\', '"': '"', '\'': '\''}[c]; ok {
+		b.WriteByte(r)
+		l.pos++
+		return nil
+	}
+	switch {
+	case c == '\n' || c == '\r':
+		l.newline()
+		b.WriteByte('\n')
+	case c == 'x':
+		if !isHexDigit(l.peekByte(1)) || !isHexDigit(l.peekByte(2)) {
+			l.pos += 3
+			if l.pos > len(l.src) {
+				l.pos = len(l.src)
+			}
+			return l.errorf(l.src[start:l.pos], "hexadecimal digit expected")
+		}
+		var v byte
+		fmt.Sscanf(l.src[l.pos+1:l.pos+3], "%x", &v)
+		b.WriteByte(v)
+		l.pos += 3
+	case c == 'z':
+		l.pos++
+		for l.pos < len(l.src) {
+			if c := l.src[l.pos]; c == '\n' || c == '\r' {
+				l.newline()
+			} else if c == ' ' || c == '\t' || c == '\f' || c == '\v' {
+				l.pos++
+			} else {
+				break
+			}
+		}
+	case isDigit(c):
+		v := 0
+		for i := 0; i < 3 && l.pos < len(l.src) && isDigit(l.src[l.pos]); i++ {
+			v = 10*v + int(l.src[l.pos]-'0')
+			l.pos++
+		}
+		if v > 255 {
+			return l.errorf(l.src[start:l.pos], "decimal escape too large")
+		}
+		b.WriteByte(byte(v))
+	default:
+		l.pos++
+		return l.errorf(l.src[start:l.pos], "invalid escape sequence")
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
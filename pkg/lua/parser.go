@@ -0,0 +1,582 @@
+/*
+Package lua parses Lua 5.2, the language of Fibaro HC2 scenes, into a syntax tree.
+The parser reports the same syntax errors as the reference implementation, so
+that errors can be found before a scene is uploaded.
+*/
+package lua
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SyntaxError is a syntax error in a Lua chunk
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// Parse parses a Lua chunk. A returned error is a *SyntaxError.
+func Parse(src string) (chunk *Chunk, err error) {
+	p := &parser{lex: newLexer(src)}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			chunk, err = nil, e
+		}
+	}()
+	p.advance()
+	p.openFunction(true)
+	block := p.block()
+	if p.tok.Type != EOF {
+		p.errorf("'<eof>' expected")
+	}
+	p.closeFunction()
+	return &Chunk{Block: block, Comments: p.lex.comments}, nil
+}
+
+// parser is a recursive descent parser following the grammar of the Lua 5.2 manual
+type parser struct {
+	lex   *lexer
+	tok   Token
+	ahead *Token
+	fn    *funcState
+}
+
+// funcState tracks the blocks of the function being parsed, for goto, break and ...
+type funcState struct {
+	parent *funcState
+	vararg bool
+	block  *blockState
+}
+
+type blockState struct {
+	parent *blockState
+	loop   bool
+	labels map[string]int // labels of the block and their line
+	gotos  []pendingGoto  // gotos of this and enclosed blocks not yet resolved
+}
+
+type pendingGoto struct {
+	label string
+	line  int
+}
+
+func (p *parser) fail(line int, msg string) {
+	panic(&SyntaxError{Line: line, Msg: msg})
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	p.fail(p.tok.Line, fmt.Sprintf(format, args...)+" near '"+p.tok.near()+"'")
+}
+
+func (p *parser) advance() {
+	if p.ahead != nil {
+		p.tok, p.ahead = *p.ahead, nil
+		return
+	}
+	t, err := p.lex.next()
+	if err != nil {
+		panic(err)
+	}
+	p.tok = t
+}
+
+func (p *parser) lookahead() Token {
+	if p.ahead == nil {
+		t, err := p.lex.next()
+		if err != nil {
+			panic(err)
+		}
+		p.ahead = &t
+	}
+	return *p.ahead
+}
+
+// is reports whether the current token is the keyword or operator s
+func (p *parser) is(s string) bool {
+	return (p.tok.Type == Keyword || p.tok.Type == Op) && p.tok.Value == s
+}
+
+func (p *parser) accept(s string) bool {
+	if p.is(s) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.accept(s) {
+		p.errorf("'%s' expected", s)
+	}
+}
+
+// expectMatch expects the token closing what, opened at line
+func (p *parser) expectMatch(close, open string, line int) {
+	if p.accept(close) {
+		return
+	}
+	if line == p.tok.Line {
+		p.errorf("'%s' expected", close)
+	}
+	p.errorf("'%s' expected (to close '%s' at line %d)", close, open, line)
+}
+
+func (p *parser) name() string {
+	if p.tok.Type != Name {
+		p.errorf("<name> expected")
+	}
+	n := p.tok.Value
+	p.advance()
+	return n
+}
+
+func (p *parser) openFunction(vararg bool) {
+	p.fn = &funcState{parent: p.fn, vararg: vararg}
+}
+
+func (p *parser) closeFunction() {
+	p.fn = p.fn.parent
+}
+
+func (p *parser) openBlock(loop bool) {
+	p.fn.block = &blockState{parent: p.fn.block, loop: loop, labels: map[string]int{}}
+}
+
+// closeBlock resolves the gotos of the block. Unresolved gotos are passed to the
+// enclosing block, or are an error at the end of the function.
+func (p *parser) closeBlock() {
+	b := p.fn.block
+	p.fn.block = b.parent
+	for _, g := range b.gotos {
+		if _, ok := b.labels[g.label]; ok {
+			continue
+		}
+		if b.parent == nil {
+			p.fail(g.line, fmt.Sprintf("no visible label '%s' for goto at line %d", g.label, g.line))
+		}
+		b.parent.gotos = append(b.parent.gotos, g)
+	}
+}
+
+func blockFollow(t Token, withUntil bool) bool {
+	if t.Type == EOF {
+		return true
+	}
+	if t.Type != Keyword {
+		return false
+	}
+	switch t.Value {
+	case "else", "elseif", "end":
+		return true
+	case "until":
+		return withUntil
+	}
+	return false
+}
+
+// block parses a block in a new scope
+func (p *parser) block() *Block {
+	return p.scopedBlock(false)
+}
+
+func (p *parser) scopedBlock(loop bool) *Block {
+	p.openBlock(loop)
+	b := p.statements()
+	p.closeBlock()
+	return b
+}
+
+func (p *parser) statements() *Block {
+	b := &Block{Pos: Pos{p.tok.Line}}
+	for !blockFollow(p.tok, true) {
+		if p.is("return") {
+			b.Stmts = append(b.Stmts, p.returnStmt())
+			break
+		}
+		if s := p.statement(); s != nil {
+			b.Stmts = append(b.Stmts, s)
+		}
+	}
+	return b
+}
+
+func (p *parser) returnStmt() Stmt {
+	s := &ReturnStmt{Pos: Pos{p.tok.Line}}
+	p.advance()
+	if !blockFollow(p.tok, true) && !p.is(";") {
+		s.Exprs = p.exprList()
+	}
+	p.accept(";")
+	return s
+}
+
+func (p *parser) statement() Stmt {
+	line := p.tok.Line
+	pos := Pos{line}
+	if p.tok.Type == Keyword {
+		switch p.tok.Value {
+		case "if":
+			return p.ifStmt()
+		case "while":
+			p.advance()
+			cond := p.expr()
+			p.expect("do")
+			block := p.scopedBlock(true)
+			p.expectMatch("end", "while", line)
+			return &WhileStmt{Pos: pos, Cond: cond, Block: block}
+		case "do":
+			p.advance()
+			block := p.block()
+			p.expectMatch("end", "do", line)
+			return &DoStmt{Pos: pos, Block: block}
+		case "for":
+			return p.forStmt()
+		case "repeat":
+			p.advance()
+			// the scope of the block includes the condition
+			p.openBlock(true)
+			block := p.statements()
+			p.expectMatch("until", "repeat", line)
+			cond := p.expr()
+			p.closeBlock()
+			return &RepeatStmt{Pos: pos, Block: block, Cond: cond}
+		case "function":
+			p.advance()
+			var name Expr = &NameExpr{Pos: Pos{p.tok.Line}, Name: p.name()}
+			method := false
+			for p.is(".") || p.is(":") {
+				method = p.is(":")
+				p.advance()
+				keyLine := p.tok.Line
+				name = &IndexExpr{Pos: pos, Obj: name, Key: &StringExpr{Pos: Pos{keyLine}, Value: p.name()}}
+				if method {
+					break
+				}
+			}
+			return &FunctionStmt{Pos: pos, Name: name, Method: method, Func: p.body(method, line)}
+		case "local":
+			p.advance()
+			if p.accept("function") {
+				name := p.name()
+				return &LocalFunctionStmt{Pos: pos, Name: name, Func: p.body(false, line)}
+			}
+			s := &LocalStmt{Pos: pos, Names: []string{p.name()}}
+			for p.accept(",") {
+				s.Names = append(s.Names, p.name())
+			}
+			if p.accept("=") {
+				s.Exprs = p.exprList()
+			}
+			return s
+		case "break":
+			p.advance()
+			if !p.inLoop() {
+				p.fail(line, fmt.Sprintf("<break> at line %d not inside a loop", line))
+			}
+			return &BreakStmt{Pos: pos}
+		case "goto":
+			p.advance()
+			label := p.name()
+			p.fn.block.gotos = append(p.fn.block.gotos, pendingGoto{label: label, line: line})
+			return &GotoStmt{Pos: pos, Label: label}
+		}
+	}
+	if p.accept(";") {
+		return nil
+	}
+	if p.accept("::") {
+		name := p.name()
+		if l, ok := p.fn.block.labels[name]; ok {
+			p.fail(line, fmt.Sprintf("label '%s' already defined on line %d", name, l))
+		}
+		p.fn.block.labels[name] = line
+		p.expect("::")
+		return &LabelStmt{Pos: pos, Name: name}
+	}
+	return p.exprStmt()
+}
+
+func (p *parser) inLoop() bool {
+	for b := p.fn.block; b != nil; b = b.parent {
+		if b.loop {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) ifStmt() Stmt {
+	line := p.tok.Line
+	s := &IfStmt{Pos: Pos{line}}
+	for {
+		p.advance() // if or elseif
+		s.Conds = append(s.Conds, p.expr())
+		p.expect("then")
+		s.Blocks = append(s.Blocks, p.block())
+		if !p.is("elseif") {
+			break
+		}
+	}
+	if p.accept("else") {
+		s.Else = p.block()
+	}
+	p.expectMatch("end", "if", line)
+	return s
+}
+
+func (p *parser) forStmt() Stmt {
+	line := p.tok.Line
+	p.advance()
+	first := p.name()
+	switch {
+	case p.accept("="):
+		s := &NumericForStmt{Pos: Pos{line}, Name: first}
+		s.Start = p.expr()
+		p.expect(",")
+		s.Limit = p.expr()
+		if p.accept(",") {
+			s.Step = p.expr()
+		}
+		p.expect("do")
+		s.Block = p.scopedBlock(true)
+		p.expectMatch("end", "for", line)
+		return s
+	case p.is(",") || p.is("in"):
+		s := &GenericForStmt{Pos: Pos{line}, Names: []string{first}}
+		for p.accept(",") {
+			s.Names = append(s.Names, p.name())
+		}
+		p.expect("in")
+		s.Exprs = p.exprList()
+		p.expect("do")
+		s.Block = p.scopedBlock(true)
+		p.expectMatch("end", "for", line)
+		return s
+	}
+	p.errorf("'=' or 'in' expected")
+	return nil
+}
+
+// body parses the parameters and block of a function, starting at (
+func (p *parser) body(method bool, line int) *FunctionExpr {
+	f := &FunctionExpr{Pos: Pos{line}}
+	if method {
+		f.Params = append(f.Params, "self")
+	}
+	p.expect("(")
+	if !p.is(")") {
+		for {
+			if p.accept("...") {
+				f.Vararg = true
+				break
+			}
+			f.Params = append(f.Params, p.name())
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	p.expect(")")
+	p.openFunction(f.Vararg)
+	f.Block = p.block()
+	p.closeFunction()
+	p.expectMatch("end", "function", line)
+	return f
+}
+
+func (p *parser) exprStmt() Stmt {
+	line := p.tok.Line
+	e := p.suffixedExpr()
+	if p.is("=") || p.is(",") {
+		s := &AssignStmt{Pos: Pos{line}, Targets: []Expr{e}}
+		for p.accept(",") {
+			s.Targets = append(s.Targets, p.suffixedExpr())
+		}
+		for _, t := range s.Targets {
+			switch t.(type) {
+			case *NameExpr, *IndexExpr:
+			default:
+				p.errorf("syntax error")
+			}
+		}
+		p.expect("=")
+		s.Exprs = p.exprList()
+		return s
+	}
+	call, ok := e.(*CallExpr)
+	if !ok {
+		p.errorf("syntax error")
+	}
+	return &CallStmt{Pos: Pos{line}, Call: call}
+}
+
+func (p *parser) exprList() []Expr {
+	list := []Expr{p.expr()}
+	for p.accept(",") {
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+func (p *parser) primaryExpr() Expr {
+	line := p.tok.Line
+	switch {
+	case p.tok.Type == Name:
+		return &NameExpr{Pos: Pos{line}, Name: p.name()}
+	case p.accept("("):
+		e := p.expr()
+		p.expectMatch(")", "(", line)
+		return &ParenExpr{Pos: Pos{line}, X: e}
+	}
+	p.errorf("unexpected symbol")
+	return nil
+}
+
+func (p *parser) suffixedExpr() Expr {
+	e := p.primaryExpr()
+	for {
+		line := p.tok.Line
+		switch {
+		case p.accept("."):
+			keyLine := p.tok.Line
+			e = &IndexExpr{Pos: Pos{e.Line()}, Obj: e, Key: &StringExpr{Pos: Pos{keyLine}, Value: p.name()}}
+		case p.accept("["):
+			key := p.expr()
+			p.expect("]")
+			e = &IndexExpr{Pos: Pos{e.Line()}, Obj: e, Key: key}
+		case p.accept(":"):
+			method := p.name()
+			e = &CallExpr{Pos: Pos{e.Line()}, Func: e, Method: method, Args: p.callArgs(line)}
+		case p.is("(") || p.is("{") || p.tok.Type == String:
+			e = &CallExpr{Pos: Pos{e.Line()}, Func: e, Args: p.callArgs(line)}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *parser) callArgs(line int) []Expr {
+	switch {
+	case p.tok.Type == String:
+		s := &StringExpr{Pos: Pos{p.tok.Line}, Value: p.tok.Value}
+		p.advance()
+		return []Expr{s}
+	case p.is("{"):
+		return []Expr{p.table()}
+	case p.accept("("):
+		var args []Expr
+		if !p.is(")") {
+			args = p.exprList()
+		}
+		p.expectMatch(")", "(", line)
+		return args
+	}
+	p.errorf("function arguments expected")
+	return nil
+}
+
+func (p *parser) table() Expr {
+	line := p.tok.Line
+	t := &TableExpr{Pos: Pos{line}}
+	p.expect("{")
+	for !p.is("}") {
+		switch {
+		case p.tok.Type == Name && p.lookahead().Type == Op && p.lookahead().Value == "=":
+			key := &StringExpr{Pos: Pos{p.tok.Line}, Value: p.name()}
+			p.advance() // =
+			t.Fields = append(t.Fields, Field{Key: key, Value: p.expr()})
+		case p.accept("["):
+			key := p.expr()
+			p.expect("]")
+			p.expect("=")
+			t.Fields = append(t.Fields, Field{Key: key, Value: p.expr()})
+		default:
+			t.Fields = append(t.Fields, Field{Value: p.expr()})
+		}
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	p.expectMatch("}", "{", line)
+	return t
+}
+
+func (p *parser) simpleExpr() Expr {
+	pos := Pos{p.tok.Line}
+	switch p.tok.Type {
+	case Number:
+		e := &NumberExpr{Pos: pos, Value: p.tok.Value}
+		p.advance()
+		return e
+	case String:
+		e := &StringExpr{Pos: pos, Value: p.tok.Value}
+		p.advance()
+		return e
+	}
+	switch {
+	case p.accept("nil"):
+		return &NilExpr{pos}
+	case p.accept("true"):
+		return &TrueExpr{pos}
+	case p.accept("false"):
+		return &FalseExpr{pos}
+	case p.is("..."):
+		if !p.fn.vararg {
+			p.errorf("cannot use '...' outside a vararg function")
+		}
+		p.advance()
+		return &VarargExpr{pos}
+	case p.is("{"):
+		return p.table()
+	case p.accept("function"):
+		return p.body(false, pos.L)
+	}
+	return p.suffixedExpr()
+}
+
+// binary operators and their left and right priority
+var binaryPriority = map[string][2]int{
+	"+": {6, 6}, "-": {6, 6}, "*": {7, 7}, "/": {7, 7}, "%": {7, 7},
+	"^": {10, 9}, "..": {5, 4},
+	"==": {3, 3}, "~=": {3, 3}, "<": {3, 3}, "<=": {3, 3}, ">": {3, 3}, ">=": {3, 3},
+	"and": {2, 2}, "or": {1, 1},
+}
+
+const unaryPriority = 8
+
+func (p *parser) expr() Expr {
+	return p.subExpr(0)
+}
+
+// subExpr parses an expression whose binary operators bind stronger than limit
+func (p *parser) subExpr(limit int) Expr {
+	var e Expr
+	if (p.tok.Type == Op || p.tok.Type == Keyword) && (p.tok.Value == "not" || p.tok.Value == "-" || p.tok.Value == "#") {
+		u := &UnaryExpr{Pos: Pos{p.tok.Line}, Op: p.tok.Value}
+		p.advance()
+		u.X = p.subExpr(unaryPriority)
+		e = u
+	} else {
+		e = p.simpleExpr()
+	}
+	for p.tok.Type == Op || p.tok.Type == Keyword {
+		prio, ok := binaryPriority[p.tok.Value]
+		if !ok || prio[0] <= limit {
+			break
+		}
+		b := &BinaryExpr{Pos: Pos{e.Line()}, Op: p.tok.Value, L: e}
+		p.advance()
+		b.R = p.subExpr(prio[1])
+		e = b
+	}
+	return e
+}
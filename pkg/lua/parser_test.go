@@ -0,0 +1,134 @@
+package lua
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse_Valid(t *testing.T) {
+	tests := []string{
+		``,
+		`local a, b = 1, 0x1F`,
+		`x = 3.5e-2 + .5 - 0xA.8p1 * 2 ^ -3 ^ 2 .. "s" .. 'q'`,
+		`local t = {1, 2; x = 3, ["y"] = 4, [5] = {}, }`,
+		`print "hello" print {1} print([[long
+string]])`,
+		`local s = "esc \a\b\f\n\r\t\v\\\"\'\x41\65\z
+		     continued"`,
+		`--[==[ long
+comment ]==] x = 1 -- line comment`,
+		`function a.b.c:m(x, ...) return self, ... end`,
+		`local function f() return end`,
+		`for i = 1, 10, 2 do if i > 5 then break elseif i then else end end`,
+		`for k, v in pairs(t) do while true do repeat local x = k until x end end`,
+		`do goto skip; print(1) ::skip:: end`,
+		`::top:: do goto top end`,
+		`a, b.c, d[1] = f(), g:h(), not #t`,
+		`local x = (f)().y:z "s" {1}`,
+		`return f(...)`,
+		`#!/usr/bin/lua
+print(1)`,
+		`local x = function(...) local a = ... end;;`,
+		`local r = a and b or c == d ~= e <= f >= g < h > i % j / k`,
+		`fibaro:call(12, "turnOn") if (fibaro:countScenes() > 1) then fibaro:abort() end`,
+	}
+	for _, src := range tests {
+		if _, err := Parse(src); err != nil {
+			t.Errorf("Parse(%q) = %v", src, err)
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`x = = 1`, "line 1: unexpected symbol near '='"},
+		{`x = 1 +`, "line 1: unexpected symbol near '<eof>'"},
+		{"if x then\n  y = 1\n", "line 3: 'end' expected (to close 'if' at line 1) near '<eof>'"},
+		{"function f()\nreturn 1\nx = 2\nend", "line 3: 'end' expected (to close 'function' at line 1) near 'x'"},
+		{`f(1, 2`, "line 1: ')' expected near '<eof>'"},
+		{"f(1,\n2", "line 2: ')' expected (to close '(' at line 1) near '<eof>'"},
+		{`x`, "line 1: syntax error near '<eof>'"},
+		{`f() = 1`, "line 1: syntax error near '='"},
+		{`local 1 = 2`, "line 1: <name> expected near '1'"},
+		{`for i do end`, "line 1: '=' or 'in' expected near 'do'"},
+		{`s = "abc`, "line 1: unfinished string near '<eof>'"},
+		{"s = \"abc\nd\"", "line 1: unfinished string near '\"abc'"},
+		{`s = "\q"`, "line 1: invalid escape sequence near '\"\\q'"},
+		{`s = "\300"`, "line 1: decimal escape too large near '\"\\300'"},
+		{`s = [[abc`, "line 1: unfinished long string near '<eof>'"},
+		{"--[[ comment\n", "line 2: unfinished long comment near '<eof>'"},
+		{`x = 3x`, "line 1: malformed number near '3x'"},
+		{`x = 1 @ 2`, "line 1: unexpected symbol near '@'"},
+		{"\nbreak", "line 2: <break> at line 2 not inside a loop"},
+		{"goto nowhere", "line 1: no visible label 'nowhere' for goto at line 1"},
+		{"::a:: ::a::", "line 1: label 'a' already defined on line 1"},
+		{`function f() return ... end`, "line 1: cannot use '...' outside a vararg function near '...'"},
+		{"if x then else elseif y then end", "line 1: 'end' expected near 'elseif'"},
+		{"end", "line 1: '<eof>' expected near 'end'"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want %s", tt.src, tt.want)
+			continue
+		}
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Parse(%q) returned %T, want *SyntaxError", tt.src, err)
+		}
+		if err.Error() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestParse_Fixtures(t *testing.T) {
+	// the other lua-files are fixtures for the require expansion and not valid Lua
+	files := []string{"SZAllLightsOff.lua", "doubleHeader.lua", "noHeader.lua", "shortHeader.lua",
+		"shortHeader2.lua", "shortHeader3.lua", "shortHeaderNew.lua", "simpleLua.lua", "triggers.lua", "xref.lua"}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(filepath.Join("../../test", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(string(b)); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+func TestParse_Tree(t *testing.T) {
+	chunk, err := Parse("-- turn on\nfibaro:call(12, 'turnOn')\nlocal x = 1 + 2 * 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.Comments) != 1 || chunk.Comments[0].Text != " turn on" {
+		t.Errorf("Comments = %#v", chunk.Comments)
+	}
+	call := chunk.Block.Stmts[0].(*CallStmt).Call
+	if call.Method != "call" || call.Func.(*NameExpr).Name != "fibaro" || call.Line() != 2 {
+		t.Errorf("call = %#v", call)
+	}
+	if len(call.Args) != 2 || call.Args[1].(*StringExpr).Value != "turnOn" {
+		t.Errorf("args = %#v", call.Args)
+	}
+	sum := chunk.Block.Stmts[1].(*LocalStmt).Exprs[0].(*BinaryExpr)
+	if sum.Op != "+" || sum.R.(*BinaryExpr).Op != "*" {
+		t.Errorf("1 + 2 * 3 parsed as %#v", sum)
+	}
+
+	var names []string
+	Inspect(chunk.Block, func(n Node) bool {
+		if e, ok := n.(*NameExpr); ok {
+			names = append(names, e.Name)
+		}
+		return true
+	})
+	if strings.Join(names, ",") != "fibaro" {
+		t.Errorf("Inspect found names %v", names)
+	}
+}
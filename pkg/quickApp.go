@@ -0,0 +1,267 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the files a QuickApp is unpacked into by WriteDir
+const (
+	QuickAppManifestFile string = "quickapp.json"
+	QuickAppExtension    string = ".fqa"
+)
+
+// Hc3QuickApp represents a QuickApp of the HC3 system in the format of an
+// exported .fqa file. Can be encoded as JSON.
+type Hc3QuickApp struct {
+	ID                int                        `json:"id,omitempty"` // the device id, not part of an export from the HC3
+	Name              string                     `json:"name"`
+	Type              string                     `json:"type"`
+	APIVersion        string                     `json:"apiVersion,omitempty"`
+	InitialProperties map[string]json.RawMessage `json:"initialProperties,omitempty"`
+	InitialInterfaces []string                   `json:"initialInterfaces"`
+	Files             []Hc3QuickAppFile          `json:"files"`
+}
+
+// Hc3QuickAppFile represents one Lua file of a QuickApp. Can be encoded as JSON.
+type Hc3QuickAppFile struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	IsMain  bool   `json:"isMain"`
+	IsOpen  bool   `json:"isOpen"`
+	Content string `json:"content,omitempty"`
+}
+
+// ReadQuickApp decodes a QuickApp from an .fqa file
+func ReadQuickApp(r io.Reader) (Hc3QuickApp, error) {
+	var qa Hc3QuickApp
+	if err := json.NewDecoder(r).Decode(&qa); err != nil {
+		return qa, fmt.Errorf("decoding QuickApp: %w", err)
+	}
+	return qa, nil
+}
+
+// WriteFqa writes the QuickApp as .fqa file to path
+func (qa Hc3QuickApp) WriteFqa(path string) error {
+	b, err := json.MarshalIndent(qa, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// WriteDir unpacks the QuickApp into dir. Every file of the QuickApp is written
+// to <name>.lua, all other information to quickapp.json. Lua files in dir that are
+// not part of the QuickApp, e.g. files deleted in the HC3, are removed, as they
+// would be added to the QuickApp by ReadQuickAppDir.
+func (qa Hc3QuickApp) WriteDir(dir string) error {
+	files := map[string]bool{}
+	for _, file := range qa.Files {
+		if err := checkQuickAppFileName(file.Name); err != nil {
+			return err
+		}
+		files[file.Name+".lua"] = true
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		if files[filepath.Base(file)] {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	manifest := qa
+	manifest.Files = make([]Hc3QuickAppFile, len(qa.Files))
+	for i, file := range qa.Files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file.Name+".lua"), []byte(file.Content), 0644); err != nil {
+			return err
+		}
+		file.Content = ""
+		manifest.Files[i] = file
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, QuickAppManifestFile), append(b, '\n'), 0644)
+}
+
+// ReadQuickAppDir rebuilds a QuickApp from a directory written by WriteDir. Lua
+// files not listed in quickapp.json are added as further files of the QuickApp.
+func ReadQuickAppDir(dir string) (Hc3QuickApp, error) {
+	var qa Hc3QuickApp
+	_, b, err := readFile(filepath.Join(dir, QuickAppManifestFile))
+	if err != nil {
+		return qa, err
+	}
+	if err := json.Unmarshal(b, &qa); err != nil {
+		return qa, fmt.Errorf("%s: %w", QuickAppManifestFile, err)
+	}
+
+	listed := map[string]bool{}
+	for i, file := range qa.Files {
+		if err := checkQuickAppFileName(file.Name); err != nil {
+			return qa, fmt.Errorf("%s: %w", QuickAppManifestFile, err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name+".lua"))
+		if err != nil {
+			return qa, err
+		}
+		qa.Files[i].Content = string(b)
+		listed[file.Name] = true
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return qa, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".lua")
+		if listed[name] {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return qa, err
+		}
+		qa.Files = append(qa.Files, Hc3QuickAppFile{Name: name, Type: "lua", Content: string(b)})
+	}
+	return qa, nil
+}
+
+// checkQuickAppFileName returns an error if name can not be used as the name of a
+// file in the directory of a QuickApp, as it is empty or contains a path separator or ..
+func checkQuickAppFileName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid name of QuickApp file: %q", name)
+	}
+	return nil
+}
+
+// AllQuickApps returns all devices of the HC3 system that are QuickApps
+func (f *FibaroHc3) AllQuickApps() ([]Hc2Device, error) {
+	devices, err := f.AllDevices()
+	if err != nil {
+		return nil, err
+	}
+	var qas []Hc2Device
+	for _, d := range devices {
+		if d.Implements("quickApp") {
+			qas = append(qas, d)
+		}
+	}
+	return qas, nil
+}
+
+// ExportQuickApp downloads the QuickApp with the given device id in the format of an .fqa file
+func (f *FibaroHc3) ExportQuickApp(id int) (Hc3QuickApp, error) {
+	var qa Hc3QuickApp
+	if err := f.getJSON("/quickApp/export/"+strconv.Itoa(id), &qa); err != nil {
+		return qa, err
+	}
+	qa.ID = id
+	return qa, nil
+}
+
+// QuickAppFiles downloads the list of files of a QuickApp. The content of the files is not included.
+func (f *FibaroHc3) QuickAppFiles(id int) ([]Hc3QuickAppFile, error) {
+	var files []Hc3QuickAppFile
+	if err := f.getJSON("/quickApp/"+strconv.Itoa(id)+"/files", &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// PutQuickAppFiles uploads the files of qa to the existing QuickApp qa.ID. Files
+// are updated or created, files of the QuickApp missing in qa are deleted.
+// If the QuickApp does not exist in the HC3 the returned error matches ErrNotFound.
+func (f *FibaroHc3) PutQuickAppFiles(qa Hc3QuickApp) error {
+	existing, err := f.QuickAppFiles(qa.ID)
+	if err != nil {
+		return err
+	}
+	remaining := map[string]bool{}
+	for _, file := range existing {
+		remaining[file.Name] = true
+	}
+
+	cmd := "/quickApp/" + strconv.Itoa(qa.ID) + "/files"
+	var update []Hc3QuickAppFile
+	for _, file := range qa.Files {
+		if !remaining[file.Name] {
+			b, err := json.Marshal(file)
+			if err != nil {
+				return err
+			}
+			resp, err := requestPost(f.cfg, cmd, b)
+			if err := checkResponse(http.MethodPost, cmd, resp, err); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(remaining, file.Name)
+		update = append(update, file)
+	}
+
+	if len(update) > 0 {
+		b, err := json.Marshal(update)
+		if err != nil {
+			return err
+		}
+		resp, err := requestPut(f.cfg, cmd, b)
+		if err := checkResponse(http.MethodPut, cmd, resp, err); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range existing {
+		if !remaining[file.Name] || file.IsMain {
+			continue
+		}
+		del := cmd + "/" + url.PathEscape(file.Name)
+		resp, err := requestDelete(f.cfg, del)
+		if err := checkResponse(http.MethodDelete, del, resp, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportQuickApp creates a new QuickApp from qa, as if the .fqa file was imported
+// in the web interface. Returns the device id of the new QuickApp.
+func (f *FibaroHc3) ImportQuickApp(qa Hc3QuickApp) (newID int, err error) {
+	qa.ID = 0
+	b, err := json.Marshal(qa)
+	if err != nil {
+		return -1, err
+	}
+	const cmd = "/quickApp/import"
+	resp, err := requestPostFile(f.cfg, cmd, "file", qa.Name+QuickAppExtension, b)
+	if err := checkResponse(http.MethodPost, cmd, resp, err); err != nil {
+		return -1, err
+	}
+	var d Hc2Device
+	if err := json.Unmarshal(resp.Body(), &d); err != nil {
+		return -1, decodeError(http.MethodPost, cmd, resp, err)
+	}
+	return d.ID, nil
+}
@@ -0,0 +1,141 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func readQuickAppFixture(t *testing.T) Hc3QuickApp {
+	file, err := os.Open("../test/quickApp40.fqa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	qa, err := ReadQuickApp(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return qa
+}
+
+func TestHc3QuickApp_WriteDir(t *testing.T) {
+	qa := readQuickAppFixture(t)
+	qa.ID = 40
+
+	dir, err := ioutil.TempDir("", "hc3qa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := qa.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "utils.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, string(b), qa.Files[1].Content)
+
+	got, err := ReadQuickAppDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the initial properties are indented in quickapp.json, so compare them encoded
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(qa)
+	AssertEqual(t, string(gotJSON), string(wantJSON))
+
+	// new Lua files are added to the QuickApp
+	if err := ioutil.WriteFile(filepath.Join(dir, "extra.lua"), []byte("x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ReadQuickAppDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files) != 3 {
+		t.Fatalf("ReadQuickAppDir() has %d files, want 3", len(got.Files))
+	}
+	AssertEqual(t, got.Files[2], Hc3QuickAppFile{Name: "extra", Type: "lua", Content: "x = 1\n"})
+
+	// files no longer part of the QuickApp are removed
+	if err := qa.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "extra.lua")); !os.IsNotExist(err) {
+		t.Errorf("WriteDir() should remove extra.lua, got %v", err)
+	}
+
+	os.Remove(filepath.Join(dir, "main.lua"))
+	if _, err := ReadQuickAppDir(dir); err == nil {
+		t.Error("ReadQuickAppDir() with missing main.lua should fail")
+	}
+
+	for _, name := range []string{"../escape", "sub/file", `sub\file`, ""} {
+		bad := qa
+		bad.Files = []Hc3QuickAppFile{{Name: name, Type: "lua"}}
+		if err := bad.WriteDir(dir); err == nil {
+			t.Errorf("WriteDir() with file %q should fail", name)
+		}
+	}
+}
+
+func TestFibaroHc3_ExportQuickApp(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	fixture, _ := ioutil.ReadFile("../test/quickApp40.fqa")
+	httpmock.RegisterResponder(http.MethodGet, "http://192.10.66.55/api/quickApp/export/40", httpmock.NewBytesResponder(200, fixture))
+
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	qa, err := f.ExportQuickApp(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, qa.ID, 40)
+	AssertEqual(t, qa.Name, "Shelly Plug")
+	AssertEqual(t, len(qa.Files), 2)
+	AssertEqual(t, qa.Files[0].IsMain, true)
+}
+
+func TestFibaroHc3_PutQuickAppFiles(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	const files = "http://192.10.66.55/api/quickApp/40/files"
+	httpmock.RegisterResponder(http.MethodGet, files, httpmock.NewStringResponder(200,
+		`[{"name":"main","type":"lua","isMain":true,"isOpen":true},{"name":"old","type":"lua","isMain":false,"isOpen":false}]`))
+	var put []Hc3QuickAppFile
+	httpmock.RegisterResponder(http.MethodPut, files, func(req *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(req.Body).Decode(&put); err != nil {
+			return nil, err
+		}
+		return httpmock.NewStringResponse(200, ""), nil
+	})
+	httpmock.RegisterResponder(http.MethodPost, files, httpmock.NewStringResponder(200, `{}`))
+	httpmock.RegisterResponder(http.MethodDelete, files+"/old", httpmock.NewStringResponder(200, ""))
+
+	qa := readQuickAppFixture(t)
+	qa.ID = 40
+	f := &FibaroHc3{FibaroHc2{*cfg}}
+	if err := f.PutQuickAppFiles(qa); err != nil {
+		t.Fatal(err)
+	}
+
+	info := httpmock.GetCallCountInfo()
+	AssertEqual(t, info["POST "+files], 1)
+	AssertEqual(t, info["PUT "+files], 1)
+	AssertEqual(t, info["DELETE "+files+"/old"], 1)
+	if len(put) != 1 || put[0].Name != "main" || put[0].Content != qa.Files[0].Content {
+		t.Errorf("PutQuickAppFiles() updated %#v", put)
+	}
+}
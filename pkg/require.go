@@ -0,0 +1,252 @@
+package fibarohc2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultLuaPath is the search path for libraries used if none is given. As in LUA_PATH
+// the templates are separated by ; and ? is replaced by the module name.
+const DefaultLuaPath = "?.lua;?/init.lua"
+
+// RequireStatement matches the require statements expanded by a RequireExpander: a require
+// at the beginning of a line, optionally assigned to a local variable, as in
+//
+//	require("lib.debug")
+//	local json = require "json"
+//
+// The submatches are the local variable and the module name, in one of four groups
+// depending on the quotes and parentheses used.
+var RequireStatement = regexp.MustCompile(`^\s*(?:local\s+([A-Za-z_]\w*)\s*=\s*)?require\s*(?:\(\s*(?:'([^']*)'|"([^"]*)")\s*\)|'([^']*)'|"([^"]*)");?`)
+
+// DefaultRequireIgnore matches the require statements commented out, but not expanded,
+// before a scene is uploaded.
+// TODO: Find a reasonable way to parametrize the libary2Ignore feature
+var DefaultRequireIgnore = regexp.MustCompile(`library2Ignore`)
+
+// RequireExpander replaces the require statements of lua-scripts by the libraries they
+// require, as the HC2 does not support require. Each library is inlined once, at the
+// first require statement, later ones are commented out. A library whose value is
+// assigned to a local variable is wrapped into a function, its result is kept in the
+// global table __hc2Modules.
+type RequireExpander struct {
+	Path   []string       // templates searched for a module, ? is replaced by the module name with . replaced by /
+	Dir    string         // directory relative templates are resolved in, the current directory if empty
+	Ignore *regexp.Regexp // require statements matching Ignore are commented out, but not expanded
+
+	loaded map[string]requiredModule // resolved path of the modules inlined
+	stack  []string                  // modules being expanded, to detect circular requires
+}
+
+type requiredModule struct {
+	name   string     // name of the module in the require statement inlining it
+	origin SourceLine // the require statement inlining the module
+	value  bool       // if the value of the module is kept
+}
+
+// NewRequireExpander returns a RequireExpander searching the templates of luaPath,
+// separated by ;
+func NewRequireExpander(luaPath string) *RequireExpander {
+	e := &RequireExpander{}
+	for _, t := range strings.Split(luaPath, ";") {
+		if t = strings.TrimSpace(t); t != "" {
+			e.Path = append(e.Path, t)
+		}
+	}
+	return e
+}
+
+// Expand replaces the require statements in lua, read from file, by the content of the
+// required libraries. The returned SourceMap gives the origin of every line. Missing
+// and circular modules are reported as *LuaError of kind ErrRequire.
+func (e *RequireExpander) Expand(lua, file string) (string, SourceMap, error) {
+	e.loaded = map[string]requiredModule{}
+	e.stack = nil
+	return e.expand(lua, file)
+}
+
+// PrepareLua expands the require statements of lua, read from file, with e and checks
+// the syntax of the result, as done before a scene is uploaded. If e is nil the require
+// statements are kept. A syntax error is returned as *LuaError of kind ErrLuaSyntax
+// together with the expanded code, which can still be uploaded. For all other errors
+// the code is not returned.
+func PrepareLua(e *RequireExpander, lua, file string) (string, SourceMap, error) {
+	var sourceMap SourceMap
+	if e != nil {
+		var err error
+		if lua, sourceMap, err = e.Expand(lua, file); err != nil {
+			return "", nil, err
+		}
+	} else {
+		for n := 1; n <= lineCount(lua); n++ {
+			sourceMap.Add(file, n)
+		}
+	}
+	return lua, sourceMap, CheckLuaSyntax(lua, file, sourceMap)
+}
+
+// Resolve returns the file of module, or an error listing the files searched
+func (e *RequireExpander) Resolve(module string) (string, error) {
+	name := strings.Replace(module, ".", "/", -1)
+	var tried []string
+	for _, t := range e.Path {
+		path := strings.Replace(t, "?", name, -1)
+		if e.Dir != "" && !filepath.IsAbs(t) {
+			path = filepath.Join(e.Dir, path)
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+		tried = append(tried, path)
+	}
+	return "", fmt.Errorf("module '%s' not found, no file %s", module, strings.Join(tried, ", "))
+}
+
+func (e *RequireExpander) expand(lua, file string) (string, SourceMap, error) {
+	var sb strings.Builder
+	var sourceMap SourceMap
+	expanded := false
+	for n, text := range luaLines(lua) {
+		line := n + 1
+		i := RequireStatement.FindStringSubmatchIndex(text)
+		if i == nil {
+			sb.WriteString(text + "\n")
+			sourceMap.Add(file, line)
+			continue
+		}
+		expanded = true
+		stmt, rest := text[:i[1]], text[i[1]:]
+		if e.Ignore != nil && e.Ignore.MatchString(stmt) {
+			sb.WriteString(slComment(stmt) + rest + "\n")
+			sourceMap.Add(file, line)
+			continue
+		}
+
+		m := RequireStatement.FindStringSubmatch(stmt)
+		module := m[2] + m[3] + m[4] + m[5]
+		library, header, libraryMap, err := e.inline(stmt, m[1], module, SourceLine{File: file, Line: line})
+		if err != nil {
+			return "", nil, err
+		}
+		library += rest + "\n"
+		sb.WriteString(library)
+		// the library follows the header lines, all other lines are the require statement's
+		for n := 0; n < strings.Count(library, "\n"); n++ {
+			if n >= header && n-header < len(libraryMap) {
+				sourceMap = append(sourceMap, libraryMap[n-header])
+			} else {
+				sourceMap.Add(file, line)
+			}
+		}
+	}
+	if !expanded {
+		sourceMap = sourceMap[:0]
+		for n := 1; n <= lineCount(lua); n++ {
+			sourceMap.Add(file, n)
+		}
+		return lua, sourceMap, nil
+	}
+	return sb.String(), sourceMap, nil
+}
+
+// inline returns the replacement of the require statement stmt at origin, and the number
+// of lines before the library in it. The result of the library is assigned to variable,
+// if not empty.
+func (e *RequireExpander) inline(stmt, variable, module string, origin SourceLine) (string, int, SourceMap, error) {
+	fail := func(format string, args ...interface{}) (string, int, SourceMap, error) {
+		return "", 0, nil, &LuaError{SourceLine: origin, Msg: fmt.Sprintf(format, args...), Kind: ErrRequire}
+	}
+	path, err := e.Resolve(module)
+	if err != nil {
+		return fail("%v", err)
+	}
+	path = filepath.Clean(path)
+
+	for i, p := range e.stack {
+		if p == path {
+			return fail("circular require of module '%s': %s -> %s", module, strings.Join(e.stack[i:], " -> "), path)
+		}
+	}
+	if m, ok := e.loaded[path]; ok {
+		switch {
+		case variable == "":
+			return slComment(stmt + " <-- ALREADY EXPANDED"), 0, nil, nil
+		case !m.value:
+			return fail("module '%s' is required with its value, but already expanded without at %s:%d", module, m.origin.File, m.origin.Line)
+		}
+		return slComment(stmt+" <-- ALREADY EXPANDED") + "\nlocal " + variable + " = " + moduleValue(m.name), 0, nil, nil
+	}
+
+	f, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fail("%v", err)
+	}
+	e.loaded[path] = requiredModule{name: module, origin: origin, value: variable != ""}
+	e.stack = append(e.stack, path)
+	library, sourceMap, err := e.expand(string(f), path)
+	e.stack = e.stack[:len(e.stack)-1]
+	if err != nil {
+		return "", 0, nil, err
+	}
+	// a library not ending with a newline is followed directly by the end marker
+	if !strings.HasSuffix(string(f), "\n") {
+		library = strings.TrimSuffix(library, "\n")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(slComment(stmt))
+	sb.WriteString(`
+-- LIBRARY BEGIN -------------------------
+-- DO NOT MODIFY THE CODE
+`)
+	header := 3
+	if variable != "" {
+		sb.WriteString("__hc2Modules = __hc2Modules or {}\n")
+		sb.WriteString(moduleValue(module) + " = (function(...)\n")
+		header += 2
+	}
+	sb.WriteString(library)
+	if variable != "" {
+		sb.WriteString("\nend)(" + fmt.Sprintf("%q", module) + ")")
+	}
+	sb.WriteString("\n-- LIBRARY END -------------------------\n")
+	if variable != "" {
+		sb.WriteString("local " + variable + " = " + moduleValue(module))
+	}
+	return sb.String(), header, sourceMap, nil
+}
+
+// moduleValue returns the Lua expression of the value of an inlined module
+func moduleValue(module string) string {
+	return fmt.Sprintf("__hc2Modules[%q]", module)
+}
+
+// slComment comments out a line replaced by the expansion
+func slComment(s string) string {
+	return "--^ " + s
+}
+
+// lineCount returns the number of lines of s, the last one not necessarily terminated by \n
+// luaLines splits lua into its lines, without line terminators. Unlike a bufio.Scanner
+// it does not limit the length of a line.
+func luaLines(lua string) []string {
+	if lua == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(lua, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
+
+func lineCount(s string) int {
+	if s == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(s, "\n"), "\n") + 1
+}
@@ -0,0 +1,193 @@
+package fibarohc2
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/theovassiliou/hc2-tools/pkg/lua"
+)
+
+// requireFixture creates the files in a temporary directory and returns an expander searching it
+func requireFixture(t *testing.T, files map[string]string) (*RequireExpander, string) {
+	dir, err := ioutil.TempDir("", "require")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := NewRequireExpander("?.lua;lib/?/init.lua")
+	e.Dir = dir
+	return e, dir
+}
+
+func TestRequireExpander_Expand(t *testing.T) {
+	e, dir := requireFixture(t, map[string]string{
+		"debug.lua":         "function debug(s)\n  print(s)\nend\n",
+		"util/str.lua":      "require('debug')\nfunction trim(s) return s end",
+		"lib/json/init.lua": "local M = {}\nfunction M.encode(v) return '' end\nreturn M\n",
+	})
+	defer os.RemoveAll(dir)
+
+	src := "require('util.str') -- strings\nrequire \"debug\"\nlocal json = require \"json\"\nlocal j2 = require('json');\nprint(1)"
+	got, m, err := e.Expand(src, "scene.lua")
+	if err != nil {
+		t.Fatalf("Expand() = %v", err)
+	}
+	want := `--^ require('util.str')
+-- LIBRARY BEGIN -------------------------
+-- DO NOT MODIFY THE CODE
+--^ require('debug')
+-- LIBRARY BEGIN -------------------------
+-- DO NOT MODIFY THE CODE
+function debug(s)
+  print(s)
+end
+
+-- LIBRARY END -------------------------
+
+function trim(s) return s end
+-- LIBRARY END -------------------------
+ -- strings
+--^ require "debug" <-- ALREADY EXPANDED
+--^ local json = require "json"
+-- LIBRARY BEGIN -------------------------
+-- DO NOT MODIFY THE CODE
+__hc2Modules = __hc2Modules or {}
+__hc2Modules["json"] = (function(...)
+local M = {}
+function M.encode(v) return '' end
+return M
+
+end)("json")
+-- LIBRARY END -------------------------
+local json = __hc2Modules["json"]
+--^ local j2 = require('json'); <-- ALREADY EXPANDED
+local j2 = __hc2Modules["json"]
+print(1)
+`
+	AssertEqual(t, got, want)
+	if _, err := lua.Parse(got); err != nil {
+		t.Errorf("expanded script is not valid Lua: %v", err)
+	}
+
+	if len(m) != lineCount(got) {
+		t.Fatalf("SourceMap has %d lines, script %d", len(m), lineCount(got))
+	}
+	origins := map[int]SourceLine{
+		1:  {"scene.lua", 1},
+		4:  {filepath.Join(dir, "util/str.lua"), 1},
+		7:  {filepath.Join(dir, "debug.lua"), 1},
+		9:  {filepath.Join(dir, "debug.lua"), 3},
+		13: {filepath.Join(dir, "util/str.lua"), 2},
+		15: {"scene.lua", 1},
+		16: {"scene.lua", 2},
+		22: {filepath.Join(dir, "lib/json/init.lua"), 1},
+		27: {"scene.lua", 3},
+		30: {"scene.lua", 4},
+		31: {"scene.lua", 5},
+	}
+	for line, want := range origins {
+		if got, _ := m.Origin(line); got != want {
+			t.Errorf("Origin(%d) = %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestRequireExpander_Unchanged(t *testing.T) {
+	e, dir := requireFixture(t, map[string]string{"a.lua": "print('a')\n"})
+	defer os.RemoveAll(dir)
+	e.Ignore = regexp.MustCompile(`library2Ignore`)
+
+	src := "-- require('a')\nprint(require)\nx = 1"
+	got, m, err := e.Expand(src, "scene.lua")
+	if err != nil || got != src || len(m) != 3 {
+		t.Errorf("Expand() = %q, %v, %v", got, m, err)
+	}
+
+	got, _, err = e.Expand("require('library2Ignore/x')\n", "scene.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got, "--^ require('library2Ignore/x')\n")
+}
+
+func TestRequireExpander_Errors(t *testing.T) {
+	e, dir := requireFixture(t, map[string]string{
+		"a.lua":    "x = 1\nrequire('b')\n",
+		"b.lua":    "require('a')\n",
+		"c.lua":    "require('missing.mod')\n",
+		"bare.lua": "y = 2\n",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"require('a')", filepath.Join(dir, "b.lua") + ":1: circular require of module 'a': " +
+			filepath.Join(dir, "a.lua") + " -> " + filepath.Join(dir, "b.lua") + " -> " + filepath.Join(dir, "a.lua")},
+		{"\nrequire('c')", filepath.Join(dir, "c.lua") + ":1: module 'missing.mod' not found, no file " +
+			filepath.Join(dir, "missing/mod.lua") + ", " + filepath.Join(dir, "lib/missing/mod/init.lua")},
+		{"require('bare')\nlocal b = require('bare')", "scene.lua:2: module 'bare' is required with its value, but already expanded without at scene.lua:1"},
+	}
+	for _, tt := range tests {
+		_, _, err := e.Expand(tt.src, "scene.lua")
+		var le *LuaError
+		if !errors.As(err, &le) || !errors.Is(err, ErrRequire) {
+			t.Errorf("Expand(%q) = %v, want *LuaError", tt.src, err)
+			continue
+		}
+		AssertEqual(t, err.Error(), tt.want)
+	}
+}
+
+func TestRequireExpander_LongLines(t *testing.T) {
+	e, dir := requireFixture(t, map[string]string{"a.lua": "print('a')\n"})
+	defer os.RemoveAll(dir)
+
+	long := "s = '" + strings.Repeat("x", 70*1024) + "'"
+	for _, src := range []string{"require('a')\n" + long + "\nprint(1)\n", long + "\nrequire('a')\nprint(1)\n"} {
+		got, m, err := e.Expand(src, "scene.lua")
+		if err != nil {
+			t.Fatalf("Expand() = %v", err)
+		}
+		if !strings.Contains(got, long+"\n") || !strings.HasSuffix(got, "print(1)\n") || !strings.Contains(got, "print('a')") {
+			t.Errorf("Expand() dropped lines, got %d bytes", len(got))
+		}
+		if len(m) != lineCount(got) {
+			t.Errorf("SourceMap has %d lines, script %d", len(m), lineCount(got))
+		}
+	}
+}
+
+func TestPrepareLua(t *testing.T) {
+	e, dir := requireFixture(t, map[string]string{"a.lua": "function a() end\n"})
+	defer os.RemoveAll(dir)
+
+	got, m, err := PrepareLua(e, "require('a')\na()\n", "scene.lua")
+	if err != nil || !strings.Contains(got, "function a() end") || len(m) != lineCount(got) {
+		t.Errorf("PrepareLua() = %q, %v, %v", got, m, err)
+	}
+
+	got, m, err = PrepareLua(nil, "require('a')\na(\n", "scene.lua")
+	var le *LuaError
+	if !errors.As(err, &le) || !errors.Is(err, ErrLuaSyntax) || got != "require('a')\na(\n" || len(m) != 2 {
+		t.Errorf("PrepareLua() = %q, %v, %v, want syntax error", got, m, err)
+	}
+
+	if got, _, err = PrepareLua(e, "require('missing')\n", "scene.lua"); !errors.Is(err, ErrRequire) || got != "" {
+		t.Errorf("PrepareLua() = %q, %v, want ErrRequire", got, err)
+	}
+}
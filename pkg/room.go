@@ -1,8 +1,32 @@
 package fibarohc2
 
+import "encoding/json"
+
 // Hc2Room represents a room in the HC2 system. Can be encoded as JSON.
 type Hc2Room struct {
-	RoomID    int    `json:"id"`
-	Name      string `json:"name"`
-	SectionID int    `json:"sectionID"`
+	RoomID            int            `json:"id"`
+	Name              string         `json:"name"`
+	SectionID         int            `json:"sectionID"`
+	Icon              string         `json:"icon,omitempty"`
+	DefaultSensors    Hc2RoomSensors `json:"defaultSensors"`
+	DefaultThermostat int            `json:"defaultThermostat"`
+	SortOrder         int            `json:"sortOrder,omitempty"`
+}
+
+// Hc2RoomSensors are the devices shown as temperature, humidity and light of a
+// room. 0 if no device is selected.
+type Hc2RoomSensors struct {
+	Temperature int `json:"temperature"`
+	Humidity    int `json:"humidity"`
+	Light       int `json:"light"`
+}
+
+// UnmarshalJSON decodes the default sensors of a room. The HC3 lists the default
+// sensors as array instead, which is ignored.
+func (s *Hc2RoomSensors) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '{' {
+		return nil
+	}
+	type plain Hc2RoomSensors
+	return json.Unmarshal(b, (*plain)(s))
 }
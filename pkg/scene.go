@@ -2,13 +2,11 @@ package fibarohc2
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"io/ioutil"
-	"os"
 	"regexp"
-	"strconv"
 	"strings"
-
-	log "github.com/sirupsen/logrus"
 )
 
 // Possible values to the used as runconfig field. Others are prohibited
@@ -34,6 +32,7 @@ type Hc2Scene struct {
 
 // Hc2DebugMessage represents a debug message of the FibaroHC2 system
 type Hc2DebugMessage struct {
+	ID        int    `json:"id,omitempty"` // increasing id of the message, HC3 only
 	Timestamp int64  `json:"timestamp,omitempty"`
 	Type      string `json:"type,omitempty"`
 	Txt       string `json:"txt,omitempty"`
@@ -52,66 +51,83 @@ func NewHc2Scene() (scene Hc2Scene) {
 }
 
 var m = map[string]*regexp.Regexp{
-	"gitHookComment":       regexp.MustCompile(`(?s)--\[\[ FIBARO_GIT_HOOK(.*?)--\]\]`),
-	"@sceneID":             regexp.MustCompile(`@sceneID=(.*)`),
-	"@name":                regexp.MustCompile(`@name=\"(.*)\"`),
-	"@maxRunningInstances": regexp.MustCompile(`@maxRunningInstances=(.*)`),
-	"@roomID":              regexp.MustCompile(`@roomID=(.*)`),
-	"@runConfig":           regexp.MustCompile(`@runConfig=(.*)`),
-	"@type":                regexp.MustCompile(`@type=\"(.*)\"`),
-	"@autostart":           regexp.MustCompile(`@autostart=(.*)`),
-	"@isLua":               regexp.MustCompile(`@isLua=(.*)`),
-	"@visible":             regexp.MustCompile(`@visble=(.*)`),
+	"gitHookComment": regexp.MustCompile(`(?s)--\[\[ FIBARO_GIT_HOOK(.*?)--\]\]`),
 }
 
-// Parse parses a file input, and extracts the scene information located in comments.
-// Comments are supposed to have the following format
-// 	--[[ FIBARO_GIT_HOOK
-// 	@sceneID=203
-// 	@name="A_Trial_2"
-// 	@roomID=305
-// 	@runConfig=TRIGGER_AND_MANUAL
-// 	@maxRunningInstances=2
-// 	--]]
-// If there is no header included then SceneID will be set to -1, and lua will still contain the file content
-// If there is a header and the appropriate tag is not included then the field will not be touched
-func (scene *Hc2Scene) Parse(input []byte) {
+// Parse parses a file input, and extracts the scene information located in the first
+// FIBARO_GIT_HOOK header. Headers are supposed to have the following format
+//
+//	--[[ FIBARO_GIT_HOOK
+//	@sceneID=203
+//	@name="A_Trial_2"
+//	@roomID=305
+//	@runConfig=TRIGGER_AND_MANUAL
+//	@maxRunningInstances=2
+//	--]]
+//
+// If there is no header included then SceneID will be set to -1, and lua will still contain the file content.
+// A header without @sceneID describes a new scene, and SceneID will be set to -1 as well. If there is a
+// header and any other tag is not included then the field will not be touched.
+// Unknown keys, duplicate keys and malformed values are reported as *HeaderError. In this case only the
+// Lua field is set.
+func (scene *Hc2Scene) Parse(input []byte) error {
 	// in any case we set the Lua field to the content
 	scene.Lua = string(input)
 
-	// check whether we have a header in the []byte
-	i := m["gitHookComment"].FindSubmatchIndex(input)
-	if i == nil {
+	lines, found, err := parseHeader(input)
+	if err != nil {
+		return err
+	}
+	if !found {
 		scene.SceneID = -1
-		// don't need to try to parse the rest
-		return
+		return nil
 	}
-	sceneSetInt(&scene.SceneID, "@sceneID", input)
-	if sceneSetInt(&scene.RoomID, "@roomID", input) == -1 {
-		// TODO: set to default
-		scene.RoomID = 0
+
+	parsed := *scene
+	seen := map[string]bool{}
+	for _, l := range lines {
+		key := l.key
+		if k, ok := legacyHeaderKeys[key]; ok {
+			key = k
+		}
+		var field *sceneHeaderField
+		for i := range sceneHeaderFields {
+			if sceneHeaderFields[i].key == key {
+				field = &sceneHeaderFields[i]
+			}
+		}
+		if field == nil {
+			return &HeaderError{Line: l.line, Msg: "unknown key @" + l.key}
+		}
+		if seen[key] {
+			return &HeaderError{Line: l.line, Msg: "duplicate key @" + l.key}
+		}
+		seen[key] = true
+		if err := field.parse(&parsed, l.value); err != nil {
+			return &HeaderError{Line: l.line, Msg: "@" + l.key + ": " + err.Error()}
+		}
 	}
-	sceneSetString(&scene.Name, "@name", input)
-	if sceneSetInt(&scene.MaxRunningInstances, "@maxRunningInstances", input) == -1 {
-		// TODO: set to default
-		scene.MaxRunningInstances = 0
+	if !seen["sceneID"] {
+		parsed.SceneID = -1
 	}
-	sceneSetString(&scene.RunConfig, "@runConfig", input)
-	sceneSetString(&scene.Type, "@type", input)
-	sceneSetBool(&scene.Autostart, "@autostart", input)
-	sceneSetBool(&scene.IsLua, "@isLua", input)
-	sceneSetBool(&scene.Visible, "@visible", input)
-
+	*scene = parsed
+	return nil
 }
 
 // ParseFile parses a file provided by it's path, and extracts the scene information located in comments, i.e.
 // the FIBARO_GIT_HEADER. If trim set to true, existing FIBARO_GIT_HEADERs will be removed from the Lua field.
-func (scene *Hc2Scene) ParseFile(path string, trim bool) {
-	_, dat := readFile(path)
-	scene.Parse(dat)
+func (scene *Hc2Scene) ParseFile(path string, trim bool) error {
+	_, dat, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	if err := scene.Parse(dat); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
 	if trim {
 		scene.TrimLuaHeaders()
 	}
+	return nil
 }
 
 // TrimLuaHeaders removes all FIBARO_GIT_HOOK headers
@@ -129,11 +145,16 @@ func (scene *Hc2Scene) TrimLuaHeaders() {
 	scene.Lua = string(a)
 }
 
+// HasLuaHeader returns whether the Lua scene contains a FIBARO_GIT_HOOK header
+func (scene *Hc2Scene) HasLuaHeader() bool {
+	return m["gitHookComment"].MatchString(scene.Lua)
+}
+
 // UpdateLuaHeader deletes all headers and appends a new one
 // add the end of the Lua scene with all field set to the Hc2Scene values.
 func (scene *Hc2Scene) UpdateLuaHeader() {
 	scene.TrimLuaHeaders()
-	if string(scene.Lua[len(scene.Lua)-1:]) != "\n" {
+	if !strings.HasSuffix(scene.Lua, "\n") {
 		scene.Lua += "\n"
 	}
 	scene.Lua += scene.ToComment()
@@ -152,82 +173,35 @@ func (scene *Hc2Scene) SanityCheck() (pass bool) {
 	return
 }
 
-// ToComment translates a Hc2Scene into a LUA comment and returns it as string.m
+// ToComment translates a Hc2Scene into a LUA comment and returns it as string.
+// Parse restores all fields, except Lua, from the returned comment.
 func (scene *Hc2Scene) ToComment() string {
 	var str strings.Builder
 
 	_, _ = str.WriteString("--[[ FIBARO_GIT_HOOK - DO NOT CHANGE AS IT WILL BE DISCARDED \n")
-	_, _ = str.WriteString("@sceneID=" + strconv.Itoa(scene.SceneID) + "\n")
-	_, _ = str.WriteString("@name=\"" + scene.Name + "\"\n")
-	_, _ = str.WriteString("@roomID=" + strconv.Itoa(scene.RoomID) + "\n")
-	_, _ = str.WriteString("@autostart=" + strconv.FormatBool(scene.Autostart) + "\n")
-	_, _ = str.WriteString("@runConfig=" + scene.RunConfig + "\n")
-	_, _ = str.WriteString("@maxRunningInstance=" + strconv.Itoa(scene.MaxRunningInstances) + "\n")
-	_, _ = str.WriteString("@type=\"" + scene.Type + "\"\n")
-	_, _ = str.WriteString("@isLua=" + strconv.FormatBool(scene.IsLua) + "\n")
+	for _, f := range sceneHeaderFields {
+		_, _ = str.WriteString("@" + f.key + "=" + f.format(scene) + "\n")
+	}
 	_, _ = str.WriteString("--]]\n")
 	return str.String()
 }
 
-func sceneSetString(v *string, k string, i []byte) string {
-	var submatch [][]byte
-	submatch = m[k].FindSubmatch(i)
-	result := ""
-	if len(submatch) > 0 {
-		result = string(submatch[1])
-
-	}
-	*v = result
-	return result
-}
-
-func sceneSetInt(v *int, k string, i []byte) int {
-	var submatch [][]byte
-	submatch = m[k].FindSubmatch(i)
-	result := -1
-	if len(submatch) > 0 {
-		i, _ := strconv.Atoi(string(submatch[1]))
-		result = i
-	}
-	*v = result
-	return result
-}
-
-func sceneSetBool(v *bool, k string, i []byte) bool {
-	var submatch [][]byte
-	submatch = m[k].FindSubmatch(i)
-	result := *v
-	if len(submatch) > 0 {
-		i, _ := strconv.ParseBool(string(submatch[1]))
-		result = i
-	}
-	*v = result
-	return result
-}
-
-// ReadFile opens a file provided by it's path and returns the number of lines read and the file
+// readFile opens a file provided by it's path and returns the number of lines read and the file
 // contents as byte array.
-func readFile(path string) (int, []byte) {
-	dat, readErr := ioutil.ReadFile(path)
-
-	if readErr != nil {
-		log.Fatal(readErr)
-	}
-
-	file, openErr := os.Open(path)
-	if openErr != nil {
-		log.Fatal(openErr)
+func readFile(path string) (int, []byte, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
 	}
-	defer file.Close()
 
 	var noOfLines int
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(dat))
 	for scanner.Scan() {
 		noOfLines++
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return 0, nil, err
 	}
-	return noOfLines, dat
+	return noOfLines, dat, nil
 }
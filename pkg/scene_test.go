@@ -2,7 +2,10 @@ package fibarohc2
 
 import (
 	"encoding/json"
+	"errors"
+	"reflect"
 	"testing"
+	"testing/quick"
 )
 
 func TestSceneStringTable(t *testing.T) {
@@ -21,9 +24,10 @@ func TestSceneStringTable(t *testing.T) {
 				"@roomID=0\n" +
 				"@autostart=false\n" +
 				"@runConfig=\n" +
-				"@maxRunningInstance=0\n" +
+				"@maxRunningInstances=0\n" +
 				"@type=\"\"\n" +
 				"@isLua=false\n" +
+				"@visible=false\n" +
 				"--]]\n"},
 		{
 			Hc2Scene{
@@ -35,9 +39,10 @@ func TestSceneStringTable(t *testing.T) {
 				"@roomID=0\n" +
 				"@autostart=false\n" +
 				"@runConfig=\n" +
-				"@maxRunningInstance=0\n" +
+				"@maxRunningInstances=0\n" +
 				"@type=\"\"\n" +
 				"@isLua=false\n" +
+				"@visible=false\n" +
 				"--]]\n"},
 	}
 
@@ -92,9 +97,10 @@ func TestSceneUnmarshal(t *testing.T) {
 @roomID=0
 @autostart=false
 @runConfig=TRIGGER_AND_RUN
-@maxRunningInstance=0
+@maxRunningInstances=0
 @type=""
 @isLua=false
+@visible=false
 --]]
 `)
 }
@@ -110,6 +116,7 @@ func TestHc2Scene_ToComment(t *testing.T) {
 		Type                string
 		Autostart           bool
 		IsLua               bool
+		Visible             bool
 	}
 	tests := []struct {
 		name   string
@@ -124,9 +131,10 @@ func TestHc2Scene_ToComment(t *testing.T) {
 @roomID=0
 @autostart=false
 @runConfig=
-@maxRunningInstance=0
+@maxRunningInstances=0
 @type=""
 @isLua=false
+@visible=false
 --]]
 `},
 		{"Valid FullStructAllTrue",
@@ -140,6 +148,7 @@ func TestHc2Scene_ToComment(t *testing.T) {
 				Type:                "com.script",
 				Autostart:           true,
 				IsLua:               true,
+				Visible:             true,
 			},
 			`--[[ FIBARO_GIT_HOOK - DO NOT CHANGE AS IT WILL BE DISCARDED 
 @sceneID=22
@@ -147,9 +156,10 @@ func TestHc2Scene_ToComment(t *testing.T) {
 @roomID=5
 @autostart=true
 @runConfig=MANUAL_ONLY
-@maxRunningInstance=2
+@maxRunningInstances=2
 @type="com.script"
 @isLua=true
+@visible=true
 --]]
 `},
 		{"Valid FullStructAllFalse",
@@ -170,9 +180,10 @@ func TestHc2Scene_ToComment(t *testing.T) {
 @roomID=5
 @autostart=false
 @runConfig=MANUAL_ONLY
-@maxRunningInstance=2
+@maxRunningInstances=2
 @type="com.script"
 @isLua=false
+@visible=false
 --]]
 `},
 		{"Valid FullStructAllNegate",
@@ -186,6 +197,7 @@ func TestHc2Scene_ToComment(t *testing.T) {
 				Type:                "com.script",
 				Autostart:           true,
 				IsLua:               true,
+				Visible:             true,
 			},
 			`--[[ FIBARO_GIT_HOOK - DO NOT CHANGE AS IT WILL BE DISCARDED 
 @sceneID=-1
@@ -193,9 +205,10 @@ func TestHc2Scene_ToComment(t *testing.T) {
 @roomID=-1
 @autostart=true
 @runConfig=MANUAL_ONLY
-@maxRunningInstance=-1
+@maxRunningInstances=-1
 @type="com.script"
 @isLua=true
+@visible=true
 --]]
 `},
 	}
@@ -211,6 +224,7 @@ func TestHc2Scene_ToComment(t *testing.T) {
 				Type:                tt.fields.Type,
 				Autostart:           tt.fields.Autostart,
 				IsLua:               tt.fields.IsLua,
+				Visible:             tt.fields.Visible,
 			}
 			if got := scene.ToComment(); got != tt.want {
 				t.Errorf("Hc2Scene.ToComment() = %v, want %v", got, tt.want)
@@ -232,11 +246,12 @@ func TestHc2Scene_ParseFile(t *testing.T) {
 		{
 			"Reading shortHeader.lua",
 			Hc2Scene{
-				SceneID:   203,
-				RoomID:    305,
-				Name:      "A_Trial_2",
-				Lua:       "../test/shortHeader.lua",
-				RunConfig: "TRIGGER_AND_MANUAL",
+				SceneID:             203,
+				RoomID:              305,
+				Name:                "A_Trial_2",
+				Lua:                 "../test/shortHeader.lua",
+				RunConfig:           "TRIGGER_AND_MANUAL",
+				MaxRunningInstances: 2,
 			},
 			args{"../test/shortHeader.lua"},
 		},
@@ -274,210 +289,161 @@ func TestHc2Scene_ParseFile(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			scene := &Hc2Scene{}
-			scene.ParseFile(tt.args.path, false)
+			if err := scene.ParseFile(tt.args.path, false); err != nil {
+				t.Fatal(err)
+			}
 			AssertHc2SceneEqual(t, *scene, Hc2Scene(tt.fields))
 		})
 	}
 }
 
-func Test_sceneSetInt(t *testing.T) {
-	var i int
-	type args struct {
-		v *int
-		k string
-		i []byte
-	}
+func TestHc2Scene_Parse(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
-		want int
+		name     string
+		input    string
+		want     Hc2Scene
+		wantLine int // line of the HeaderError, 0 if no error is expected
 	}{
 		{
-			"int 1",
-			args{
-				&i,
-				"@sceneID",
-				[]byte(`@sceneID=22`),
-			},
-			22,
+			"all fields",
+			"fibaro:debug(\"hello\")\n" +
+				"--[[ FIBARO_GIT_HOOK - DO NOT CHANGE AS IT WILL BE DISCARDED \n" +
+				"@sceneID=22\n" +
+				"@name=\"A \\\"quoted\\\" name\"\n" +
+				"@roomID=5\n" +
+				"@autostart=true\n" +
+				"@runConfig=MANUAL_ONLY\n" +
+				"@maxRunningInstances=3\n" +
+				"@type=\"com.fibaro.luaScene\"\n" +
+				"@isLua=true\n" +
+				"@visible=false\n" +
+				"--]]\n",
+			Hc2Scene{SceneID: 22, Name: "A \"quoted\" name", RoomID: 5, Autostart: true, RunConfig: ManualOnly,
+				MaxRunningInstances: 3, Type: "com.fibaro.luaScene", IsLua: true, Visible: false},
+			0,
 		},
 		{
-			"int 2",
-			args{
-				&i,
-				"@sceneID",
-				[]byte(`@sceneID=0`),
-			},
+			"legacy maxRunningInstance and unquoted name",
+			"--[[ FIBARO_GIT_HOOK\n" +
+				"@sceneID=22\n" +
+				"@name=Marvel\n" +
+				"\n" +
+				"  @maxRunningInstance=4  \n" +
+				"--]]\n",
+			Hc2Scene{SceneID: 22, Name: "Marvel", MaxRunningInstances: 4, RunConfig: ManualOnly, Visible: true},
 			0,
 		},
 		{
-			"int 3",
-			args{
-				&i,
-				"@sceneID",
-				[]byte(`@sceneID=-1`),
-			},
-			-1,
+			"legacy unescaped name",
+			"--[[ FIBARO_GIT_HOOK\n" +
+				"@sceneID=22\n" +
+				"@name=\"Say \"hi\" C:\\temp\"\n" +
+				"--]]\n",
+			Hc2Scene{SceneID: 22, Name: "Say \"hi\" C:\\temp", RunConfig: ManualOnly, MaxRunningInstances: 2, Visible: true},
+			0,
 		},
 		{
-			"int inv",
-			args{
-				&i,
-				"@sceneID",
-				[]byte(`@sceneID=hallo`),
-			},
+			"keys outside of the header are ignored",
+			"-- @name=\"not the name\"\n" +
+				"--[[ FIBARO_GIT_HOOK\n" +
+				"@sceneID=22\n" +
+				"--]]\n" +
+				"local s = \"@roomID=7\"\n",
+			Hc2Scene{SceneID: 22, RunConfig: ManualOnly, MaxRunningInstances: 2, Visible: true},
 			0,
 		},
 		{
-			"int inv2",
-			args{
-				&i,
-				"@sceneID",
-				[]byte(`@sceneD=22`),
-			},
-			-1,
+			"new scene without sceneID",
+			"--[[ FIBARO_GIT_HOOK\n@name=\"New\"\n--]]\n",
+			Hc2Scene{SceneID: -1, Name: "New", RunConfig: ManualOnly, MaxRunningInstances: 2, Visible: true},
+			0,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := sceneSetInt(tt.args.v, tt.args.k, tt.args.i); got != tt.want {
-				t.Errorf("sceneSetInt() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_sceneSetBool(t *testing.T) {
-	var b bool
-	type args struct {
-		v *bool
-		k string
-		i []byte
-	}
-	tests := []struct {
-		name string
-		args args
-		want bool
-	}{
 		{
-			"bool 1",
-			args{
-				&b,
-				"@autostart",
-				[]byte(`@autostart=true`),
-			},
-			true,
+			"unknown key",
+			"-- code\n--[[ FIBARO_GIT_HOOK\n@sceneID=22\n@visble=true\n--]]\n",
+			Hc2Scene{},
+			4,
 		},
 		{
-			"bool 2",
-			args{
-				&b,
-				"@autostart",
-				[]byte(`@autostart=false`),
-			},
-			false,
+			"malformed integer",
+			"--[[ FIBARO_GIT_HOOK\n@maxRunningInstances=\"NOT_YET_USED\"\n--]]\n",
+			Hc2Scene{},
+			2,
 		},
 		{
-			"bool inv",
-			args{
-				&b,
-				"@autostart",
-				[]byte(`@autostart=xx`),
-			},
-			false,
+			"malformed boolean",
+			"--[[ FIBARO_GIT_HOOK\n@sceneID=22\n@visible=yes\n--]]\n",
+			Hc2Scene{},
+			3,
 		},
 		{
-			"bool inv2",
-			args{
-				&b,
-				"@autostart",
-				[]byte(`@autos=true`),
-			},
-			false,
+			"malformed string",
+			"--[[ FIBARO_GIT_HOOK\n@name=\"unterminated\n--]]\n",
+			Hc2Scene{},
+			2,
+		},
+		{
+			"no key",
+			"--[[ FIBARO_GIT_HOOK\n@sceneID=22\nsomething else\n--]]\n",
+			Hc2Scene{},
+			3,
+		},
+		{
+			"duplicate key",
+			"--[[ FIBARO_GIT_HOOK\n@sceneID=22\n@sceneID=23\n--]]\n",
+			Hc2Scene{},
+			3,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := sceneSetBool(tt.args.v, tt.args.k, tt.args.i); got != tt.want {
-				t.Errorf("sceneSetBool() = %v, want %v", got, tt.want)
+			scene := NewHc2Scene()
+			err := scene.Parse([]byte(tt.input))
+			if tt.wantLine != 0 {
+				var he *HeaderError
+				if !errors.As(err, &he) || !errors.Is(err, ErrInvalidHeader) {
+					t.Fatalf("Hc2Scene.Parse() error = %v, want HeaderError", err)
+				}
+				AssertEqual(t, he.Line, tt.wantLine)
+				AssertEqual(t, scene.SceneID, -1)
+				AssertEqual(t, scene.Lua, tt.input)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Hc2Scene.Parse() error = %v", err)
+			}
+			tt.want.Lua = tt.input
+			if tt.want.Type == "" {
+				tt.want.Type = "com.fibaro.luaScene"
+			}
+			if !reflect.DeepEqual(scene, tt.want) {
+				t.Errorf("Hc2Scene.Parse() = %#v, want %#v", scene, tt.want)
 			}
 		})
 	}
 }
 
-func Test_sceneSetString(t *testing.T) {
-	var s string
-	type args struct {
-		v *string
-		k string
-		i []byte
+// TestHc2Scene_ParseToComment checks that Parse restores all fields written by ToComment
+func TestHc2Scene_ParseToComment(t *testing.T) {
+	roundTrip := func(want Hc2Scene, code string) bool {
+		want.Lua = code + "\n" + want.ToComment()
+		var got Hc2Scene
+		if err := got.Parse([]byte(want.Lua)); err != nil {
+			t.Logf("Parse(%q) error = %v", want.Lua, err)
+			return false
+		}
+		return reflect.DeepEqual(got, want)
 	}
-	tests := []struct {
-		name string
-		args args
-		want string
-	}{
-		{
-			"string 1",
-			args{
-				&s,
-				"@name",
-				[]byte(`@name=""`),
-			},
-			"",
-		},
-		{
-			"string 2",
-			args{
-				&s,
-				"@name",
-				[]byte(`@name="hello"`),
-			},
-			"hello",
-		},
-		{
-			"string 3",
-			args{
-				&s,
-				"@name",
-				[]byte(`@name="-1"`),
-			},
-			"-1",
-		},
-		{
-			"string 4",
-			args{
-				&s,
-				"@name",
-				[]byte(`@name="This is a test"`),
-			},
-			"This is a test",
-		},
-		{
-			"string inv1",
-			args{
-				&s,
-				"@name",
-				[]byte(`@name="`),
-			},
-			"",
-		},
-		{
-			"string inv2",
-			args{
-				&s,
-				"@name",
-				[]byte(`@name=22`),
-			},
-			"",
-		},
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := sceneSetString(tt.args.v, tt.args.k, tt.args.i); got != tt.want {
-				t.Errorf("sceneSetString() = %v, want %v", got, tt.want)
-			}
-		})
+
+	special := []string{"", " ", "]]", "--]]", "a]]]b", "\n", "\"", "\\", "=", "@name=\"x\"", "Küche", "\x00\xff"}
+	for _, s := range special {
+		scene := Hc2Scene{Name: s, RunConfig: s, Type: s}
+		if !roundTrip(scene, "") {
+			t.Errorf("round trip failed for %q", s)
+		}
 	}
 }
 
@@ -486,9 +452,10 @@ func TestReadFile(t *testing.T) {
 		path string
 	}
 	tests := []struct {
-		name string
-		args args
-		want int
+		name    string
+		args    args
+		want    int
+		wantErr bool
 	}{
 		{
 			"reading shortHeader.lua",
@@ -496,13 +463,23 @@ func TestReadFile(t *testing.T) {
 				"../test/shortHeader.lua",
 			},
 			16,
+			false,
+		},
+		{
+			"reading non existing file",
+			args{
+				"../test/doesNotExist.lua",
+			},
+			0,
+			true,
 		},
-		// TODO: Add test cases for non existing paths.
-		// The problem with this is that the application terminates.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := readFile(tt.args.path)
+			got, _, err := readFile(tt.args.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
 			if got != tt.want {
 				t.Errorf("ReadFile() got = %v, want %v", got, tt.want)
 			}
@@ -526,15 +503,20 @@ func TestLoadConfig(t *testing.T) {
 }
 
 func TestParseMultiHeaderScene(t *testing.T) {
-	_, s := readFile("../test/doubleHeader.lua")
+	_, s, err := readFile("../test/doubleHeader.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
 	t.Run("Parsing double headers", func(t *testing.T) {
 		scene1 := NewHc2Scene()
 		scene1.Parse(s)
 		AssertHc2SceneWOLuaEqual(t, scene1, Hc2Scene{
-			Name:      "A_Trial_2",
-			SceneID:   203,
-			RoomID:    306,
-			RunConfig: TriggerAndManual,
+			Name:                "A_Trial_2",
+			SceneID:             203,
+			RoomID:              306,
+			RunConfig:           TriggerAndManual,
+			MaxRunningInstances: 2,
+			Type:                "com.fibaro.luaScene",
 		})
 	})
 
@@ -551,10 +533,12 @@ func TestParseMultiHeaderScene(t *testing.T) {
 			scene2 := NewHc2Scene()
 			scene2.Parse(a)
 			AssertHc2SceneWOLuaEqual(t, scene2, Hc2Scene{
-				Name:      "A_Trial_4",
-				SceneID:   204,
-				RoomID:    305,
-				RunConfig: TriggerAndManual,
+				Name:                "A_Trial_4",
+				SceneID:             204,
+				RoomID:              305,
+				RunConfig:           TriggerAndManual,
+				MaxRunningInstances: 2,
+				Type:                "com.fibaro.luaScene",
 			})
 		} else {
 			t.FailNow()
@@ -634,3 +618,14 @@ func TestHc2Scene_SanityCheck(t *testing.T) {
 		})
 	}
 }
+
+func TestHc2Scene_HasLuaHeader(t *testing.T) {
+	scene := Hc2Scene{SceneID: 12, RoomID: 5, Lua: "print(1)\n"}
+	if scene.HasLuaHeader() {
+		t.Error("HasLuaHeader() of a scene without header = true")
+	}
+	scene.UpdateLuaHeader()
+	if !scene.HasLuaHeader() {
+		t.Error("HasLuaHeader() of a scene with header = false")
+	}
+}
@@ -4,4 +4,5 @@ package fibarohc2
 type Hc2Section struct {
 	SectionID int    `json:"id"`
 	Name      string `json:"name"`
+	SortOrder int    `json:"sortOrder,omitempty"`
 }
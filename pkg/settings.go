@@ -21,6 +21,7 @@ type Hc2Info struct {
 	ZwaveVersion string `json:"zwaveVersion,omitempty"`
 	ZwaveRegion  string `json:"zwaveRegion,omitempty"`
 	ServerStatus int    `json:"serverStatus,omitempty"`
+	Platform     string `json:"platform,omitempty"` // only reported by HC3 and Yubii
 	// "defaultLanguage": "",
 	// "sunsetHour": "",
 	// "sunriseHour": "",
@@ -0,0 +1,147 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/theovassiliou/hc2-tools/pkg/lua"
+)
+
+// SourceLine is the origin of a line of a lua-script
+type SourceLine struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// SourceMap maps the lines of a lua-script with expanded require statements to
+// the file and line they originate from. Entry i describes line i+1.
+type SourceMap []SourceLine
+
+// Add appends the origin of the next line
+func (m *SourceMap) Add(file string, line int) {
+	*m = append(*m, SourceLine{File: file, Line: line})
+}
+
+// Origin returns the origin of line, starting with 1
+func (m SourceMap) Origin(line int) (SourceLine, bool) {
+	if line < 1 || line > len(m) {
+		return SourceLine{}, false
+	}
+	return m[line-1], true
+}
+
+// Inlined reports if a line of the lua-script originates from a file other than file,
+// i.e. if the source map is needed to locate lines
+func (m SourceMap) Inlined(file string) bool {
+	for i, l := range m {
+		if l.File != file || l.Line != i+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// lineReferences matches the line numbers in error messages of the HC2, as in
+// "line 12: attempt to call a nil value" or "[string \"...\"]:12:"
+var lineReferences = regexp.MustCompile(`\bline (\d+)|\[string "(?:[^"\\]|\\.)*"\]:(\d+)`)
+
+// Rewrite replaces the line numbers in a debug message of the HC2 by the file and line
+// they originate from. Lines not covered by the map are kept.
+func (m SourceMap) Rewrite(msg string) string {
+	return lineReferences.ReplaceAllStringFunc(msg, func(ref string) string {
+		sm := lineReferences.FindStringSubmatch(ref)
+		line, _ := strconv.Atoi(sm[1] + sm[2])
+		origin, ok := m.Origin(line)
+		if !ok {
+			return ref
+		}
+		return fmt.Sprintf("%s:%d", origin.File, origin.Line)
+	})
+}
+
+// SourceMapSuffix is appended to the name of a lua-script to get the name of its
+// source map file
+const SourceMapSuffix = ".map"
+
+// SceneSourceMap is the source map of a scene uploaded to the HC2, as stored next to
+// the lua-script
+type SceneSourceMap struct {
+	SceneID int       `json:"sceneID"`
+	Hash    string    `json:"hash"` // LuaHash of the uploaded scene
+	Lines   SourceMap `json:"lines"`
+}
+
+// WriteSourceMap writes the source map to path. Files are stored relative to the
+// directory of path, so that the lua-scripts and the map can be moved together.
+func WriteSourceMap(path string, sm SceneSourceMap) error {
+	dir := filepath.Dir(path)
+	lines := make(SourceMap, len(sm.Lines))
+	for i, l := range sm.Lines {
+		lines[i] = l
+		if rel, err := filepath.Rel(dir, l.File); err == nil {
+			lines[i].File = filepath.ToSlash(rel)
+		}
+	}
+	sm.Lines = lines
+	b, err := json.Marshal(sm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// ReadSourceMap reads a source map written by WriteSourceMap. The files are returned
+// relative to the current directory.
+func ReadSourceMap(path string) (SceneSourceMap, error) {
+	var sm SceneSourceMap
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sm, err
+	}
+	if err := json.Unmarshal(b, &sm); err != nil {
+		return sm, fmt.Errorf("invalid source map %s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i, l := range sm.Lines {
+		if !filepath.IsAbs(l.File) {
+			sm.Lines[i].File = filepath.Join(dir, filepath.FromSlash(l.File))
+		}
+	}
+	return sm, nil
+}
+
+// LuaError is an error found in a lua-script, reported at its origin
+type LuaError struct {
+	SourceLine
+	Msg  string
+	Kind error // ErrLuaSyntax
+}
+
+func (e *LuaError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Unwrap returns the kind of the error, so that errors.Is can be used on a LuaError.
+func (e *LuaError) Unwrap() error {
+	return e.Kind
+}
+
+// CheckLuaSyntax parses the lua-script as Lua 5.2, as run by the HC2. A syntax error is
+// returned as *LuaError, located by m. If m does not cover the line, file is reported.
+func CheckLuaSyntax(script string, file string, m SourceMap) error {
+	_, err := lua.Parse(script)
+	var se *lua.SyntaxError
+	if !errors.As(err, &se) {
+		return err
+	}
+	origin, ok := m.Origin(se.Line)
+	if !ok {
+		origin = SourceLine{File: file, Line: se.Line}
+	}
+	return &LuaError{SourceLine: origin, Msg: se.Msg, Kind: ErrLuaSyntax}
+}
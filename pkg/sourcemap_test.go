@@ -0,0 +1,76 @@
+package fibarohc2
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckLuaSyntax(t *testing.T) {
+	var m SourceMap
+	m.Add("scene.lua", 1)
+	m.Add("lib/a.lua", 7)
+	m.Add("scene.lua", 2)
+
+	if err := CheckLuaSyntax("x = 1\ny = 2\nz = 3\n", "scene.lua", m); err != nil {
+		t.Errorf("CheckLuaSyntax() = %v", err)
+	}
+
+	err := CheckLuaSyntax("x = 1\ny = = 2\nz = 3\n", "scene.lua", m)
+	var le *LuaError
+	if !errors.As(err, &le) || !errors.Is(err, ErrLuaSyntax) {
+		t.Fatalf("CheckLuaSyntax() = %v, want *LuaError", err)
+	}
+	AssertEqual(t, err.Error(), "lib/a.lua:7: unexpected symbol near '='")
+
+	// lines not covered by the map are reported in file
+	err = CheckLuaSyntax("x = 1\ny = 2\nz = 3\nend", "scene.lua", m)
+	AssertEqual(t, err.Error(), "scene.lua:4: '<eof>' expected near 'end'")
+}
+
+func TestSourceMap_Rewrite(t *testing.T) {
+	var m SourceMap
+	m.Add("scene.lua", 1)
+	m.Add("lib/a.lua", 7)
+
+	AssertEqual(t, m.Rewrite("line 2: attempt to call a nil value"), "lib/a.lua:7: attempt to call a nil value")
+	AssertEqual(t, m.Rewrite(`[string "fibaro:debug..."]:1: boom`), "scene.lua:1: boom")
+	AssertEqual(t, m.Rewrite("line 3: outside the map, pipeline 2"), "line 3: outside the map, pipeline 2")
+
+	if !m.Inlined("scene.lua") {
+		t.Errorf("Inlined() = false for a map with a library")
+	}
+	if m[:1].Inlined("scene.lua") {
+		t.Errorf("Inlined() = true for a map without library")
+	}
+}
+
+func TestWriteSourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var m SourceMap
+	m.Add(filepath.Join(dir, "scenes", "scene.lua"), 1)
+	m.Add(filepath.Join(dir, "lib", "a.lua"), 7)
+	path := filepath.Join(dir, "scenes", "scene.lua.map")
+	os.Mkdir(filepath.Dir(path), 0755)
+	if err := WriteSourceMap(path, SceneSourceMap{SceneID: 55, Hash: "abc", Lines: m}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	AssertEqual(t, string(b), `{"sceneID":55,"hash":"abc","lines":[{"file":"scene.lua","line":1},{"file":"../lib/a.lua","line":7}]}`+"\n")
+
+	sm, err := ReadSourceMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.SceneID != 55 || sm.Hash != "abc" || !reflect.DeepEqual(sm.Lines, m) {
+		t.Errorf("ReadSourceMap() = %v, want %v", sm, m)
+	}
+}
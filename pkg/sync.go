@@ -0,0 +1,230 @@
+package fibarohc2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SyncStateFile is the default name of the file recording the last synchronised
+// version of every scene, relative to the synchronised directory.
+const SyncStateFile string = ".hc2sync.json"
+
+// SyncActionKind is the action required to synchronise a scene
+type SyncActionKind int
+
+// The actions hc2Sync derives for a scene by comparing the local file, the scene in the
+// HC2 and the version recorded in the SyncState.
+const (
+	SyncUnchanged     SyncActionKind = iota // local and remote are equal
+	SyncPull                                // only remote changed, or remote only
+	SyncPush                                // only local changed
+	SyncConflict                            // local and remote changed
+	SyncDeletedLocal                        // synced before, local file is gone
+	SyncDeletedRemote                       // synced before, scene is gone in the HC2
+	SyncUnknownRemote                       // local file refers to a scene unknown to the HC2
+	SyncDuplicate                           // several local files, or remote scenes, have the sceneID
+)
+
+func (k SyncActionKind) String() string {
+	return [...]string{"unchanged", "pull", "push", "conflict", "deleted locally", "deleted remotely", "unknown remote", "duplicate"}[k]
+}
+
+// SyncStateEntry records the last synchronised version of a scene
+type SyncStateEntry struct {
+	Path string `json:"path"` // path of the file, relative to the synchronised directory
+	Hash string `json:"hash"` // LuaHash of the scene when it was synchronised
+}
+
+// SyncState records the last synchronised version of all scenes, keyed by sceneID
+type SyncState map[int]SyncStateEntry
+
+// LocalScene is a scene read from a file of the synchronised directory
+type LocalScene struct {
+	Path  string // path of the file, relative to the synchronised directory
+	Scene Hc2Scene
+}
+
+// SyncAction is the action required to synchronise one scene. Local and Remote are
+// nil if the scene does not exist on the respective side. For SyncDuplicate they are
+// the first of the files or scenes, and Duplicates lists the paths of all local files
+// and the names of all remote scenes, prefixed with hc2/, with the sceneID.
+type SyncAction struct {
+	SceneID    int
+	Kind       SyncActionKind
+	Local      *LocalScene
+	Remote     *Hc2Scene
+	Duplicates []string
+}
+
+// LuaHash returns a hash of the Lua code of a scene. FIBARO_GIT_HOOK headers and
+// trailing newlines are ignored, so that the hash of a file and the scene it has
+// been uploaded to are equal.
+func LuaHash(lua string) string {
+	s := Hc2Scene{Lua: lua}
+	s.TrimLuaHeaders()
+	sum := sha256.Sum256([]byte(strings.TrimRight(s.Lua, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Diff returns a unified diff between the local and the remote version of the
+// scene. Headers are ignored.
+func (a SyncAction) Diff() string {
+	var local, remote string
+	if a.Local != nil {
+		s := a.Local.Scene
+		s.TrimLuaHeaders()
+		local = normalizeLua(s.Lua)
+	}
+	if a.Remote != nil {
+		s := *a.Remote
+		s.TrimLuaHeaders()
+		remote = normalizeLua(s.Lua)
+	}
+	localName, remoteName := "/dev/null", "/dev/null"
+	if a.Local != nil {
+		localName = "local/" + filepath.ToSlash(a.Local.Path)
+	}
+	if a.Remote != nil {
+		remoteName = "hc2/" + a.Remote.Name
+	}
+	return UnifiedDiff(localName, remoteName, local, remote)
+}
+
+// ReadSyncState reads the SyncState from a file. A missing file results in an empty SyncState.
+func ReadSyncState(path string) (SyncState, error) {
+	state := SyncState{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Write writes the SyncState to a file.
+func (s SyncState) Write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// ReadLocalScenes reads all lua-files below dir that carry a FIBARO_GIT_HOOK header.
+// Files without header are returned as untracked.
+func ReadLocalScenes(dir string) (scenes []LocalScene, untracked []string, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".lua" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		scene := NewHc2Scene()
+		if err := scene.ParseFile(path, false); err != nil {
+			return err
+		}
+		if scene.SceneID == -1 {
+			untracked = append(untracked, rel)
+			return nil
+		}
+		scenes = append(scenes, LocalScene{Path: rel, Scene: scene})
+		return nil
+	})
+	return
+}
+
+// PlanSync compares the local scenes with the remote scenes, using the state recorded
+// at the last synchronisation, and returns the action required for every scene, ordered
+// by sceneID. Remote scenes must contain the Lua code. A sceneID used by several local
+// files, or remote scenes, results in a SyncDuplicate, as it is unclear which one to
+// synchronise.
+func PlanSync(local []LocalScene, remote []Hc2Scene, state SyncState) []SyncAction {
+	locals := map[int]*LocalScene{}
+	duplicates := map[int][]string{}
+	for i := range local {
+		id := local[i].Scene.SceneID
+		if first, dup := locals[id]; dup {
+			if len(duplicates[id]) == 0 {
+				duplicates[id] = append(duplicates[id], first.Path)
+			}
+			duplicates[id] = append(duplicates[id], local[i].Path)
+			continue
+		}
+		locals[id] = &local[i]
+	}
+	remotes := map[int]*Hc2Scene{}
+	for i := range remote {
+		id := remote[i].SceneID
+		if first, dup := remotes[id]; dup {
+			if !containsString(duplicates[id], "hc2/"+first.Name) {
+				duplicates[id] = append(duplicates[id], "hc2/"+first.Name)
+			}
+			duplicates[id] = append(duplicates[id], "hc2/"+remote[i].Name)
+			continue
+		}
+		remotes[id] = &remote[i]
+	}
+
+	ids := map[int]bool{}
+	for id := range locals {
+		ids[id] = true
+	}
+	for id := range remotes {
+		ids[id] = true
+	}
+	sorted := make([]int, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+
+	actions := make([]SyncAction, 0, len(sorted))
+	for _, id := range sorted {
+		a := SyncAction{SceneID: id, Local: locals[id], Remote: remotes[id]}
+		base, synced := state[id]
+
+		switch {
+		case len(duplicates[id]) > 0:
+			a.Kind = SyncDuplicate
+			a.Duplicates = duplicates[id]
+		case a.Local == nil && synced:
+			a.Kind = SyncDeletedLocal
+		case a.Local == nil:
+			a.Kind = SyncPull
+		case a.Remote == nil && synced:
+			a.Kind = SyncDeletedRemote
+		case a.Remote == nil:
+			a.Kind = SyncUnknownRemote
+		default:
+			localHash := LuaHash(a.Local.Scene.Lua)
+			remoteHash := LuaHash(a.Remote.Lua)
+			switch {
+			case localHash == remoteHash:
+				a.Kind = SyncUnchanged
+			case synced && localHash == base.Hash:
+				a.Kind = SyncPull
+			case synced && remoteHash == base.Hash:
+				a.Kind = SyncPush
+			default:
+				a.Kind = SyncConflict
+			}
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
@@ -0,0 +1,112 @@
+package fibarohc2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlanSync(t *testing.T) {
+	local := func(id int, lua string) LocalScene {
+		s := Hc2Scene{SceneID: id, Lua: lua}
+		s.UpdateLuaHeader()
+		return LocalScene{Path: "scene.lua", Scene: s}
+	}
+	remote := func(id int, lua string) Hc2Scene {
+		return Hc2Scene{SceneID: id, Lua: lua}
+	}
+
+	tests := []struct {
+		name   string
+		local  []LocalScene
+		remote []Hc2Scene
+		state  SyncState
+		want   SyncActionKind
+	}{
+		{"equal, never synced", []LocalScene{local(1, "a")}, []Hc2Scene{remote(1, "a\n")}, SyncState{}, SyncUnchanged},
+		{"remote changed", []LocalScene{local(1, "a")}, []Hc2Scene{remote(1, "b")}, SyncState{1: {Hash: LuaHash("a")}}, SyncPull},
+		{"local changed", []LocalScene{local(1, "b")}, []Hc2Scene{remote(1, "a")}, SyncState{1: {Hash: LuaHash("a")}}, SyncPush},
+		{"both changed", []LocalScene{local(1, "b")}, []Hc2Scene{remote(1, "c")}, SyncState{1: {Hash: LuaHash("a")}}, SyncConflict},
+		{"different, never synced", []LocalScene{local(1, "b")}, []Hc2Scene{remote(1, "c")}, SyncState{}, SyncConflict},
+		{"remote only", nil, []Hc2Scene{remote(1, "a")}, SyncState{}, SyncPull},
+		{"deleted locally", nil, []Hc2Scene{remote(1, "a")}, SyncState{1: {Hash: LuaHash("a")}}, SyncDeletedLocal},
+		{"deleted remotely", []LocalScene{local(1, "a")}, nil, SyncState{1: {Hash: LuaHash("a")}}, SyncDeletedRemote},
+		{"unknown remote", []LocalScene{local(1, "a")}, nil, SyncState{}, SyncUnknownRemote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PlanSync(tt.local, tt.remote, tt.state)
+			if len(got) != 1 {
+				t.Fatalf("PlanSync() returned %d actions, want 1", len(got))
+			}
+			if got[0].Kind != tt.want {
+				t.Errorf("PlanSync() = %v, want %v", got[0].Kind, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSync_Duplicates(t *testing.T) {
+	local := func(id int, path string) LocalScene {
+		s := Hc2Scene{SceneID: id, Lua: "a"}
+		s.UpdateLuaHeader()
+		return LocalScene{Path: path, Scene: s}
+	}
+	got := PlanSync(
+		[]LocalScene{local(1, "a.lua"), local(1, "b.lua"), local(2, "c.lua")},
+		[]Hc2Scene{{SceneID: 1, Name: "A", Lua: "a"}, {SceneID: 2, Name: "C", Lua: "a"}, {SceneID: 2, Name: "D", Lua: "a"}},
+		SyncState{})
+	want := []SyncAction{
+		{SceneID: 1, Kind: SyncDuplicate, Duplicates: []string{"a.lua", "b.lua"}},
+		{SceneID: 2, Kind: SyncDuplicate, Duplicates: []string{"hc2/C", "hc2/D"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("PlanSync() returned %d actions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		AssertEqual(t, got[i].Kind, want[i].Kind)
+		if !reflect.DeepEqual(got[i].Duplicates, want[i].Duplicates) {
+			t.Errorf("Duplicates of scene %d = %v, want %v", got[i].SceneID, got[i].Duplicates, want[i].Duplicates)
+		}
+	}
+}
+
+func TestSyncStateReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hc2sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, SyncStateFile)
+	state, err := ReadSyncState(path)
+	if err != nil || len(state) != 0 {
+		t.Fatalf("ReadSyncState() of missing file = %v, %v", state, err)
+	}
+	state[203] = SyncStateEntry{Path: "Room/A_Trial_2.lua", Hash: LuaHash("a")}
+	if err := state.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadSyncState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, got[203], state[203])
+}
+
+func TestReadLocalScenes(t *testing.T) {
+	scenes, untracked, err := ReadLocalScenes("../test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenes) == 0 || len(untracked) == 0 {
+		t.Errorf("ReadLocalScenes() = %d scenes, %d untracked, want both > 0", len(scenes), len(untracked))
+	}
+	for _, s := range scenes {
+		if s.Scene.SceneID == -1 {
+			t.Errorf("ReadLocalScenes() returned %s without header", s.Path)
+		}
+	}
+}
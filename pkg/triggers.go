@@ -0,0 +1,272 @@
+package fibarohc2
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PropertyTrigger starts a scene when a property of a device changes, e.g. "449 value"
+type PropertyTrigger struct {
+	DeviceID int
+	Property string
+}
+
+// EventTrigger starts a scene when a device emits an event, e.g. "448 CentralSceneEvent"
+type EventTrigger struct {
+	DeviceID int
+	Event    string
+	Args     []string // further words of the line, if any
+}
+
+// SceneTriggers represents the trigger block at the beginning of a Lua scene
+//
+//	--[[
+//	%% autostart
+//	%% properties
+//	449 value
+//	%% events
+//	448 CentralSceneEvent
+//	%% globals
+//	TimeOfDay
+//	--]]
+type SceneTriggers struct {
+	Autostart          bool
+	KillOtherInstances bool
+	Properties         []PropertyTrigger
+	Weather            []string
+	Events             []EventTrigger
+	Globals            []string
+}
+
+// TriggerError reports a malformed trigger block. Line is the line number in the
+// Lua code, starting at 1.
+type TriggerError struct {
+	Line int
+	Msg  string
+}
+
+func (e *TriggerError) Error() string {
+	return fmt.Sprintf("trigger block, line %d: %s", e.Line, e.Msg)
+}
+
+// Unwrap returns ErrInvalidTriggers, so that errors.Is can be used on a TriggerError.
+func (e *TriggerError) Unwrap() error {
+	return ErrInvalidTriggers
+}
+
+// UnknownReferencesError lists the devices and global variables referenced by
+// SceneTriggers that do not exist in the HC2.
+type UnknownReferencesError struct {
+	Devices []int
+	Globals []string
+}
+
+func (e *UnknownReferencesError) Error() string {
+	var parts []string
+	if len(e.Devices) > 0 {
+		ids := make([]string, len(e.Devices))
+		for i, id := range e.Devices {
+			ids[i] = strconv.Itoa(id)
+		}
+		parts = append(parts, "unknown devices "+strings.Join(ids, ", "))
+	}
+	if len(e.Globals) > 0 {
+		parts = append(parts, "unknown global variables "+strings.Join(e.Globals, ", "))
+	}
+	return "triggers reference " + strings.Join(parts, " and ")
+}
+
+// Unwrap returns ErrInvalidTriggers, so that errors.Is can be used on an UnknownReferencesError.
+func (e *UnknownReferencesError) Unwrap() error {
+	return ErrInvalidTriggers
+}
+
+// triggerBlock matches a long comment at the beginning of the Lua code whose first
+// line starts with %%
+var triggerBlock = regexp.MustCompile(`(?s)\A\s*--\[\[[ \t]*\r?\n([ \t]*%%.*?)(?:--)?\]\]`)
+
+// findTriggerBlock returns the start and end of the trigger block, and the start and end of its content
+func findTriggerBlock(lua string) []int {
+	return triggerBlock.FindStringSubmatchIndex(lua)
+}
+
+// ParseSceneTriggers parses the trigger block at the beginning of the Lua code.
+// found is false if the Lua code has no trigger block.
+func ParseSceneTriggers(lua string) (t SceneTriggers, found bool, err error) {
+	t, _, found, err = parseSceneTriggers(lua)
+	return t, found, err
+}
+
+// triggerLines are the line numbers of the entries of SceneTriggers, in the same order
+type triggerLines struct {
+	properties, events, globals []int
+}
+
+// parseSceneTriggers parses the trigger block like ParseSceneTriggers, and also
+// returns the line of every property, event and global variable
+func parseSceneTriggers(lua string) (t SceneTriggers, lines triggerLines, found bool, err error) {
+	i := findTriggerBlock(lua)
+	if i == nil {
+		return t, lines, false, nil
+	}
+	first := 1 + strings.Count(lua[:i[2]], "\n")
+
+	section := ""
+	for n, l := range strings.Split(lua[i[2]:i[3]], "\n") {
+		line := first + n
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(l, "%%") {
+			section = strings.TrimSpace(strings.TrimPrefix(l, "%%"))
+			switch section {
+			case "autostart":
+				t.Autostart = true
+			case "killOtherInstances":
+				t.KillOtherInstances = true
+			case "properties", "weather", "events", "globals":
+			default:
+				return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("unknown section %%%% %s", section)}
+			}
+			continue
+		}
+
+		fields := strings.Fields(l)
+		switch section {
+		case "properties":
+			if len(fields) != 2 {
+				return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("expected <deviceID> <property>, got %q", l)}
+			}
+			id, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("invalid deviceID %q", fields[0])}
+			}
+			t.Properties = append(t.Properties, PropertyTrigger{DeviceID: id, Property: fields[1]})
+			lines.properties = append(lines.properties, line)
+		case "events":
+			if len(fields) < 2 {
+				return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("expected <deviceID> <event>, got %q", l)}
+			}
+			id, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("invalid deviceID %q", fields[0])}
+			}
+			t.Events = append(t.Events, EventTrigger{DeviceID: id, Event: fields[1], Args: fields[2:]})
+			lines.events = append(lines.events, line)
+		case "globals", "weather":
+			if len(fields) != 1 {
+				return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("expected one name per line, got %q", l)}
+			}
+			if section == "globals" {
+				t.Globals = append(t.Globals, l)
+				lines.globals = append(lines.globals, line)
+			} else {
+				t.Weather = append(t.Weather, l)
+			}
+		case "":
+			return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("%q outside of a section", l)}
+		default:
+			return t, lines, true, &TriggerError{Line: line, Msg: fmt.Sprintf("unexpected %q in section %%%% %s", l, section)}
+		}
+	}
+	return t, lines, true, nil
+}
+
+// ToComment returns the trigger block as Lua comment. The sections properties, events
+// and globals are always included, as done by the HC2.
+func (t SceneTriggers) ToComment() string {
+	var str strings.Builder
+
+	_, _ = str.WriteString("--[[\n")
+	if t.Autostart {
+		_, _ = str.WriteString("%% autostart\n")
+	}
+	if t.KillOtherInstances {
+		_, _ = str.WriteString("%% killOtherInstances\n")
+	}
+	_, _ = str.WriteString("%% properties\n")
+	for _, p := range t.Properties {
+		_, _ = str.WriteString(strconv.Itoa(p.DeviceID) + " " + p.Property + "\n")
+	}
+	if len(t.Weather) > 0 {
+		_, _ = str.WriteString("%% weather\n")
+		for _, w := range t.Weather {
+			_, _ = str.WriteString(w + "\n")
+		}
+	}
+	_, _ = str.WriteString("%% events\n")
+	for _, e := range t.Events {
+		_, _ = str.WriteString(strings.Join(append([]string{strconv.Itoa(e.DeviceID), e.Event}, e.Args...), " ") + "\n")
+	}
+	_, _ = str.WriteString("%% globals\n")
+	for _, g := range t.Globals {
+		_, _ = str.WriteString(g + "\n")
+	}
+	_, _ = str.WriteString("--]]")
+	return str.String()
+}
+
+// SetSceneTriggers replaces the trigger block of the Lua code with t. If the Lua code
+// has no trigger block, the block is inserted at the beginning.
+func SetSceneTriggers(lua string, t SceneTriggers) string {
+	i := findTriggerBlock(lua)
+	if i == nil {
+		return t.ToComment() + "\n" + lua
+	}
+	// keep leading whitespace
+	start := i[0] + strings.Index(lua[i[0]:], "--[[")
+	return lua[:start] + t.ToComment() + lua[i[1]:]
+}
+
+// DeviceIDs returns the IDs of all devices referenced by the triggers, sorted and without duplicates
+func (t SceneTriggers) DeviceIDs() []int {
+	seen := map[int]bool{}
+	var ids []int
+	add := func(id int) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, p := range t.Properties {
+		add(p.DeviceID)
+	}
+	for _, e := range t.Events {
+		add(e.DeviceID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Validate checks that all devices and global variables referenced by the triggers
+// exist, and returns an *UnknownReferencesError otherwise.
+func (t SceneTriggers) Validate(devices []Hc2Device, globals []Hc2GlobalVariable) error {
+	knownDevices := map[int]bool{}
+	for _, d := range devices {
+		knownDevices[d.ID] = true
+	}
+	knownGlobals := map[string]bool{}
+	for _, g := range globals {
+		knownGlobals[g.Name] = true
+	}
+
+	e := &UnknownReferencesError{}
+	for _, id := range t.DeviceIDs() {
+		if !knownDevices[id] {
+			e.Devices = append(e.Devices, id)
+		}
+	}
+	for _, g := range t.Globals {
+		if !knownGlobals[g] {
+			e.Globals = append(e.Globals, g)
+		}
+	}
+	if len(e.Devices) > 0 || len(e.Globals) > 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package fibarohc2
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var triggersLua = SceneTriggers{
+	Autostart:          true,
+	KillOtherInstances: true,
+	Properties:         []PropertyTrigger{{449, "value"}, {419, "value"}},
+	Weather:            []string{"Temperature"},
+	Events:             []EventTrigger{{448, "CentralSceneEvent", []string{}}, {312, "sceneActivation", []string{"2"}}},
+	Globals:            []string{"TimeOfDay", "SleepState"},
+}
+
+func TestParseSceneTriggers(t *testing.T) {
+	_, b, err := readFile("../test/triggers.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, found, err := ParseSceneTriggers(string(b))
+	if err != nil || !found {
+		t.Fatalf("ParseSceneTriggers() = %v, %v", found, err)
+	}
+	if !reflect.DeepEqual(got, triggersLua) {
+		t.Errorf("ParseSceneTriggers() = %#v, want %#v", got, triggersLua)
+	}
+
+	_, found, err = ParseSceneTriggers("local x = 1\n--[[\n%% properties\n--]]\n")
+	if found || err != nil {
+		t.Errorf("ParseSceneTriggers() of block not at the beginning = %v, %v", found, err)
+	}
+}
+
+func TestParseSceneTriggers_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		lua      string
+		wantLine int
+	}{
+		{"unknown section", "--[[\n%% properties\n%% sunrise\n--]]\n", 3},
+		{"invalid deviceID", "\n--[[\n%% properties\n12 value\nabc value\n--]]\n", 5},
+		{"missing property", "--[[\n%% properties\n12\n--]]\n", 3},
+		{"missing event", "--[[\n%% events\n12\n--]]\n", 3},
+		{"two globals in a line", "--[[\n%% globals\nA B\n--]]\n", 3},
+		{"content in autostart", "--[[\n%% autostart\nnow\n--]]\n", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, found, err := ParseSceneTriggers(tt.lua)
+			var te *TriggerError
+			if !found || !errors.As(err, &te) || !errors.Is(err, ErrInvalidTriggers) {
+				t.Fatalf("ParseSceneTriggers() = %v, %v, want TriggerError", found, err)
+			}
+			AssertEqual(t, te.Line, tt.wantLine)
+		})
+	}
+}
+
+func TestSceneTriggers_ToComment(t *testing.T) {
+	AssertEqual(t, SceneTriggers{}.ToComment(), "--[[\n%% properties\n%% events\n%% globals\n--]]")
+
+	got, found, err := ParseSceneTriggers(triggersLua.ToComment())
+	if err != nil || !found {
+		t.Fatalf("ParseSceneTriggers() = %v, %v", found, err)
+	}
+	if !reflect.DeepEqual(got, triggersLua) {
+		t.Errorf("ParseSceneTriggers(ToComment()) = %#v, want %#v", got, triggersLua)
+	}
+}
+
+func TestSetSceneTriggers(t *testing.T) {
+	trig := SceneTriggers{Globals: []string{"TimeOfDay"}}
+	want := "--[[\n%% properties\n%% events\n%% globals\nTimeOfDay\n--]]\n\nlocal x = 1\n"
+
+	AssertEqual(t, SetSceneTriggers("--[[\n%% properties\n%% globals\n--]]\n\nlocal x = 1\n", trig), want)
+	AssertEqual(t, SetSceneTriggers("\nlocal x = 1\n", trig), want)
+
+	_, b, err := readFile("../test/triggers.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lua := SetSceneTriggers(string(b), trig)
+	if !strings.HasSuffix(lua, "--]]\n\n-- %% globals\n-- NotATrigger\nlocal x = 1\n") {
+		t.Errorf("SetSceneTriggers() changed the code: %q", lua)
+	}
+}
+
+func TestSceneTriggers_Validate(t *testing.T) {
+	devices := []Hc2Device{{ID: 449}, {ID: 448}, {ID: 312}}
+	globals := []Hc2GlobalVariable{{Name: "TimeOfDay"}}
+
+	err := triggersLua.Validate(devices, globals)
+	var ue *UnknownReferencesError
+	if !errors.As(err, &ue) || !errors.Is(err, ErrInvalidTriggers) {
+		t.Fatalf("Validate() error = %v, want UnknownReferencesError", err)
+	}
+	if !reflect.DeepEqual(ue.Devices, []int{419}) || !reflect.DeepEqual(ue.Globals, []string{"SleepState"}) {
+		t.Errorf("Validate() = %v", ue)
+	}
+
+	devices = append(devices, Hc2Device{ID: 419})
+	globals = append(globals, Hc2GlobalVariable{Name: "SleepState"})
+	if err := triggersLua.Validate(devices, globals); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
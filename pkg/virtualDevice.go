@@ -0,0 +1,307 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the files a virtual device is exploded into by WriteDir
+const (
+	VirtualDeviceLayoutFile   string = "layout.json"
+	VirtualDeviceMainLoopFile string = "mainLoop.lua"
+)
+
+// Hc2VirtualDevice represents a virtual device of the FibaroHC2 system. Can be encoded as JSON.
+type Hc2VirtualDevice struct {
+	ID         int                        `json:"id,omitempty"`
+	Name       string                     `json:"name"`
+	RoomID     int                        `json:"roomID"`
+	Type       string                     `json:"type,omitempty"`
+	Visible    bool                       `json:"visible"`
+	Enabled    bool                       `json:"enabled"`
+	Properties Hc2VirtualDeviceProperties `json:"properties"`
+	Actions    map[string]int             `json:"actions,omitempty"`
+}
+
+// Hc2VirtualDeviceProperties represents the properties of a virtual device. The
+// main loop and the rows are decoded, all other properties are kept as they are in Others.
+type Hc2VirtualDeviceProperties struct {
+	MainLoop string
+	Rows     []Hc2VirtualDeviceRow
+	Others   map[string]json.RawMessage
+}
+
+// Hc2VirtualDeviceRow represents one row of the user interface of a virtual device.
+type Hc2VirtualDeviceRow struct {
+	Type     string                    `json:"type"`
+	Elements []Hc2VirtualDeviceElement `json:"elements"`
+}
+
+// Hc2VirtualDeviceElement represents a button, slider or label of a virtual
+// device. If Lua is set Msg contains the Lua code executed by the element.
+type Hc2VirtualDeviceElement struct {
+	ID              int    `json:"id"`
+	Lua             bool   `json:"lua"`
+	WaitForResponse bool   `json:"waitForResponse"`
+	Caption         string `json:"caption"`
+	Name            string `json:"name"`
+	Empty           bool   `json:"empty"`
+	Msg             string `json:"msg"`
+	ButtonIcon      int    `json:"buttonIcon"`
+	Favourite       bool   `json:"favourite"`
+	Main            bool   `json:"main"`
+}
+
+// UnmarshalJSON decodes the properties, keeping unknown properties in Others.
+func (p *Hc2VirtualDeviceProperties) UnmarshalJSON(b []byte) error {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	if v, ok := all["mainLoop"]; ok {
+		if err := json.Unmarshal(v, &p.MainLoop); err != nil {
+			return err
+		}
+		delete(all, "mainLoop")
+	}
+	if v, ok := all["rows"]; ok {
+		if err := json.Unmarshal(v, &p.Rows); err != nil {
+			return err
+		}
+		delete(all, "rows")
+	}
+	p.Others = all
+	return nil
+}
+
+// MarshalJSON encodes the properties, including all properties kept in Others.
+func (p Hc2VirtualDeviceProperties) MarshalJSON() ([]byte, error) {
+	all := map[string]interface{}{}
+	for k, v := range p.Others {
+		all[k] = v
+	}
+	all["mainLoop"] = p.MainLoop
+	rows := p.Rows
+	if rows == nil {
+		rows = []Hc2VirtualDeviceRow{}
+	}
+	all["rows"] = rows
+	return json.Marshal(all)
+}
+
+// LuaElements returns all elements of the virtual device that execute Lua code.
+func (vd *Hc2VirtualDevice) LuaElements() []*Hc2VirtualDeviceElement {
+	var elements []*Hc2VirtualDeviceElement
+	for r := range vd.Properties.Rows {
+		for e := range vd.Properties.Rows[r].Elements {
+			if vd.Properties.Rows[r].Elements[e].Lua {
+				elements = append(elements, &vd.Properties.Rows[r].Elements[e])
+			}
+		}
+	}
+	return elements
+}
+
+var vdHeader = map[string]*regexp.Regexp{
+	"@vdID":      regexp.MustCompile(`@vdID=(.*)`),
+	"@elementID": regexp.MustCompile(`@elementID=(.*)`),
+	"@mainLoop":  regexp.MustCompile(`@mainLoop=(.*)`),
+}
+
+// vdComment returns the FIBARO_GIT_HOOK header of a Lua file of a virtual device.
+// An elementID of -1 denotes the main loop.
+func vdComment(vdID, elementID int) string {
+	var str strings.Builder
+	_, _ = str.WriteString("--[[ FIBARO_GIT_HOOK - DO NOT CHANGE AS IT WILL BE DISCARDED \n")
+	_, _ = str.WriteString("@vdID=" + strconv.Itoa(vdID) + "\n")
+	if elementID == -1 {
+		_, _ = str.WriteString("@mainLoop=true\n")
+	} else {
+		_, _ = str.WriteString("@elementID=" + strconv.Itoa(elementID) + "\n")
+	}
+	_, _ = str.WriteString("--]]\n")
+	return str.String()
+}
+
+// vdFileOwner returns the id of the virtual device in the FIBARO_GIT_HOOK header of
+// file, or -1 if it has none.
+func vdFileOwner(file string) int {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return -1
+	}
+	i := m["gitHookComment"].FindIndex(b)
+	if i == nil {
+		return -1
+	}
+	s := vdHeader["@vdID"].FindSubmatch(b[i[0]:i[1]])
+	if s == nil {
+		return -1
+	}
+	id, err := strconv.Atoi(string(s[1]))
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// luaFileName returns the name of the file the Lua code of an element is written to.
+func (e Hc2VirtualDeviceElement) luaFileName() string {
+	name := e.Name
+	if name == "" {
+		name = e.Caption
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return strconv.Itoa(e.ID) + "-" + name + ".lua"
+}
+
+// WriteDir explodes the virtual device into the directory dir. The main loop
+// and the Lua code of every element is written to its own file, carrying a
+// FIBARO_GIT_HOOK header with the IDs of the virtual device and the element. All
+// other information is written to the layout file. Existing files are overwritten,
+// and other Lua files of the virtual device, e.g. of renamed or deleted elements, are
+// removed. Lua files without the FIBARO_GIT_HOOK header of the virtual device are kept.
+func (vd Hc2VirtualDevice) WriteDir(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	files := map[string]bool{VirtualDeviceMainLoopFile: true}
+	for _, e := range vd.LuaElements() {
+		files[e.luaFileName()] = true
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		if files[filepath.Base(file)] || vdFileOwner(file) != vd.ID {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	// the header is separated by a newline, which is removed again by ReadVirtualDeviceDir
+	write := func(name, lua string, elementID int) error {
+		return ioutil.WriteFile(filepath.Join(dir, name), []byte(lua+"\n"+vdComment(vd.ID, elementID)), 0644)
+	}
+
+	if err := write(VirtualDeviceMainLoopFile, vd.Properties.MainLoop, -1); err != nil {
+		return err
+	}
+	layout := vd
+	layout.Properties.MainLoop = ""
+	layout.Properties.Rows = make([]Hc2VirtualDeviceRow, len(vd.Properties.Rows))
+	for r, row := range vd.Properties.Rows {
+		layout.Properties.Rows[r] = Hc2VirtualDeviceRow{Type: row.Type, Elements: make([]Hc2VirtualDeviceElement, len(row.Elements))}
+		for e, element := range row.Elements {
+			if element.Lua {
+				if err := write(element.luaFileName(), element.Msg, element.ID); err != nil {
+					return err
+				}
+				element.Msg = ""
+			}
+			layout.Properties.Rows[r].Elements[e] = element
+		}
+	}
+
+	b, err := json.MarshalIndent(layout, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, VirtualDeviceLayoutFile), append(b, '\n'), 0644)
+}
+
+// ReadVirtualDeviceDir reassembles a virtual device from a directory written by WriteDir.
+// The Lua files are assigned to the elements by the IDs in their FIBARO_GIT_HOOK headers,
+// which are removed from the code. It fails if the main loop or a Lua element has no
+// file, or more than one.
+func ReadVirtualDeviceDir(dir string) (Hc2VirtualDevice, error) {
+	var vd Hc2VirtualDevice
+	_, b, err := readFile(filepath.Join(dir, VirtualDeviceLayoutFile))
+	if err != nil {
+		return vd, err
+	}
+	if err := json.Unmarshal(b, &vd); err != nil {
+		return vd, fmt.Errorf("%s: %w", VirtualDeviceLayoutFile, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return vd, err
+	}
+	sort.Strings(files)
+
+	elements := map[int]*Hc2VirtualDeviceElement{}
+	for _, e := range vd.LuaElements() {
+		elements[e.ID] = e
+	}
+
+	read := map[int]string{} // element id, -1 for the main loop, to its file
+	for _, file := range files {
+		_, b, err := readFile(file)
+		if err != nil {
+			return vd, err
+		}
+		i := m["gitHookComment"].FindIndex(b)
+		if i == nil {
+			return vd, fmt.Errorf("%s: no FIBARO_GIT_HOOK header", file)
+		}
+		header := b[i[0]:i[1]]
+		lua := strings.TrimSuffix(string(b[:i[0]]), "\n")
+
+		if s := vdHeader["@vdID"].FindSubmatch(header); s != nil {
+			if id, err := strconv.Atoi(string(s[1])); err == nil && id != vd.ID {
+				return vd, fmt.Errorf("%s: belongs to virtual device %d, not %d", file, id, vd.ID)
+			}
+		}
+		if s := vdHeader["@mainLoop"].FindSubmatch(header); s != nil && string(s[1]) == "true" {
+			if other, dup := read[-1]; dup {
+				return vd, fmt.Errorf("%s: main loop already read from %s", file, other)
+			}
+			read[-1] = file
+			vd.Properties.MainLoop = lua
+			continue
+		}
+		s := vdHeader["@elementID"].FindSubmatch(header)
+		if s == nil {
+			return vd, fmt.Errorf("%s: neither @elementID nor @mainLoop in header", file)
+		}
+		id, err := strconv.Atoi(string(s[1]))
+		if err != nil {
+			return vd, fmt.Errorf("%s: invalid @elementID: %w", file, err)
+		}
+		e, ok := elements[id]
+		if !ok {
+			return vd, fmt.Errorf("%s: no Lua element with id %d in %s", file, id, VirtualDeviceLayoutFile)
+		}
+		if other, dup := read[id]; dup {
+			return vd, fmt.Errorf("%s: element %d already read from %s", file, id, other)
+		}
+		read[id] = file
+		e.Msg = lua
+	}
+
+	if _, ok := read[-1]; !ok {
+		return vd, fmt.Errorf("%s: no file with the main loop", dir)
+	}
+	for _, e := range vd.LuaElements() {
+		if _, ok := read[e.ID]; !ok {
+			return vd, fmt.Errorf("%s: no file for Lua element %d %s", dir, e.ID, e.Name)
+		}
+	}
+	return vd, nil
+}
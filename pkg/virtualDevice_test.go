@@ -0,0 +1,115 @@
+package fibarohc2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestFibaroHc2_OneVirtualDevice(t *testing.T) {
+	cfg := NewFibaroHc2Config(ConfigFileName).Config()
+	httpmock.ActivateNonDefault(cfg.client.GetClient())
+	defer httpmock.DeactivateAndReset()
+
+	fixture, _ := ioutil.ReadFile("../test/virtualDevice77.json")
+	httpmock.RegisterResponder(http.MethodGet, "/api/virtualDevices/77", httpmock.NewBytesResponder(200, fixture))
+
+	f := &FibaroHc2{
+		cfg: *cfg,
+	}
+	vd, err := f.OneVirtualDevice(77)
+	if err != nil {
+		t.Fatalf("FibaroHc2.OneVirtualDevice() produced error = %v", err)
+	}
+	AssertEqual(t, vd.Name, "HueSchlafzimmer")
+	AssertEqual(t, len(vd.Properties.Rows), 2)
+	AssertEqual(t, len(vd.LuaElements()), 2)
+	AssertEqual(t, string(vd.Properties.Others["ip"]), `"192.168.178.49"`)
+
+	// unknown properties survive a round trip
+	b, _ := json.Marshal(vd.Properties)
+	var want struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	var got map[string]interface{}
+	json.Unmarshal(fixture, &want)
+	json.Unmarshal(b, &got)
+	delete(want.Properties, "rows")
+	delete(got, "rows")
+	if !reflect.DeepEqual(got, want.Properties) {
+		t.Errorf("json.Marshal(Hc2VirtualDeviceProperties) = %v, want %v", got, want.Properties)
+	}
+}
+
+func TestHc2VirtualDevice_WriteReadDir(t *testing.T) {
+	fixture, _ := ioutil.ReadFile("../test/virtualDevice77.json")
+	var vd Hc2VirtualDevice
+	if err := json.Unmarshal(fixture, &vd); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "hc2vd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := vd.WriteDir(dir); err != nil {
+		t.Fatalf("Hc2VirtualDevice.WriteDir() produced error = %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	AssertEqual(t, len(files), 4)
+
+	got, err := ReadVirtualDeviceDir(dir)
+	if err != nil {
+		t.Fatalf("ReadVirtualDeviceDir() produced error = %v", err)
+	}
+	if !reflect.DeepEqual(got, vd) {
+		t.Errorf("ReadVirtualDeviceDir() = %v, want %v", got, vd)
+	}
+
+	// a Lua file belonging to another virtual device is rejected
+	ioutil.WriteFile(filepath.Join(dir, "foreign.lua"), []byte(vdComment(78, 2)), 0644)
+	if _, err := ReadVirtualDeviceDir(dir); err == nil {
+		t.Errorf("ReadVirtualDeviceDir() expected error for foreign file")
+	}
+
+	// files of renamed elements are removed by WriteDir, files of others are kept
+	ioutil.WriteFile(filepath.Join(dir, "scene.lua"), []byte("--[[\n%% properties\n--]]\n"), 0644)
+	e := vd.LuaElements()[0]
+	stale := filepath.Join(dir, e.luaFileName())
+	e.Name = "renamed"
+	if err := vd.WriteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("WriteDir() should remove %s, got %v", stale, err)
+	}
+	for _, name := range []string{"foreign.lua", "scene.lua"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("WriteDir() should keep %s, got %v", name, err)
+		}
+		os.Remove(filepath.Join(dir, name))
+	}
+	files, _ = filepath.Glob(filepath.Join(dir, "*"))
+	AssertEqual(t, len(files), 4)
+
+	// two files of the same element are rejected
+	ioutil.WriteFile(stale, []byte("x = 1\n"+vdComment(vd.ID, e.ID)), 0644)
+	if _, err := ReadVirtualDeviceDir(dir); err == nil {
+		t.Errorf("ReadVirtualDeviceDir() expected error for duplicate @elementID")
+	}
+
+	// a Lua element without file is rejected
+	os.Remove(stale)
+	os.Remove(filepath.Join(dir, e.luaFileName()))
+	if _, err := ReadVirtualDeviceDir(dir); err == nil {
+		t.Errorf("ReadVirtualDeviceDir() expected error for missing element file")
+	}
+}
@@ -0,0 +1,277 @@
+package fibarohc2
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RefKind is the kind of item referenced from Lua code
+type RefKind int
+
+// The items that can be referenced from Lua code
+const (
+	RefDevice RefKind = iota
+	RefScene
+	RefGlobal
+)
+
+func (k RefKind) String() string {
+	return [...]string{"device", "scene", "global"}[k]
+}
+
+// LuaReference is a reference from Lua code to a device, a scene or a global variable
+type LuaReference struct {
+	Kind RefKind
+	ID   int    // ID of the device or scene
+	Name string // name of the global variable
+	Line int    // line of the reference, starting at 1
+	Via  string // the function or trigger section the reference was found in, e.g. fibaro:call
+}
+
+// XRefSource identifies Lua code of a scene or a virtual device
+type XRefSource struct {
+	Kind string // "scene" or "vd"
+	ID   int
+	Name string // name of the scene, or the virtual device and its button
+}
+
+// XRefUse is a reference of a source to an item
+type XRefUse struct {
+	Source XRefSource
+	Ref    LuaReference
+}
+
+// XRef maps devices, scenes and global variables to the Lua code referencing them
+type XRef struct {
+	Devices map[int][]XRefUse
+	Scenes  map[int][]XRefUse
+	Globals map[string][]XRefUse
+}
+
+// Methods of fibaro taking the id of a device or scene, or the name of a global variable,
+// as first argument
+var (
+	FibaroDeviceMethods = []string{"call", "get", "getValue", "getModificationTime", "getType", "getName",
+		"getRoomID", "getSectionID", "getRoomNameByDeviceID", "wakeUpDeadDevice"}
+	FibaroSceneMethods  = []string{"startScene", "killScenes", "setSceneEnabled", "isSceneEnabled", "countScenes"}
+	FibaroGlobalMethods = []string{"getGlobal", "getGlobalValue", "getGlobalModificationTime", "setGlobal"}
+)
+
+var luaReference = []struct {
+	kind RefKind
+	re   *regexp.Regexp
+}{
+	{RefDevice, regexp.MustCompile(`fibaro:(` + strings.Join(FibaroDeviceMethods, "|") + `)\s*\(\s*(\d+)`)},
+	{RefScene, regexp.MustCompile(`fibaro:(` + strings.Join(FibaroSceneMethods, "|") + `)\s*\(\s*(\d+)`)},
+	{RefGlobal, regexp.MustCompile(`fibaro:(` + strings.Join(FibaroGlobalMethods, "|") + `)\s*\(\s*(?:"([^"]*)"|'([^']*)')`)},
+}
+
+// FindLuaReferences statically scans Lua code for references to devices, scenes
+// and global variables. Only literal IDs and names are found. References in
+// comments are ignored, except the ones in the trigger block.
+func FindLuaReferences(lua string) []LuaReference {
+	var refs []LuaReference
+
+	if t, lines, found, err := parseSceneTriggers(lua); found && err == nil {
+		for i, p := range t.Properties {
+			refs = append(refs, LuaReference{Kind: RefDevice, ID: p.DeviceID, Line: lines.properties[i], Via: "%% properties"})
+		}
+		for i, e := range t.Events {
+			refs = append(refs, LuaReference{Kind: RefDevice, ID: e.DeviceID, Line: lines.events[i], Via: "%% events"})
+		}
+		for i, g := range t.Globals {
+			refs = append(refs, LuaReference{Kind: RefGlobal, Name: g, Line: lines.globals[i], Via: "%% globals"})
+		}
+	}
+
+	code := stripLuaComments(lua)
+	for _, r := range luaReference {
+		for _, m := range r.re.FindAllStringSubmatchIndex(code, -1) {
+			ref := LuaReference{
+				Kind: r.kind,
+				Line: 1 + strings.Count(code[:m[0]], "\n"),
+				Via:  "fibaro:" + code[m[2]:m[3]],
+			}
+			switch {
+			case r.kind == RefGlobal && m[4] != -1:
+				ref.Name = code[m[4]:m[5]]
+			case r.kind == RefGlobal:
+				ref.Name = code[m[6]:m[7]]
+			default:
+				ref.ID, _ = strconv.Atoi(code[m[4]:m[5]])
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Line < refs[j].Line })
+	return refs
+}
+
+// stripLuaComments replaces all comments in the Lua code by spaces. Newlines are
+// kept, so that line numbers do not change.
+func stripLuaComments(lua string) string {
+	b := []byte(lua)
+	blank := func(from, to int) {
+		for k := from; k < to && k < len(b); k++ {
+			if b[k] != '\n' {
+				b[k] = ' '
+			}
+		}
+	}
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '"' || b[i] == '\'':
+			q := b[i]
+			for i++; i < len(b) && b[i] != q && b[i] != '\n'; i++ {
+				if b[i] == '\\' {
+					i++
+				}
+			}
+		case b[i] == '[' && longBracketLevel(b[i:]) >= 0:
+			i = longBracketEnd(b, i) - 1
+		case b[i] == '-' && i+1 < len(b) && b[i+1] == '-':
+			end := i + 2
+			if end < len(b) && b[end] == '[' && longBracketLevel(b[end:]) >= 0 {
+				end = longBracketEnd(b, end)
+			} else {
+				for end < len(b) && b[end] != '\n' {
+					end++
+				}
+			}
+			blank(i, end)
+			i = end - 1
+		}
+	}
+	return string(b)
+}
+
+// longBracketLevel returns the level of the opening long bracket at the beginning
+// of b, i.e. the number of '=' in [==[, or -1 if b does not start with a long bracket.
+func longBracketLevel(b []byte) int {
+	level := 0
+	for level+1 < len(b) && b[level+1] == '=' {
+		level++
+	}
+	if level+1 < len(b) && b[level+1] == '[' {
+		return level
+	}
+	return -1
+}
+
+// longBracketEnd returns the index after the closing long bracket matching the
+// opening long bracket at b[start], or len(b) if it is not closed.
+func longBracketEnd(b []byte, start int) int {
+	level := longBracketLevel(b[start:])
+	closing := "]" + strings.Repeat("=", level) + "]"
+	i := strings.Index(string(b[start+level+2:]), closing)
+	if i == -1 {
+		return len(b)
+	}
+	return start + level + 2 + i + len(closing)
+}
+
+// NewXRef creates an empty XRef
+func NewXRef() XRef {
+	return XRef{
+		Devices: map[int][]XRefUse{},
+		Scenes:  map[int][]XRefUse{},
+		Globals: map[string][]XRefUse{},
+	}
+}
+
+// Add adds all references found in the Lua code of source
+func (x XRef) Add(source XRefSource, lua string) {
+	for _, ref := range FindLuaReferences(lua) {
+		use := XRefUse{Source: source, Ref: ref}
+		switch ref.Kind {
+		case RefDevice:
+			x.Devices[ref.ID] = append(x.Devices[ref.ID], use)
+		case RefScene:
+			x.Scenes[ref.ID] = append(x.Scenes[ref.ID], use)
+		case RefGlobal:
+			x.Globals[ref.Name] = append(x.Globals[ref.Name], use)
+		}
+	}
+}
+
+// AddScene adds all references of a scene
+func (x XRef) AddScene(scene Hc2Scene) {
+	x.Add(XRefSource{Kind: "scene", ID: scene.SceneID, Name: scene.Name}, scene.Lua)
+}
+
+// AddVirtualDevice adds all references of the main loop and the buttons of a virtual device
+func (x XRef) AddVirtualDevice(vd Hc2VirtualDevice) {
+	x.Add(XRefSource{Kind: "vd", ID: vd.ID, Name: vd.Name + "/mainLoop"}, vd.Properties.MainLoop)
+	for _, e := range vd.LuaElements() {
+		x.Add(XRefSource{Kind: "vd", ID: vd.ID, Name: vd.Name + "/" + e.luaFileName()}, e.Msg)
+	}
+}
+
+// Unknown returns the referenced devices, scenes and global variables that do not exist
+// in the given lists, sorted. Devices include virtual devices.
+func (x XRef) Unknown(devices []Hc2Device, vds []Hc2VirtualDevice, scenes []Hc2Scene, globals []Hc2GlobalVariable) (unknownDevices []int, unknownScenes []int, unknownGlobals []string) {
+	knownDevices := map[int]bool{}
+	for _, d := range devices {
+		knownDevices[d.ID] = true
+	}
+	for _, vd := range vds {
+		knownDevices[vd.ID] = true
+	}
+	knownScenes := map[int]bool{}
+	for _, s := range scenes {
+		knownScenes[s.SceneID] = true
+	}
+	knownGlobals := map[string]bool{}
+	for _, g := range globals {
+		knownGlobals[g.Name] = true
+	}
+
+	for _, id := range x.DeviceIDs() {
+		if !knownDevices[id] {
+			unknownDevices = append(unknownDevices, id)
+		}
+	}
+	for _, id := range x.SceneIDs() {
+		if !knownScenes[id] {
+			unknownScenes = append(unknownScenes, id)
+		}
+	}
+	for _, name := range x.GlobalNames() {
+		if !knownGlobals[name] {
+			unknownGlobals = append(unknownGlobals, name)
+		}
+	}
+	return
+}
+
+// DeviceIDs returns the IDs of all referenced devices, sorted
+func (x XRef) DeviceIDs() []int {
+	return sortedIDs(x.Devices)
+}
+
+// SceneIDs returns the IDs of all referenced scenes, sorted
+func (x XRef) SceneIDs() []int {
+	return sortedIDs(x.Scenes)
+}
+
+// GlobalNames returns the names of all referenced global variables, sorted
+func (x XRef) GlobalNames() []string {
+	names := make([]string, 0, len(x.Globals))
+	for name := range x.Globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedIDs(m map[int][]XRefUse) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
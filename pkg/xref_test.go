@@ -0,0 +1,67 @@
+package fibarohc2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindLuaReferences(t *testing.T) {
+	_, b, err := readFile("../test/xref.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LuaReference{
+		{Kind: RefDevice, ID: 449, Line: 3, Via: "%% properties"},
+		{Kind: RefDevice, ID: 448, Line: 5, Via: "%% events"},
+		{Kind: RefGlobal, Name: "TimeOfDay", Line: 7, Via: "%% globals"},
+		{Kind: RefDevice, ID: 449, Line: 15, Via: "fibaro:getValue"},
+		{Kind: RefDevice, ID: 450, Line: 16, Via: "fibaro:call"},
+		{Kind: RefGlobal, Name: "SleepState", Line: 17, Via: "fibaro:setGlobal"},
+		{Kind: RefScene, ID: 12, Line: 19, Via: "fibaro:startScene"},
+		{Kind: RefGlobal, Name: "TimeOfDay", Line: 20, Via: "fibaro:getGlobalValue"},
+	}
+	if got := FindLuaReferences(string(b)); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindLuaReferences() = %v, want %v", got, want)
+	}
+}
+
+func TestStripLuaComments(t *testing.T) {
+	tests := []struct {
+		lua  string
+		want string
+	}{
+		{"a -- b\nc", "a     \nc"},
+		{"a --[[ b\nc ]] d", "a       \n     d"},
+		{"s = \"--\" -- c", "s = \"--\"     "},
+		{"s = [[ -- ]]", "s = [[ -- ]]"},
+		{"s = 'it\\'s' --", "s = 'it\\'s'   "},
+	}
+	for _, tt := range tests {
+		if got := stripLuaComments(tt.lua); got != tt.want {
+			t.Errorf("stripLuaComments(%q) = %q, want %q", tt.lua, got, tt.want)
+		}
+	}
+}
+
+func TestXRef(t *testing.T) {
+	_, b, err := readFile("../test/xref.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := NewXRef()
+	x.AddScene(Hc2Scene{SceneID: 3, Name: "Xref", Lua: string(b)})
+	x.AddVirtualDevice(Hc2VirtualDevice{ID: 77, Name: "VD", Properties: Hc2VirtualDeviceProperties{MainLoop: "fibaro:call(451, 'turnOff')"}})
+
+	AssertEqual(t, len(x.Devices[449]), 2)
+	AssertEqual(t, x.Devices[451][0].Source, XRefSource{Kind: "vd", ID: 77, Name: "VD/mainLoop"})
+
+	devices, scenes, globals := x.Unknown(
+		[]Hc2Device{{ID: 448}, {ID: 449}, {ID: 450}},
+		nil,
+		[]Hc2Scene{{SceneID: 3}},
+		[]Hc2GlobalVariable{{Name: "TimeOfDay"}},
+	)
+	if !reflect.DeepEqual(devices, []int{451}) || !reflect.DeepEqual(scenes, []int{12}) || !reflect.DeepEqual(globals, []string{"SleepState"}) {
+		t.Errorf("XRef.Unknown() = %v, %v, %v", devices, scenes, globals)
+	}
+}
@@ -51,6 +51,17 @@ targets = {
     'hc2UploadScene' : './cmd/hc2UploadScene',
     'hc2DownloadScene' : './cmd/hc2DownloadScene',
     'hc2SceneInteract' : './cmd/hc2SceneInteract',
+    'hc2Globals' : './cmd/hc2Globals',
+    'hc2DownloadVD' : './cmd/hc2DownloadVD',
+    'hc2UploadVD' : './cmd/hc2UploadVD',
+    'hc2Sync' : './cmd/hc2Sync',
+    'hc2Sim' : './cmd/hc2Sim',
+    'hc2DownloadQA' : './cmd/hc2DownloadQA',
+    'hc2UploadQA' : './cmd/hc2UploadQA',
+    'hc2Backup' : './cmd/hc2Backup',
+    'hc2Restore' : './cmd/hc2Restore',
+    'hc2Lint' : './cmd/hc2Lint',
+    'hc2Mqtt' : './cmd/hc2Mqtt',
 
 
 }
@@ -11,7 +11,7 @@
 @name="A_Trial_2"
 @roomID=306
 @runConfig=TRIGGER_AND_MANUAL
-@maxRunningInstances="NOT_YET_USED"
+@maxRunningInstances=2
 --]]
 
 --[[ FIBARO_GIT_HOOK
@@ -19,5 +19,5 @@
 @name="A_Trial_4"
 @roomID=305
 @runConfig=TRIGGER_AND_MANUAL
-@maxRunningInstances="NOT_YET_USED"
+@maxRunningInstances=2
 --]]
\ No newline at end of file
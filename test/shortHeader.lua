@@ -12,5 +12,5 @@
 @name="A_Trial_2"
 @roomID=305
 @runConfig=TRIGGER_AND_MANUAL
-@maxRunningInstances="NOT_YET_USED"
+@maxRunningInstances=2
 --]]
\ No newline at end of file
@@ -0,0 +1,19 @@
+--[[
+%% autostart
+%% properties
+449 value
+419 value
+%% weather
+Temperature
+%% events
+448 CentralSceneEvent
+312 sceneActivation 2
+%% globals
+TimeOfDay
+SleepState
+%% killOtherInstances
+--]]
+
+-- %% globals
+-- NotATrigger
+local x = 1
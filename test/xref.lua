@@ -0,0 +1,21 @@
+--[[
+%% properties
+449 value
+%% events
+448 CentralSceneEvent
+%% globals
+TimeOfDay
+--]]
+
+-- fibaro:call(999, "turnOff") is a comment
+--[==[
+fibaro:setGlobal("Commented", "1")
+]==]
+local s = "-- not a comment"
+if fibaro:getValue(449, "value") == "1" then
+  fibaro:call( 450, "turnOn")
+  fibaro:setGlobal('SleepState', "Awake")
+end
+fibaro:startScene(12)
+local tod = fibaro:getGlobalValue("TimeOfDay")
+fibaro:call(fibaro:getSelfId(), "pressButton", "1")